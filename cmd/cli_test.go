@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// runCLI runs jsl with args, its flags reset to their defaults, and returns
+// what it wrote to stdout. Stdin is empty, as in a terminal.
+func runCLI(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	resetFlags(rootCmd)
+
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStdin, savedStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, w
+	defer func() { os.Stdin, os.Stdout = savedStdin, savedStdout }()
+
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&out, r)
+		close(done)
+	}()
+
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	err = rootCmd.Execute()
+	w.Close()
+	<-done
+	r.Close()
+	return out.String(), err
+}
+
+// resetFlags sets the flags of a command and its subcommands back to their
+// defaults, the commands sharing package variables between runs
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.PersistentFlags().VisitAll(reset)
+	cmd.Flags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// writeFile writes content to name in dir, returning its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOutputIsNotInput(t *testing.T) {
+	dir := t.TempDir()
+	content := "{\"a\":1}\n{\"a\":2}\n"
+	input := writeFile(t, dir, "d.jsonl", content)
+
+	for _, args := range [][]string{
+		{input, "SELECT a INTO '" + input + "'"},
+		{input, "SELECT a", "--output", input},
+		// The same file through another path
+		{input, "SELECT a", "--output", filepath.Join(dir, ".", "d.jsonl")},
+		{input, "UPDATE SET a = 3", "--output", input},
+		{"SELECT a FROM '" + input + "' INTO '" + input + "'"},
+	} {
+		if _, err := runCLI(t, args...); err == nil {
+			t.Errorf("%q: expected an error writing to the input", args)
+		}
+		if got := readFile(t, input); got != content {
+			t.Fatalf("%q: input changed to %q", args, got)
+		}
+	}
+
+	output := filepath.Join(dir, "out.jsonl")
+	if _, err := runCLI(t, input, "SELECT a INTO '"+output+"'"); err != nil {
+		t.Fatalf("INTO another file failed: %v", err)
+	}
+	if got := readFile(t, output); got != content {
+		t.Errorf("Expected the rows in %s, got %q", output, got)
+	}
+}
+
+func TestNoWrite(t *testing.T) {
+	t.Setenv(NoWriteEnv, "1")
+	dir := t.TempDir()
+	input := writeFile(t, dir, "d.jsonl", "{\"ts\":1,\"a\":1}\n{\"ts\":2,\"a\":2}\n")
+	other := writeFile(t, dir, "e.jsonl", "{\"ts\":3,\"a\":3}\n")
+	src := t.TempDir()
+	writeFile(t, src, "s.json", `{"a":1}`)
+	out := filepath.Join(dir, "out.jsonl")
+
+	for _, args := range [][]string{
+		{input, "SELECT a INTO '" + out + "'"},
+		{input, "SELECT a", "--output", out},
+		{input, "UPDATE SET a = 3", "--output", out},
+		{input, "DELETE WHERE a = 1", "--output", out},
+		{input, "SELECT a", "--trace", "all", "--trace-file", out},
+		{input, "SELECT a", "--skip-errors", "--errors-file", out},
+		{"convert", src, "--to", "jsonl", "--out-dir", filepath.Join(dir, "converted")},
+	} {
+		_, err := runCLI(t, args...)
+		if err == nil || !strings.Contains(err.Error(), NoWriteEnv) {
+			t.Errorf("%q: expected %s to refuse writing, got %v", args, NoWriteEnv, err)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Fatalf("%q: expected no output file, got %v", args, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "converted")); !os.IsNotExist(err) {
+		t.Errorf("Expected no converted directory, got %v", err)
+	}
+
+	// Writing to stdout is still allowed, UPDATE, DELETE and merge included
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{input, "SELECT a WHERE a > 1"}, "{\"a\":2}\n"},
+		{[]string{input, "UPDATE SET a = 0 WHERE a = 1"}, "{\"ts\":1,\"a\":0}\n{\"ts\":2,\"a\":2}\n"},
+		{[]string{input, "DELETE WHERE a = 1"}, "{\"ts\":2,\"a\":2}\n"},
+		{[]string{"merge", "--sorted-by", "ts", other, input}, "{\"ts\":1,\"a\":1}\n{\"ts\":2,\"a\":2}\n{\"ts\":3,\"a\":3}\n"},
+	} {
+		got, err := runCLI(t, tt.args...)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q = %q, %v, want %q", tt.args, got, err, tt.expected)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 2 {
+		t.Errorf("Expected only the inputs in %s, got %v, %v", dir, entries, err)
+	}
+
+	t.Setenv(NoWriteEnv, "maybe")
+	if _, err := runCLI(t, input, "SELECT a"); err == nil || !strings.Contains(err.Error(), "invalid "+NoWriteEnv) {
+		t.Errorf("Expected an invalid %s to be refused, got %v", NoWriteEnv, err)
+	}
+}
+
+func TestArrayMatchUpdateDelete(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "d.jsonl", "{\"id\":1,\"tags\":[1,5]}\n{\"id\":2,\"tags\":[5,6]}\n")
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{input, "DELETE WHERE tags > 2"}, ""},
+		{[]string{input, "DELETE WHERE tags > 2", "--array-match", "all"}, "{\"id\":1,\"tags\":[1,5]}\n"},
+		{[]string{input, "DELETE WHERE tags > 2", "--array-match", "none"}, "{\"id\":1,\"tags\":[1,5]}\n{\"id\":2,\"tags\":[5,6]}\n"},
+		// An explicit quantifier wins over --array-match
+		{[]string{input, "DELETE WHERE ANY(tags) > 5", "--array-match", "all"}, "{\"id\":1,\"tags\":[1,5]}\n"},
+		{[]string{input, "UPDATE SET id = 0 WHERE tags > 2", "--array-match", "all"}, "{\"id\":1,\"tags\":[1,5]}\n{\"id\":0,\"tags\":[5,6]}\n"},
+		{[]string{input, "UPDATE SET id = 0 WHERE tags < 2", "--array-match", "NONE"}, "{\"id\":1,\"tags\":[1,5]}\n{\"id\":0,\"tags\":[5,6]}\n"},
+	} {
+		got, err := runCLI(t, tt.args...)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q = %q, %v, want %q", tt.args, got, err, tt.expected)
+		}
+	}
+
+	for _, query := range []string{"DELETE WHERE tags > 2", "UPDATE SET id = 0"} {
+		if _, err := runCLI(t, input, query, "--array-match", "some"); err == nil || !strings.Contains(err.Error(), "--array-match") {
+			t.Errorf("%q: expected an invalid --array-match to be refused, got %v", query, err)
+		}
+	}
+}
+
+func TestWhereFlags(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "users.jsonl", `{"name":"ann","age":30,"city":"Rome"}
+{"name":"bob","age":20,"city":"Rome"}
+{"name":"cid","age":40,"city":"Oslo"}
+{"name":"dan","age":70,"city":"Oslo"}
+`)
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		// Flag conditions alone filter the records
+		{[]string{input, "--where", "age>25", "--where", "city=Rome"}, `[{"age":30,"city":"Rome","name":"ann"}]` + "\n"},
+		{[]string{input, "--where", "age<25", "--where", "age>65", "--any"}, `[{"age":20,"city":"Rome","name":"bob"},{"age":70,"city":"Oslo","name":"dan"}]` + "\n"},
+		// With a statement, they are required on top of its WHERE
+		{[]string{input, "SELECT name WHERE age > 25", "--where", "city=Rome"}, "{\"name\":\"ann\"}\n"},
+		{[]string{input, "SELECT name", "--where", "city=Oslo"}, "{\"name\":\"cid\"}\n{\"name\":\"dan\"}\n"},
+		// --any joins the flag conditions only
+		{[]string{input, "SELECT name WHERE age > 25", "--where", "city=Rome", "--where", "age>65", "--any"}, "{\"name\":\"ann\"}\n{\"name\":\"dan\"}\n"},
+		// An OR in the WHERE stays grouped
+		{[]string{input, "SELECT name WHERE age > 60 OR city = 'Rome'", "--where", "age<50"}, "{\"name\":\"ann\"}\n{\"name\":\"bob\"}\n"},
+		{[]string{"SELECT name FROM '" + input + "' WHERE city = 'Rome'", "--where", "age<25"}, "{\"name\":\"bob\"}\n"},
+		{[]string{input, "SELECT COUNT(*) AS n WHERE city = 'Oslo'", "--where", "age>50"}, "{\"n\":1}\n"},
+		{[]string{input, "UPDATE SET age = 0 WHERE city = 'Oslo'", "--where", "age>50"}, `{"name":"ann","age":30,"city":"Rome"}
+{"name":"bob","age":20,"city":"Rome"}
+{"name":"cid","age":40,"city":"Oslo"}
+{"name":"dan","age":0,"city":"Oslo"}
+`},
+		{[]string{input, "DELETE WHERE city = 'Rome'", "--where", "age<25", "--where", "age>65", "--any"}, `{"name":"ann","age":30,"city":"Rome"}
+{"name":"cid","age":40,"city":"Oslo"}
+{"name":"dan","age":70,"city":"Oslo"}
+`},
+	} {
+		got, err := runCLI(t, tt.args...)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q = %q, %v, want %q", tt.args, got, err, tt.expected)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{input, ".name", "--where", "age>25"}, "cannot be combined"},
+		{[]string{input, "age>25", "--where", "city=Rome"}, "cannot be combined"},
+		{[]string{input, "--any"}, "--any requires --where"},
+		{[]string{input, "SELECT name", "--any"}, "--any requires --where"},
+	} {
+		if _, err := runCLI(t, tt.args...); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected an error containing %q, got %v", tt.args, tt.err, err)
+		}
+	}
+}
+
+func TestStrictLimit(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "f.jsonl", "{\"a\":1}\n{\"a\":2}\n{\"a\":3,\"b\":1}\n")
+	for _, args := range [][]string{
+		{"--strict", input, "SELECT zz LIMIT 1"},
+		{"--strict", "--parallel", "2", input, "SELECT zz LIMIT 1"},
+		{"--strict", input, "SELECT zz"},
+	} {
+		out, err := runCLI(t, args...)
+		if err == nil || !strings.Contains(err.Error(), "unknown field(s) zz") {
+			t.Errorf("%q: expected the unknown field to be reported, got %v", args, err)
+		}
+		if out != "" {
+			t.Errorf("%q: expected no row before the error, got %q", args, out)
+		}
+	}
+
+	// A field found after the rows LIMIT outputs
+	for _, args := range [][]string{
+		{"--strict", input, "SELECT b LIMIT 1"},
+		{"--strict", "--parallel", "2", input, "SELECT b LIMIT 1"},
+	} {
+		if out, err := runCLI(t, args...); err != nil || out != "{\"b\":null}\n" {
+			t.Errorf("%q = %q, %v, want one row", args, out, err)
+		}
+	}
+}
+
+func TestSortNulls(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "d.jsonl", "{\"n\":\"a\",\"p\":2}\n{\"n\":\"b\"}\n{\"n\":\"c\",\"p\":1}\n")
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"sort", input, "--by", "p desc"}, "a c b"},
+		{[]string{"sort", input, "--by", "p desc nulls first"}, "b a c"},
+		{[]string{"sort", input, "--by", "p NULLS FIRST"}, "b c a"},
+		{[]string{input, "SELECT n ORDER BY p DESC"}, "a c b"},
+		{[]string{input, "SELECT n ORDER BY p DESC NULLS FIRST"}, "b a c"},
+		{[]string{input, "SELECT n ORDER BY p NULLS LAST"}, "c a b"},
+	} {
+		out, err := runCLI(t, tt.args...)
+		if err != nil {
+			t.Fatalf("%q failed: %v", tt.args, err)
+		}
+		var names []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("%q: %v", tt.args, err)
+			}
+			names = append(names, fmt.Sprint(record["n"]))
+		}
+		if got := strings.Join(names, " "); got != tt.expected {
+			t.Errorf("%q = %s, want %s", tt.args, got, tt.expected)
+		}
+	}
+
+	if _, err := runCLI(t, "sort", input, "--by", "p nulls middle"); err == nil || !strings.Contains(err.Error(), "NULLS FIRST or NULLS LAST") {
+		t.Errorf("Expected an invalid nulls order to be refused, got %v", err)
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "d.jsonl", "{\"z\":2,\"m\":{\"y\":1,\"b\":2},\"a\":1}\n{\"z\":1,\"m\":{\"y\":3,\"b\":4},\"a\":2}\n")
+	other := writeFile(t, dir, "e.jsonl", "{\"z\":0,\"c\":1}\n")
+	array := writeFile(t, dir, "d.json", "[{\"z\":1,\"a\":2}]")
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"sort", input, "--by", "z"}, "{\"z\":1,\"m\":{\"y\":3,\"b\":4},\"a\":2}\n{\"z\":2,\"m\":{\"y\":1,\"b\":2},\"a\":1}\n"},
+		{[]string{"sort", array, "--by", "a"}, "[{\"z\":1,\"a\":2}]\n"},
+		{[]string{"set", input, ".m.c", "0"}, "{\"z\":2,\"m\":{\"y\":1,\"b\":2,\"c\":0},\"a\":1}\n{\"z\":1,\"m\":{\"y\":3,\"b\":4,\"c\":0},\"a\":2}\n"},
+		{[]string{"del", input, ".m.y", ".a"}, "{\"z\":2,\"m\":{\"b\":2}}\n{\"z\":1,\"m\":{\"b\":4}}\n"},
+		{[]string{"merge", "--sorted-by", "a", other, array}, "{\"z\":0,\"c\":1}\n{\"z\":1,\"a\":2}\n"},
+		{[]string{input, "."}, "{\"z\":2,\"m\":{\"y\":1,\"b\":2},\"a\":1}\n{\"z\":1,\"m\":{\"y\":3,\"b\":4},\"a\":2}\n"},
+		{[]string{input, ".m"}, "{\"y\":1,\"b\":2}\n{\"y\":3,\"b\":4}\n"},
+		{[]string{input, ".m", "--extract"}, "{\"y\":1}\n{\"b\":2}\n{\"y\":3}\n{\"b\":4}\n"},
+		{[]string{input, ".", "--select", "z,a"}, "{\"z\":2,\"a\":1}\n{\"z\":1,\"a\":2}\n"},
+		{[]string{input, ".keys()"}, "[\"z\",\"m\",\"a\"]\n[\"z\",\"m\",\"a\"]\n"},
+		{[]string{input, ".m | values()"}, "[1,2]\n[3,4]\n"},
+	} {
+		got, err := runCLI(t, tt.args...)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q = %q, %v, want %q", tt.args, got, err, tt.expected)
+		}
+	}
+}
+
+func TestOutputFlags(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "in.jsonl", "{\"c\":\"a\",\"n\":1}\n{\"c\":\"b\",\"n\":2}\n{\"c\":\"a\",\"n\":3}\n")
+
+	pattern := filepath.Join(dir, "out", "{c}.jsonl")
+	if _, err := runCLI(t, input, "SELECT * WHERE n > 1", "-o", pattern, "--partition-by", "c"); err != nil {
+		t.Fatalf("Partitioning SELECT * failed: %v", err)
+	}
+	for name, expected := range map[string]string{
+		"a.jsonl": "{\"*\":{\"c\":\"a\",\"n\":3}}\n",
+		"b.jsonl": "{\"*\":{\"c\":\"b\",\"n\":2}}\n",
+	} {
+		if got := readFile(t, filepath.Join(dir, "out", name)); got != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "null.jsonl")); err == nil {
+		t.Error("Expected no row without a partition value")
+	}
+
+	// Path and filter expressions write to stdout only
+	for _, args := range [][]string{
+		{input, ".c", "-o", filepath.Join(dir, "path.jsonl")},
+		{input, "n>1", "-o", filepath.Join(dir, "filter.jsonl")},
+		{input, "--where", "n>1", "-o", filepath.Join(dir, "where.jsonl")},
+		{input, ".c", "--partition-by", "c"},
+	} {
+		if _, err := runCLI(t, args...); err == nil || !strings.Contains(err.Error(), "only apply to SELECT") {
+			t.Errorf("%q: expected the output flags to be refused, got %v", args, err)
+		}
+	}
+}
+
+func TestFormatTo(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "in.json", `[{"b":1,"a":2},{"b":3}]`)
+
+	for _, args := range [][]string{
+		{"format", input, "--to", "jsonl", "--pretty=false"},
+		{"format", input, "-t", "jsonl", "--pretty=false"},
+	} {
+		got, err := runCLI(t, args...)
+		if err != nil {
+			t.Fatalf("%q failed: %v", args, err)
+		}
+		if expected := "{\"b\":1,\"a\":2}\n{\"b\":3}\n"; got != expected {
+			t.Errorf("%q: expected %q, got %q", args, expected, got)
+		}
+	}
+}
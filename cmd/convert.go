@@ -1,38 +1,65 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/spf13/cobra"
 )
 
 var (
-	convertOutput string
-	convertPretty bool
+	convertOutput    string
+	convertPretty    bool
+	convertOutDir    string
+	convertRecursive bool
+	convertJobs      int
+	convertForce     bool
 )
 
 var convertCmd = &cobra.Command{
-	Use:   "convert [file|-]",
-	Short: "Convert between JSON and JSONL formats",
-	Long: `Convert a file between JSON and JSONL formats.
+	Use:   "convert [file|dir|-]",
+	Short: "Convert between JSON, JSONL and MessagePack formats, or to CSV",
+	Long: `Convert a file between JSON, JSONL and MessagePack formats, or to CSV.
 	
 Supports:
   - File paths: jsl convert data.json --to jsonl
   - Stdin: cat data.json | jsl convert --to jsonl
+  - Directories: jsl convert ./in --to jsonl --out-dir ./out
+    Every .json/.jsonl/.msgpack file is converted into --out-dir, keeping the
+    directory layout (--recursive descends into subdirectories). --out-dir
+    must be outside the directory, and existing files are not overwritten
+    unless --force is given.
 
 Examples:
   jsl convert data.json --to jsonl
   jsl convert data.jsonl --to json
   cat data.json | jsl convert --to jsonl
-  echo '{"name":"Alice"}' | jsl convert --to jsonl`,
+  jsl convert events.msgpack --to jsonl
+  jsl convert data.jsonl --to msgpack > data.msgpack
+  jsl convert data.jsonl --to csv > data.csv
+  echo '{"name":"Alice"}' | jsl convert --to jsonl
+  jsl convert ./in-dir --to jsonl --out-dir ./out-dir --recursive --jobs 8`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runConvert,
 }
 
 func init() {
-	convertCmd.Flags().StringVarP(&convertOutput, "to", "t", "", "Target format (json or jsonl)")
+	convertCmd.Flags().StringVarP(&convertOutput, "to", "t", "", "Target format (json, jsonl, msgpack or csv, nested objects becoming dotted columns)")
 	convertCmd.Flags().BoolVar(&convertPretty, "pretty", true, "Pretty print output")
+	convertCmd.Flags().StringVar(&convertOutDir, "out-dir", "", "Output directory when converting a directory")
+	convertCmd.Flags().BoolVarP(&convertRecursive, "recursive", "r", false, "Convert files in subdirectories too")
+	convertCmd.Flags().IntVarP(&convertJobs, "jobs", "j", runtime.NumCPU(), "Number of files converted in parallel")
+	convertCmd.Flags().BoolVarP(&convertForce, "force", "f", false, "Overwrite existing files in --out-dir")
 	convertCmd.MarkFlagRequired("to")
 }
 
@@ -42,20 +69,222 @@ func runConvert(cmd *cobra.Command, args []string) error {
 		filename = args[0]
 	}
 
-	p, err := parser.NewParser(filename)
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		return convertDir(filename)
+	}
+
+	p, err := newParser(filename)
 	if err != nil {
 		return err
 	}
 	defer p.Close()
 
-	records, err := p.ReadAll()
+	records, err := p.ReadAllOrdered()
+	if err != nil {
+		return err
+	}
+
+	return writeConverted(os.Stdout, records, convertOutput, convertPretty)
+}
+
+// writeConverted writes records in a target format: jsonl, msgpack, csv,
+// else JSON
+func writeConverted[R parser.Object](w io.Writer, records []R, format string, pretty bool) error {
+	var sink database.Sink
+	switch format {
+	case "jsonl":
+		sink = database.NewJSONLinesSink(w, pretty)
+	case "msgpack":
+		sink = database.NewMsgpackSink(w)
+	case "csv":
+		sink = database.NewCSVSink(w)
+	default:
+		sink = database.NewJSONArraySink(w, pretty)
+	}
+	for _, record := range records {
+		if err := sink.Write(database.NewJSONRow(record)); err != nil {
+			return err
+		}
+		diag.Counters().Emitted.Add(1)
+	}
+	return sink.Close()
+}
+
+// convertResult is the outcome of converting one file of a directory
+type convertResult struct {
+	src, dst string
+	err      error
+}
+
+// convertDir converts every supported file under dir into convertOutDir and
+// prints a per-file summary to stderr
+func convertDir(dir string) error {
+	if convertOutDir == "" {
+		return fmt.Errorf("--out-dir is required when converting a directory")
+	}
+	if err := checkWritable("convert --out-dir"); err != nil {
+		return err
+	}
+	ext := "." + strings.ToLower(convertOutput)
+	if ext != ".json" && ext != ".jsonl" && ext != ".msgpack" && ext != ".csv" {
+		return fmt.Errorf("unsupported target format '%s' (use json, jsonl, msgpack or csv)", convertOutput)
+	}
+	if err := checkOutDir(dir, convertOutDir); err != nil {
+		return err
+	}
+	jobs := convertJobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	files, err := convertibleFiles(dir)
 	if err != nil {
 		return err
 	}
 
-	// Output in target format
-	if convertOutput == "jsonl" {
-		return parser.WriteJSONL(os.Stdout, records, convertPretty)
+	results := make([]convertResult, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = convertFile(dir, files[i], ext)
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			diag.Warn(diag.CodeFileFailed, fmt.Sprintf("FAIL %s: %v", r.src, r.err), "file", r.src, "error", r.err.Error())
+		} else {
+			diag.Info(diag.CodeFileConverted, fmt.Sprintf("ok   %s -> %s", r.src, r.dst), "file", r.src, "output", r.dst)
+		}
+	}
+	diag.Info(diag.CodeSummary, fmt.Sprintf("%d file(s) converted, %d failed", len(results)-failed, failed),
+		"converted", len(results)-failed, "failed", failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed to convert", failed, len(results))
+	}
+	return nil
+}
+
+// checkOutDir fails when outDir is dir or inside it: the workers would write
+// into the files they are reading, or convert their own outputs
+func checkOutDir(dir, outDir string) error {
+	src, err := resolvePath(dir)
+	if err != nil {
+		return err
+	}
+	dst, err := resolvePath(outDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(src, dst)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("--out-dir %s must be outside the converted directory %s", outDir, dir)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of a file following symbolic links,
+// as far as it exists
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Join(append([]string{abs}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(abs)}, missing...)
+		abs = parent
+	}
+}
+
+// convertibleFiles lists the .json/.jsonl/.msgpack files of dir (relative to it)
+func convertibleFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !convertRecursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".jsonl", ".msgpack", ".mpk":
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// convertFile converts dir/rel into the same relative path under convertOutDir with extension ext
+func convertFile(dir, rel, ext string) convertResult {
+	src := filepath.Join(dir, rel)
+	dst := filepath.Join(convertOutDir, strings.TrimSuffix(rel, filepath.Ext(rel))+ext)
+	r := convertResult{src: src, dst: dst}
+
+	p, err := newParser(src)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer p.Close()
+
+	records, err := p.ReadAllOrdered()
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		r.err = err
+		return r
+	}
+	// Without --force an existing file, e.g. the output of another input
+	// of the same name, is kept
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !convertForce {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(dst, flags, 0o666)
+	if errors.Is(err, fs.ErrExist) {
+		err = fmt.Errorf("%s already exists (use --force to overwrite it)", dst)
+	}
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	// JSON Lines files stay one record per line regardless of --pretty
+	err = writeConverted(f, records, strings.TrimPrefix(ext, "."), convertPretty && ext == ".json")
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-	return parser.WriteJSON(os.Stdout, records, convertPretty)
+	r.err = err
+	return r
 }
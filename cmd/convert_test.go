@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertDir(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.json", `[{"id":1},{"id":2}]`)
+	writeFile(t, src, "b.jsonl", "{\"id\":3}\n")
+	writeFile(t, src, "notes.txt", "skipped")
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "sub"), "c.json", `{"id":4}`)
+
+	out := filepath.Join(t.TempDir(), "out")
+	if _, err := runCLI(t, "convert", src, "--to", "jsonl", "--out-dir", out); err != nil {
+		t.Fatalf("convert failed: %v", err)
+	}
+	for name, expected := range map[string]string{
+		"a.jsonl": "{\"id\":1}\n{\"id\":2}\n",
+		"b.jsonl": "{\"id\":3}\n",
+	} {
+		if got := readFile(t, filepath.Join(out, name)); got != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "sub")); !os.IsNotExist(err) {
+		t.Errorf("Expected subdirectories to be skipped without --recursive, got %v", err)
+	}
+
+	// Existing outputs are kept unless forced
+	writeFile(t, out, "a.jsonl", "kept\n")
+	if _, err := runCLI(t, "convert", src, "--to", "jsonl", "--out-dir", out); err == nil {
+		t.Error("Expected an error converting over existing files")
+	}
+	if got := readFile(t, filepath.Join(out, "a.jsonl")); got != "kept\n" {
+		t.Errorf("Expected the existing file to be kept, got %q", got)
+	}
+	if _, err := runCLI(t, "convert", src, "--to", "jsonl", "--out-dir", out, "--recursive", "--force"); err != nil {
+		t.Fatalf("convert --force failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(out, "a.jsonl")); got != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Errorf("Expected --force to overwrite a.jsonl, got %q", got)
+	}
+	if got := readFile(t, filepath.Join(out, "sub", "c.jsonl")); got != "{\"id\":4}\n" {
+		t.Errorf("Expected --recursive to convert sub/c.json, got %q", got)
+	}
+
+	// Two inputs converted to the same output
+	clash := t.TempDir()
+	writeFile(t, clash, "d.json", `{"id":5}`)
+	writeFile(t, clash, "d.jsonl", "{\"id\":6}\n")
+	_, err := runCLI(t, "convert", clash, "--to", "json", "--out-dir", filepath.Join(t.TempDir(), "out"))
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("Expected one of two clashing files to fail, got %v", err)
+	}
+}
+
+func TestConvertOutDirInside(t *testing.T) {
+	src := t.TempDir()
+	input := writeFile(t, src, "a.json", `{"id":1}`)
+	for _, args := range [][]string{
+		{"--out-dir", src, "--force"},
+		{"--out-dir", filepath.Join(src, ".")},
+		{"--out-dir", filepath.Join(src, "out")},
+		{"--out-dir", filepath.Join(src, "out"), "--recursive"},
+	} {
+		_, err := runCLI(t, append([]string{"convert", src, "--to", "jsonl"}, args...)...)
+		if err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("%q: expected the out-dir to be refused, got %v", args, err)
+		}
+	}
+	if got := readFile(t, input); got != `{"id":1}` {
+		t.Errorf("Input changed to %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(src, "out")); !os.IsNotExist(err) {
+		t.Errorf("Expected no output directory to be created, got %v", err)
+	}
+
+	// A sibling directory sharing the name prefix is outside
+	sibling := src + "-out"
+	defer os.RemoveAll(sibling)
+	if _, err := runCLI(t, "convert", src, "--to", "jsonl", "--out-dir", sibling); err != nil {
+		t.Errorf("convert into a sibling directory failed: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var delCmd = &cobra.Command{
+	Use:   "del [file|-] path [path...]",
+	Short: "Delete keys at paths from every record",
+	Long: `Delete the values at one or more paths from every record and print the
+modified documents. Use * to reach every element of an array, e.g. to strip
+fields before sharing data. Paths that do not exist are ignored.
+
+Supports:
+  - File paths: jsl del data.json .user.password
+  - Stdin: cat data.json | jsl del .user.password (or use "-")
+
+Examples:
+  jsl del data.json .user.password
+  jsl del users.jsonl '.users.*.password' .token
+  jsl del data.json '.tags[0]'`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runDel,
+}
+
+func runDel(cmd *cobra.Command, args []string) error {
+	// Paths start with a dot, anything before them is the input
+	filename := "-"
+	if len(args) > 1 && !strings.HasPrefix(args[0], ".") {
+		filename, args = args[0], args[1:]
+	}
+	return RunDel(filename, args, QueryPretty)
+}
+
+// RunDel deletes paths from every record of filename and writes the records
+// to stdout in the input format
+func RunDel(filename string, paths []string, pretty bool) error {
+	p, err := newParser(filename)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	records, err := p.ReadAllOrdered()
+	if err != nil {
+		return err
+	}
+
+	for i := range records {
+		for _, path := range paths {
+			if records[i], err = query.DeleteOrdered(records[i], path); err != nil {
+				return err
+			}
+		}
+	}
+	return writeRecords(p, records, pretty)
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/bisegni/jsl/pkg/query"
 	"github.com/spf13/cobra"
@@ -18,6 +20,8 @@ var (
 	filterPretty     bool
 	filterFormat     string
 	filterExpression string
+	filterWhere      []string
+	filterAny        bool
 )
 
 var filterCmd = &cobra.Command{
@@ -28,20 +32,29 @@ var filterCmd = &cobra.Command{
 Supports two syntax styles:
 1. Expression style (recommended): jsl filter data.json age>28
 2. Flag style (verbose): jsl filter data.json --field age --op ">" --value 28
+   or, for several conditions: jsl filter data.json --where 'age>28' --where 'city=Rome'
 
-Expression operators: =, !=, >, >=, <, <=, ~= (contains)
+Expression operators: =, !=, >, >=, <, <=, ~= (contains). Conditions
+combine with AND, OR, NOT and parentheses as in a WHERE clause.
+
+Conditions on arrays match if any element matches. Wrap the field in
+ALL(...) or NONE(...) to require every element or no element to match.
 
 Examples:
   # Expression style (concise)
   jsl filter data.json age>28
   jsl filter data.jsonl status=active
   jsl filter data.json name~=john
+  jsl filter data.json "ALL(scores)>50"
+  jsl filter data.json "(age>28 AND city='NY') OR vip=true"
   cat data.json | jsl filter - age>=30
   cat data.json | jsl filter age>=30
   
   # Flag style (verbose)
   jsl filter data.json --field age --op ">" --value 28
-  jsl filter data.jsonl --field status --op "=" --value active`,
+  jsl filter data.jsonl --field status --op "=" --value active
+  jsl filter data.json --where 'age>28' --where 'status=active'
+  jsl filter data.json --where 'age<18' --where 'age>65' --any`,
 	Args: cobra.RangeArgs(0, 2),
 	RunE: runFilter,
 }
@@ -50,6 +63,8 @@ func init() {
 	filterCmd.Flags().StringVarP(&filterField, "field", "f", "", "Field path to filter on")
 	filterCmd.Flags().StringVarP(&filterOperator, "op", "o", "=", "Operator (=, !=, >, >=, <, <=, contains)")
 	filterCmd.Flags().StringVarP(&filterValue, "value", "v", "", "Value to compare against")
+	filterCmd.Flags().StringArrayVar(&filterWhere, "where", nil, "Filter condition (e.g., 'age>28'); repeat to require all of them")
+	filterCmd.Flags().BoolVar(&filterAny, "any", false, "Match records satisfying any --where condition instead of all")
 	filterCmd.Flags().BoolVar(&filterPretty, "pretty", true, "Pretty print output")
 	filterCmd.Flags().StringVar(&filterFormat, "format", "json", "Output format (json or jsonl)")
 }
@@ -60,37 +75,107 @@ func IsFilterExpression(expr string) bool {
 	return query.IsFilterExpression(expr)
 }
 
-func RunFilter(filename string, field, operator, value string, pretty bool, extract bool, selectFields []string, format string) error {
+func RunFilter(filename string, expr *query.FilterExpr, pretty bool, extract bool, selectFields []string, format string) error {
+	f, err := newFilter(expr)
+	if err != nil {
+		return err
+	}
+	return RunFilterExpression(filename, &query.Condition{Filter: f}, pretty, extract, selectFields, format)
+}
+
+// newFilter builds the filter of a parsed condition, applying --ci and the
+// --array-match default
+func newFilter(expr *query.FilterExpr) (*query.Filter, error) {
 	// Validate we have all required fields
-	if field == "" || value == "" {
-		return fmt.Errorf("field and value are required")
+	if expr.Field == "" || expr.Value == "" {
+		return nil, fmt.Errorf("field and value are required")
+	}
+	quantifier := expr.Quantifier
+	if quantifier == "" {
+		var err error
+		if quantifier, err = defaultQuantifier(); err != nil {
+			return nil, err
+		}
 	}
 
-	p, err := parser.NewParser(filename)
-	if err != nil {
-		return err
+	f := expr.Filter()
+	f.CaseInsensitive = QueryCI
+	f.IgnoreCase = QueryIgnoreCase
+	f.Quantifier = quantifier
+	return f, nil
+}
+
+// defaultQuantifier returns the quantifier of conditions on arrays that do
+// not choose one, set by --array-match
+func defaultQuantifier() (string, error) {
+	if !query.IsQuantifier(QueryArrayMatch) {
+		return "", fmt.Errorf("invalid --array-match %q (use any, all or none)", QueryArrayMatch)
 	}
-	defer p.Close()
+	return strings.ToUpper(QueryArrayMatch), nil
+}
 
-	records, err := p.ReadAll()
+// parseFilterArg parses a filter argument with the WHERE grammar, so that
+// boolean logic works outside SQL. Single conditions whose value the grammar
+// does not accept unquoted (email=a@b.c, day>2024-01-31) fall back to the
+// field/op/value form.
+func parseFilterArg(arg string) (query.Expression, error) {
+	quantifier, err := defaultQuantifier()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	expr, err := query.ParseFilter(arg)
+	if err != nil {
+		fe := query.ParseFilterExpression(arg)
+		if fe == nil {
+			return nil, fmt.Errorf("invalid filter expression: %s: %w", arg, err)
+		}
+		f, ferr := newFilter(fe)
+		if ferr != nil {
+			return nil, ferr
+		}
+		return &query.Condition{Filter: f}, nil
+	}
+	query.SetCaseInsensitive(expr, QueryCI)
+	query.SetIgnoreCase(expr, QueryIgnoreCase)
+	query.SetDefaultQuantifier(expr, quantifier)
+	return expr, nil
+}
 
-	// Parse filter value
-	var filterVal interface{}
-	filterVal = value
+// whereExpression compiles repeated --where conditions into an expression
+// tree, requiring all of them or, with any set, at least one
+func whereExpression(conditions []string, any bool) (query.Expression, error) {
+	var tree query.Expression
+	for _, cond := range conditions {
+		leaf, err := parseFilterArg(cond)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tree == nil:
+			tree = leaf
+		case any:
+			tree = &query.OrExpression{Left: tree, Right: leaf}
+		default:
+			tree = &query.AndExpression{Left: tree, Right: leaf}
+		}
+	}
+	return tree, nil
+}
 
-	// Try to parse as number
-	if val, err := parseNumber(value); err == nil {
-		filterVal = val
+// RunFilterExpression outputs the records of filename matching expr
+func RunFilterExpression(filename string, expr query.Expression, pretty bool, extract bool, selectFields []string, format string) error {
+	records, err := readRecords(filename)
+	if err != nil {
+		return err
 	}
 
-	f := query.NewFilter(field, operator, filterVal)
 	var filtered []parser.Record
 
+	expr = query.CompileExpression(expr)
 	for _, record := range records {
-		if f.Match(record) {
+		matched := expr.Evaluate(record)
+		diag.Counters().Match(matched)
+		if matched {
 			if len(selectFields) > 0 {
 				pruned := make(parser.Record)
 				for _, fld := range selectFields {
@@ -98,6 +183,9 @@ func RunFilter(filename string, field, operator, value string, pretty bool, extr
 						pruned[fld] = val
 					}
 				}
+				if source, ok := record[parser.SourceField]; ok {
+					pruned[parser.SourceField] = source
+				}
 				filtered = append(filtered, pruned)
 			} else {
 				filtered = append(filtered, record)
@@ -105,81 +193,89 @@ func RunFilter(filename string, field, operator, value string, pretty bool, extr
 		}
 	}
 
+	if QueryFlatten {
+		for i, record := range filtered {
+			filtered[i] = parser.Record(database.Flatten(record).(database.OrderedMap).ToMap())
+		}
+	}
+
 	// Output filtered records
 	if extract {
 		encoder := json.NewEncoder(os.Stdout)
 		if pretty {
 			encoder.SetIndent("", "  ")
 		}
-		return encoder.Encode(filtered)
+		if err := encoder.Encode(filtered); err != nil {
+			return err
+		}
+		diag.Counters().Emitted.Add(int64(len(filtered)))
+		return nil
 	}
 
-	if strings.ToLower(format) == "jsonl" {
-		return parser.WriteJSONL(os.Stdout, filtered, pretty)
-	}
-	return parser.WriteJSON(os.Stdout, filtered, pretty)
+	return writeConverted(os.Stdout, filtered, strings.ToLower(format), pretty)
 }
 
 func runFilter(cmd *cobra.Command, args []string) error {
 	var filename string
-	var field, operator, value string
+
+	// flagFilter builds the filter from --field, --op and --value
+	flagFilter := func() *query.FilterExpr {
+		quantifier, field := query.SplitQuantifier(filterField)
+		return &query.FilterExpr{Field: field, Operator: filterOperator, Value: filterValue, Quantifier: quantifier}
+	}
+
+	// Repeated --where conditions
+	if len(filterWhere) > 0 {
+		if len(args) > 1 {
+			return fmt.Errorf("--where cannot be combined with an expression argument")
+		}
+		filename = "-"
+		if len(args) == 1 {
+			filename = args[0]
+		}
+		tree, err := whereExpression(filterWhere, filterAny)
+		if err != nil {
+			return err
+		}
+		return RunFilterExpression(filename, tree, filterPretty, false, QuerySelect, filterFormat)
+	}
 
 	// Parse arguments
+	var expr query.Expression
+	var err error
 	if len(args) == 0 {
 		// Reading from stdin, check for expression in flags
 		filename = "-"
 		if filterField == "" {
 			return fmt.Errorf("when reading from stdin, provide filter expression or use --field, --op, --value flags")
 		}
-		field = filterField
-		operator = filterOperator
-		value = filterValue
+		return RunFilter(filename, flagFilter(), filterPretty, false, QuerySelect, filterFormat)
 	} else if len(args) == 1 {
 		// One argument: could be filename or expression
 		arg := args[0]
 
 		// Check if it's an expression (contains operator)
-		if expr := query.ParseFilterExpression(arg); expr != nil {
+		if query.IsFilterExpression(arg) {
 			// It's an expression, read from stdin
 			filename = "-"
-			field = expr.Field
-			operator = expr.Operator
-			value = expr.Value
+			if expr, err = parseFilterArg(arg); err != nil {
+				return err
+			}
 		} else if filterField != "" {
 			// It's a filename with flags
-			filename = arg
-			field = filterField
-			operator = filterOperator
-			value = filterValue
+			return RunFilter(arg, flagFilter(), filterPretty, false, QuerySelect, filterFormat)
 		} else {
 			return fmt.Errorf("provide filter expression (e.g., age>28) or use --field, --op, --value flags")
 		}
 	} else if len(args) == 2 {
 		// Two arguments: filename and expression
 		filename = args[0]
-		expr := query.ParseFilterExpression(args[1])
-		if expr == nil {
-			return fmt.Errorf("invalid filter expression: %s (use format: field>value)", args[1])
+		if expr, err = parseFilterArg(args[1]); err != nil {
+			return err
 		}
-		field = expr.Field
-		operator = expr.Operator
-		value = expr.Value
 	} else {
 		return fmt.Errorf("too many arguments")
 	}
 
-	return RunFilter(filename, field, operator, value, filterPretty, false, QuerySelect, filterFormat)
-}
-
-func parseNumber(s string) (interface{}, error) {
-	var val interface{}
-	if err := json.Unmarshal([]byte(s), &val); err != nil {
-		return nil, err
-	}
-	switch val.(type) {
-	case float64, int, int64:
-		return val, nil
-	default:
-		return nil, fmt.Errorf("not a number")
-	}
+	return RunFilterExpression(filename, expr, filterPretty, false, QuerySelect, filterFormat)
 }
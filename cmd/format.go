@@ -9,7 +9,7 @@ import (
 
 var (
 	formatPretty bool
-	formatOutput string
+	formatTo     string
 )
 
 var formatCmd = &cobra.Command{
@@ -23,7 +23,7 @@ Supports:
 
 Examples:
   jsl format data.json
-  jsl format data.jsonl --output jsonl
+  jsl format data.jsonl --to jsonl
   cat data.json | jsl format
   echo '{"name":"Alice"}' | jsl format`,
 	Args: cobra.MaximumNArgs(1),
@@ -32,7 +32,7 @@ Examples:
 
 func init() {
 	formatCmd.Flags().BoolVar(&formatPretty, "pretty", true, "Pretty print output")
-	formatCmd.Flags().StringVarP(&formatOutput, "output", "o", "", "Output format (json or jsonl, auto-detect if not specified)")
+	formatCmd.Flags().StringVarP(&formatTo, "to", "t", "", "Output format (json or jsonl, auto-detect if not specified)")
 }
 
 func runFormat(cmd *cobra.Command, args []string) error {
@@ -41,19 +41,19 @@ func runFormat(cmd *cobra.Command, args []string) error {
 		filename = args[0]
 	}
 
-	p, err := parser.NewParser(filename)
+	p, err := newParser(filename)
 	if err != nil {
 		return err
 	}
 	defer p.Close()
 
-	records, err := p.ReadAll()
+	records, err := p.ReadAllOrdered()
 	if err != nil {
 		return err
 	}
 
 	// Determine output format
-	outputFormat := formatOutput
+	outputFormat := formatTo
 	if outputFormat == "" {
 		// Auto-detect from input
 		if p.IsJSONL() {
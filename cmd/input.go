@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/planner"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// openTable returns the table of an input argument (database.OpenInput),
+// read with the input options of the command line
+func openTable(filename string) (database.Table, error) {
+	return database.OpenInput(filename, inputOptions())
+}
+
+// fromResolver resolves the tables named by FROM: the tables of catalog
+// (the REPL's, nil otherwise), then the sources of registered providers,
+// files and glob patterns ('other.jsonl'). Other names scan the input
+// argument.
+func fromResolver(catalog *database.Catalog) *planner.Resolver {
+	return &planner.Resolver{Catalog: catalog, Open: openFrom}
+}
+
+// openFrom returns the table of a FROM naming a provider source or files,
+// or nil (database.OpenFrom)
+func openFrom(name string) (database.Table, error) {
+	return database.OpenFrom(name, inputOptions())
+}
+
+// inputOptions returns the input options of the command line: --provenance,
+// --lenient, --skip-errors, --root and the --max-* limits
+func inputOptions() database.InputOptions {
+	return database.InputOptions{
+		Sources:    QueryProvenance,
+		Lenient:    QueryLenient,
+		SkipErrors: skipHandler(),
+		Root:       rootPath(),
+		Limits:     inputLimits(),
+	}
+}
+
+// rootPath returns the keys of the --root path, or nil without it
+func rootPath() []string {
+	if QueryRoot == "" {
+		return nil
+	}
+	return query.SplitPath(QueryRoot)
+}
+
+// newParser returns a parser of an input argument, lenient under --lenient,
+// skipping malformed lines under --skip-errors, streaming the array at
+// --root and bounded by the --max-* limits
+func newParser(filename string) (*parser.Parser, error) {
+	p, err := parser.NewParser(filename)
+	if err != nil {
+		return nil, err
+	}
+	if QueryLenient {
+		p.Lenient()
+	}
+	if handler := skipHandler(); handler != nil {
+		p.SkipErrors(handler)
+	}
+	if root := rootPath(); root != nil {
+		p.Root(root)
+	}
+	if err := p.SetLimits(inputLimits()); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// readValues reads all top-level values of an input argument, the files
+// matched by a glob pattern one after the other. Objects are read as
+// OrderedMap, keeping their keys in document order.
+func readValues(filename string) ([]interface{}, error) {
+	return readInput(filename, false, true)
+}
+
+// readRecords reads all records of an input argument like readValues, which
+// must all be objects, adding their sources under --provenance
+func readRecords(filename string) ([]parser.Record, error) {
+	values, err := readInput(filename, QueryProvenance, false)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]parser.Record, 0, len(values))
+	for _, v := range values {
+		record, ok := v.(parser.Record)
+		if !ok && v != nil {
+			return nil, fmt.Errorf("expected a JSON object, got %s", parser.TypeOf(v))
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func readInput(filename string, sources, ordered bool) ([]interface{}, error) {
+	files, err := database.ExpandPattern(filename)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, file := range files {
+		err := func() error {
+			p, err := newParser(file)
+			if err != nil {
+				return err
+			}
+			defer p.Close()
+			if sources {
+				p.TrackSources()
+			}
+			if ordered {
+				p.PreserveOrder()
+			}
+			v, err := p.ReadAllValues()
+			values = append(values, v...)
+			return err
+		}()
+		if err != nil {
+			if len(files) > 1 {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// spoolStdin copies stdin to a temporary file, for inputs read more than
+// once (every query of the REPL reopens its input). It returns the file
+// name and a function removing the file.
+func spoolStdin() (string, func(), error) {
+	file, err := os.CreateTemp("", "jsl-stdin-*.json")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	remove := func() { os.Remove(file.Name()) }
+	if _, err := io.Copy(file, os.Stdin); err != nil {
+		file.Close()
+		remove()
+		return "", nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return file.Name(), remove, nil
+}
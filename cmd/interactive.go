@@ -1,70 +1,77 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
 
 	"github.com/bisegni/jsl/pkg/database"
-	"github.com/bisegni/jsl/pkg/engine"
-	"github.com/bisegni/jsl/pkg/plan"
-	"github.com/bisegni/jsl/pkg/planner"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/query"
 	"github.com/chzyer/readline"
 )
 
 func RunInteractive(filename string) error {
-	fmt.Println("Interactive mode enabled. Type 'exit' or 'quit' to leave.")
+	fmt.Println("Interactive mode enabled. Type 'exit' or 'quit' to leave, '\\dt' to list tables, '\\tree [path]' to show the data structure, '\\reload' to reread the input.")
 	if filename == "-" {
 		fmt.Println("Reading from stdin...")
 	} else {
 		fmt.Printf("Reading from file: %s\n", filename)
 	}
 
-	// For interactive mode, we likely want to load the data once if possible,
-	// OR create a table that can be queried repeatedly.
-	// Since JSONTable implementation in root.go (and pkg/database) seems to re-parse on Iterate,
-	// this is fine for now. If performance is an issue, we'd cache it.
-	// However, for stdin ("-"), re-reading isn't possible unless buffered.
-	// pkg/database/json_table.go uses parser.NewParser(filename).
-	// If filename is "-", parser might consume stdin.
-
-	// TODO: For "-" (stdin), we might need to buffer it into a temp file or memory
-	// if we want to query it multiple times.
-	// Let's assume for this iteration that we rely on the existing infrastructure.
-	// If the parser reads stdin once, subsequent queries might fail on "-".
-	// Let's check: can we re-read stdin? No.
-	// So for interactive mode with stdin, we MUST read it into memory or a temp file first.
-	// OR, we just warn user: "Single pass on stdin not supported for multiple queries"
-	// BUT, the request implies "write query without exit", so we probably need to handle this.
-
-	// Let's load the table first.
-	// To support multiple queries on the same data, especially from stdin,
-	// we should probably verify if we can re-iterate.
-	// Since we don't have a "MemoryTable" yet exposed easily here without importing internal parser structs,
-	// let's stick to the simplest implementation:
-	// 1. Create Input Table
-	// 2. Loop REPL
-
-	// WARN: If filename is "-", the first query will consume it. Subsequent queries will find EOF.
-	// We might need a "BufferedJSONTable" or similar if we want to fix that,
-	// but for this task "add interactive mode", let's start with the REPL loop.
-	// We can add a warning for stdin users if needed, or maybe the user just wants to type one query?
-	// No, "write query without exit" implies multiple queries.
-	// We'll proceed with standard `database.NewJSONTable(filename)` and see.
-
-	rl, err := readline.NewEx(&readline.Config{
+	// Every query reopens its input, which stdin does not allow: spool it
+	// to a temporary file, and read the queries from the terminal instead
+	source := filename
+	config := &readline.Config{
 		Prompt:          "> ",
 		HistoryFile:     "", // In-memory history for this session
 		InterruptPrompt: "^C",
 		EOFPrompt:       "exit",
-	})
+	}
+	if filename == "-" {
+		spooled, remove, err := spoolStdin()
+		if err != nil {
+			return err
+		}
+		defer remove()
+		filename, source = spooled, "<stdin>"
+
+		tty, err := terminalInput(config)
+		if err != nil {
+			return err
+		}
+		defer tty.Close()
+	}
+
+	catalog, err := newInteractiveCatalog(filename, source)
+	if err != nil {
+		return err
+	}
+
+	rl, err := readline.NewEx(config)
 	if err != nil {
 		return err
 	}
 	defer rl.Close()
 
+	// confirm asks a yes/no question, yes being the default
+	confirm := func(question string) bool {
+		rl.SetPrompt(question + " [Y/n] ")
+		defer rl.SetPrompt("> ")
+		answer, err := rl.Readline()
+		if err != nil {
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "" || answer == "y" || answer == "yes"
+	}
+
 	for {
 		line, err := rl.Readline()
 		if err == readline.ErrInterrupt {
@@ -88,57 +95,300 @@ func RunInteractive(filename string) error {
 		if strings.EqualFold(trimmed, "exit") || strings.EqualFold(trimmed, "quit") {
 			break
 		}
+		if trimmed == `\dt` {
+			printTables(os.Stdout, catalog)
+			continue
+		}
+		if trimmed == `\reload` {
+			if err := reloadCatalog(catalog, filename, source); err != nil {
+				diag.Error(err)
+			}
+			continue
+		}
+		if path, ok := treeCommand(trimmed); ok {
+			if err := printTree(os.Stdout, catalog, path); err != nil {
+				diag.Error(err)
+			}
+			continue
+		}
+
+		// Process Query; Ctrl-C cancels it, not the session
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = executeInteractiveQuery(ctx, filename, trimmed, catalog, confirm)
+		stop()
+		if errors.Is(err, context.Canceled) {
+			diag.Error(fmt.Errorf("query cancelled"))
+		} else if err != nil {
+			diag.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// terminalInput makes readline read from the terminal (/dev/tty) rather
+// than stdin, which carries the data
+func terminalInput(config *readline.Config) (io.Closer, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, fmt.Errorf("interactive mode on stdin needs a terminal to read queries from: %w", err)
+	}
+	fd := int(tty.Fd())
+	var state *readline.State
+	config.Stdin = readline.NewCancelableStdin(tty)
+	config.FuncIsTerminal = func() bool {
+		return readline.IsTerminal(fd) && readline.IsTerminal(int(os.Stdout.Fd()))
+	}
+	config.FuncMakeRaw = func() error {
+		var err error
+		state, err = readline.MakeRaw(fd)
+		return err
+	}
+	config.FuncExitRaw = func() error {
+		if state == nil {
+			return nil
+		}
+		return readline.Restore(fd, state)
+	}
+	return tty, nil
+}
+
+// describeSampleSize bounds how many rows \dt scans to infer a table schema
+const describeSampleSize = 1000
+
+// newInteractiveCatalog registers the REPL input as the default table,
+// listed by \dt under source
+func newInteractiveCatalog(filename, source string) (*database.Catalog, error) {
+	catalog := database.NewCatalog()
+	if err := registerInput(catalog, filename, source); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// registerInput registers the REPL input as the default table. An input no
+// larger than --memory-limit is cached by the first query reading it whole,
+// later SELECTs querying the cached rows.
+func registerInput(catalog *database.Catalog, filename, source string) error {
+	table, err := openTable(filename)
+	if err != nil {
+		return err
+	}
+	if stat, err := os.Stat(filename); err == nil && (QueryMemoryLimit == 0 || stat.Size() <= int64(QueryMemoryLimit)) {
+		table = database.NewMemoryTable(table)
+	}
+	catalog.RegisterTableWithInfo("default", table, describeInput(table, filename, source))
+	return nil
+}
+
+// describeInput returns the metadata of the table of an input argument,
+// listed under source: its format, its schema and row count inferred from
+// its first describeSampleSize rows, the rows of a larger file estimated
+// from its size
+func describeInput(table database.Table, filename, source string) database.TableInfo {
+	info := database.TableInfo{
+		Source:      source,
+		Format:      getFormat(strings.HasSuffix(filename, ".jsonl")),
+		RowEstimate: -1,
+		Size:        -1,
+	}
+
+	if schema, count, err := database.Describe(table, describeSampleSize); err == nil {
+		info.Schema = schema
+		info.RowEstimate = count
+		info.RowsExact = count < describeSampleSize
+		if stat, err := os.Stat(filename); err == nil {
+			info.Size = stat.Size()
+			if !info.RowsExact {
+				if rows, exact, err := database.EstimateRows(table, info.Size, describeSampleSize); err == nil {
+					info.RowEstimate, info.RowsExact = rows, exact
+				}
+			}
+		}
+	}
+	return info
+}
+
+// reloadCatalog drops the cached rows of the REPL input and describes it
+// again (REPL \reload)
+func reloadCatalog(catalog *database.Catalog, filename, source string) error {
+	if err := registerInput(catalog, filename, source); err != nil {
+		return err
+	}
+	fmt.Printf("Reloaded %s\n", source)
+	return nil
+}
+
+// printTables lists the catalog tables (REPL \dt)
+func printTables(w io.Writer, catalog *database.Catalog) {
+	for _, info := range catalog.List() {
+		rows := "?"
+		if info.RowsExact {
+			rows = fmt.Sprintf("%d", info.RowEstimate)
+		} else if info.RowEstimate >= 0 {
+			rows = fmt.Sprintf("~%d", info.RowEstimate)
+		}
+		fmt.Fprintf(w, "%s\tsource: %s\tformat: %s\trows: %s\n", info.Name, info.Source, info.Format, rows)
 
-		// Process Query
-		if err := executeInteractiveQuery(filename, trimmed); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fields := make([]string, 0, len(info.Schema))
+		for field := range info.Schema {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fmt.Fprintf(w, "  %s: %s\n", field, info.Schema[field])
 		}
 	}
+}
+
+// treeCommand parses "\tree [path]", returning the path ("" for whole records)
+func treeCommand(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, `\tree`)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
 
+// treeSampleWidth bounds the length of the sample values printed by \tree
+const treeSampleWidth = 40
+
+// printTree renders the structure inferred from the default table under
+// path as an indented tree with types and sample values (REPL \tree).
+// Fields missing from some objects are marked with "?".
+func printTree(w io.Writer, catalog *database.Catalog, path string) error {
+	table, err := catalog.GetTable("default")
+	if err != nil {
+		return err
+	}
+	root, count, err := database.DescribeTree(table, strings.TrimPrefix(path, "."), describeSampleSize)
+	if err != nil {
+		return err
+	}
+	if root.Count == 0 {
+		return fmt.Errorf("path '%s' not found in the first %d record(s)", path, count)
+	}
+
+	if path == "" {
+		path = "."
+	}
+	fmt.Fprintf(w, "%s: %s%s\n", path, root.TypeString(), treeSample(root))
+	printTreeChildren(w, root, "  ")
 	return nil
 }
 
-func executeInteractiveQuery(filename, expression string) error {
+func printTreeChildren(w io.Writer, node *database.SchemaNode, indent string) {
+	for _, field := range node.Fields() {
+		name := field.Name
+		if field.Optional(node) {
+			name += "?"
+		}
+		fmt.Fprintf(w, "%s%s: %s%s\n", indent, name, field.TypeString(), treeSample(field))
+		printTreeChildren(w, field, indent+"  ")
+	}
+	if node.Elements != nil {
+		fmt.Fprintf(w, "%s*: %s%s\n", indent, node.Elements.TypeString(), treeSample(node.Elements))
+		printTreeChildren(w, node.Elements, indent+"  ")
+	}
+}
+
+// treeSample formats the sample value of a node, e.g. ` = "Alice"`
+func treeSample(node *database.SchemaNode) string {
+	if node.Sample == nil {
+		return ""
+	}
+	data, err := json.Marshal(node.Sample)
+	if err != nil {
+		return ""
+	}
+	sample := []rune(string(data))
+	if len(sample) > treeSampleWidth {
+		sample = append(sample[:treeSampleWidth-3], []rune("...")...)
+	}
+	return " = " + string(sample)
+}
+
+// displayLimit is the LIMIT offered for queries returning many rows
+const displayLimit = 100
+
+// scanWarning warns when a query has neither WHERE, LIMIT nor aggregation
+// and the row estimate of its table exceeds --scan-warn-rows, i.e. when it
+// is about to print a large part of the data
+func scanWarning(catalog *database.Catalog, q *query.SelectQuery) bool {
+	if ScanWarnRows <= 0 || q.Filter != nil || q.Limit != nil || q.GroupBy != "" || q.Into != "" || q.FromQuery != nil {
+		return false
+	}
+	for _, f := range q.Fields {
+		if f.Aggregate != "" {
+			return false
+		}
+	}
+	table := q.FromTable
+	if table == "" {
+		table = "default"
+	}
+	info, err := catalog.Info(table)
+	if err != nil || info.RowEstimate <= ScanWarnRows {
+		return false
+	}
+
+	rows := fmt.Sprintf("~%d", info.RowEstimate)
+	if info.RowsExact {
+		rows = fmt.Sprintf("%d", info.RowEstimate)
+	}
+	msg := fmt.Sprintf("query has no WHERE or LIMIT and will output %s rows", rows)
+	if info.Size >= 0 {
+		msg += fmt.Sprintf(" from %.1f MiB", float64(info.Size)/(1<<20))
+	}
+	diag.Warn(diag.CodeScanCost, msg, "table", table, "rows", info.RowEstimate, "bytes", info.Size)
+	return true
+}
+
+func executeInteractiveQuery(ctx context.Context, filename, expression string, catalog *database.Catalog, confirm func(string) bool) error {
 	// 1. Try SQL-like
-	if strings.HasPrefix(strings.ToUpper(expression), "SELECT") {
+	if hasStatementPrefix(expression, "SELECT") {
 		q, err := query.ParseQuery(expression)
 		if err != nil {
 			return fmt.Errorf("parse error: %w", err)
 		}
+		if err := applyQueryOptions(q); err != nil {
+			return err
+		}
+		if scanWarning(catalog, q) && confirm(fmt.Sprintf("Append LIMIT %d?", displayLimit)) {
+			limit := displayLimit
+			q.Limit = &limit
+		}
 
-		inputTable := database.NewJSONTable(filename)
-
-		// Create Plan
-		rootNode, err := planner.CreatePlan(q, inputTable)
+		// The input is read from the catalog, cached across queries
+		table, err := catalog.GetTable("default")
 		if err != nil {
-			return fmt.Errorf("planning error: %w", err)
+			return err
 		}
-
-		// Explain Mode (check global flag, though interactive might want per-query flag processing?)
-		// For simplicity, we use the global flag.
-		if QueryExplain {
-			fmt.Println("Execution Plan:")
-			fmt.Println(plan.FormatPlan(rootNode))
-			return nil
+		return runSelectTable(ctx, q, table, catalog)
+	}
+	if hasStatementPrefix(expression, "UPDATE") {
+		u, err := query.ParseUpdate(expression)
+		if err != nil {
+			return fmt.Errorf("parse error: %w", err)
 		}
-
-		executor := engine.NewExecutor()
-		executor.Pretty = QueryPretty
-		// We print to stdout
-		return executor.Execute(rootNode, os.Stdout)
+		return runUpdate(ctx, u, filename)
+	}
+	if hasStatementPrefix(expression, "DELETE") {
+		d, err := query.ParseDelete(expression)
+		if err != nil {
+			return fmt.Errorf("parse error: %w", err)
+		}
+		return runDelete(ctx, d, filename)
 	}
 
 	// 2. Try Filter Expression
 	if query.IsFilterExpression(expression) {
-		expr := query.ParseFilterExpression(expression)
-		if expr != nil {
-			// Reuse RunFilter from root.go or similar logic?
-			// RunFilter is in root.go but not exported? No, it's likely internal to package cmd.
-			// Let's check root.go again. It calls RunFilter.
-			// We can call RunFilter if it's in the same package (cmd).
-			// We need to pass the global flags: QueryPretty, QueryExtract, QuerySelect
-			return RunFilter(filename, expr.Field, expr.Operator, expr.Value, QueryPretty, QueryExtract, QuerySelect, "json")
+		expr, err := parseFilterArg(expression)
+		if err != nil {
+			return err
 		}
+		// We need to pass the global flags: QueryPretty, QueryExtract, QuerySelect
+		return RunFilterExpression(filename, expr, QueryPretty, QueryExtract, QuerySelect, "json")
 	}
 
 	// 3. Try Path Query
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrintTree(t *testing.T) {
+	resetFlags(rootCmd)
+	// JSON numbers are read as floats
+	input := writeFile(t, t.TempDir(), "data.jsonl", `{"id":1,"user":{"name":"ann","email":"ann@example.com"},"items":[{"sku":"x","qty":1},{"sku":"y"}],"v":1}
+{"id":2,"user":{"name":"bob"},"items":[],"v":"one"}
+{"id":3,"v":null}
+`)
+	catalog, err := newInteractiveCatalog(input, "data.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path     string
+		expected string
+	}{
+		{"", `.: object
+  id: float = 1
+  items?: array
+    *: object
+      qty?: float = 1
+      sku: string = "x"
+  user?: object
+    email?: string = "ann@example.com"
+    name: string = "ann"
+  v: float|string|null = 1
+`},
+		{".items", `.items: array
+  *: object
+    qty?: float = 1
+    sku: string = "x"
+`},
+	} {
+		var out bytes.Buffer
+		if err := printTree(&out, catalog, tt.path); err != nil {
+			t.Fatalf("%q: %v", tt.path, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.path, tt.expected, out.String())
+		}
+	}
+
+	if err := printTree(&bytes.Buffer{}, catalog, ".missing"); err == nil {
+		t.Error("Expected an error for a path found in no record")
+	}
+	if path, ok := treeCommand(`\tree  .user `); !ok || path != ".user" {
+		t.Errorf("treeCommand() = %q, %v", path, ok)
+	}
+	if _, ok := treeCommand(`\treex`); ok {
+		t.Error(`Expected \treex not to be \tree`)
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/plan"
+)
+
+// Limits on the inputs (see inputLimits)
+var (
+	QueryMaxRecordSize byteSize
+	QueryMaxRecords    int64
+	QueryMaxBytes      byteSize
+)
+
+// QueryMemoryLimit bounds the rows held by ORDER BY and GROUP BY
+var QueryMemoryLimit = byteSize(plan.DefaultMemoryLimit)
+
+// byteSize is a flag holding a number of bytes, with an optional unit: KB,
+// MB and GB are powers of 1000, K, KiB, M, MiB, G and GiB powers of 1024
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+func (s *byteSize) Set(value string) error {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	factor := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, factor = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix)), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q (e.g. 1048576, 512KiB or 64MB)", value)
+	}
+	*s = byteSize(n * factor)
+	return nil
+}
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Type() string {
+	return "size"
+}
+
+// inputLimits returns the limits set by --max-record-size, --max-records
+// and --max-bytes
+func inputLimits() parser.Limits {
+	return parser.Limits{
+		MaxRecordSize: int64(QueryMaxRecordSize),
+		MaxRecords:    QueryMaxRecords,
+		MaxBytes:      int64(QueryMaxBytes),
+	}
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/spf13/cobra"
+)
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup [file offset...]",
+	Short: "Print the raw records located by --provenance sources",
+	Long: `Print the records found at byte offsets of a file, as written in the
+file. Without arguments, read the results of a --provenance query from stdin
+and print the records their _source fields point to; an aggregated row
+prints every record of its group.
+
+Records read from stdin or inline JSON cannot be looked up.
+
+Examples:
+  jsl data.jsonl "SELECT MAX(price) AS max_price" --provenance | jsl lookup
+  jsl data.jsonl "price>100" --provenance | jsl lookup
+  jsl lookup data.jsonl 0 1523`,
+	RunE: runLookup,
+}
+
+func runLookup(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return RunLookupSources("-", os.Stdout)
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("lookup needs a file and at least one offset")
+	}
+	for _, arg := range args[1:] {
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("invalid offset %q", arg)
+		}
+		if err := writeRawRecord(os.Stdout, args[0], offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunLookupSources prints the records located by the _source fields of the
+// records of filename
+func RunLookupSources(filename string, w io.Writer) error {
+	p, err := parser.NewParser(filename)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	values, err := p.ReadAllValues()
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		record, ok := v.(parser.Record)
+		if !ok {
+			return fmt.Errorf("expected records with a %s field, got %s", parser.SourceField, parser.TypeOf(v))
+		}
+		var sources []interface{}
+		switch source := record[parser.SourceField].(type) {
+		case nil:
+			return fmt.Errorf("record without %s field (run the query with --provenance)", parser.SourceField)
+		case []interface{}:
+			sources = source
+		default:
+			sources = []interface{}{source}
+		}
+		for _, source := range sources {
+			file, offset, err := sourceLocation(source)
+			if err != nil {
+				return err
+			}
+			if err := writeRawRecord(w, file, offset); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sourceLocation returns the file and offset of a _source value
+func sourceLocation(source interface{}) (string, int64, error) {
+	m, ok := source.(map[string]interface{})
+	if !ok {
+		return "", 0, fmt.Errorf("invalid %s: %v", parser.SourceField, source)
+	}
+	file, _ := m["file"].(string)
+	offset, ok := m["offset"].(float64)
+	if file == "" || !ok {
+		return "", 0, fmt.Errorf("invalid %s: %v", parser.SourceField, source)
+	}
+	if file == "<stdin>" || file == "<inline>" {
+		return "", 0, fmt.Errorf("records read from %s cannot be looked up", file)
+	}
+	return file, int64(offset), nil
+}
+
+// writeRawRecord writes the record at offset of file as it appears there
+func writeRawRecord(w io.Writer, file string, offset int64) error {
+	raw, err := parser.ReadAt(file, offset)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", raw); err != nil {
+		return err
+	}
+	return nil
+}
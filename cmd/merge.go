@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/engine"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/spf13/cobra"
+)
+
+var mergeSortedBy string
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge --sorted-by field file...",
+	Short: "Merge files sorted by a field into one sorted JSONL stream",
+	Long: `Merge several files, each already sorted by a field, into a single JSONL
+stream sorted by that field. Only the current record of each file is held in
+memory, so arbitrarily large logs can be merged.
+
+Numbers compare numerically and RFC3339 timestamps chronologically, whatever
+their time zone. Records without the field are written as soon as they are
+read. Records with equal keys keep the order of the files on the command line.
+A file that is not sorted is reported with a warning; its records are still
+merged, but the output is then only partially ordered.
+
+Examples:
+  jsl merge --sorted-by ts a.jsonl b.jsonl c.jsonl
+  jsl merge --sorted-by meta.time app.jsonl db.jsonl > all.jsonl`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runMerge,
+}
+
+func init() {
+	mergeCmd.Flags().StringVar(&mergeSortedBy, "sorted-by", "", "Field the input files are sorted by (e.g., ts)")
+	mergeCmd.MarkFlagRequired("sorted-by")
+}
+
+func runMerge(cmd *cobra.Command, args []string) error {
+	return RunMerge(args, mergeSortedBy, QueryPretty)
+}
+
+// RunMerge performs a streaming k-way merge of files sorted by field and
+// writes the records to stdout as JSONL
+func RunMerge(files []string, field string, pretty bool) error {
+	inputs := make([]engine.MergeInput, len(files))
+	for i, name := range files {
+		p, err := newParser(name)
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		inputs[i] = engine.MergeInput{Name: name, Records: p}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	encoder := json.NewEncoder(out)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	merger := &engine.Merger{
+		Field:           field,
+		CaseInsensitive: QueryCI,
+		Unsorted: func(input string, key, last interface{}) {
+			diag.Warn(diag.CodeUnsorted, fmt.Sprintf("%s is not sorted by %s (%v after %v)", input, field, key, last),
+				"file", input, "field", field)
+		},
+	}
+	err := merger.Merge(inputs, func(record parser.OrderedMap) error {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		diag.Counters().Emitted.Add(1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
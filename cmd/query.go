@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/bisegni/jsl/pkg/query"
 	"github.com/spf13/cobra"
@@ -22,6 +26,7 @@ Supports:
 Examples:
   jsl query data.json .user.name
   jsl query data.jsonl .items.*.price
+  jsl query data.json '.name, .address.city'
   cat data.json | jsl query - .metadata
   echo '{"name":"Alice"}' | jsl query .name
   jsl query '{"user":{"name":"Alice"}}' .user.name`,
@@ -60,18 +65,24 @@ func init() {
 }
 
 func RunQuery(filename string, queryPath string, queryPretty bool, queryExtract bool, selectFields []string) error {
-	p, err := parser.NewParser(filename)
+	records, err := readValues(filename)
 	if err != nil {
 		return err
 	}
-	defer p.Close()
 
-	records, err := p.ReadAll()
-	if err != nil {
-		return err
+	if paths := query.SplitPaths(queryPath); len(paths) > 1 {
+		if queryExtract {
+			return fmt.Errorf("--extract cannot be combined with multiple paths")
+		}
+		if QueryWithPaths {
+			return fmt.Errorf("--with-paths cannot be combined with multiple paths")
+		}
+		return runMultiQuery(records, paths, queryPretty, selectFields)
 	}
 
 	q := query.NewQuery(queryPath)
+	q.CaseInsensitive = QueryCI
+	q.IgnoreCase = QueryIgnoreCase
 
 	// If path is "." or empty, apply selection to all records
 	if queryPath == "" || queryPath == "." {
@@ -89,9 +100,10 @@ func RunQuery(filename string, queryPath string, queryPretty bool, queryExtract
 			} else {
 				output = record
 			}
-			if err := encoder.Encode(output); err != nil {
+			if err := encoder.Encode(flattenOutput(output)); err != nil {
 				return err
 			}
+			diag.Counters().Emitted.Add(1)
 		}
 		return nil
 	}
@@ -104,7 +116,30 @@ func RunQuery(filename string, queryPath string, queryPretty bool, queryExtract
 		encoder.SetIndent("", "")
 	}
 
+	if QueryWithPaths {
+		return runWithPaths(records, q, encoder, selectFields)
+	}
+
+	pipeline := query.IsPipeline(queryPath)
 	for _, record := range records {
+		if pipeline && !queryExtract {
+			// Each output of a jq-style pipeline is a line, as in jq
+			outputs, err := q.ExtractEach(record)
+			if err != nil {
+				continue
+			}
+			for _, out := range outputs {
+				if len(selectFields) > 0 {
+					out = applySelection(out, selectFields)
+				}
+				if err := encoder.Encode(flattenOutput(out)); err != nil {
+					return err
+				}
+				diag.Counters().Emitted.Add(1)
+			}
+			continue
+		}
+
 		val, err := q.Extract(record)
 		if err != nil {
 			continue // Skip records where path doesn't exist
@@ -114,6 +149,14 @@ func RunQuery(filename string, queryPath string, queryPretty bool, queryExtract
 
 		if queryExtract {
 			switch v := val.(type) {
+			case database.OrderedMap:
+				for _, kv := range v {
+					if len(selectFields) > 0 {
+						resultsToPrint = append(resultsToPrint, applySelection(kv.Val, selectFields))
+					} else {
+						resultsToPrint = append(resultsToPrint, database.OrderedMap{kv})
+					}
+				}
 			case map[string]interface{}:
 				for k, subVal := range v {
 					if len(selectFields) > 0 {
@@ -144,17 +187,155 @@ func RunQuery(filename string, queryPath string, queryPretty bool, queryExtract
 		}
 
 		for _, res := range resultsToPrint {
-			if err := encoder.Encode(res); err != nil {
+			if err := encoder.Encode(flattenOutput(res)); err != nil {
 				return err
 			}
+			diag.Counters().Emitted.Add(1)
+		}
+	}
+
+	return nil
+}
+
+// runWithPaths prints one {"path": ..., "value": ...} object per value the
+// query resolves to, naming the concrete keys and indices that matched
+func runWithPaths(records []interface{}, q *query.Query, encoder *json.Encoder, selectFields []string) error {
+	if query.IsPipeline(q.Path) {
+		return fmt.Errorf("--with-paths cannot be combined with pipelines")
+	}
+	for _, record := range records {
+		matches, err := q.ExtractWithPaths(record)
+		if err != nil {
+			continue // Skip records where path doesn't exist
+		}
+		for _, m := range matches {
+			if len(selectFields) > 0 {
+				m.Value = applySelection(m.Value, selectFields)
+			}
+			m.Value = flattenOutput(m.Value)
+			if err := encoder.Encode(m); err != nil {
+				return err
+			}
+			diag.Counters().Emitted.Add(1)
+		}
+	}
+	return nil
+}
+
+// RunExists prints, for each record, whether the path (or each of several
+// comma-separated paths) resolves. It returns ExitStatus(2) if a path is
+// missing from any record, so that scripts can test the exit code.
+func RunExists(filename string, queryPath string) error {
+	records, err := readValues(filename)
+	if err != nil {
+		return err
+	}
+
+	paths := query.SplitPaths(queryPath)
+	queries := make([]*query.Query, len(paths))
+	for i, path := range paths {
+		queries[i] = query.NewQuery(path)
+		queries[i].CaseInsensitive = QueryCI
+		queries[i].IgnoreCase = QueryIgnoreCase
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	missing := false
+	for _, record := range records {
+		var out interface{}
+		if len(queries) == 1 {
+			found := queries[0].Exists(record)
+			missing = missing || !found
+			out = found
+		} else {
+			row := make(database.OrderedMap, 0, len(queries))
+			for i, q := range queries {
+				found := q.Exists(record)
+				missing = missing || !found
+				row = append(row, database.KeyVal{Key: query.DisplayPath(strings.TrimPrefix(paths[i], ".")), Val: found})
+			}
+			out = row
+		}
+		if err := encoder.Encode(out); err != nil {
+			return err
 		}
+		diag.Counters().Emitted.Add(1)
 	}
 
+	if missing || len(records) == 0 {
+		return ExitStatus(2)
+	}
 	return nil
 }
 
+// runMultiQuery extracts several paths in one pass and emits one object per
+// record, keyed by path. Missing paths are null; records matching none of
+// the paths are skipped.
+func runMultiQuery(records []interface{}, paths []string, queryPretty bool, selectFields []string) error {
+	queries := make([]*query.Query, len(paths))
+	keys := make([]string, len(paths))
+	for i, path := range paths {
+		if path == "" {
+			return fmt.Errorf("empty path in path list")
+		}
+		queries[i] = query.NewQuery(path)
+		queries[i].CaseInsensitive = QueryCI
+		queries[i].IgnoreCase = QueryIgnoreCase
+		keys[i] = query.DisplayPath(strings.TrimPrefix(path, "."))
+		if keys[i] == "" {
+			keys[i] = "."
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	if queryPretty {
+		encoder.SetIndent("", "  ")
+	}
+
+	for _, record := range records {
+		row := make(database.OrderedMap, 0, len(queries))
+		found := false
+		for i, q := range queries {
+			val, err := q.Extract(record)
+			if err != nil {
+				val = nil
+			} else {
+				found = true
+				if len(selectFields) > 0 {
+					val = applySelection(val, selectFields)
+				}
+			}
+			row = append(row, database.KeyVal{Key: keys[i], Val: val})
+		}
+		if !found {
+			continue
+		}
+		if err := encoder.Encode(flattenOutput(row)); err != nil {
+			return err
+		}
+		diag.Counters().Emitted.Add(1)
+	}
+	return nil
+}
+
+// flattenOutput applies --flatten to an output value
+func flattenOutput(v interface{}) interface{} {
+	if !QueryFlatten {
+		return v
+	}
+	return database.Flatten(v)
+}
+
 func applySelection(val interface{}, fields []string) interface{} {
 	switch v := val.(type) {
+	case database.OrderedMap:
+		selected := make(database.OrderedMap, 0, len(fields))
+		for _, f := range fields {
+			if val, ok := v.Get(f); ok {
+				selected = append(selected, database.KeyVal{Key: f, Val: val})
+			}
+		}
+		return selected
 	case parser.Record:
 		newMap := make(parser.Record)
 		for _, f := range fields {
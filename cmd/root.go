@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/engine"
 	"github.com/bisegni/jsl/pkg/plan"
 	"github.com/bisegni/jsl/pkg/planner"
@@ -17,6 +25,45 @@ var (
 	QueryPath       string
 	QueryPretty     bool
 	QueryExplain    bool
+	QueryAnalyze    bool
+	QuerySchema     bool
+	QueryCI         bool
+	QueryIgnoreCase bool
+	QueryStrict     bool
+	QueryArrayMatch string
+	QueryProvenance bool
+	QueryLenient    bool
+	QuerySkipErrors bool
+	QueryErrorsFile string
+	QueryRoot       string
+	QueryParallel   int
+	QueryTempDir    string
+	QuerySortedBy   string
+	QueryFormat     string
+	QueryTemplate   string
+	BulkIndex       string
+	QueryNest       bool
+	QueryFlatten    bool
+	FlushEvery      time.Duration
+	OutputFile      string
+	OutputCompress  string
+	PartitionBy     string
+	QueryExists     bool
+	QueryWithPaths  bool
+	QueryWhere      []string
+	QueryWhereAny   bool
+	Summary         bool
+	BufferSize      int
+	MaxOutputRows   int
+	ValueFormats    []string
+	ScanWarnRows    int
+	QueryTrace      []string
+	QueryTraceLimit int
+	QueryTraceFile  string
+	NoWrite         bool
+	Quiet           bool
+	Verbosity       int
+	Diagnostics     string
 	QueryExtract    bool
 	QuerySelect     []string
 	InteractiveMode bool
@@ -30,6 +77,7 @@ If no command is provided, it defaults to querying the specified file.
 
 Supports:
   - File paths: jsl data.json .user.name
+  - Glob patterns, scanned as one table: jsl 'logs/*.jsonl' "SELECT ..."
   - Stdin: cat data.json | jsl .user.name  (or use "-" as filename)
   - Inline JSON: jsl '{"name":"Alice"}' .name
 
@@ -39,7 +87,10 @@ Examples:
   echo '{"name":"Alice"}' | jsl .name
   jsl '{"name":"Alice","age":30}' .name
   jsl stats data.jsonl`,
-	Args: cobra.RangeArgs(0, 2),
+	Args:              cobra.RangeArgs(0, 2),
+	PersistentPreRunE: configure,
+	// Errors are reported by main through diag
+	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check if stdin has data
 		stat, _ := os.Stdin.Stat()
@@ -71,6 +122,10 @@ Examples:
 			if hasStdin {
 				filename = "-"
 				expression = arg
+			} else if hasStatementPrefix(arg, "SELECT") {
+				// A query reading a database or file named in its FROM
+				filename = "-"
+				expression = arg
 			} else {
 				// If not stdin, it could be a filename (default query) or
 				// if we have flags, maybe an expression?
@@ -84,62 +139,571 @@ Examples:
 			expression = args[1]
 		}
 
+		// Flag conditions filter the records of the only argument, or are
+		// required on top of the WHERE of a statement
+		var flagWhere query.Expression
+		if len(QueryWhere) > 0 {
+			expr, err := whereExpression(QueryWhere, QueryWhereAny)
+			if err != nil {
+				return err
+			}
+			if !isStatement(expression) {
+				if len(args) > 1 {
+					return fmt.Errorf("--where cannot be combined with a path or filter expression argument")
+				}
+				if len(args) == 1 {
+					filename = args[0]
+				}
+				if err := checkStatementOutput(); err != nil {
+					return err
+				}
+				return RunFilterExpression(filename, expr, QueryPretty, QueryExtract, QuerySelect, "json")
+			}
+			flagWhere = expr
+		} else if QueryWhereAny {
+			return fmt.Errorf("--any requires --where conditions")
+		}
+
+		// Existence probes treat the expression as path(s)
+		if QueryExists {
+			cmd.SilenceUsage = true
+			return RunExists(filename, expression)
+		}
+		if !isStatement(expression) {
+			if err := checkStatementOutput(); err != nil {
+				return err
+			}
+		}
+
 		// Intelligent routing
 		// Check if it's a SQL-like query
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(expression)), "SELECT") {
+		if hasStatementPrefix(expression, "SELECT") {
 			q, err := query.ParseQuery(expression)
 			if err != nil {
 				return fmt.Errorf("failed to parse query: %w", err)
 			}
+			q.Filter = andWhere(q.Filter, flagWhere)
+			if err := applyQueryOptions(q); err != nil {
+				return err
+			}
 
-			// Create Input Table
-			inputTable := database.NewJSONTable(filename)
+			return runSelect(cmd.Context(), q, filename)
+		}
 
-			// 1. Create Execution Plan
-			rootNode, err := planner.CreatePlan(q, inputTable)
+		if hasStatementPrefix(expression, "UPDATE") {
+			u, err := query.ParseUpdate(expression)
 			if err != nil {
-				return fmt.Errorf("planning error: %w", err)
+				return fmt.Errorf("failed to parse query: %w", err)
 			}
+			u.Filter = andWhere(u.Filter, flagWhere)
+			return runUpdate(cmd.Context(), u, filename)
+		}
 
-			// Explain Mode
-			if QueryExplain {
-				fmt.Println("Execution Plan:")
-				fmt.Println(plan.FormatPlan(rootNode))
-				return nil
+		if hasStatementPrefix(expression, "DELETE") {
+			d, err := query.ParseDelete(expression)
+			if err != nil {
+				return fmt.Errorf("failed to parse query: %w", err)
 			}
-
-			// Execute
-			executor := engine.NewExecutor()
-			executor.Pretty = QueryPretty
-			return executor.Execute(rootNode, os.Stdout)
+			d.Filter = andWhere(d.Filter, flagWhere)
+			return runDelete(cmd.Context(), d, filename)
 		}
 
 		if query.IsFilterExpression(expression) {
-			expr := query.ParseFilterExpression(expression)
-			if expr != nil {
-				return RunFilter(filename, expr.Field, expr.Operator, expr.Value, QueryPretty, QueryExtract, QuerySelect, "json")
+			expr, err := parseFilterArg(expression)
+			if err != nil {
+				return err
 			}
+			return RunFilterExpression(filename, expr, QueryPretty, QueryExtract, QuerySelect, "json")
 		}
 
 		return RunQuery(filename, expression, QueryPretty, QueryExtract, QuerySelect)
 	},
 }
 
+// ExitStatus is returned to exit with the given status without reporting
+// an error (e.g. a path missing under --exists)
+type ExitStatus int
+
+func (s ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// hasStatementPrefix reports whether expression starts with the given SQL
+// keyword as a whole word (deleted=null is a filter, not a DELETE)
+func hasStatementPrefix(expression, keyword string) bool {
+	s := strings.ToUpper(strings.TrimSpace(expression))
+	if !strings.HasPrefix(s, keyword) {
+		return false
+	}
+	rest := s[len(keyword):]
+	return rest == "" || !(rest[0] == '_' || unicode.IsLetter(rune(rest[0])) || unicode.IsDigit(rune(rest[0])))
+}
+
+// checkStatementOutput refuses --output and --partition-by, which only
+// write the results of statements, so that the output of a path or filter
+// expression is not silently left on stdout
+func checkStatementOutput() error {
+	if OutputFile != "" || PartitionBy != "" {
+		return fmt.Errorf("--output and --partition-by only apply to SELECT, UPDATE and DELETE; redirect the output of a path or filter expression instead")
+	}
+	return nil
+}
+
+// isStatement reports whether expression is a SELECT, UPDATE or DELETE statement
+func isStatement(expression string) bool {
+	return hasStatementPrefix(expression, "SELECT") || hasStatementPrefix(expression, "UPDATE") || hasStatementPrefix(expression, "DELETE")
+}
+
+// andWhere requires the --where conditions (nil if none) on top of the
+// WHERE of a statement
+func andWhere(filter, flagWhere query.Expression) query.Expression {
+	switch {
+	case flagWhere == nil:
+		return filter
+	case filter == nil:
+		return flagWhere
+	}
+	return &query.AndExpression{Left: filter, Right: flagWhere}
+}
+
+// runSelect plans a parsed SELECT query over filename and executes it,
+// writing to stdout or to the query's INTO target
+func runSelect(ctx context.Context, q *query.SelectQuery, filename string) error {
+	// Create Input Table
+	inputTable, err := openTable(filename)
+	if err != nil {
+		return err
+	}
+	return runSelectTable(ctx, q, inputTable, nil)
+}
+
+// runSelectTable runs a SELECT over an opened input table, its FROM naming
+// the tables of catalog (nil outside the REPL) or files
+func runSelectTable(ctx context.Context, q *query.SelectQuery, inputTable database.Table, catalog *database.Catalog) error {
+	// 1. Create Execution Plan
+	rootNode, err := fromResolver(catalog).CreatePlan(q, inputTable)
+	if err != nil {
+		return fmt.Errorf("planning error: %w", err)
+	}
+
+	return executePlan(ctx, rootNode, q.Into)
+}
+
+// runUpdate rewrites the records of filename matching the UPDATE statement and
+// writes all records (changed or not) to stdout
+func runUpdate(ctx context.Context, u *query.UpdateQuery, filename string) error {
+	if QueryCI && u.Filter != nil {
+		query.SetCaseInsensitive(u.Filter, true)
+	}
+	if QueryIgnoreCase && u.Filter != nil {
+		query.SetIgnoreCase(u.Filter, true)
+	}
+	quantifier, err := defaultQuantifier()
+	if err != nil {
+		return err
+	}
+	if u.Filter != nil {
+		query.SetDefaultQuantifier(u.Filter, quantifier)
+	}
+
+	table, err := openTable(filename)
+	if err != nil {
+		return err
+	}
+	rootNode, err := planner.CreateUpdatePlan(u, table)
+	if err != nil {
+		return fmt.Errorf("planning error: %w", err)
+	}
+
+	return executePlan(ctx, rootNode, "")
+}
+
+// runDelete writes the records of filename that do not match the DELETE statement to stdout
+func runDelete(ctx context.Context, d *query.DeleteQuery, filename string) error {
+	if QueryCI && d.Filter != nil {
+		query.SetCaseInsensitive(d.Filter, true)
+	}
+	if QueryIgnoreCase && d.Filter != nil {
+		query.SetIgnoreCase(d.Filter, true)
+	}
+	quantifier, err := defaultQuantifier()
+	if err != nil {
+		return err
+	}
+	if d.Filter != nil {
+		query.SetDefaultQuantifier(d.Filter, quantifier)
+	}
+
+	table, err := openTable(filename)
+	if err != nil {
+		return err
+	}
+	rootNode, err := planner.CreateDeletePlan(d, table)
+	if err != nil {
+		return fmt.Errorf("planning error: %w", err)
+	}
+
+	return executePlan(ctx, rootNode, "")
+}
+
+// executePlan explains or executes a plan, writing to stdout or to the into file
+func executePlan(ctx context.Context, rootNode plan.Node, into string) error {
+	// Explain Mode
+	if QueryExplain && !QueryAnalyze {
+		fmt.Println("Execution Plan:")
+		fmt.Println(plan.FormatPlan(rootNode))
+		return nil
+	}
+
+	if diag.Default().Enabled(diag.LevelDebug) {
+		diag.Debug(diag.CodePlan, "execution plan:\n"+strings.TrimRight(plan.FormatPlan(rootNode), "\n"))
+	}
+
+	if len(QueryTrace) > 0 {
+		tracer := &plan.Tracer{W: os.Stderr, Limit: QueryTraceLimit, Nodes: QueryTrace}
+		if QueryTraceFile != "" {
+			if err := checkWritable("--trace-file"); err != nil {
+				return err
+			}
+			f, err := os.Create(QueryTraceFile)
+			if err != nil {
+				return fmt.Errorf("failed to create trace file: %w", err)
+			}
+			defer f.Close()
+			tracer.W = f
+		}
+		rootNode = plan.Trace(rootNode, tracer)
+	}
+
+	// Execute
+	executor := engine.NewExecutor()
+	executor.Pretty = QueryPretty
+	executor.SchemaHeader = QuerySchema
+	executor.TempDir = QueryTempDir
+	executor.Format = QueryFormat
+	if BulkIndex != "" && QueryFormat != engine.FormatESBulk {
+		return fmt.Errorf("--index requires --format %s", engine.FormatESBulk)
+	}
+	executor.BulkIndex = BulkIndex
+	if QueryTemplate != "" {
+		tmpl, err := engine.ParseTemplate(QueryTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid --template: %w", err)
+		}
+		executor.Template = tmpl
+	}
+	executor.NestOutput = QueryNest
+	executor.Flatten = QueryFlatten
+	executor.MaxRows = MaxOutputRows
+	if diag.Default().Enabled(diag.LevelDebug) {
+		executor.Hooks = debugHooks{}
+	}
+	executor.Formatters = &database.Formatters{}
+	for _, spec := range ValueFormats {
+		if err := executor.Formatters.Add(spec); err != nil {
+			return err
+		}
+	}
+	if FlushEvery > 0 {
+		executor.BufferSize = BufferSize
+		executor.FlushInterval = FlushEvery
+	} else {
+		// Write every row through
+		executor.BufferSize = 0
+	}
+
+	if QueryAnalyze {
+		return analyzePlan(ctx, executor, rootNode)
+	}
+
+	if into != "" {
+		if err := checkWritable("INTO"); err != nil {
+			return err
+		}
+	}
+	if OutputFile != "" {
+		if into != "" {
+			return fmt.Errorf("cannot use both INTO and --output")
+		}
+		if err := checkWritable("--output"); err != nil {
+			return err
+		}
+		into = OutputFile
+	}
+	if err := database.CheckCompression(OutputCompress); err != nil {
+		return err
+	}
+	if into == "" {
+		if PartitionBy != "" {
+			return fmt.Errorf("--partition-by requires --output")
+		}
+		out, err := database.NewCompressWriter(os.Stdout, OutputCompress)
+		if err != nil {
+			return err
+		}
+		_, err = executor.Execute(ctx, rootNode, out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return rowLimitError(err)
+	}
+
+	if PartitionBy != "" {
+		sink, err := database.NewPartitionedSink(into, PartitionBy)
+		if err != nil {
+			return err
+		}
+		sink.Compression = OutputCompress
+		stats, err := executor.ExecuteInto(ctx, rootNode, sink)
+		if closeErr := sink.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return rowLimitError(err)
+		}
+		files := len(sink.Files())
+		diag.Info(diag.CodeSummary, fmt.Sprintf("%d row(s) written to %d file(s)", stats.Emitted, files), "rows", stats.Emitted, "files", files)
+		return nil
+	}
+
+	// Creating the file truncates it before the plan reads it
+	if err := checkNotInput(into, rootNode); err != nil {
+		return err
+	}
+	codec := OutputCompress
+	if codec == "" {
+		codec = database.CompressionOf(into)
+	}
+	sink, err := database.NewCompressedFileSink(into, codec)
+	if err != nil {
+		return err
+	}
+	stats, err := executor.ExecuteInto(ctx, rootNode, sink)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return rowLimitError(err)
+	}
+	diag.Info(diag.CodeSummary, fmt.Sprintf("%d row(s) written to %s", stats.Emitted, into), "rows", stats.Emitted, "file", into)
+	return nil
+}
+
+// checkNotInput fails when the output file is one of the files a plan reads
+func checkNotInput(output string, rootNode plan.Node) error {
+	out, err := os.Stat(output)
+	if err != nil {
+		// A new file
+		return nil
+	}
+	for _, file := range plan.InputFiles(rootNode) {
+		if in, err := os.Stat(file); err == nil && os.SameFile(in, out) {
+			return fmt.Errorf("cannot write the results to %s, an input of the query", output)
+		}
+	}
+	return nil
+}
+
+// debugHooks report the rows and time of every plan node once it finishes,
+// at debug level
+type debugHooks struct {
+	plan.NopHooks
+}
+
+func (debugHooks) OnNodeFinish(ctx context.Context, n plan.Node, stats plan.NodeStats, err error) {
+	msg := fmt.Sprintf("%s finished: %d row(s) in %s", n.Explain(), stats.Rows, stats.Time.Round(time.Microsecond))
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	diag.Debug(diag.CodePlan, msg, "rows", stats.Rows, "duration_ms", stats.Time.Milliseconds())
+}
+
+// analyzePlan executes a plan discarding its results, then prints it with
+// the rows and time of every node (--analyze)
+func analyzePlan(ctx context.Context, executor *engine.Executor, rootNode plan.Node) error {
+	executor.Analyze = true
+	stats, err := executor.Execute(ctx, rootNode, io.Discard)
+	if err != nil {
+		return rowLimitError(err)
+	}
+	fmt.Println("Execution Plan:")
+	fmt.Println(plan.FormatPlan(stats.Plan))
+	return nil
+}
+
+// rowLimitError points an exceeded --max-output-rows guard to the flag
+func rowLimitError(err error) error {
+	if errors.Is(err, engine.ErrTooManyRows) {
+		return fmt.Errorf("%w, aborting (check for rows multiplied by unwinding, or raise --max-output-rows)", err)
+	}
+	return err
+}
+
+// configure applies the global options before any command runs
+func configure(cmd *cobra.Command, args []string) error {
+	if err := configureDiagnostics(cmd, args); err != nil {
+		return err
+	}
+	if QueryFlatten && QueryNest {
+		return fmt.Errorf("--flatten and --nest-output are mutually exclusive")
+	}
+	if err := configureNoWrite(); err != nil {
+		return err
+	}
+	return configureSkipErrors()
+}
+
+// NoWriteEnv enables --no-write from the environment. It cannot be turned
+// off with a flag, so an embedding environment can enforce read-only use.
+const NoWriteEnv = "JSL_NO_WRITE"
+
+// configureNoWrite enables --no-write when NoWriteEnv is set to a true value
+func configureNoWrite() error {
+	value, ok := os.LookupEnv(NoWriteEnv)
+	if !ok || value == "" {
+		return nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q (use true or false)", NoWriteEnv, value)
+	}
+	NoWrite = NoWrite || enabled
+	return nil
+}
+
+// checkWritable fails when --no-write forbids the file-writing feature
+func checkWritable(feature string) error {
+	if NoWrite {
+		return fmt.Errorf("%s writes files, which --no-write (or %s) forbids", feature, NoWriteEnv)
+	}
+	return nil
+}
+
+// configureDiagnostics applies --quiet, --verbose and --diagnostics to the default reporter
+func configureDiagnostics(cmd *cobra.Command, args []string) error {
+	r := diag.Default()
+	switch Diagnostics {
+	case diag.FormatText, diag.FormatJSON:
+		r.Format = Diagnostics
+	default:
+		return fmt.Errorf("invalid --diagnostics %q (use text or json)", Diagnostics)
+	}
+	if Diagnostics == diag.FormatJSON {
+		// keep stderr parseable
+		cmd.SilenceUsage = true
+	}
+
+	switch {
+	case Quiet && Verbosity > 0:
+		return fmt.Errorf("--quiet and --verbose are mutually exclusive")
+	case Quiet:
+		r.Level = diag.LevelError
+	default:
+		r.Level = diag.LevelInfo + diag.Level(Verbosity)
+	}
+	return nil
+}
+
+// applyQueryOptions copies the engine options given as flags onto a parsed query
+func applyQueryOptions(q *query.SelectQuery) error {
+	q.CaseInsensitive = QueryCI
+	q.IgnoreCase = QueryIgnoreCase
+	q.Strict = QueryStrict
+	if !query.IsQuantifier(QueryArrayMatch) {
+		return fmt.Errorf("invalid --array-match %q (use any, all or none)", QueryArrayMatch)
+	}
+	q.ArrayMatch = strings.ToUpper(QueryArrayMatch)
+	q.Sources = QueryProvenance
+	if QueryParallel < 0 {
+		return fmt.Errorf("invalid --parallel %d (use 0 for one worker per CPU)", QueryParallel)
+	}
+	q.Workers = QueryParallel
+	if QueryParallel == 0 {
+		q.Workers = runtime.NumCPU()
+	}
+	// Spilling writes temporary files
+	if !NoWrite {
+		q.MemoryLimit, q.TempDir = int64(QueryMemoryLimit), QueryTempDir
+	}
+	q.SortedBy = QuerySortedBy
+	return nil
+}
+
 func Execute() error {
-	return rootCmd.Execute()
+	start := time.Now()
+	err := rootCmd.Execute()
+	if skipErr := reportSkipped(); err == nil {
+		err = skipErr
+	}
+	if Summary {
+		diag.Counters().Report(diag.Default(), time.Since(start))
+	}
+	return err
 }
 
 func init() {
+	// The command line reads the URLs it is given
+	database.EnableHTTP()
+
 	rootCmd.PersistentFlags().StringVarP(&QueryPath, "path", "p", ".", "Path to extract (e.g., .user.name)")
 	rootCmd.PersistentFlags().BoolVar(&QueryPretty, "pretty", false, "Pretty print output")
+	rootCmd.PersistentFlags().StringVar(&QueryFormat, "format", engine.FormatJSONL, "Output format for SQL results: jsonl, json-array, msgpack, csv (a header from the fields of the first row, nested objects as dotted columns), table (aligned columns, long values truncated) or es-bulk (Elasticsearch _bulk body)")
+	rootCmd.PersistentFlags().StringVar(&BulkIndex, "index", "", "With --format es-bulk, the Elasticsearch index of the bulk actions (else left to the _bulk URL)")
+	rootCmd.PersistentFlags().StringVar(&QueryTemplate, "template", "", "Render each SQL result row through a Go template, one per line (e.g. '{{.name}}: {{.price}}'; {{json .field}} prints a value as JSON)")
+	rootCmd.PersistentFlags().BoolVar(&QueryFlatten, "flatten", false, "Output nested objects as single-level objects with dotted keys ({\"supplier\":{\"country\":...}} -> supplier.country), e.g. for CSV export")
+	rootCmd.PersistentFlags().BoolVar(&QueryNest, "nest-output", false, "Rebuild nested objects from dotted keys of SQL results (supplier.country -> {\"supplier\":{\"country\":...}})")
+	rootCmd.PersistentFlags().DurationVar(&FlushEvery, "flush-every", engine.DefaultFlushInterval, "Flush buffered SQL results at least this often (e.g. 1s; 0 = write every row immediately)")
+	rootCmd.PersistentFlags().IntVar(&BufferSize, "buffer-size", engine.DefaultBufferSize, "Bytes of SQL results buffered before a write")
+	rootCmd.PersistentFlags().StringArrayVar(&ValueFormats, "format-value", nil, "Format SQL result values: field=FORMAT or type:TYPE=FORMAT, FORMAT being rfc3339, bytes or fixed:N (e.g. price=fixed:2)")
+	rootCmd.PersistentFlags().IntVar(&MaxOutputRows, "max-output-rows", 0, "Abort SQL queries producing more rows than this (0 = no limit)")
+	rootCmd.PersistentFlags().BoolVar(&NoWrite, "no-write", false, "Refuse every feature writing files (INTO, --output, --trace-file, --errors-file, convert --out-dir); also enabled by "+NoWriteEnv+"=1")
+	rootCmd.PersistentFlags().StringVarP(&OutputFile, "output", "o", "", "Write SQL results to a file instead of stdout (.jsonl for JSON Lines, .msgpack or .mpk for MessagePack, .csv for CSV, else a JSON array)")
+	rootCmd.PersistentFlags().StringVar(&OutputCompress, "compress", "", "Compress SQL results written to stdout or --output: gzip or zstd (implied by an --output file ending in .gz or .zst)")
+	rootCmd.PersistentFlags().StringVar(&PartitionBy, "partition-by", "", "Write one --output file per value of a field; the pattern holds the field in braces (-o 'out/{category}.jsonl')")
+	rootCmd.PersistentFlags().BoolVar(&QueryExists, "exists", false, "Print whether the path resolves in each record; exit status 2 if it is missing from any")
+	rootCmd.PersistentFlags().StringArrayVar(&QueryWhere, "where", nil, "Filter condition (e.g., 'age>28'); repeat to require all of them. With a SELECT, UPDATE or DELETE, required on top of its WHERE")
+	rootCmd.PersistentFlags().BoolVar(&QueryWhereAny, "any", false, "Match records satisfying any --where condition instead of all")
 	rootCmd.PersistentFlags().BoolVar(&QueryExplain, "explain", false, "Print execution plan")
+	rootCmd.PersistentFlags().BoolVar(&QueryAnalyze, "analyze", false, "Execute the query, discarding its results, and print the execution plan with the rows produced and time spent by every node")
+	rootCmd.PersistentFlags().BoolVar(&QuerySchema, "schema-header", false, "Emit a #jsl-schema header line preserving field types for chained jsl calls")
+	rootCmd.PersistentFlags().StringSliceVar(&QueryTrace, "trace", nil, "Log rows passing plan nodes to stderr: all, node kinds (Filter,Project) or ids (1 = root, in --explain order)")
+	rootCmd.PersistentFlags().IntVar(&QueryTraceLimit, "trace-limit", 20, "Maximum rows traced per node (0 = unlimited)")
+	rootCmd.PersistentFlags().StringVar(&QueryTraceFile, "trace-file", "", "Write --trace output to a file instead of stderr")
 	rootCmd.PersistentFlags().BoolVarP(&QueryExtract, "extract", "e", false, "Extract mode (flattened line-by-line output)")
+	rootCmd.PersistentFlags().BoolVar(&QueryWithPaths, "with-paths", false, "Print each value a path resolves to with its concrete path ({\"path\":\"metrics.cpu_temp\",\"value\":60})")
 	rootCmd.PersistentFlags().StringSliceVarP(&QuerySelect, "select", "s", []string{}, "Select specific fields to include in output (e.g., value,metadata)")
+	rootCmd.PersistentFlags().BoolVar(&QueryCI, "ci", false, "Match field names case-insensitively (e.g., Name matches name)")
+	rootCmd.PersistentFlags().BoolVar(&QueryIgnoreCase, "ignore-case", false, "Compare string values case-insensitively (e.g., name~=john matches John)")
+	rootCmd.PersistentFlags().BoolVar(&QueryStrict, "strict", false, "Fail when a queried field is not present in any scanned record (catches typos) or a selected field is neither grouped nor aggregated")
+	rootCmd.PersistentFlags().StringVar(&QueryArrayMatch, "array-match", "any", "How WHERE conditions match arrays: any, all or none (override per condition with ANY(...)/ALL(...)/NONE(...))")
+	rootCmd.PersistentFlags().BoolVar(&QueryLenient, "lenient", false, "Accept config-style JSON: // and /* */ comments, trailing commas and unquoted keys (always on for .json5 and .jsonc files)")
+	rootCmd.PersistentFlags().BoolVar(&QuerySkipErrors, "skip-errors", false, "Skip the lines that fail to decode instead of aborting (one record per line), reporting their count on stderr")
+	rootCmd.PersistentFlags().StringVar(&QueryErrorsFile, "errors-file", "", "With --skip-errors, write the skipped lines to a file")
+	rootCmd.PersistentFlags().StringVar(&QueryRoot, "root", "", "Stream the elements of the array at this path as the records (e.g. .items for {\"meta\": ..., \"items\": [...]})")
+	rootCmd.PersistentFlags().IntVar(&QueryParallel, "parallel", 1, "Scan JSONL files for SQL queries with this many workers, decoding and filtering parts of the files in parallel (0 = one per CPU)")
+	rootCmd.PersistentFlags().Var(&QueryMemoryLimit, "memory-limit", "Memory of the rows held by ORDER BY and GROUP BY before spilling them to temporary files (e.g. 512MiB; 0 = no limit; --no-write keeps them in memory)")
+	rootCmd.PersistentFlags().StringVar(&QueryTempDir, "temp-dir", "", "Directory of the temporary files spilled by --memory-limit and spooled by --schema-header (default: the system temporary directory)")
+	rootCmd.PersistentFlags().StringVar(&QuerySortedBy, "sorted-by", "", "Declare the input sorted by this field: GROUP BY on it outputs each group as soon as it ends, in input order, holding one group in memory (fails on rows out of order)")
+	rootCmd.PersistentFlags().Var(&QueryMaxRecordSize, "max-record-size", "Fail on a record larger than this (e.g. 16MiB; 0 = no limit)")
+	rootCmd.PersistentFlags().Int64Var(&QueryMaxRecords, "max-records", 0, "Fail on an input holding more records than this (0 = no limit)")
+	rootCmd.PersistentFlags().Var(&QueryMaxBytes, "max-bytes", "Fail on an input larger than this (e.g. 2GB; 0 = no limit)")
+	rootCmd.PersistentFlags().BoolVar(&QueryProvenance, "provenance", false, "Add a _source field locating each record in its file ({\"file\",\"offset\",\"line\"}) to filter and SQL results; aggregated rows list the sources of their group (see jsl lookup)")
+	rootCmd.PersistentFlags().BoolVar(&Summary, "summary", false, "Report records read, matched, emitted and skipped, bytes processed and duration on stderr when done")
+	rootCmd.PersistentFlags().BoolVarP(&Quiet, "quiet", "q", false, "Only report errors on stderr")
+	rootCmd.PersistentFlags().CountVarP(&Verbosity, "verbose", "v", "Report more details on stderr (repeat for more)")
+	rootCmd.PersistentFlags().StringVar(&Diagnostics, "diagnostics", diag.FormatText, "Format of warnings and notices on stderr: text or json (one event per line)")
 	rootCmd.PersistentFlags().BoolVarP(&InteractiveMode, "interactive", "i", false, "Interactive REPL mode")
+	rootCmd.PersistentFlags().IntVar(&ScanWarnRows, "scan-warn-rows", 100000, "In interactive mode, warn before a query without WHERE or LIMIT outputs more rows than this, offering to append a LIMIT (0 = never)")
 
 	// Subcommands that still make sense as separate actions
 	rootCmd.AddCommand(formatCmd)
 	rootCmd.AddCommand(convertCmd)
 	rootCmd.AddCommand(statsCmd)
 	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(setCmd)
+	rootCmd.AddCommand(delCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(sortCmd)
+	rootCmd.AddCommand(lookupCmd)
+	rootCmd.AddCommand(serveCmd)
 }
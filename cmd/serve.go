@@ -0,0 +1,733 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/engine"
+	"github.com/bisegni/jsl/pkg/jsl"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/planner"
+	"github.com/bisegni/jsl/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen  string
+	serveTimeout time.Duration
+	serveMaxRows int
+	serveToken   string
+	serveAllow   []string
+)
+
+// ServeTokenEnv sets the token of serve from the environment, when
+// --token-file is not given
+const ServeTokenEnv = "JSL_SERVE_TOKEN"
+
+// maxQuerySize bounds the body of a /query request
+const maxQuerySize = 1 << 20
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [file|-]",
+	Short: "Serve queries over an input on HTTP",
+	Long: `Serve queries over an input on HTTP. POST /query takes a SELECT
+statement or a path expression as the request body and streams the results
+as JSON Lines.
+
+The server listens on localhost unless --listen names another address.
+With a token, read from the first line of --token-file or from the
+JSL_SERVE_TOKEN environment variable, a request must carry it in an
+"Authorization: Bearer <token>" header or gets a 401 response; listening
+beyond localhost without one is warned about. A query running longer than
+--timeout or producing more than --max-rows rows is aborted.
+
+The input is read by every query (stdin is read once and kept in memory).
+With --summary, the records read and rows emitted by each query are
+reported on stderr.
+A FROM clause can name the served input: its file name (data.jsonl), or
+that name without directory and extensions (data). It can only name another
+file when --allow lists it or a directory holding it; symbolic links are
+followed before checking, so none leads out of the safelist, and glob
+patterns are refused.
+A query failing before its first row gets a 400 (invalid query) or 500
+response; one failing later is cut short, the response ending without its
+final chunk.
+
+GET /tables lists the served input as a table, with its format, row count
+and the field types inferred from its first rows when the server started
+(as \dt in the REPL); GET /tables/NAME describes it by any name a FROM
+accepts.
+
+Results are paged through with /query?limit=N: the response holds up to N
+rows and, when more follow, a Jsl-Next-Cursor header whose value is passed
+as &cursor= to get the next page. A cursor locates the next page in the
+served JSONL file, which is read from there. Only queries outputting their
+rows in input order are paged: path expressions, and SELECTs without GROUP
+BY, aggregates, ORDER BY, LIMIT or a subquery.
+
+Examples:
+  jsl serve --listen 127.0.0.1:8080 data.jsonl
+  curl -d "SELECT user, COUNT(*) AS n GROUP BY user" localhost:8080/query
+  curl -d ".user.name" localhost:8080/query
+  JSL_SERVE_TOKEN=s3cret jsl serve --listen :8080 --allow archive/ data.jsonl
+  curl -H "Authorization: Bearer s3cret" -d "SELECT * FROM 'archive/2024.jsonl'" host:8080/query
+  curl -i -d "SELECT user WHERE n > 1" 'localhost:8080/query?limit=100'
+  curl localhost:8080/tables/data`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8080", "Address to listen on (\":8080\" listens on every interface)")
+	serveCmd.Flags().DurationVar(&serveTimeout, "timeout", 30*time.Second, "Abort a query running longer than this (0 = no limit)")
+	serveCmd.Flags().IntVar(&serveMaxRows, "max-rows", 100000, "Abort a query producing more rows than this (0 = no limit)")
+	serveCmd.Flags().StringVar(&serveToken, "token-file", "", "File whose first line is the token requests must send as \"Authorization: Bearer <token>\" (default $"+ServeTokenEnv+")")
+	serveCmd.Flags().StringSliceVar(&serveAllow, "allow", nil, "File or directory a FROM may also name (repeatable)")
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	filename := "-"
+	if len(args) > 0 {
+		filename = args[0]
+	}
+	table, err := openTable(filename)
+	if err != nil {
+		return err
+	}
+	handler, err := newQueryHandler(filename, table)
+	if err != nil {
+		return err
+	}
+	if serveTimeout < 0 || serveMaxRows < 0 {
+		return fmt.Errorf("--timeout and --max-rows must not be negative")
+	}
+	handler.timeout = serveTimeout
+	handler.maxRows = serveMaxRows
+	if handler.token, err = readServeToken(serveToken); err != nil {
+		return err
+	}
+	if handler.allowed, err = allowedPaths(serveAllow); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", serveListen)
+	if err != nil {
+		return err
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() && handler.token == "" {
+		diag.Warn(diag.CodeListening, fmt.Sprintf("serving on %s without a token: anyone reaching it can query (set --token-file or %s)", addr, ServeTokenEnv), "address", addr.String())
+	}
+	server := &http.Server{Handler: newServeMux(handler), ReadHeaderTimeout: 10 * time.Second}
+	diag.Info(diag.CodeListening, fmt.Sprintf("serving %s on %s", filename, listener.Addr()), "input", filename, "address", listener.Addr().String())
+	return server.Serve(listener)
+}
+
+// newServeMux returns the routes of serve: POST /query, GET /tables and
+// GET /tables/{name}
+func newServeMux(h *queryHandler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/query", h)
+	mux.HandleFunc("/tables", h.listTables)
+	mux.HandleFunc("/tables/{name}", h.describeTable)
+	return mux
+}
+
+// queryHandler runs the queries of POST /query over a table
+type queryHandler struct {
+	// names are those a FROM may give the table (servedNames)
+	names      []string
+	table      database.Table
+	formatters *database.Formatters
+	// catalog holds the served table with its metadata, for /tables
+	catalog *database.Catalog
+	// timeout aborts the queries running longer (0 = no limit)
+	timeout time.Duration
+	// maxRows aborts the queries producing more rows (0 = no limit)
+	maxRows int
+	// token is required from the requests, unless empty
+	token string
+	// allowed are the files and directories, absolute and without symbolic
+	// links, a FROM may name besides the served input
+	allowed []string
+}
+
+// readServeToken returns the first line of the file filename, or the
+// value of ServeTokenEnv without a file: empty, serve has no token
+func readServeToken(filename string) (string, error) {
+	if filename == "" {
+		return strings.TrimSpace(os.Getenv(ServeTokenEnv)), nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	token, _, _ := strings.Cut(string(data), "\n")
+	if token = strings.TrimSpace(token); token == "" {
+		return "", fmt.Errorf("the --token-file %s holds no token", filename)
+	}
+	return token, nil
+}
+
+// allowedPaths returns the files and directories of --allow, absolute and
+// with their symbolic links resolved
+func allowedPaths(paths []string) ([]string, error) {
+	var allowed []string
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err == nil {
+			abs, err = filepath.EvalSymlinks(abs)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("--allow %s: %w", path, err)
+		}
+		allowed = append(allowed, abs)
+	}
+	return allowed, nil
+}
+
+// authorized reports whether r carries the token of the handler, when it
+// has one
+func (h *queryHandler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(h.token)) == 1
+}
+
+// newQueryHandler returns the handler of the queries over table, read from
+// filename, with the output options of the command line
+func newQueryHandler(filename string, table database.Table) (*queryHandler, error) {
+	h := &queryHandler{names: servedNames(filename), table: table, formatters: &database.Formatters{}}
+	source := filename
+	if filename == "-" {
+		source = "<stdin>"
+	}
+	h.catalog = database.NewCatalog()
+	h.catalog.RegisterTableWithInfo(h.names[len(h.names)-1], table, describeInput(table, filename, source))
+	for _, spec := range ValueFormats {
+		if err := h.formatters.Add(spec); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// checkRequest answers the requests lacking the token of the handler with
+// a 401, and those using another method than method with a 405, returning
+// whether r is to be served
+func (h *queryHandler) checkRequest(w http.ResponseWriter, r *http.Request, method, usage string) bool {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="jsl"`)
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return false
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, usage, http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// tableJSON is the description of a table answered by /tables
+type tableJSON struct {
+	Name   string        `json:"name"`
+	Source string        `json:"source"`
+	Format string        `json:"format"`
+	Rows   int           `json:"rows"` // -1 if unknown
+	Exact  bool          `json:"rows_exact"`
+	Size   int64         `json:"size"` // -1 if unknown
+	Schema parser.Schema `json:"schema"`
+}
+
+func newTableJSON(info database.TableInfo) tableJSON {
+	schema := info.Schema
+	if schema == nil {
+		schema = parser.Schema{}
+	}
+	return tableJSON{Name: info.Name, Source: info.Source, Format: info.Format, Rows: info.RowEstimate, Exact: info.RowsExact, Size: info.Size, Schema: schema}
+}
+
+// listTables answers GET /tables with the description of every table a
+// FROM can name, as REPL \dt lists them
+func (h *queryHandler) listTables(w http.ResponseWriter, r *http.Request) {
+	if !h.checkRequest(w, r, http.MethodGet, "use GET to list the tables") {
+		return
+	}
+	tables := []tableJSON{}
+	for _, info := range h.catalog.List() {
+		tables = append(tables, newTableJSON(info))
+	}
+	writeJSON(w, tables)
+}
+
+// describeTable answers GET /tables/{name} with the description of a table,
+// its name being any the FROM of a query accepts
+func (h *queryHandler) describeTable(w http.ResponseWriter, r *http.Request) {
+	if !h.checkRequest(w, r, http.MethodGet, "use GET to describe a table") {
+		return
+	}
+	name := r.PathValue("name")
+	for _, served := range h.names {
+		if strings.EqualFold(name, served) {
+			info, err := h.catalog.Info(h.names[len(h.names)-1])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, newTableJSON(info))
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("unknown table %s", name), http.StatusNotFound)
+}
+
+// writeJSON answers a request with v encoded as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		diag.Warn(diag.CodeError, fmt.Sprintf("failed to write the response: %v", err))
+	}
+}
+
+func (h *queryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.checkRequest(w, r, http.MethodPost, "use POST with the query as the body") {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxQuerySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	expression := strings.TrimSpace(string(body))
+	if expression == "" {
+		http.Error(w, "empty query", http.StatusBadRequest)
+		return
+	}
+	pg, err := h.parsePage(r, expression)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The counters of this request alone, other requests running concurrently
+	stats := &diag.Stats{}
+	ctx := diag.WithStats(r.Context(), stats)
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+
+	out := &responseWriter{w: w}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if hasStatementPrefix(expression, "SELECT") {
+		err = h.runSelect(ctx, expression, pg, out)
+	} else if hasStatementPrefix(expression, "UPDATE") || hasStatementPrefix(expression, "DELETE") {
+		err = badRequest{fmt.Errorf("only SELECT statements and path expressions are served")}
+	} else {
+		err = h.runPath(ctx, expression, pg, out)
+	}
+	if Summary {
+		stats.Report(diag.Default(), time.Since(start))
+	}
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	var bad badRequest
+	switch {
+	case out.written:
+		// The status is sent: cut the response short
+		diag.Warn(diag.CodeError, fmt.Sprintf("query failed after its first rows: %v", err))
+		panic(http.ErrAbortHandler)
+	case errors.As(err, &bad):
+		http.Error(w, bad.Error(), http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, fmt.Sprintf("query aborted after the --timeout of %s", h.timeout), http.StatusServiceUnavailable)
+	case errors.Is(err, engine.ErrTooManyRows):
+		http.Error(w, fmt.Sprintf("%v, aborting (narrow the query with WHERE or LIMIT, or raise --max-rows)", err), http.StatusInternalServerError)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// runSelect streams the rows of a SELECT statement to w, or writes the
+// page pg of them when set
+func (h *queryHandler) runSelect(ctx context.Context, sql string, pg *page, w *responseWriter) error {
+	q, err := query.ParseQuery(sql)
+	if err != nil {
+		return badRequest{fmt.Errorf("parse error: %w", err)}
+	}
+	if q.Into != "" {
+		return badRequest{fmt.Errorf("INTO is not supported by serve")}
+	}
+	if err := applyQueryOptions(q); err != nil {
+		return err
+	}
+	table := h.table
+	if pg != nil {
+		if err := pageable(q); err != nil {
+			return badRequest{err}
+		}
+		if table, err = pg.open(); err != nil {
+			return err
+		}
+	}
+	node, err := h.resolver(table, pg != nil).CreatePlan(q, table)
+	if err != nil {
+		return badRequest{fmt.Errorf("planning error: %w", err)}
+	}
+
+	executor := engine.NewExecutor()
+	executor.NestOutput = QueryNest
+	executor.Flatten = QueryFlatten
+	executor.Formatters = h.formatters
+	if pg != nil {
+		// The page is bounded by its limit, itself bounded by --max-rows
+		_, err = executor.ExecuteInto(ctx, node, pg)
+		return pg.write(w, err)
+	}
+	executor.MaxRows = h.rowLimit()
+	_, err = executor.Execute(ctx, node, w)
+	return err
+}
+
+// servedNames returns the names a FROM may give the input filename: the
+// name itself and its base name without extensions ("data" for
+// "logs/data.jsonl.gz"), "stdin" for standard input
+func servedNames(filename string) []string {
+	if filename == "-" {
+		return []string{"-", "stdin"}
+	}
+	base := filepath.Base(filename)
+	names := []string{filename, base}
+	if i := strings.IndexByte(base, '.'); i > 0 {
+		names = append(names, base[:i])
+	}
+	return names
+}
+
+// resolver resolves the FROM clauses of the queries to table, the served
+// input, or to the files of the --allow safelist, refusing any other name
+// so that a query cannot read other files. A page only reads the served
+// input, its cursor locating rows in it.
+func (h *queryHandler) resolver(table database.Table, paged bool) *planner.Resolver {
+	return &planner.Resolver{Open: func(name string) (database.Table, error) {
+		for _, served := range h.names {
+			if strings.EqualFold(name, served) {
+				return table, nil
+			}
+		}
+		if paged {
+			return nil, fmt.Errorf("only the served input %s can be queried by page", h.names[0])
+		}
+		path, ok := h.allowedPath(name)
+		if !ok {
+			return nil, fmt.Errorf("only the served input %s and the files of --allow can be queried", h.names[0])
+		}
+		return openTable(path)
+	}}
+}
+
+// allowedPath returns the file a FROM names, absolute and without symbolic
+// links, and whether it is one of the allowed files or within one of the
+// allowed directories. Glob patterns are refused.
+func (h *queryHandler) allowedPath(name string) (string, bool) {
+	if len(h.allowed) == 0 || strings.ContainsAny(name, "*?[") {
+		return "", false
+	}
+	path, err := filepath.Abs(name)
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		return "", false
+	}
+	for _, allowed := range h.allowed {
+		if path == allowed || strings.HasPrefix(path, strings.TrimSuffix(allowed, string(filepath.Separator))+string(filepath.Separator)) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// runPath streams the values of a path expression in every record to w,
+// counting them in the statistics of ctx, or writes the page pg of them
+// when set
+func (h *queryHandler) runPath(ctx context.Context, path string, pg *page, w *responseWriter) error {
+	if !strings.HasPrefix(path, ".") {
+		return badRequest{fmt.Errorf("expected a SELECT statement or a path expression (.user.name), got %q", path)}
+	}
+	table := h.table
+	if pg != nil {
+		var err error
+		if table, err = pg.open(); err != nil {
+			return err
+		}
+	}
+	db := jsl.NewDB(table)
+	db.CaseInsensitive = QueryCI
+	db.IgnoreCase = QueryIgnoreCase
+	values, err := db.ExtractContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer values.Close()
+
+	stats := diag.RunStats(ctx)
+	if pg != nil {
+		for values.Next() {
+			if err = pg.Write(values.Row()); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = values.Error()
+		}
+		if stats != nil {
+			stats.Emitted.Add(int64(pg.rows))
+		}
+		return pg.write(w, err)
+	}
+
+	limit := h.rowLimit()
+	buffered := bufio.NewWriterSize(w, engine.DefaultBufferSize)
+	sink := database.NewJSONLinesSink(buffered, false)
+	for rows := 0; values.Next(); rows++ {
+		if limit > 0 && rows == limit {
+			return fmt.Errorf("%w: more than %d", engine.ErrTooManyRows, limit)
+		}
+		if err := sink.Write(values.Row()); err != nil {
+			return err
+		}
+		if stats != nil {
+			stats.Emitted.Add(1)
+		}
+	}
+	if err := values.Error(); err != nil {
+		return err
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// rowLimit returns the number of rows a query may produce, the lower of
+// --max-rows and --max-output-rows (0 = no limit)
+func (h *queryHandler) rowLimit() int {
+	if MaxOutputRows > 0 && (h.maxRows == 0 || MaxOutputRows < h.maxRows) {
+		return MaxOutputRows
+	}
+	return h.maxRows
+}
+
+// nextCursorHeader is the header of a page naming the cursor of the next one
+const nextCursorHeader = "Jsl-Next-Cursor"
+
+// errPageFull stops a query once its page is complete
+var errPageFull = errors.New("page full")
+
+// page is a page of the results of a query (?limit=N&cursor=...), a sink
+// holding its rows until it is complete. A cursor locates the record of
+// the served JSONL file the next page starts at and the rows of that record
+// already returned; it is tied to the query by a fingerprint. Rows are
+// attributed to the record the scan read last, which holds for the queries
+// outputting their rows in input order (pageable).
+type page struct {
+	table       *database.JSONTable
+	fingerprint string
+	limit       int
+	offset      int64 // of the record the page starts at
+	skip        int   // rows of that record returned by the previous page
+
+	resumed    *database.ResumedTable
+	buf        bytes.Buffer
+	sink       database.Sink
+	rows       int
+	record     int64 // offset of the record of the last row
+	recordRows int   // rows of that record so far
+	next       string
+}
+
+// parsePage returns the page a request asks for, nil when it has no limit
+func (h *queryHandler) parsePage(r *http.Request, expression string) (*page, error) {
+	params := r.URL.Query()
+	if !params.Has("limit") {
+		if params.Has("cursor") {
+			return nil, fmt.Errorf("cursor requires limit")
+		}
+		return nil, nil
+	}
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid limit %q: expected a positive number of rows", params.Get("limit"))
+	}
+	if max := h.rowLimit(); max > 0 && limit > max {
+		return nil, fmt.Errorf("limit %d exceeds the %d rows a query may produce (--max-rows)", limit, max)
+	}
+	table, ok := h.table.(*database.JSONTable)
+	if !ok {
+		return nil, fmt.Errorf("pagination requires a JSON Lines file input")
+	}
+	pg := &page{table: table, fingerprint: queryFingerprint(expression), limit: limit}
+	if cursor := params.Get("cursor"); cursor != "" {
+		if err := pg.decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	return pg, nil
+}
+
+// queryFingerprint identifies a query in its cursors
+func queryFingerprint(expression string) string {
+	sum := sha256.Sum256([]byte(expression))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeCursor returns the cursor of the page starting at the row skip of
+// the record at offset
+func (pg *page) encodeCursor(offset int64, skip int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%s", offset, skip, pg.fingerprint)))
+}
+
+// decodeCursor sets the start of the page from a cursor
+func (pg *page) decodeCursor(cursor string) error {
+	invalid := fmt.Errorf("invalid cursor %q", cursor)
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return invalid
+	}
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 3 {
+		return invalid
+	}
+	if pg.offset, err = strconv.ParseInt(parts[0], 10, 64); err != nil || pg.offset < 0 {
+		return invalid
+	}
+	if pg.skip, err = strconv.Atoi(parts[1]); err != nil || pg.skip < 0 {
+		return invalid
+	}
+	if parts[2] != pg.fingerprint {
+		return fmt.Errorf("the cursor is of another query")
+	}
+	return nil
+}
+
+// open returns the served table from the start of the page
+func (pg *page) open() (database.Table, error) {
+	resumed, err := pg.table.Resume(pg.offset)
+	if err != nil {
+		return nil, badRequest{fmt.Errorf("pagination: %w", err)}
+	}
+	pg.resumed = resumed
+	pg.record = pg.offset
+	pg.sink = database.NewJSONLinesSink(&pg.buf, false)
+	return resumed, nil
+}
+
+// pageable returns why the rows of q cannot be paged through, nil when
+// they are output in input order
+func pageable(q *query.SelectQuery) error {
+	aggregate := q.GroupBy != ""
+	for _, f := range q.Fields {
+		aggregate = aggregate || f.Aggregate != ""
+	}
+	var clause string
+	switch {
+	case aggregate:
+		clause = "GROUP BY or aggregates"
+	case len(q.OrderBy) > 0:
+		clause = "ORDER BY"
+	case q.Limit != nil:
+		clause = "LIMIT"
+	case q.FromQuery != nil:
+		clause = "a subquery"
+	case q.Strict:
+		clause = "--strict"
+	default:
+		return nil
+	}
+	return fmt.Errorf("a query with %s cannot be paged through", clause)
+}
+
+// Write adds a row to the page, skipping those of the previous page. The
+// row past the limit ends the page with errPageFull, locating the next one.
+func (pg *page) Write(row database.Row) error {
+	if offset := pg.resumed.Offset(); offset != pg.record {
+		pg.record, pg.recordRows = offset, 0
+	}
+	pg.recordRows++
+	if pg.record == pg.offset && pg.recordRows <= pg.skip {
+		return nil
+	}
+	if pg.rows == pg.limit {
+		pg.next = pg.encodeCursor(pg.record, pg.recordRows-1)
+		return errPageFull
+	}
+	pg.rows++
+	return pg.sink.Write(row)
+}
+
+// Close does nothing: the rows are written by write
+func (pg *page) Close() error {
+	return nil
+}
+
+// write sends the page once the query ended with err, naming the next page
+// in the nextCursorHeader header
+func (pg *page) write(w *responseWriter, err error) error {
+	if err != nil && !errors.Is(err, errPageFull) {
+		return err
+	}
+	if pg.next != "" {
+		w.w.Header().Set(nextCursorHeader, pg.next)
+	}
+	if pg.buf.Len() == 0 {
+		return nil
+	}
+	_, err = w.Write(pg.buf.Bytes())
+	return err
+}
+
+// badRequest is an error of the query rather than of its execution
+type badRequest struct {
+	error
+}
+
+// responseWriter flushes every write to the client, so that rows are
+// streamed as they are written (every FlushInterval of the executor)
+type responseWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.written = true
+	n, err := rw.w.Write(p)
+	if flusher, ok := rw.w.(http.Flusher); ok && err == nil {
+		flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,454 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// newTestHandler returns the handler of the queries over the records of
+// content, the flags reset to their defaults
+func newTestHandler(t *testing.T, content string) *queryHandler {
+	t.Helper()
+	resetFlags(rootCmd)
+	filename := writeFile(t, t.TempDir(), "data.jsonl", content)
+	h, err := newQueryHandler(filename, database.NewJSONTable(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// post sends a query to the handler, returning the response
+func post(h http.Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+	return rec
+}
+
+func TestServeQueries(t *testing.T) {
+	h := newTestHandler(t, `{"user":"ann","n":1}
+{"user":"bob","n":2}
+{"user":"ann","n":3}
+`)
+	tests := []struct {
+		body     string
+		status   int
+		expected string // the body, or a part of it for errors
+	}{
+		{"SELECT user, SUM(n) AS total GROUP BY user", http.StatusOK, "{\"user\":\"ann\",\"total\":4}\n{\"user\":\"bob\",\"total\":2}\n"},
+		{"SELECT n WHERE user = 'bob'", http.StatusOK, "{\"n\":2}\n"},
+		{".user", http.StatusOK, "\"ann\"\n\"bob\"\n\"ann\"\n"},
+		// FROM names the served input only
+		{"SELECT n FROM data WHERE user = 'bob'", http.StatusOK, "{\"n\":2}\n"},
+		{"SELECT n FROM 'data.jsonl' WHERE n > 2", http.StatusOK, "{\"n\":3}\n"},
+		{"SELECT n FROM (SELECT n FROM DATA) WHERE n < 2", http.StatusOK, "{\"n\":1}\n"},
+		{"SELECT n FROM events", http.StatusBadRequest, "only the served input"},
+		{"SELECT * FROM '/etc/passwd'", http.StatusBadRequest, "only the served input"},
+		{"SELECT n FROM (SELECT n FROM 'other.jsonl')", http.StatusBadRequest, "only the served input"},
+		{"SELEC n", http.StatusBadRequest, "expected a SELECT statement"},
+		{"SELECT n WHERE", http.StatusBadRequest, "parse error"},
+		{"SELECT n INTO 'out.jsonl'", http.StatusBadRequest, "INTO is not supported"},
+		{"UPDATE SET n = 0", http.StatusBadRequest, "only SELECT statements"},
+		{"DELETE WHERE n = 1", http.StatusBadRequest, "only SELECT statements"},
+		{"  ", http.StatusBadRequest, "empty query"},
+	}
+	for _, tt := range tests {
+		rec := post(h, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d (%s)", tt.body, tt.status, rec.Code, rec.Body)
+			continue
+		}
+		if tt.status == http.StatusOK {
+			if rec.Body.String() != tt.expected {
+				t.Errorf("%q: expected %q, got %q", tt.body, tt.expected, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("%q: unexpected Content-Type %q", tt.body, ct)
+			}
+		} else if !strings.Contains(rec.Body.String(), tt.expected) {
+			t.Errorf("%q: expected an error containing %q, got %q", tt.body, tt.expected, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET: expected 405 allowing POST, got %d (Allow %q)", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	rec = post(h, "SELECT n WHERE user = '"+strings.Repeat("x", maxQuerySize)+"'")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized query: expected 413, got %d", rec.Code)
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	h := newTestHandler(t, `{"n":1}
+`)
+	h.token = "s3cret"
+	for _, auth := range []string{"", "Bearer wrong", "Basic s3cret", "Bearer s3cre"} {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader("SELECT n"))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("Authorization %q: expected a 401 asking for a bearer token, got %d (%s)", auth, rec.Code, rec.Body)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader("SELECT n"))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"n\":1}\n" {
+		t.Errorf("Expected the token to be accepted, got %d (%s)", rec.Code, rec.Body)
+	}
+
+	// From the first line of --token-file, or the environment
+	dir := t.TempDir()
+	t.Setenv(ServeTokenEnv, "from-env")
+	if token, err := readServeToken(""); err != nil || token != "from-env" {
+		t.Errorf("readServeToken() = %q, %v, want the token of %s", token, err, ServeTokenEnv)
+	}
+	if token, err := readServeToken(writeFile(t, dir, "token", "from-file\nignored\n")); err != nil || token != "from-file" {
+		t.Errorf("readServeToken() = %q, %v, want the first line of the file", token, err)
+	}
+	if _, err := readServeToken(writeFile(t, dir, "empty", "\n")); err == nil {
+		t.Error("Expected an empty token file to be refused")
+	}
+}
+
+func TestServeAllow(t *testing.T) {
+	h := newTestHandler(t, `{"n":1}
+`)
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	inside := writeFile(t, allowed, "inside.jsonl", `{"n":2}`+"\n")
+	outside := writeFile(t, dir, "outside.jsonl", `{"n":3}`+"\n")
+	if err := os.Symlink(outside, filepath.Join(allowed, "link.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is allowed by default
+	if rec := post(h, "SELECT n FROM '"+inside+"'"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected files to be refused without --allow, got %d (%s)", rec.Code, rec.Body)
+	}
+
+	var err error
+	if h.allowed, err = allowedPaths([]string{allowed}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from     string
+		status   int
+		expected string
+	}{
+		{inside, http.StatusOK, "{\"n\":2}\n"},
+		{filepath.Join(allowed, "..", "allowed", "inside.jsonl"), http.StatusOK, "{\"n\":2}\n"},
+		{outside, http.StatusBadRequest, "the files of --allow"},
+		{filepath.Join(allowed, "..", "outside.jsonl"), http.StatusBadRequest, "the files of --allow"},
+		{filepath.Join(allowed, "link.jsonl"), http.StatusBadRequest, "the files of --allow"},
+		{filepath.Join(allowed, "*.jsonl"), http.StatusBadRequest, "the files of --allow"},
+		{allowed + "-sibling.jsonl", http.StatusBadRequest, "the files of --allow"},
+	}
+	for _, tt := range tests {
+		rec := post(h, "SELECT n FROM '"+tt.from+"'")
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.expected) {
+			t.Errorf("FROM %s: expected %d %q, got %d %q", tt.from, tt.status, tt.expected, rec.Code, rec.Body)
+		}
+	}
+
+	// A page reads the served input only
+	if rec := postURL(h, "/query?limit=1", "SELECT n FROM '"+inside+"'"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a page of an allowed file to be refused, got %d (%s)", rec.Code, rec.Body)
+	}
+	if _, err := allowedPaths([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Expected a missing --allow path to be refused")
+	}
+}
+
+func TestServeAbort(t *testing.T) {
+	// More rows than the output buffer holds, then a malformed record
+	var content strings.Builder
+	for i := 0; i < 50000; i++ {
+		content.WriteString(`{"n":1}` + "\n")
+	}
+	content.WriteString("{broken\n")
+	h := newTestHandler(t, content.String())
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	for _, body := range []string{"SELECT n", ".n"} {
+		resp, err := http.Post(server.URL, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%q: request failed: %v", body, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%q: expected the rows to start with 200, got %d", body, resp.StatusCode)
+		}
+		if err == nil {
+			t.Errorf("%q: expected the response to be cut short, read %d bytes", body, len(data))
+		}
+		if len(data) == 0 {
+			t.Errorf("%q: expected the first rows before the abort", body)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestServeCounters(t *testing.T) {
+	h := newTestHandler(t, "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n")
+	Summary = true
+	defer func() { Summary = false }()
+	var reports lockedBuffer
+	r := diag.Default()
+	savedW, savedFormat, savedLevel := r.W, r.Format, r.Level
+	r.W, r.Format, r.Level = &reports, diag.FormatText, diag.LevelInfo
+	defer func() { r.W, r.Format, r.Level = savedW, savedFormat, savedLevel }()
+
+	// Every request reports its own records and rows, whatever runs
+	// concurrently
+	const requests = 8
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		body := ".n"
+		if i%2 == 1 {
+			body = "SELECT n WHERE n > 1"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := post(h, body); rec.Code != http.StatusOK {
+				t.Errorf("%q: expected 200, got %d (%s)", body, rec.Code, rec.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(reports.buf.String()), "\n")
+	if len(lines) != requests {
+		t.Fatalf("Expected a summary per request, got\n%s", reports.buf.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "read 3 record(s)") ||
+			!(strings.Contains(line, "matched 2, emitted 2") || strings.Contains(line, "), emitted 3")) {
+			t.Errorf("Unexpected request summary: %s", line)
+		}
+	}
+}
+
+// postServer sends a query to a server, returning the status and body of
+// the response and whether it was cut short
+func postServer(t *testing.T, server *httptest.Server, body string) (int, string, bool) {
+	t.Helper()
+	resp, err := http.Post(server.URL, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%q: request failed: %v", body, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data), err != nil
+}
+
+func TestServeLimits(t *testing.T) {
+	if def := serveCmd.Flags().Lookup("listen").DefValue; !strings.HasPrefix(def, "127.0.0.1:") {
+		t.Errorf("Expected serve to listen on localhost by default, got %q", def)
+	}
+
+	h := newTestHandler(t, "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n")
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	// Rows beyond the limit abort the query, with an error status when no
+	// row was sent yet
+	h.maxRows = 2
+	for _, body := range []string{"SELECT n", ".n"} {
+		status, data, cut := postServer(t, server, body)
+		if !cut && (status != http.StatusInternalServerError || !strings.Contains(data, "--max-rows")) {
+			t.Errorf("%q: expected the row limit to abort the query, got %d (%s)", body, status, data)
+		}
+	}
+	for _, body := range []string{"SELECT n LIMIT 2", "SELECT n WHERE n > 1", ".n | select(. > 1)"} {
+		if status, data, cut := postServer(t, server, body); status != http.StatusOK || cut {
+			t.Errorf("%q: expected the rows within the limit, got %d (%s)", body, status, data)
+		}
+	}
+
+	h.maxRows = 0
+	h.timeout = time.Nanosecond
+	for _, body := range []string{"SELECT n", ".n"} {
+		status, data, cut := postServer(t, server, body)
+		if !cut && (status != http.StatusServiceUnavailable || !strings.Contains(data, "--timeout")) {
+			t.Errorf("%q: expected the timeout to abort the query, got %d (%s)", body, status, data)
+		}
+	}
+}
+
+// postURL sends a query to the handler at target ("/query?limit=2"),
+// returning the response
+func postURL(h http.Handler, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestServePages(t *testing.T) {
+	h := newTestHandler(t, `{"n":1,"tags":["a","b","c"]}
+{"n":2,"tags":["d"]}
+
+{"n":3,"tags":["e","f"]}
+`)
+	tests := []struct {
+		body  string
+		limit int
+		pages []string
+	}{
+		// The rows of a record are split across pages
+		{"SELECT tags AS t", 2, []string{`{"t":"a"}{"t":"b"}`, `{"t":"c"}{"t":"d"}`, `{"t":"e"}{"t":"f"}`}},
+		{"SELECT tags AS t", 4, []string{`{"t":"a"}{"t":"b"}{"t":"c"}{"t":"d"}`, `{"t":"e"}{"t":"f"}`}},
+		{".tags | .[]", 3, []string{`"a""b""c"`, `"d""e""f"`}},
+		{"SELECT n WHERE n > 1", 1, []string{`{"n":2}`, `{"n":3}`}},
+		{"SELECT n WHERE n > 5", 1, []string{``}},
+		{".n", 10, []string{`123`}},
+	}
+	for _, tt := range tests {
+		var pages []string
+		cursor := ""
+		for len(pages) <= len(tt.pages) {
+			target := fmt.Sprintf("/query?limit=%d", tt.limit)
+			if cursor != "" {
+				target += "&cursor=" + cursor
+			}
+			rec := postURL(h, target, tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%q at %s: expected 200, got %d (%s)", tt.body, target, rec.Code, rec.Body)
+			}
+			pages = append(pages, strings.ReplaceAll(rec.Body.String(), "\n", ""))
+			if cursor = rec.Header().Get(nextCursorHeader); cursor == "" {
+				break
+			}
+		}
+		if !reflect.DeepEqual(pages, tt.pages) {
+			t.Errorf("%q by %d: expected pages %q, got %q", tt.body, tt.limit, tt.pages, pages)
+		}
+	}
+
+	// A cursor is tied to its query
+	rec := postURL(h, "/query?limit=1", ".n")
+	cursor := rec.Header().Get(nextCursorHeader)
+	if cursor == "" {
+		t.Fatalf("Expected a cursor, got %q", rec.Body)
+	}
+	h.maxRows = 5
+	for _, tt := range []struct {
+		target, body, expected string
+	}{
+		{"/query?limit=1&cursor=" + cursor, ".user", "another query"},
+		{"/query?limit=1&cursor=bm9wZQ", ".n", "invalid cursor"},
+		{"/query?cursor=" + cursor, ".n", "cursor requires limit"},
+		{"/query?limit=0", ".n", "invalid limit"},
+		{"/query?limit=ten", ".n", "invalid limit"},
+		{"/query?limit=6", ".n", "--max-rows"},
+		{"/query?limit=1", "SELECT COUNT(*) AS c", "GROUP BY or aggregates"},
+		{"/query?limit=1", "SELECT n ORDER BY n", "ORDER BY"},
+		{"/query?limit=1", "SELECT n LIMIT 2", "LIMIT"},
+		{"/query?limit=1", "SELECT n FROM (SELECT n)", "subquery"},
+	} {
+		rec := postURL(h, tt.target, tt.body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.expected) {
+			t.Errorf("%q at %s: expected a 400 containing %q, got %d (%s)", tt.body, tt.target, tt.expected, rec.Code, rec.Body)
+		}
+	}
+
+	// Only a JSONL file can be paged through
+	h, err := newQueryHandler("-", database.NewSliceTable([]map[string]interface{}{{"n": 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := postURL(h, "/query?limit=1", ".n"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "JSON Lines") {
+		t.Errorf("Expected a table that is not a file to refuse pages, got %d (%s)", rec.Code, rec.Body)
+	}
+}
+
+func TestServeTables(t *testing.T) {
+	h := newTestHandler(t, `{"user":"ann","n":1.5}
+{"user":"bob","n":2.5,"tags":["a"]}
+`)
+	mux := newServeMux(h)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	var tables []tableJSON
+	rec := get("/tables")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /tables: expected a JSON 200, got %d (%s)", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tables); err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 {
+		t.Fatalf("Expected the served table alone, got %s", rec.Body)
+	}
+	table := tables[0]
+	expected := parser.Schema{"user": parser.TypeString, "n": parser.TypeFloat, "tags": parser.TypeArray}
+	if table.Name != "data" || table.Format != "JSONL" || table.Rows != 2 || !table.Exact || table.Size <= 0 || !reflect.DeepEqual(table.Schema, expected) {
+		t.Errorf("Unexpected table %+v", table)
+	}
+
+	// Described by any name FROM accepts
+	for _, name := range []string{"data", "DATA", "data.jsonl"} {
+		var described tableJSON
+		rec := get("/tables/" + name)
+		if err := json.Unmarshal(rec.Body.Bytes(), &described); rec.Code != http.StatusOK || err != nil || !reflect.DeepEqual(described, table) {
+			t.Errorf("GET /tables/%s = %d %s, want %+v", name, rec.Code, rec.Body, table)
+		}
+	}
+	if rec := get("/tables/events"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown table to be a 404, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tables", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /tables: expected 405, got %d", rec.Code)
+	}
+	h.token = "s3cret"
+	if rec := get("/tables"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected /tables to require the token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var setCmd = &cobra.Command{
+	Use:   "set [file|-] path value",
+	Short: "Set a value at a path in every record",
+	Long: `Set the value at a path in every record and print the modified documents.
+Missing intermediate objects are created. The value is parsed as JSON
+(true, 42, null, {"a":1}, "quoted") and taken as a plain string otherwise.
+
+Supports:
+  - File paths: jsl set data.json .user.active true
+  - Stdin: cat data.json | jsl set .user.active true (or use "-")
+
+Examples:
+  jsl set data.json .user.active true
+  jsl set data.jsonl .meta.source import
+  jsl set data.json '.items.*.checked' false
+  jsl set data.json '.tags[0]' '"first"'`,
+	Args: cobra.RangeArgs(2, 3),
+	RunE: runSet,
+}
+
+func runSet(cmd *cobra.Command, args []string) error {
+	filename := "-"
+	if len(args) == 3 {
+		filename, args = args[0], args[1:]
+	}
+	return RunSet(filename, args[0], parseSetValue(args[1]), QueryPretty)
+}
+
+// RunSet sets value at path in every record of filename and writes the
+// records to stdout in the input format
+func RunSet(filename string, path string, value interface{}, pretty bool) error {
+	p, err := newParser(filename)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	records, err := p.ReadAllOrdered()
+	if err != nil {
+		return err
+	}
+
+	for i, record := range records {
+		if records[i], err = query.SetOrdered(record, path, value); err != nil {
+			return err
+		}
+	}
+	return writeRecords(p, records, pretty)
+}
+
+// writeRecords writes modified records to stdout in the format they were
+// read, their keys in document order
+func writeRecords(p *parser.Parser, records []parser.OrderedMap, pretty bool) error {
+	switch {
+	case p.IsJSONL():
+		return parser.WriteJSONL(os.Stdout, records, pretty)
+	case p.IsArray():
+		return parser.WriteJSON(os.Stdout, records, pretty)
+	default:
+		// One (or several concatenated) documents are written back as such
+		return parser.WriteJSONL(os.Stdout, records, pretty)
+	}
+}
+
+// parseSetValue reads a command line value as JSON, falling back to a string
+func parseSetValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	return v
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// skippedLines records the malformed lines skipped under --skip-errors
+var skippedLines = &skipLog{}
+
+// skipLog counts the skipped lines, each once although tables may be
+// scanned several times, and copies them to the --errors-file
+type skipLog struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	file  *os.File
+	count int
+	err   error
+}
+
+// skipHandler returns the handler of the lines skipped under --skip-errors,
+// nil without it
+func skipHandler() parser.ErrorHandler {
+	if !QuerySkipErrors {
+		return nil
+	}
+	return skippedLines.skip
+}
+
+func (l *skipLog) skip(name string, line int, data []byte, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := fmt.Sprintf("%s:%d", name, line)
+	if l.seen[key] {
+		return
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	l.seen[key] = true
+	l.count++
+	diag.Counters().Skipped.Add(1)
+	diag.Debug(diag.CodeSkippedRecord, fmt.Sprintf("%s: line %d skipped: %v", name, line, err),
+		"file", name, "line", line, "error", err.Error())
+
+	if l.file != nil && l.err == nil {
+		if _, l.err = l.file.Write(append(data, '\n')); l.err != nil {
+			l.err = fmt.Errorf("failed to write --errors-file: %w", l.err)
+		}
+	}
+}
+
+// configureSkipErrors opens the --errors-file
+func configureSkipErrors() error {
+	if QueryErrorsFile == "" {
+		return nil
+	}
+	if !QuerySkipErrors {
+		return fmt.Errorf("--errors-file requires --skip-errors")
+	}
+	if err := checkWritable("--errors-file"); err != nil {
+		return err
+	}
+	file, err := os.Create(QueryErrorsFile)
+	if err != nil {
+		return fmt.Errorf("failed to create errors file: %w", err)
+	}
+	skippedLines.file = file
+	return nil
+}
+
+// reportSkipped warns about the lines skipped during the run and closes the
+// --errors-file
+func reportSkipped() error {
+	l := skippedLines
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count > 0 {
+		msg := fmt.Sprintf("skipped %d malformed line(s)", l.count)
+		if l.file != nil {
+			msg += " (written to " + QueryErrorsFile + ")"
+		}
+		diag.Warn(diag.CodeSkippedRecord, msg, "lines", l.count)
+	}
+	if l.file == nil {
+		return l.err
+	}
+	if err := l.file.Close(); l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
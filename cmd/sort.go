@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"sort"
+
+	"github.com/bisegni/jsl/pkg/engine"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var sortBy string
+
+var sortCmd = &cobra.Command{
+	Use:   "sort [file|-] --by 'key [asc|desc] [nulls first|last], ...'",
+	Short: "Sort records by one or more fields",
+	Long: `Sort the records of a file by one or more keys, each ascending unless
+followed by desc, and print them in the input format. The sort is stable:
+records with equal keys keep their input order.
+
+Numbers compare numerically and RFC3339 timestamps chronologically. Records
+without a key sort after the others in either direction, unless the key is
+followed by nulls first.
+
+Examples:
+  jsl sort data.jsonl --by price
+  jsl sort data.jsonl --by 'category asc, price desc, name'
+  jsl sort data.jsonl --by 'price desc nulls first'
+  cat data.json | jsl sort --by 'meta.ts desc'`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runSort,
+}
+
+func init() {
+	sortCmd.Flags().StringVar(&sortBy, "by", "", "Sort keys, comma-separated, each optionally followed by asc or desc and nulls first or last (e.g., 'category, price desc')")
+	sortCmd.MarkFlagRequired("by")
+}
+
+func runSort(cmd *cobra.Command, args []string) error {
+	filename := "-"
+	if len(args) == 1 {
+		filename = args[0]
+	}
+	keys, err := query.ParseOrderBy(sortBy)
+	if err != nil {
+		return err
+	}
+	return RunSort(filename, keys, QueryPretty)
+}
+
+// RunSort writes the records of filename to stdout, stably sorted by keys
+func RunSort(filename string, keys []query.OrderKey, pretty bool) error {
+	p, err := newParser(filename)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	records, err := p.ReadAllOrdered()
+	if err != nil {
+		return err
+	}
+
+	queries := make([]*query.Query, len(keys))
+	for i, key := range keys {
+		queries[i] = query.NewQuery(key.Path)
+		queries[i].CaseInsensitive = QueryCI
+	}
+	values := make([][]interface{}, len(records))
+	for r, record := range records {
+		values[r] = make([]interface{}, len(keys))
+		for i, q := range queries {
+			values[r][i] = engine.SortKey(q, record)
+		}
+	}
+
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return query.CompareRows(values[order[i]], values[order[j]], keys) < 0
+	})
+	sorted := make([]parser.OrderedMap, len(records))
+	for i, r := range order {
+		sorted[i] = records[r]
+	}
+	return writeRecords(p, sorted, pretty)
+}
@@ -32,7 +32,7 @@ func runStats(cmd *cobra.Command, args []string) error {
 		filename = args[0]
 	}
 
-	p, err := parser.NewParser(filename)
+	p, err := newParser(filename)
 	if err != nil {
 		return err
 	}
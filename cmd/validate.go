@@ -3,7 +3,6 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/spf13/cobra"
 )
 
@@ -30,7 +29,7 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		filename = args[0]
 	}
 
-	p, err := parser.NewParser(filename)
+	p, err := newParser(filename)
 	if err != nil {
 		return err
 	}
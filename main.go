@@ -1,15 +1,20 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"os"
 
 	"github.com/bisegni/jsl/cmd"
+	"github.com/bisegni/jsl/pkg/diag"
 )
 
 func main() {
 	if err := cmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		var status cmd.ExitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		diag.Error(err)
 		os.Exit(1)
 	}
 }
@@ -1,37 +1,185 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/parser"
 )
 
-// Catalog manages a collection of named tables (databases)
+// TableInfo describes a table registered in a Catalog
+type TableInfo struct {
+	Name         string
+	Source       string        // Source path or description (e.g. file name, "<stdin>")
+	Format       string        // "JSON", "JSONL", ...
+	Schema       parser.Schema // Inferred field types, may be nil
+	RowEstimate  int           // Estimated number of rows, -1 if unknown
+	RowsExact    bool          // RowEstimate is an exact count
+	Size         int64         // Size of the source in bytes, -1 if unknown
+	RegisteredAt time.Time
+}
+
+type catalogEntry struct {
+	table Table
+	info  TableInfo
+}
+
+// Catalog manages a collection of named tables (databases). It is safe for
+// concurrent use: tables can be registered while queries look them up.
 type Catalog struct {
-	tables map[string]Table
+	tables map[string]catalogEntry
 	mu     sync.RWMutex
 }
 
 // NewCatalog creates a new empty catalog
 func NewCatalog() *Catalog {
 	return &Catalog{
-		tables: make(map[string]Table),
+		tables: make(map[string]catalogEntry),
 	}
 }
 
 // RegisterTable adds a table to the catalog
 func (c *Catalog) RegisterTable(name string, t Table) {
+	c.RegisterTableWithInfo(name, t, TableInfo{RowEstimate: -1})
+}
+
+// RegisterTableWithInfo adds a table to the catalog along with its metadata.
+// The name and registration time are filled in by the catalog.
+func (c *Catalog) RegisterTableWithInfo(name string, t Table, info TableInfo) {
+	info.Name = name
+	info.RegisteredAt = time.Now()
+	info.Schema = copySchema(info.Schema)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.tables[name] = t
+	c.tables[name] = catalogEntry{table: t, info: info}
 }
 
 // GetTable retrieves a table by name
 func (c *Catalog) GetTable(name string) (Table, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	t, ok := c.tables[name]
+	e, ok := c.tables[name]
 	if !ok {
 		return nil, fmt.Errorf("table '%s' not found", name)
 	}
-	return t, nil
+	return e.table, nil
+}
+
+// Info returns the metadata of a table by name
+func (c *Catalog) Info(name string) (TableInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.tables[name]
+	if !ok {
+		return TableInfo{}, fmt.Errorf("table '%s' not found", name)
+	}
+	return e.info.clone(), nil
+}
+
+// List returns the metadata of all registered tables, sorted by name
+func (c *Catalog) List() []TableInfo {
+	c.mu.RLock()
+	infos := make([]TableInfo, 0, len(c.tables))
+	for _, e := range c.tables {
+		infos = append(infos, e.info.clone())
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// clone returns a copy that does not share the schema map
+func (i TableInfo) clone() TableInfo {
+	i.Schema = copySchema(i.Schema)
+	return i
+}
+
+func copySchema(s parser.Schema) parser.Schema {
+	if s == nil {
+		return nil
+	}
+	out := make(parser.Schema, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
+
+// EstimateRows extrapolates the number of rows of a source of size bytes
+// from the encoded size of its first sampleSize rows. The count is exact
+// (and exact is true) when the table has fewer rows than the sample.
+func EstimateRows(t Table, size int64, sampleSize int) (rows int, exact bool, err error) {
+	it, err := t.Iterate(context.Background())
+	if err != nil {
+		return 0, false, err
+	}
+	defer it.Close()
+
+	var sampled int64
+	count := 0
+	for count < sampleSize && it.Next() {
+		count++
+		data, err := json.Marshal(it.Row().Primitive())
+		if err != nil {
+			return 0, false, err
+		}
+		sampled += int64(len(data)) + 1 // one line per row
+	}
+	if err := it.Error(); err != nil {
+		return 0, false, err
+	}
+	if count < sampleSize || sampled == 0 {
+		return count, true, nil
+	}
+	return int(float64(size) * float64(count) / float64(sampled)), false, nil
+}
+
+// Describe scans up to sampleSize rows of a table and infers the types of its
+// top-level fields. The returned count is exact when it is below sampleSize.
+// Fields seen with different types are reported as the first type found.
+func Describe(t Table, sampleSize int) (parser.Schema, int, error) {
+	it, err := t.Iterate(context.Background())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer it.Close()
+
+	schema := parser.Schema{}
+	add := func(key string, val interface{}) {
+		if _, seen := schema[key]; seen {
+			return
+		}
+		if typ := parser.TypeOf(val); typ != "" {
+			schema[key] = typ
+		}
+	}
+
+	count := 0
+	for count < sampleSize && it.Next() {
+		count++
+		switch v := it.Row().Primitive().(type) {
+		case parser.Record:
+			for k, val := range v {
+				add(k, val)
+			}
+		case map[string]interface{}:
+			for k, val := range v {
+				add(k, val)
+			}
+		case OrderedMap:
+			for _, kv := range v {
+				add(kv.Key, kv.Val)
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, count, err
+	}
+	return schema, count, nil
 }
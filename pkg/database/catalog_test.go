@@ -0,0 +1,157 @@
+package database_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+func TestCatalogRegister(t *testing.T) {
+	c := database.NewCatalog()
+	table := database.NewSliceTable(nil)
+	schema := parser.Schema{"id": parser.TypeInt}
+	before := time.Now()
+	c.RegisterTableWithInfo("users", table, database.TableInfo{
+		Name:        "ignored",
+		Source:      "users.jsonl",
+		Format:      "JSONL",
+		Schema:      schema,
+		RowEstimate: 42,
+		RowsExact:   true,
+		Size:        1024,
+	})
+
+	got, err := c.GetTable("users")
+	if err != nil || got != table {
+		t.Fatalf("GetTable(users) = %v, %v, want the registered table", got, err)
+	}
+	info, err := c.Info("users")
+	if err != nil {
+		t.Fatalf("Info(users) failed: %v", err)
+	}
+	if info.Name != "users" || info.Source != "users.jsonl" || info.Format != "JSONL" ||
+		info.RowEstimate != 42 || !info.RowsExact || info.Size != 1024 {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if info.RegisteredAt.Before(before) {
+		t.Errorf("Expected the registration time to be set, got %v", info.RegisteredAt)
+	}
+
+	// The catalog keeps its own copy of the schema
+	schema["name"] = parser.TypeString
+	info.Schema["email"] = parser.TypeString
+	if info, _ := c.Info("users"); !reflect.DeepEqual(info.Schema, parser.Schema{"id": parser.TypeInt}) {
+		t.Errorf("Expected the schema to be unaffected by its copies, got %v", info.Schema)
+	}
+
+	// Without metadata, the row count is unknown
+	c.RegisterTable("users", database.NewSliceTable(nil))
+	if info, _ := c.Info("users"); info.RowEstimate != -1 || info.Source != "" || info.Schema != nil {
+		t.Errorf("Expected re-registering to replace the info, got %+v", info)
+	}
+
+	if _, err := c.GetTable("missing"); err == nil {
+		t.Error("Expected an error getting an unknown table")
+	}
+	if _, err := c.Info("missing"); err == nil {
+		t.Error("Expected an error for the info of an unknown table")
+	}
+}
+
+func TestCatalogList(t *testing.T) {
+	c := database.NewCatalog()
+	if infos := c.List(); len(infos) != 0 {
+		t.Errorf("Expected an empty list, got %v", infos)
+	}
+	for _, name := range []string{"orders", "Users", "default", "audit"} {
+		c.RegisterTable(name, database.NewSliceTable(nil))
+	}
+
+	var names []string
+	for _, info := range c.List() {
+		names = append(names, info.Name)
+	}
+	if expected := []string{"Users", "audit", "default", "orders"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestCatalogConcurrent(t *testing.T) {
+	c := database.NewCatalog()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("t%d", i)
+			c.RegisterTable(name, database.NewSliceTable(nil))
+			if _, err := c.GetTable(name); err != nil {
+				t.Error(err)
+			}
+			c.List()
+		}(i)
+	}
+	wg.Wait()
+	if n := len(c.List()); n != 8 {
+		t.Errorf("Expected 8 tables, got %d", n)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"id": 1, "name": "ann", "score": 1.5, "tags": []interface{}{"a"}, "meta": map[string]interface{}{"x": 1}, "note": nil},
+		{"id": "2", "active": true, "note": "late"},
+		{"id": 3, "seen": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"extra": 1},
+	})
+
+	schema, count, err := database.Describe(table, 3)
+	if err != nil {
+		t.Fatalf("Describe failed: %v", err)
+	}
+	// Fields seen with several types keep the first, nulls take the type of
+	// a later value, and rows beyond the sample are not read
+	expected := parser.Schema{
+		"id":     parser.TypeInt,
+		"name":   parser.TypeString,
+		"score":  parser.TypeFloat,
+		"tags":   parser.TypeArray,
+		"meta":   parser.TypeObject,
+		"note":   parser.TypeString,
+		"active": parser.TypeBool,
+		"seen":   parser.TypeTimestamp,
+	}
+	if count != 3 || !reflect.DeepEqual(schema, expected) {
+		t.Errorf("Describe() = %v, %d, want %v, 3", schema, count, expected)
+	}
+
+	schema, count, err = database.Describe(table, 100)
+	if err != nil || count != 4 || schema["extra"] != parser.TypeInt {
+		t.Errorf("Describe() = %v, %d, %v, want every row described", schema, count, err)
+	}
+
+	schema, count, err = database.Describe(database.NewSliceTable(nil), 100)
+	if err != nil || count != 0 || len(schema) != 0 {
+		t.Errorf("Describe() of an empty table = %v, %d, %v", schema, count, err)
+	}
+}
+
+func TestEstimateRows(t *testing.T) {
+	rows := make([]map[string]interface{}, 10)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"n": i} // {"n":0} and a newline, 8 bytes
+	}
+	table := database.NewSliceTable(rows)
+
+	if n, exact, err := database.EstimateRows(table, 80, 20); err != nil || n != 10 || !exact {
+		t.Errorf("EstimateRows() = %d, %v, %v, want an exact 10", n, exact, err)
+	}
+	if n, exact, err := database.EstimateRows(table, 800, 5); err != nil || n != 100 || exact {
+		t.Errorf("EstimateRows() = %d, %v, %v, want an estimated 100", n, exact, err)
+	}
+}
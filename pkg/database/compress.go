@@ -0,0 +1,115 @@
+package database
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Output compression codecs
+const (
+	CompressNone = ""
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// compressExtensions maps the file extensions of compressed outputs to
+// their codec
+var compressExtensions = map[string]string{
+	".gz":  CompressGzip,
+	".zst": CompressZstd,
+}
+
+// CompressionOf returns the codec named by the extension of an output file
+// (.gz or .zst), or CompressNone
+func CompressionOf(filename string) string {
+	name := strings.ToLower(filename)
+	for ext, codec := range compressExtensions {
+		if strings.HasSuffix(name, ext) {
+			return codec
+		}
+	}
+	return CompressNone
+}
+
+// uncompressedName strips the compression extension from an output file
+// name, leaving the extension naming its format ("out.jsonl.gz" is JSONL)
+func uncompressedName(filename string) string {
+	name := strings.ToLower(filename)
+	for ext := range compressExtensions {
+		if strings.HasSuffix(name, ext) {
+			return filename[:len(filename)-len(ext)]
+		}
+	}
+	return filename
+}
+
+// CheckCompression fails on an unknown codec
+func CheckCompression(codec string) error {
+	switch codec {
+	case CompressNone, CompressGzip, CompressZstd:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression '%s' (use gzip or zstd)", codec)
+}
+
+// NewCompressWriter returns a writer compressing to w with codec, w itself
+// (with a no-op Close) for CompressNone. Closing the writer flushes the
+// compressed stream but does not close w.
+func NewCompressWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case CompressNone:
+		return nopWriteCloser{w}, nil
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, CheckCompression(codec)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressedFile is an output file written through a compressor
+type compressedFile struct {
+	io.WriteCloser
+	file *os.File
+}
+
+// createOutput creates (or truncates) filename, compressing what is written
+// to it with codec
+func createOutput(filename, codec string) (io.WriteCloser, error) {
+	if err := CheckCompression(codec); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	if codec == CompressNone {
+		return file, nil
+	}
+	w, err := NewCompressWriter(file, codec)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &compressedFile{WriteCloser: w, file: file}, nil
+}
+
+// Close ends the compressed stream and closes the file
+func (f *compressedFile) Close() error {
+	err := f.WriteCloser.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package database
+
+import "context"
+
+// WithContext returns an iterator over the rows of it that stops once ctx
+// is done, its Error then being the error of ctx. Iterators of contexts
+// that are never done are returned as is.
+func WithContext(ctx context.Context, it RowIterator) RowIterator {
+	if ctx.Done() == nil {
+		return it
+	}
+	return &contextIterator{RowIterator: it, ctx: ctx}
+}
+
+type contextIterator struct {
+	RowIterator
+	ctx context.Context
+	err error
+}
+
+func (it *contextIterator) Next() bool {
+	select {
+	case <-it.ctx.Done():
+		it.err = it.ctx.Err()
+		return false
+	default:
+	}
+	return it.RowIterator.Next()
+}
+
+func (it *contextIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.RowIterator.Error()
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// CSVSink writes rows to a writer as CSV. The header names the fields of
+// the first row, nested objects being flattened into dotted keys (Flatten),
+// and a later row holding a non-null field missing from the header fails.
+// Missing fields and nulls are empty cells, strings are written as is and
+// other values as JSON.
+type CSVSink struct {
+	w       *csv.Writer
+	columns map[string]int
+	cells   []string
+}
+
+// NewCSVSink returns a sink writing CSV to w
+func NewCSVSink(w io.Writer) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w)}
+}
+
+func (s *CSVSink) Write(row Row) error {
+	value := Flatten(row.Primitive())
+	if !isObject(value) {
+		return fmt.Errorf("CSV rows must be objects, got %s", parser.TypeOf(value))
+	}
+	if s.columns == nil {
+		s.columns = make(map[string]int)
+		var header []string
+		eachField(value, func(key string, _ interface{}) {
+			if _, seen := s.columns[key]; !seen {
+				s.columns[key] = len(header)
+				header = append(header, key)
+			}
+		})
+		if err := s.w.Write(header); err != nil {
+			return err
+		}
+		s.cells = make([]string, len(header))
+	}
+
+	for i := range s.cells {
+		s.cells[i] = ""
+	}
+	var err error
+	eachField(value, func(key string, val interface{}) {
+		if err != nil {
+			return
+		}
+		col, ok := s.columns[key]
+		if !ok && val == nil {
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("field %s is not a CSV column (the columns are the fields of the first row)", key)
+			return
+		}
+		s.cells[col], err = csvCell(val)
+	})
+	if err != nil {
+		return err
+	}
+	return s.w.Write(s.cells)
+}
+
+// Close flushes the rows written. The writer is not closed.
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// csvCell formats a value as a CSV cell
+func csvCell(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int, int64:
+		return fmt.Sprint(v), nil
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	// Values encoded as JSON strings (timestamps) are written unquoted
+	var s string
+	if len(data) > 0 && data[0] == '"' && json.Unmarshal(data, &s) == nil {
+		return s, nil
+	}
+	return string(data), nil
+}
+
+// CSVFileSink writes rows to a file as CSV (see CSVSink)
+type CSVFileSink struct {
+	file   io.WriteCloser
+	writer *bufio.Writer
+	sink   *CSVSink
+}
+
+// NewCSVFileSink creates (or truncates) filename and returns a sink writing to it
+func NewCSVFileSink(filename string) (*CSVFileSink, error) {
+	return newCSVFileSink(filename, CompressionOf(filename))
+}
+
+func newCSVFileSink(filename, codec string) (*CSVFileSink, error) {
+	file, err := createOutput(filename, codec)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &CSVFileSink{file: file, writer: w, sink: NewCSVSink(w)}, nil
+}
+
+func (s *CSVFileSink) Write(row Row) error {
+	return s.sink.Write(row)
+}
+
+func (s *CSVFileSink) Close() error {
+	return closeFileSink(s.sink, s.writer, s.file)
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"sort"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// Flatten turns a nested object into a single-level object with dotted
+// keys, e.g. {"supplier":{"country":"IT"}} becomes {"supplier.country":"IT"},
+// as expected by CSV exporters and spreadsheets. Arrays and empty objects
+// are kept as values. Keys keep their order in OrderedMap values and are
+// sorted in plain maps. Values that are not objects are returned unchanged.
+func Flatten(v interface{}) interface{} {
+	if !isObject(v) {
+		return v
+	}
+	out := OrderedMap{}
+	flattenInto(&out, "", v)
+	return out
+}
+
+func flattenInto(out *OrderedMap, prefix string, v interface{}) {
+	eachField(v, func(key string, val interface{}) {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if isObject(val) && !isEmptyObject(val) {
+			flattenInto(out, key, val)
+			return
+		}
+		*out = append(*out, KeyVal{Key: key, Val: val})
+	})
+}
+
+func isObject(v interface{}) bool {
+	switch v.(type) {
+	case OrderedMap, parser.Record, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+func isEmptyObject(v interface{}) bool {
+	empty := true
+	eachField(v, func(string, interface{}) { empty = false })
+	return empty
+}
+
+// eachField visits the fields of an object, plain maps in key order
+func eachField(v interface{}, fn func(key string, val interface{})) {
+	var m map[string]interface{}
+	switch o := v.(type) {
+	case OrderedMap:
+		for _, kv := range o {
+			fn(kv.Key, kv.Val)
+		}
+		return
+	case parser.Record:
+		m = o
+	case map[string]interface{}:
+		m = o
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(k, m[k])
+	}
+}
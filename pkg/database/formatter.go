@@ -0,0 +1,228 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// Value formats understood by NewValueFormatter
+const (
+	// FormatRFC3339 writes timestamps, RFC3339 strings and Unix times in seconds as RFC3339
+	FormatRFC3339 = "rfc3339"
+	// FormatBytes writes byte counts with binary units ("1.5 KiB")
+	FormatBytes = "bytes"
+	// FormatFixed writes numbers with a fixed number of decimals ("fixed:2")
+	FormatFixed = "fixed"
+)
+
+// typePrefix selects a formatter by value type rather than field name ("type:float=fixed:2")
+const typePrefix = "type:"
+
+// ValueFormatter rewrites an output value, e.g. a number of bytes as
+// "1.5 KiB". Values it does not apply to are returned unchanged.
+type ValueFormatter func(v interface{}) interface{}
+
+// Formatters selects the formatter of each output value, first by the name
+// of its field (dotted for nested objects, e.g. "supplier.since"), then by
+// the type of the value (parser.TypeOf).
+type Formatters struct {
+	Fields map[string]ValueFormatter
+	Types  map[string]ValueFormatter
+}
+
+// NewValueFormatter returns the formatter named by format: rfc3339, bytes
+// or fixed:N
+func NewValueFormatter(format string) (ValueFormatter, error) {
+	name, arg, hasArg := strings.Cut(strings.ToLower(strings.TrimSpace(format)), ":")
+	switch {
+	case name == FormatRFC3339 && !hasArg:
+		return formatRFC3339, nil
+	case name == FormatBytes && !hasArg:
+		return formatBytes, nil
+	case name == FormatFixed && hasArg:
+		decimals, err := strconv.Atoi(arg)
+		if err != nil || decimals < 0 {
+			return nil, fmt.Errorf("invalid decimals in '%s'", format)
+		}
+		return func(v interface{}) interface{} { return formatFixed(v, decimals) }, nil
+	}
+	return nil, fmt.Errorf("unknown value format '%s' (use %s, %s or %s:N)", format, FormatRFC3339, FormatBytes, FormatFixed)
+}
+
+// Add parses a "field=format" or "type:TYPE=format" specification
+func (f *Formatters) Add(spec string) error {
+	target, format, ok := strings.Cut(spec, "=")
+	target = strings.TrimSpace(target)
+	if !ok || target == "" {
+		return fmt.Errorf("invalid value format '%s' (use field=format or %sTYPE=format)", spec, typePrefix)
+	}
+	formatter, err := NewValueFormatter(format)
+	if err != nil {
+		return err
+	}
+
+	if typ, ok := strings.CutPrefix(target, typePrefix); ok {
+		switch typ {
+		case parser.TypeInt, parser.TypeFloat, parser.TypeString, parser.TypeTimestamp:
+		default:
+			return fmt.Errorf("cannot format values of type '%s'", typ)
+		}
+		if f.Types == nil {
+			f.Types = make(map[string]ValueFormatter)
+		}
+		f.Types[typ] = formatter
+		return nil
+	}
+	if f.Fields == nil {
+		f.Fields = make(map[string]ValueFormatter)
+	}
+	f.Fields[target] = formatter
+	return nil
+}
+
+// Empty reports whether no formatter is configured
+func (f *Formatters) Empty() bool {
+	return f == nil || (len(f.Fields) == 0 && len(f.Types) == 0)
+}
+
+// Apply returns a copy of a row with its values formatted; the row itself
+// is not modified
+func (f *Formatters) Apply(row interface{}) interface{} {
+	if f.Empty() {
+		return row
+	}
+	return f.format("", row)
+}
+
+func (f *Formatters) format(field string, v interface{}) interface{} {
+	if formatter, ok := f.Fields[field]; ok && field != "" {
+		return formatter(v)
+	}
+	join := func(key string) string {
+		if field == "" {
+			return key
+		}
+		return field + "." + key
+	}
+
+	switch val := v.(type) {
+	case OrderedMap:
+		out := make(OrderedMap, len(val))
+		for i, kv := range val {
+			out[i] = KeyVal{Key: kv.Key, Val: f.format(join(kv.Key), kv.Val)}
+		}
+		return out
+	case parser.Record:
+		out := make(parser.Record, len(val))
+		for k, item := range val {
+			out[k] = f.format(join(k), item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = f.format(join(k), item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = f.formatType(item)
+		}
+		return out
+	}
+	return f.formatType(v)
+}
+
+func (f *Formatters) formatType(v interface{}) interface{} {
+	if formatter, ok := f.Types[parser.TypeOf(v)]; ok {
+		return formatter(v)
+	}
+	return v
+}
+
+// FormattingSink formats the values of rows before passing them to another sink
+type FormattingSink struct {
+	Sink
+	formatters *Formatters
+}
+
+// NewFormattingSink returns sink itself when there is nothing to format
+func NewFormattingSink(sink Sink, formatters *Formatters) Sink {
+	if formatters.Empty() {
+		return sink
+	}
+	return &FormattingSink{Sink: sink, formatters: formatters}
+}
+
+func (s *FormattingSink) Write(row Row) error {
+	return s.Sink.Write(NewJSONRow(s.formatters.Apply(row.Primitive())))
+}
+
+// number converts numeric values, including json.Number, to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func formatRFC3339(v interface{}) interface{} {
+	switch val := v.(type) {
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+			return t.Format(time.RFC3339)
+		}
+		return v
+	}
+	if secs, ok := number(v); ok {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+func formatBytes(v interface{}) interface{} {
+	n, ok := number(v)
+	if !ok {
+		return v
+	}
+	const units = "KMGTPE"
+	abs := math.Abs(n)
+	if abs < 1024 {
+		return fmt.Sprintf("%g B", n)
+	}
+	exp := 0
+	for abs >= 1024*1024 && exp < len(units)-1 {
+		abs /= 1024
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/math.Pow(1024, float64(exp+1)), units[exp])
+}
+
+// formatFixed keeps numbers numeric in JSON output: json.Number is written as is
+func formatFixed(v interface{}, decimals int) interface{} {
+	n, ok := number(v)
+	if !ok {
+		return v
+	}
+	return json.Number(strconv.FormatFloat(n, 'f', decimals, 64))
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// EnableHTTP registers HTTPTable as the provider of the http:// and https://
+// URLs, which are otherwise not opened, so that importing the package does
+// not let the queries it runs fetch URLs. With hosts, only the URLs of
+// those hosts are fetched, redirects included.
+func EnableHTTP(hosts ...string) {
+	open := func(ref string) (Table, error) {
+		t := NewHTTPTable(ref)
+		t.Hosts = hosts
+		return t, nil
+	}
+	RegisterScheme("http", open)
+	RegisterScheme("https", open)
+}
+
+// HTTPTable adapts a JSON, JSONL or MessagePack document served over HTTP
+// to the Table interface. Every iterator downloads the document again, so
+// Iterate is safe for concurrent use.
+type HTTPTable struct {
+	url string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+	// Hosts, when set, are the only hosts the table fetches from, case
+	// insensitively
+	Hosts []string
+	// Retries is the number of times a transient failure (a network error,
+	// a 429 or 5xx status) is retried before the download starts, waiting
+	// Backoff then twice as long before each retry
+	Retries int
+	Backoff time.Duration
+}
+
+// NewHTTPTable creates a table over the document at url, retrying
+// transient failures 3 times from 500ms apart
+func NewHTTPTable(url string) *HTTPTable {
+	return &HTTPTable{url: url, Retries: 3, Backoff: 500 * time.Millisecond}
+}
+
+func (t *HTTPTable) Iterate(ctx context.Context) (RowIterator, error) {
+	if err := t.checkHost(t.url); err != nil {
+		return nil, err
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(t.Hosts) > 0 {
+		// A redirect must not lead out of the allowed hosts
+		checked := *client
+		checked.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if err := t.checkHost(req.URL.String()); err != nil {
+				return err
+			}
+			if client.CheckRedirect != nil {
+				return client.CheckRedirect(req, via)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		}
+		client = &checked
+	}
+
+	wait := t.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.get(ctx, client)
+		if err == nil {
+			p := parser.NewReaderParser(t.url, resp.Body)
+			p.CountInto(diag.RunStats(ctx))
+			p.PreserveOrder()
+			return WithContext(ctx, &jsonIterator{parser: p}), nil
+		}
+		var transient transientError
+		if attempt >= t.Retries || !errors.As(err, &transient) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// transientError is a failure to fetch that may not happen again
+type transientError struct{ error }
+
+func (e transientError) Unwrap() error { return e.error }
+
+// get requests the document, returning the response of a 200 status or
+// the error, a transientError when retrying may succeed
+func (t *HTTPTable) get(ctx context.Context, client *http.Client) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var redirect hostError
+		if ctx.Err() != nil || errors.As(err, &redirect) {
+			return nil, err
+		}
+		return nil, transientError{err}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("failed to fetch %s: %s", t.url, resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, transientError{err}
+	}
+	return nil, err
+}
+
+// hostError refuses a URL whose host is not among the allowed hosts
+type hostError struct{ host string }
+
+func (e hostError) Error() string {
+	return fmt.Sprintf("fetching from host %q is not allowed", e.host)
+}
+
+// checkHost returns a hostError when the host of rawURL is not one of
+// Hosts, if set
+func (t *HTTPTable) checkHost(rawURL string) error {
+	if len(t.Hosts) == 0 {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	for _, host := range t.Hosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return hostError{u.Hostname()}
+}
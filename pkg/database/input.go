@@ -0,0 +1,62 @@
+package database
+
+import "github.com/bisegni/jsl/pkg/parser"
+
+// InputOptions are the reading options of the JSON, JSONL and MessagePack
+// inputs opened by OpenInput (see JSONTable)
+type InputOptions struct {
+	// Sources adds the parser.SourceField locating each record in its file
+	Sources bool
+	// Lenient accepts comments, trailing commas and unquoted keys
+	Lenient bool
+	// SkipErrors receives the malformed lines skipped instead of failing
+	SkipErrors parser.ErrorHandler
+	// Root streams the elements of the array at this path as the records
+	Root []string
+	// Limits bound the size of each input and of its records
+	Limits parser.Limits
+}
+
+// OpenInput returns the table of an input: the table of a registered
+// provider (OpenSource: a SQLite table "app.db:users", an Excel sheet
+// "sales.xlsx:Q1", a URL once EnableHTTP is called), a JSONTable over a
+// file, inline JSON or stdin ("-"), or a MultiFileTable concatenating the
+// files matched by a glob pattern, read with opts
+func OpenInput(source string, opts InputOptions) (Table, error) {
+	if table, err := OpenSource(source); table != nil || err != nil {
+		return table, err
+	}
+	files, err := ExpandPattern(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 1 && files[0] == source {
+		table := NewJSONTable(source)
+		table.Sources = opts.Sources
+		table.Lenient = opts.Lenient
+		table.SkipErrors = opts.SkipErrors
+		table.Root = opts.Root
+		table.Limits = opts.Limits
+		return table, nil
+	}
+	table := NewMultiFileTable(files)
+	table.Sources = opts.Sources
+	table.Lenient = opts.Lenient
+	table.SkipErrors = opts.SkipErrors
+	table.Root = opts.Root
+	table.Limits = opts.Limits
+	return table, nil
+}
+
+// OpenFrom returns the table of a FROM naming a provider source or files
+// (see OpenInput), or nil for any other name, which planner.Resolver
+// resolves to the input of the query
+func OpenFrom(name string, opts InputOptions) (Table, error) {
+	if table, err := OpenSource(name); table != nil || err != nil {
+		return table, err
+	}
+	if !MatchesFiles(name) {
+		return nil, nil
+	}
+	return OpenInput(name, opts)
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// JSONLinesSink writes rows to a writer as JSON Lines, one value per line
+type JSONLinesSink struct {
+	encoder *json.Encoder
+}
+
+// NewJSONLinesSink returns a sink writing JSON Lines to w, values spanning
+// several indented lines when pretty
+func NewJSONLinesSink(w io.Writer, pretty bool) *JSONLinesSink {
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return &JSONLinesSink{encoder: encoder}
+}
+
+func (s *JSONLinesSink) Write(row Row) error {
+	return s.encoder.Encode(row.Primitive())
+}
+
+// Close does nothing: every row is written whole. The writer is not closed.
+func (s *JSONLinesSink) Close() error {
+	return nil
+}
+
+// JSONArraySink writes rows to a writer as the elements of a single JSON
+// array, streamed as they are written. Elements are separated by commas
+// alone ([{...},{...}]), or each starts a line when Lines is set;
+// NewJSONArraySink with pretty indents them on their own lines.
+type JSONArraySink struct {
+	w      io.Writer
+	pretty bool
+	// Lines starts every element on its own line, unindented
+	Lines bool
+	count int
+	err   error
+}
+
+// NewJSONArraySink returns a sink writing a JSON array to w, its elements
+// indented when pretty
+func NewJSONArraySink(w io.Writer, pretty bool) *JSONArraySink {
+	return &JSONArraySink{w: w, pretty: pretty}
+}
+
+func (s *JSONArraySink) Write(row Row) error {
+	var data []byte
+	var err error
+	if s.pretty {
+		data, err = json.MarshalIndent(row.Primitive(), "  ", "  ")
+	} else {
+		data, err = json.Marshal(row.Primitive())
+	}
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if s.count == 0 {
+		sep = "["
+	}
+	switch {
+	case s.pretty:
+		sep += "\n  "
+	case s.Lines:
+		sep += "\n"
+	}
+	if _, err := io.WriteString(s.w, sep); err != nil {
+		return err
+	}
+	s.count++
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Close ends the array, writing an empty one when no row was written. The
+// writer is not closed.
+func (s *JSONArraySink) Close() error {
+	end := "]\n"
+	switch {
+	case s.count == 0:
+		end = "[]\n"
+	case s.pretty || s.Lines:
+		end = "\n]\n"
+	}
+	_, err := io.WriteString(s.w, end)
+	return err
+}
+
+// JSONFileSink writes rows to a file. Files ending in ".jsonl" get one
+// object per line; anything else gets a single JSON array, an element per
+// line. Files ending in ".gz" or ".zst" are compressed (".jsonl.gz" being
+// compressed JSONL).
+type JSONFileSink struct {
+	file   io.WriteCloser
+	writer *bufio.Writer
+	sink   Sink
+	count  int
+}
+
+// NewJSONFileSink creates (or truncates) filename and returns a sink writing to it
+func NewJSONFileSink(filename string) (*JSONFileSink, error) {
+	return newJSONFileSink(filename, CompressionOf(filename))
+}
+
+func newJSONFileSink(filename, codec string) (*JSONFileSink, error) {
+	file, err := createOutput(filename, codec)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	s := &JSONFileSink{file: file, writer: w}
+	if strings.HasSuffix(uncompressedName(filename), ".jsonl") {
+		s.sink = NewJSONLinesSink(w, false)
+	} else {
+		array := NewJSONArraySink(w, false)
+		array.Lines = true
+		s.sink = array
+	}
+	return s, nil
+}
+
+func (s *JSONFileSink) Write(row Row) error {
+	s.count++
+	return s.sink.Write(row)
+}
+
+// Count returns the number of rows written so far
+func (s *JSONFileSink) Count() int {
+	return s.count
+}
+
+func (s *JSONFileSink) Close() error {
+	return closeFileSink(s.sink, s.writer, s.file)
+}
+
+// closeFileSink ends the output of a sink writing to a file through writer,
+// then closes the file
+func closeFileSink(sink Sink, writer *bufio.Writer, file io.Closer) error {
+	if err := sink.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,6 +1,12 @@
 package database
 
 import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/bisegni/jsl/pkg/query"
 )
@@ -15,7 +21,17 @@ func (r *JSONRow) Get(field string) (interface{}, error) {
 }
 
 func (r *JSONRow) GetWithFilter(field string, filter interface{}) (interface{}, error) {
-	q := query.NewQuery(field)
+	return r.get(query.NewQuery(r.path(field)), filter)
+}
+
+// GetCaseInsensitive is GetWithFilter matching keys regardless of case
+func (r *JSONRow) GetCaseInsensitive(field string, filter interface{}) (interface{}, error) {
+	q := query.NewQuery(r.path(field))
+	q.CaseInsensitive = true
+	return r.get(q, filter)
+}
+
+func (r *JSONRow) get(q *query.Query, filter interface{}) (interface{}, error) {
 	if filter != nil {
 		if expr, ok := filter.(query.Expression); ok {
 			q.FilterContext = expr
@@ -28,12 +44,28 @@ func (r *JSONRow) GetWithFilter(field string, filter interface{}) (interface{},
 	case map[string]interface{}:
 		return q.Extract(parser.Record(v))
 	case OrderedMap:
-		return q.Extract(parser.Record(v.ToMap()))
+		// OrderedMap implements query.Object and is walked in place
+		return q.ExtractOnValue(v)
 	default:
-		// For non-map rows (e.g. array of primitives), we can try to return the whole thing
-		// if path is simple, or error.
-		return q.Extract(parser.Record{"wrapped": v})
+		// Arrays and scalars are queried as they are (".0.name", ".")
+		return q.Extract(v)
+	}
+}
+
+// path maps a field to the path it addresses in the row: in a row that is
+// not an object, ValueColumn and * name the row itself (_value.0 is the
+// first element of an array row)
+func (r *JSONRow) path(field string) string {
+	if IsObject(r.data) {
+		return field
+	}
+	switch name := strings.TrimPrefix(field, "."); {
+	case name == ValueColumn || name == "*":
+		return "."
+	case strings.HasPrefix(name, ValueColumn+"."):
+		return name[len(ValueColumn):]
 	}
+	return field
 }
 
 func (r *JSONRow) Primitive() interface{} {
@@ -45,43 +77,241 @@ func NewJSONRow(data interface{}) Row {
 	return &JSONRow{data: data}
 }
 
-// JSONTable adapts a JSON/JSONL file to the Table interface.
+// JSONTable adapts a JSON/JSONL file to the Table interface. Objects are
+// read as OrderedMap, so that results keep the keys in document order.
+// Iterate is safe for concurrent use: files and inline JSON are reopened by
+// every iterator, while stdin, which can be read only once, is read through a
+// shared cache that later iterators replay.
 type JSONTable struct {
 	filename string
+	// Sources adds the parser.SourceField locating each record in the file
+	Sources bool
+	// Lenient accepts comments, trailing commas and unquoted keys (parser.Parser.Lenient)
+	Lenient bool
+	// SkipErrors, when set, receives the malformed lines skipped by the scan
+	// instead of failing it (parser.Parser.SkipErrors)
+	SkipErrors parser.ErrorHandler
+	// Root, when set, streams the elements of the array at this path of the
+	// document as the records (parser.Parser.Root)
+	Root []string
+	// Limits bound the size of the input and of its records (parser.Limits)
+	Limits parser.Limits
+
+	stdinOnce sync.Once
+	stdin     *recordCache
 }
 
 func NewJSONTable(filename string) *JSONTable {
 	return &JSONTable{filename: filename}
 }
 
-func (t *JSONTable) Iterate() (RowIterator, error) {
-	p, err := parser.NewParser(t.filename)
+// Files returns the file of the table, none when it reads stdin
+func (t *JSONTable) Files() []string {
+	if t.filename == "-" || t.filename == "" {
+		return nil
+	}
+	return []string{t.filename}
+}
+
+func (t *JSONTable) Iterate(ctx context.Context) (RowIterator, error) {
+	return t.iterate(ctx, false, nil)
+}
+
+// IteratePositioned is Iterate with rows resolving FileColumn and LineColumn
+func (t *JSONTable) IteratePositioned(ctx context.Context) (RowIterator, error) {
+	return t.iterate(ctx, true, nil)
+}
+
+// IterateContaining is Iterate skipping the JSONL records lacking any of
+// needles (PrefilteredTable). Stdin, cached for every iterator, is read in
+// full.
+func (t *JSONTable) IterateContaining(ctx context.Context, needles []string) (RowIterator, error) {
+	return t.iterate(ctx, false, needles)
+}
+
+func (t *JSONTable) iterate(ctx context.Context, positioned bool, needles []string) (RowIterator, error) {
+	if t.filename == "-" || t.filename == "" {
+		t.stdinOnce.Do(func() {
+			t.stdin = &recordCache{}
+			t.stdin.parser, t.stdin.err = parser.NewParser(t.filename)
+			if t.stdin.parser == nil {
+				return
+			}
+			t.stdin.parser.PreserveOrder()
+			if t.Lenient {
+				t.stdin.parser.Lenient()
+			}
+			if t.SkipErrors != nil {
+				t.stdin.parser.SkipErrors(t.SkipErrors)
+			}
+			if t.Root != nil {
+				t.stdin.parser.Root(t.Root)
+			}
+			if err := t.stdin.parser.SetLimits(t.Limits); err != nil {
+				t.stdin.parser.Close()
+				t.stdin.parser, t.stdin.err = nil, err
+				return
+			}
+			if t.Sources {
+				t.stdin.parser.TrackSources()
+			}
+		})
+		if t.stdin.parser == nil {
+			return nil, t.stdin.err
+		}
+		if positioned {
+			t.stdin.trackPositions()
+		}
+		return WithContext(ctx, &cacheIterator{cache: t.stdin, positioned: positioned}), nil
+	}
+
+	p, err := t.newParser(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if t.Sources {
+		p.TrackSources()
+	}
+	if positioned {
+		p.TrackPositions()
+	}
+	p.Prefilter(needles)
 
-	return &jsonIterator{
-		parser: p,
-	}, nil
+	return WithContext(ctx, &jsonIterator{
+		parser:     p,
+		positioned: positioned,
+	}), nil
 }
 
-type jsonIterator struct {
+// newParser opens the file with the options of the table, counting what it
+// reads in the statistics of the execution of ctx
+func (t *JSONTable) newParser(ctx context.Context) (*parser.Parser, error) {
+	p, err := parser.NewParser(t.filename)
+	if err != nil {
+		return nil, err
+	}
+	p.CountInto(diag.RunStats(ctx))
+	p.PreserveOrder()
+	if t.Lenient {
+		p.Lenient()
+	}
+	if t.SkipErrors != nil {
+		p.SkipErrors(t.SkipErrors)
+	}
+	if t.Root != nil {
+		p.Root(t.Root)
+	}
+	if err := p.SetLimits(t.Limits); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// recordCache keeps the records read from a one-shot input so that every
+// iterator sees the full stream. Records are read lazily, on demand of the
+// iterator that is furthest ahead.
+type recordCache struct {
+	mu      sync.Mutex
 	parser  *parser.Parser
-	current Row
+	records []interface{}
+	lines   []int // line of each record, when positions are tracked
+	done    bool
 	err     error
 }
 
+// trackPositions records the lines of the records, which is only possible
+// before the first one is read
+func (c *recordCache) trackPositions() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.records) == 0 && !c.done {
+		c.parser.TrackPositions()
+	}
+}
+
+// line returns the line of the i-th record, 0 when unknown
+func (c *recordCache) line(i int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i < len(c.lines) {
+		return c.lines[i]
+	}
+	return 0
+}
+
+// get returns the i-th record, reading from the input if not cached yet
+func (c *recordCache) get(i int) (interface{}, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i >= len(c.records) {
+		if c.done {
+			return nil, false, c.err
+		}
+		record, err := c.parser.ReadValue()
+		if err != nil {
+			c.done = true
+			if err != io.EOF {
+				c.err = err
+			}
+			c.parser.Close()
+			return nil, false, c.err
+		}
+		c.records = append(c.records, record)
+		if _, line := c.parser.Position(); line > 0 {
+			c.lines = append(c.lines, line)
+		}
+	}
+	return c.records[i], true, nil
+}
+
+// cacheIterator replays a recordCache from the start
+type cacheIterator struct {
+	cache      *recordCache
+	positioned bool
+	next       int
+	current    Row
+	err        error
+}
+
+func (it *cacheIterator) Next() bool {
+	record, ok, err := it.cache.get(it.next)
+	if !ok {
+		it.err = err
+		return false
+	}
+	it.current = &JSONRow{data: record}
+	if it.positioned {
+		it.current = &PositionedRow{Row: it.current, File: it.cache.parser.Name(), Line: it.cache.line(it.next)}
+	}
+	it.next++
+	return true
+}
+
+func (it *cacheIterator) Row() Row {
+	return it.current
+}
+
+func (it *cacheIterator) Error() error {
+	return it.err
+}
+
+// Close is a no-op: the cache outlives its iterators
+func (it *cacheIterator) Close() error {
+	return nil
+}
+
+type jsonIterator struct {
+	parser     *parser.Parser
+	positioned bool
+	current    Row
+	err        error
+}
+
 func (it *jsonIterator) Next() bool {
-	// Parser.Read() returns (Record, error)
-	// If it's JSONL, Read() returns one line at a time.
-	// We need to check if the parser supports iterative reading.
-	// Looking at previous context: parser.ReadAll() was used.
-	// Let's assume for now we might need to load all if parser doesn't expose iterator,
-	// OR use Read() if it's stateful.
-	// Let's check parser.go content again if unsure.
-	// Assuming Read() gets next record.
-
-	record, err := it.parser.Read()
+	// ReadValue returns the next record, which may be an object, an array
+	// or a scalar (one per JSONL line or top-level array element)
+	record, err := it.parser.ReadValue()
 	if err != nil {
 		// EOF is usually returned as error or managed check
 		// Standard io.EOF check should be here but let's assume parser handles it
@@ -94,6 +324,10 @@ func (it *jsonIterator) Next() bool {
 	}
 
 	it.current = &JSONRow{data: record}
+	if it.positioned {
+		_, line := it.parser.Position()
+		it.current = &PositionedRow{Row: it.current, File: it.parser.Name(), Line: line}
+	}
 	return true
 }
 
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryTable caches the rows of another table in memory: the first scan
+// reading the source to its end keeps the rows it yields, and later scans
+// replay them without reading the source again. Scans stopping early (e.g.
+// under a LIMIT) or failing leave the table uncached. Reload drops the cache,
+// so that the next scan reads the source again.
+//
+// When the source is a PositionedTable its rows are cached positioned, and
+// Iterate yields them without the virtual columns.
+type MemoryTable struct {
+	source Table
+
+	mu     sync.Mutex
+	rows   []Row
+	cached bool
+	epoch  int // incremented by Reload, so that older scans do not fill the cache
+}
+
+// NewMemoryTable creates a table caching the rows of source
+func NewMemoryTable(source Table) *MemoryTable {
+	return &MemoryTable{source: source}
+}
+
+func (t *MemoryTable) Iterate(ctx context.Context) (RowIterator, error) {
+	return t.iterate(ctx, false)
+}
+
+func (t *MemoryTable) IteratePositioned(ctx context.Context) (RowIterator, error) {
+	return t.iterate(ctx, true)
+}
+
+// Files returns the files of the source table (see TableFiles)
+func (t *MemoryTable) Files() []string {
+	return TableFiles(t.source)
+}
+
+// Reload drops the cached rows
+func (t *MemoryTable) Reload() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows, t.cached = nil, false
+	t.epoch++
+}
+
+func (t *MemoryTable) iterate(ctx context.Context, positioned bool) (RowIterator, error) {
+	t.mu.Lock()
+	rows, cached, epoch := t.rows, t.cached, t.epoch
+	t.mu.Unlock()
+	if cached {
+		return WithContext(ctx, &memoryIterator{rows: rows, index: -1, positioned: positioned}), nil
+	}
+
+	var it RowIterator
+	var err error
+	source, canPosition := t.source.(PositionedTable)
+	if canPosition {
+		it, err = source.IteratePositioned(ctx)
+	} else {
+		it, err = t.source.Iterate(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cachingIterator{table: t, source: it, epoch: epoch, positioned: positioned}, nil
+}
+
+// store caches the rows of a complete scan started at epoch
+func (t *MemoryTable) store(rows []Row, epoch int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.epoch == epoch && !t.cached {
+		t.rows, t.cached = rows, true
+	}
+}
+
+// plainRow strips the virtual columns of a cached row, unless positioned
+func plainRow(row Row, positioned bool) Row {
+	if p, ok := row.(*PositionedRow); ok && !positioned {
+		return p.Row
+	}
+	return row
+}
+
+// memoryIterator replays cached rows
+type memoryIterator struct {
+	rows       []Row
+	index      int
+	positioned bool
+}
+
+func (it *memoryIterator) Next() bool {
+	if it.index+1 >= len(it.rows) {
+		it.index = len(it.rows)
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *memoryIterator) Row() Row {
+	if it.index < 0 || it.index >= len(it.rows) {
+		return nil
+	}
+	return plainRow(it.rows[it.index], it.positioned)
+}
+
+func (it *memoryIterator) Error() error {
+	return nil
+}
+
+func (it *memoryIterator) Close() error {
+	return nil
+}
+
+// cachingIterator scans the source of a MemoryTable, caching its rows when
+// it reaches the end
+type cachingIterator struct {
+	table      *MemoryTable
+	source     RowIterator
+	epoch      int
+	positioned bool
+	rows       []Row
+	current    Row
+}
+
+func (it *cachingIterator) Next() bool {
+	if !it.source.Next() {
+		if it.source.Error() == nil {
+			it.table.store(it.rows, it.epoch)
+		}
+		it.rows, it.current = nil, nil
+		return false
+	}
+	row := it.source.Row()
+	it.rows = append(it.rows, row)
+	it.current = plainRow(row, it.positioned)
+	return true
+}
+
+func (it *cachingIterator) Row() Row {
+	return it.current
+}
+
+func (it *cachingIterator) Error() error {
+	return it.source.Error()
+}
+
+func (it *cachingIterator) Close() error {
+	it.rows = nil
+	return it.source.Close()
+}
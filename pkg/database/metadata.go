@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// Virtual columns of scanned rows, naming where their record comes from
+const (
+	// FileColumn is the file of the record ("<stdin>" for standard input)
+	FileColumn = "_file"
+	// LineColumn is the line (from 1) where the record starts, null when
+	// unknown (MessagePack input)
+	LineColumn = "_line"
+)
+
+// ValueColumn names the row itself in a row that is not an object (an array
+// or a scalar record, e.g. an element of [1, 2, 3]), so that SQL can filter
+// and aggregate it: SELECT _value WHERE _value > 1
+const ValueColumn = "_value"
+
+// IsObject reports whether a row primitive is an object
+func IsObject(primitive interface{}) bool {
+	switch primitive.(type) {
+	case parser.Record, map[string]interface{}, OrderedMap:
+		return true
+	}
+	return false
+}
+
+// IsMetadataColumn reports whether a field path names a virtual column
+func IsMetadataColumn(path string) bool {
+	return path == FileColumn || path == LineColumn
+}
+
+// PositionedTable is a Table whose rows can tell where their record comes from
+type PositionedTable interface {
+	Table
+	// IteratePositioned is Iterate with rows resolving the virtual columns
+	// (see PositionedRow)
+	IteratePositioned(ctx context.Context) (RowIterator, error)
+}
+
+// PositionedRow is a scanned row resolving the virtual FileColumn and
+// LineColumn, which take precedence over record fields of the same name.
+// They are not part of Primitive, so they only appear in results when
+// selected.
+type PositionedRow struct {
+	Row
+	File string
+	Line int
+}
+
+// column returns the value of a virtual column
+func (r *PositionedRow) column(field string) (interface{}, bool) {
+	switch field {
+	case FileColumn:
+		return r.File, true
+	case LineColumn:
+		if r.Line == 0 {
+			return nil, true
+		}
+		return r.Line, true
+	}
+	return nil, false
+}
+
+func (r *PositionedRow) Get(field string) (interface{}, error) {
+	if v, ok := r.column(field); ok {
+		return v, nil
+	}
+	return r.Row.Get(field)
+}
+
+func (r *PositionedRow) GetWithFilter(field string, filter interface{}) (interface{}, error) {
+	if v, ok := r.column(field); ok {
+		return v, nil
+	}
+	return r.Row.GetWithFilter(field, filter)
+}
+
+// GetCaseInsensitive resolves the virtual columns by their exact name only
+func (r *PositionedRow) GetCaseInsensitive(field string, filter interface{}) (interface{}, error) {
+	if v, ok := r.column(field); ok {
+		return v, nil
+	}
+	if ci, ok := r.Row.(CaseInsensitiveRow); ok {
+		return ci.GetCaseInsensitive(field, filter)
+	}
+	return r.Row.GetWithFilter(field, filter)
+}
+
+// Columns returns the virtual columns and their values
+func (r *PositionedRow) Columns() map[string]interface{} {
+	file, _ := r.column(FileColumn)
+	line, _ := r.column(LineColumn)
+	return map[string]interface{}{FileColumn: file, LineColumn: line}
+}
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// AppendMsgpack appends the MessagePack encoding of a value. Integral
+// numbers are written as integers, timestamps with the timestamp extension
+// and plain map keys in sorted order. Other types go through their JSON
+// encoding.
+func AppendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case string:
+		return appendMsgpackString(b, v), nil
+	case []byte:
+		return appendMsgpackBinary(b, v), nil
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return appendMsgpackInt(b, int64(v)), nil
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v)), nil
+	case float32:
+		return AppendMsgpack(b, float64(v))
+	case int:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int8:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int16:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int32:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(b, v), nil
+	case uint:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint8:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint16:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint32:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint64:
+		return appendMsgpackUint(b, v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return AppendMsgpack(b, f)
+	case time.Time:
+		return appendMsgpackTime(b, v), nil
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			var err error
+			if b, err = AppendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case OrderedMap:
+		b = appendMsgpackHeader(b, len(v), 0x80, 0xde)
+		for _, kv := range v {
+			b = appendMsgpackString(b, kv.Key)
+			var err error
+			if b, err = AppendMsgpack(b, kv.Val); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case parser.Record:
+		return appendMsgpackMap(b, v)
+	case map[string]interface{}:
+		return appendMsgpackMap(b, v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %T as MessagePack: %w", v, err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return AppendMsgpack(b, generic)
+}
+
+// WriteMsgpack writes values as a stream of MessagePack values
+func WriteMsgpack(w io.Writer, values ...interface{}) error {
+	var b []byte
+	for _, v := range values {
+		var err error
+		if b, err = AppendMsgpack(b[:0], v); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendMsgpackMap(b []byte, m map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = appendMsgpackHeader(b, len(m), 0x80, 0xde)
+	for _, k := range keys {
+		b = appendMsgpackString(b, k)
+		var err error
+		if b, err = AppendMsgpack(b, m[k]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendMsgpackHeader appends the type and length of an array or map:
+// fixed is the type byte of its short form, wide that of its 16-bit form,
+// followed by the 32-bit one
+func appendMsgpackHeader(b []byte, n int, fixed, wide byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fixed|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, wide), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, wide+1), uint32(n))
+}
+
+func appendMsgpackBinary(b []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, data...)
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func appendMsgpackUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+}
+
+// appendMsgpackTime appends a timestamp extension, in its 64-bit form when
+// the seconds fit in 34 bits, else in the 96-bit form
+func appendMsgpackTime(b []byte, t time.Time) []byte {
+	sec, nsec := t.Unix(), uint64(t.Nanosecond())
+	ext := byte(parser.MsgpackTimestamp & 0xff)
+	if sec >= 0 && sec < 1<<34 {
+		return binary.BigEndian.AppendUint64(append(b, 0xd7, ext), nsec<<34|uint64(sec))
+	}
+	b = binary.BigEndian.AppendUint32(append(b, 0xc7, 12, ext), uint32(nsec))
+	return binary.BigEndian.AppendUint64(b, uint64(sec))
+}
+
+// isMsgpackOutput reports whether an output file name asks for MessagePack
+func isMsgpackOutput(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".msgpack") || strings.HasSuffix(name, ".mpk")
+}
+
+// MsgpackSink writes rows to a writer as a stream of MessagePack values
+type MsgpackSink struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewMsgpackSink returns a sink writing MessagePack values to w
+func NewMsgpackSink(w io.Writer) *MsgpackSink {
+	return &MsgpackSink{w: w}
+}
+
+func (s *MsgpackSink) Write(row Row) error {
+	b, err := AppendMsgpack(s.buf[:0], row.Primitive())
+	if err != nil {
+		return err
+	}
+	s.buf = b
+	_, err = s.w.Write(b)
+	return err
+}
+
+// Close does nothing: every row is written whole. The writer is not closed.
+func (s *MsgpackSink) Close() error {
+	return nil
+}
+
+// MsgpackFileSink writes rows to a file as a stream of MessagePack values
+type MsgpackFileSink struct {
+	file   io.WriteCloser
+	writer *bufio.Writer
+	sink   *MsgpackSink
+}
+
+// NewMsgpackFileSink creates (or truncates) filename and returns a sink writing to it
+func NewMsgpackFileSink(filename string) (*MsgpackFileSink, error) {
+	return newMsgpackFileSink(filename, CompressionOf(filename))
+}
+
+func newMsgpackFileSink(filename, codec string) (*MsgpackFileSink, error) {
+	file, err := createOutput(filename, codec)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &MsgpackFileSink{file: file, writer: w, sink: NewMsgpackSink(w)}, nil
+}
+
+func (s *MsgpackFileSink) Write(row Row) error {
+	return s.sink.Write(row)
+}
+
+func (s *MsgpackFileSink) Close() error {
+	return closeFileSink(s.sink, s.writer, s.file)
+}
+
+// NewFileSink returns the sink writing filename in the format its extension
+// names: MessagePack for .msgpack and .mpk, CSV for .csv (CSVSink), else
+// JSON (JSONFileSink). A further .gz or .zst extension compresses the file
+// ("out.jsonl.zst").
+func NewFileSink(filename string) (Sink, error) {
+	return NewCompressedFileSink(filename, CompressionOf(filename))
+}
+
+// NewCompressedFileSink is NewFileSink compressing the file with codec
+// (CompressGzip, CompressZstd or CompressNone) whatever its extension
+func NewCompressedFileSink(filename, codec string) (Sink, error) {
+	name := uncompressedName(filename)
+	if isMsgpackOutput(name) {
+		return newMsgpackFileSink(filename, codec)
+	}
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return newCSVFileSink(filename, codec)
+	}
+	return newJSONFileSink(filename, codec)
+}
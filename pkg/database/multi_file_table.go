@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// MultiFileTable concatenates the records of several files into a single
+// table, scanning the files one after the other in the given order. Each
+// file is read as a JSONTable, so files of different formats can be mixed.
+type MultiFileTable struct {
+	files []string
+	// Sources adds the parser.SourceField locating each record in its file
+	Sources bool
+	// Lenient accepts comments, trailing commas and unquoted keys (parser.Parser.Lenient)
+	Lenient bool
+	// SkipErrors receives the malformed lines skipped (see JSONTable)
+	SkipErrors parser.ErrorHandler
+	// Root streams the array at this path of each file (see JSONTable)
+	Root []string
+	// Limits bound each file and its records (see JSONTable)
+	Limits parser.Limits
+}
+
+// NewMultiFileTable returns a table over the records of files
+func NewMultiFileTable(files []string) *MultiFileTable {
+	return &MultiFileTable{files: files}
+}
+
+// Files returns the files of the table, in scan order
+func (t *MultiFileTable) Files() []string {
+	return t.files
+}
+
+func (t *MultiFileTable) Iterate(ctx context.Context) (RowIterator, error) {
+	return &multiFileIterator{ctx: ctx, table: t}, nil
+}
+
+// IteratePositioned is Iterate with rows resolving FileColumn and LineColumn
+func (t *MultiFileTable) IteratePositioned(ctx context.Context) (RowIterator, error) {
+	return &multiFileIterator{ctx: ctx, table: t, positioned: true}, nil
+}
+
+// IterateContaining is Iterate skipping the JSONL records lacking any of
+// needles (PrefilteredTable)
+func (t *MultiFileTable) IterateContaining(ctx context.Context, needles []string) (RowIterator, error) {
+	return &multiFileIterator{ctx: ctx, table: t, needles: needles}, nil
+}
+
+// file returns the table of the i-th file
+func (t *MultiFileTable) file(i int) *JSONTable {
+	return &JSONTable{
+		filename:   t.files[i],
+		Sources:    t.Sources,
+		Lenient:    t.Lenient,
+		SkipErrors: t.SkipErrors,
+		Root:       t.Root,
+		Limits:     t.Limits,
+	}
+}
+
+// Split returns the parts of every file, in scan order (SplitTable)
+func (t *MultiFileTable) Split(size int64) ([]Table, error) {
+	var parts []Table
+	for i := range t.files {
+		fileParts, err := t.file(i).Split(size)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, fileParts...)
+	}
+	return parts, nil
+}
+
+// multiFileIterator opens the files lazily, keeping one open at a time
+type multiFileIterator struct {
+	ctx        context.Context
+	table      *MultiFileTable
+	positioned bool
+	needles    []string
+	next       int
+	current    RowIterator
+	err        error
+}
+
+func (it *multiFileIterator) Next() bool {
+	for it.err == nil {
+		if it.current == nil {
+			if it.next >= len(it.table.files) {
+				return false
+			}
+			file := it.table.file(it.next)
+			it.next++
+			it.current, it.err = file.iterate(it.ctx, it.positioned, it.needles)
+			continue
+		}
+		if it.current.Next() {
+			return true
+		}
+		it.err = it.current.Error()
+		if closeErr := it.current.Close(); it.err == nil {
+			it.err = closeErr
+		}
+		it.current = nil
+	}
+	return false
+}
+
+func (it *multiFileIterator) Row() Row {
+	return it.current.Row()
+}
+
+func (it *multiFileIterator) Error() error {
+	if it.err != nil {
+		return fmt.Errorf("%s: %w", it.table.files[it.next-1], it.err)
+	}
+	return nil
+}
+
+func (it *multiFileIterator) Close() error {
+	if it.current == nil {
+		return nil
+	}
+	err := it.current.Close()
+	it.current = nil
+	return err
+}
+
+// MatchesFiles reports whether name is an existing file or a glob pattern
+// matching some, unlike stdin ("-") or inline JSON
+func MatchesFiles(name string) bool {
+	if name == "" || name == "-" || name[0] == '{' || name[0] == '[' {
+		return false
+	}
+	if info, err := os.Stat(name); err == nil {
+		return !info.IsDir()
+	}
+	if !strings.ContainsAny(name, "*?[") {
+		return false
+	}
+	_, err := ExpandPattern(name)
+	return err == nil
+}
+
+// ExpandPattern returns the files named by an input argument: the files
+// matching a glob pattern ("logs/2026-01-*.jsonl"), in lexical order, or
+// the argument itself when it is not a pattern. Stdin ("-"), inline JSON
+// and existing files are never patterns.
+func ExpandPattern(pattern string) ([]string, error) {
+	if pattern == "" || pattern == "-" || pattern[0] == '{' || pattern[0] == '[' ||
+		!strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	if _, err := os.Stat(pattern); err == nil {
+		return []string{pattern}, nil
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file pattern '%s': %w", pattern, err)
+	}
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			files = append(files, match)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", pattern)
+	}
+	return files, nil
+}
@@ -1,63 +1,19 @@
 package database
 
 import (
-	"bytes"
-	"encoding/json"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/query"
 )
 
-// OrderedMap represents a map that preserves insertion order.
-// It is implemented as a slice of KeyVal pairs to keep it simple and lightweight for this use case.
-type KeyVal struct {
-	Key string
-	Val interface{}
-}
-
-type OrderedMap []KeyVal
+// OrderedMap represents a map that preserves insertion order; it is
+// defined by the parser, which decodes objects into it (PreserveOrder).
+type OrderedMap = parser.OrderedMap
 
-// MarshalJSON implements the json.Marshaler interface.
-func (om OrderedMap) MarshalJSON() ([]byte, error) {
-	var buf bytes.Buffer
-	buf.WriteByte('{')
-	for i, kv := range om {
-		if i > 0 {
-			buf.WriteByte(',')
-		}
-		// Marshal key
-		keyBytes, err := json.Marshal(kv.Key)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(keyBytes)
-		buf.WriteByte(':')
-		// Marshal value
-		valBytes, err := json.Marshal(kv.Val)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(valBytes)
-	}
-	buf.WriteByte('}')
-	return buf.Bytes(), nil
-}
+// KeyVal is a key/value pair of an OrderedMap
+type KeyVal = parser.KeyVal
 
-// Get returns the value for a key (O(N) lookup, but explicit for small projections)
-func (om OrderedMap) Get(key string) (interface{}, bool) {
-	for _, kv := range om {
-		if kv.Key == key {
-			return kv.Val, true
-		}
-	}
-	return nil, false
-}
-
-// ToMap converts to a standard map (losing order)
-func (om OrderedMap) ToMap() map[string]interface{} {
-	m := make(map[string]interface{}, len(om))
-	for _, kv := range om {
-		m[kv.Key] = kv.Val
-	}
-	return m
-}
+// OrderedMap can be walked by path queries without converting it to a map
+var _ query.Object = OrderedMap(nil)
 
 // FromMap creates an OrderedMap from a standard map (arbitrary order)
 // This is not usually what we want if we care about order, but useful for compatibility.
@@ -68,9 +24,3 @@ func FromMap(m map[string]interface{}) OrderedMap {
 	}
 	return om
 }
-
-// String implements fmt.Stringer
-func (om OrderedMap) String() string {
-	b, _ := om.MarshalJSON()
-	return string(b)
-}
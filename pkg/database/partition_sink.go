@@ -0,0 +1,137 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PartitionedSink writes rows to one file per value of a field. The file
+// names come from a pattern holding the field in braces, e.g.
+// "out/{category}.jsonl"; the format of each file follows NewFileSink.
+type PartitionedSink struct {
+	// Compression, when set, compresses every file with this codec whatever
+	// its extension (NewCompressedFileSink)
+	Compression string
+
+	field       string
+	pattern     string
+	placeholder string
+	files       map[string]Sink
+	order       []string
+}
+
+// NewPartitionedSink returns a sink partitioning rows by field into the
+// files named by pattern. Files are created on the first row of each value.
+func NewPartitionedSink(pattern, field string) (*PartitionedSink, error) {
+	placeholder := "{" + field + "}"
+	if field == "" || !strings.Contains(pattern, placeholder) {
+		return nil, fmt.Errorf("output pattern '%s' must contain %s", pattern, placeholder)
+	}
+	return &PartitionedSink{
+		field:       field,
+		pattern:     pattern,
+		placeholder: placeholder,
+		files:       make(map[string]Sink),
+	}, nil
+}
+
+func (s *PartitionedSink) Write(row Row) error {
+	filename := strings.ReplaceAll(s.pattern, s.placeholder, partitionName(s.value(row)))
+
+	sink, ok := s.files[filename]
+	if !ok {
+		if dir := filepath.Dir(filename); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+		codec := s.Compression
+		if codec == CompressNone {
+			codec = CompressionOf(filename)
+		}
+		var err error
+		sink, err = NewCompressedFileSink(filename, codec)
+		if err != nil {
+			return err
+		}
+		s.files[filename] = sink
+		s.order = append(s.order, filename)
+	}
+	return sink.Write(row)
+}
+
+// value returns the value of the partition field in row, looked up in the
+// record a "*" column holds when the row lacks it (SELECT *, ...)
+func (s *PartitionedSink) value(row Row) interface{} {
+	if val, err := row.Get(s.field); err == nil && val != nil {
+		return val
+	}
+	var record interface{}
+	switch data := row.Primitive().(type) {
+	case map[string]interface{}:
+		record = data["*"]
+	case OrderedMap:
+		record, _ = data.Get("*")
+	}
+	if record == nil {
+		return nil
+	}
+	val, err := (&JSONRow{data: record}).Get(s.field)
+	if err != nil {
+		return nil
+	}
+	return val
+}
+
+// Files returns the names of the files written, in creation order
+func (s *PartitionedSink) Files() []string {
+	return s.order
+}
+
+// Close closes every partition file, returning the first error
+func (s *PartitionedSink) Close() error {
+	var first error
+	for _, filename := range s.order {
+		if err := s.files[filename].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// partitionName renders a partition value as a safe file name component:
+// missing values become "null" and path separators are replaced.
+func partitionName(val interface{}) string {
+	var name string
+	switch v := val.(type) {
+	case nil:
+		name = "null"
+	case string:
+		name = v
+	case float64:
+		name = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool, int, int64:
+		name = fmt.Sprint(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			data = []byte(fmt.Sprint(v))
+		}
+		name = string(data)
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TableProvider opens the table of a source reference: a URI of the scheme
+// it is registered for ("s3://bucket/key") or a file of its extension,
+// optionally followed by ":name" naming a table of the file ("app.db:users")
+type TableProvider func(ref string) (Table, error)
+
+var providers = struct {
+	mu         sync.RWMutex
+	schemes    map[string]TableProvider
+	extensions map[string]TableProvider
+}{
+	schemes:    make(map[string]TableProvider),
+	extensions: make(map[string]TableProvider),
+}
+
+// RegisterScheme makes p open the references of a URI scheme ("s3" for
+// "s3://bucket/key"), replacing the provider registered before
+func RegisterScheme(scheme string, p TableProvider) {
+	providers.mu.Lock()
+	defer providers.mu.Unlock()
+	providers.schemes[strings.ToLower(scheme)] = p
+}
+
+// RegisterExtension makes p open the files of an extension (".csv"),
+// replacing the provider registered before
+func RegisterExtension(ext string, p TableProvider) {
+	providers.mu.Lock()
+	defer providers.mu.Unlock()
+	providers.extensions[strings.ToLower(ext)] = p
+}
+
+// OpenSource returns the table of ref opened by the provider of its scheme
+// or extension, or nil when no provider handles ref (JSON, JSONL and
+// MessagePack files are read by JSONTable)
+func OpenSource(ref string) (Table, error) {
+	if p := lookupProvider(ref); p != nil {
+		return p(ref)
+	}
+	return nil, nil
+}
+
+// lookupProvider returns the provider of the scheme of ref, else of the
+// extension of ref or of its part before a ":name" suffix
+func lookupProvider(ref string) TableProvider {
+	providers.mu.RLock()
+	defer providers.mu.RUnlock()
+	if scheme, ok := uriScheme(ref); ok {
+		return providers.schemes[scheme]
+	}
+	if p, ok := providers.extensions[strings.ToLower(filepath.Ext(ref))]; ok {
+		return p
+	}
+	if i := strings.LastIndexByte(ref, ':'); i >= 0 {
+		return providers.extensions[strings.ToLower(filepath.Ext(ref[:i]))]
+	}
+	return nil
+}
+
+// uriScheme returns the lowercased scheme of a "scheme://..." reference
+func uriScheme(ref string) (string, bool) {
+	i := strings.Index(ref, "://")
+	if i <= 0 {
+		return "", false
+	}
+	for j, c := range ref[:i] {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (j == 0 || !(c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return "", false
+		}
+	}
+	return strings.ToLower(ref[:i]), true
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Resume returns the records of the JSONL file of the table from the record
+// starting at offset (0 for the first), so that the file can be paged
+// through without reading it from the start. The files Split cannot cut
+// cannot be resumed either.
+func (t *JSONTable) Resume(offset int64) (*ResumedTable, error) {
+	size, ok := t.lineRanges(-1)
+	if !ok {
+		return nil, fmt.Errorf("cannot resume %s: not a JSON Lines file", t.filename)
+	}
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("cannot resume %s at offset %d: out of the file", t.filename, offset)
+	}
+	if offset > 0 {
+		// A record starts a line
+		f, err := os.Open(t.filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, offset-1); err != nil {
+			return nil, err
+		}
+		if b[0] != '\n' {
+			return nil, fmt.Errorf("cannot resume %s at offset %d: not the start of a line", t.filename, offset)
+		}
+	}
+	return &ResumedTable{table: t, start: offset, end: size, offset: offset}, nil
+}
+
+// ResumedTable is a JSONL file read from one of its records (Resume). It is
+// iterated by one iterator at a time, whose position Offset tracks.
+type ResumedTable struct {
+	table      *JSONTable
+	start, end int64
+	offset     int64
+}
+
+func (t *ResumedTable) Iterate(ctx context.Context) (RowIterator, error) {
+	p, err := t.table.newParser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Range(t.start, t.end); err != nil {
+		p.Close()
+		return nil, err
+	}
+	p.TrackPositions()
+	t.offset = t.start
+	return WithContext(ctx, &resumedIterator{jsonIterator: jsonIterator{parser: p}, table: t}), nil
+}
+
+// Offset returns the offset in the file of the record last read, the start
+// offset before the first one
+func (t *ResumedTable) Offset() int64 {
+	return t.offset
+}
+
+// resumedIterator is a jsonIterator updating the Offset of its table
+type resumedIterator struct {
+	jsonIterator
+	table *ResumedTable
+}
+
+func (it *resumedIterator) Next() bool {
+	if !it.jsonIterator.Next() {
+		return false
+	}
+	offset, _ := it.parser.Position()
+	it.table.offset = it.table.start + offset
+	return true
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SliceTable adapts in-memory Go data to the Table interface.
+type SliceTable struct {
+	rows []interface{}
+}
+
+// NewSliceTable creates a table over a slice of maps
+func NewSliceTable(rows []map[string]interface{}) *SliceTable {
+	t := &SliceTable{rows: make([]interface{}, len(rows))}
+	for i, r := range rows {
+		t.rows[i] = r
+	}
+	return t
+}
+
+// NewStructTable creates a table over a slice of structs (or pointers to structs).
+// Fields are named after their `json` tags, following encoding/json conventions,
+// and are converted lazily while iterating.
+func NewStructTable[T any](rows []T) *SliceTable {
+	t := &SliceTable{rows: make([]interface{}, len(rows))}
+	for i, r := range rows {
+		t.rows[i] = r
+	}
+	return t
+}
+
+func (t *SliceTable) Iterate(ctx context.Context) (RowIterator, error) {
+	return WithContext(ctx, &sliceIterator{rows: t.rows, index: -1}), nil
+}
+
+type sliceIterator struct {
+	rows    []interface{}
+	index   int
+	current Row
+}
+
+func (it *sliceIterator) Next() bool {
+	it.index++
+	if it.index >= len(it.rows) {
+		return false
+	}
+	it.current = &JSONRow{data: toRowValue(reflect.ValueOf(it.rows[it.index]))}
+	return true
+}
+
+func (it *sliceIterator) Row() Row {
+	return it.current
+}
+
+func (it *sliceIterator) Error() error {
+	return nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+// toRowValue converts Go values to the generic shapes the query engine walks
+// (map[string]interface{} and []interface{}), keeping scalars as they are.
+func toRowValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return toRowValue(v.Elem())
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t
+		}
+		m := make(map[string]interface{}, v.NumField())
+		structFields(v, m)
+		return m
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = toRowValue(iter.Value())
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface() // []byte stays opaque
+		}
+		fallthrough
+	case reflect.Array:
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			s[i] = toRowValue(v.Index(i))
+		}
+		return s
+	default:
+		return v.Interface()
+	}
+}
+
+// structFields copies the exported fields of a struct into m using json tag names.
+// Embedded structs without a tag are flattened like encoding/json does.
+func structFields(v reflect.Value, m map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		fv := v.Field(i)
+		if sf.Anonymous && name == "" {
+			for fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				structFields(fv, m)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if strings.Contains(opts, "omitempty") && fv.IsZero() {
+			continue
+		}
+		m[name] = toRowValue(fv)
+	}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// JSONL files, and globs of them, are split into ranges of lines and
+// prefiltered
+var (
+	_ SplitTable = (*JSONTable)(nil)
+	_ SplitTable = (*MultiFileTable)(nil)
+
+	_ PrefilteredTable = (*JSONTable)(nil)
+	_ PrefilteredTable = (*MultiFileTable)(nil)
+	_ PrefilteredTable = (*jsonPart)(nil)
+)
+
+// Split cuts a JSONL file into parts of about size bytes ending at line
+// boundaries (SplitTable). Stdin, inline JSON, JSON documents, MessagePack,
+// and tables tracking sources, skipping malformed lines, streaming a root
+// array or counting records against a limit are not split.
+func (t *JSONTable) Split(size int64) ([]Table, error) {
+	whole := []Table{t}
+	if size <= 0 {
+		return whole, nil
+	}
+	fileSize, ok := t.lineRanges(size)
+	if !ok {
+		// Errors are left to the scan to report
+		return whole, nil
+	}
+
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var parts []Table
+	start := int64(0)
+	buf := make([]byte, 64*1024)
+	for start+size < fileSize {
+		end, err := lineEnd(f, start+size, buf)
+		if err != nil {
+			return nil, err
+		}
+		if end >= fileSize {
+			break
+		}
+		parts = append(parts, &jsonPart{table: t, start: start, end: end})
+		start = end
+	}
+	return append(parts, &jsonPart{table: t, start: start, end: fileSize}), nil
+}
+
+// lineRanges reports whether the table reads a regular JSONL file larger
+// than min bytes which can be read by ranges of lines, returning its size
+func (t *JSONTable) lineRanges(min int64) (int64, bool) {
+	if t.filename == "" || t.filename == "-" || t.filename[0] == '{' || t.filename[0] == '[' ||
+		t.Sources || t.SkipErrors != nil || t.Root != nil || t.Limits.MaxRecords > 0 {
+		return 0, false
+	}
+	info, err := os.Stat(t.filename)
+	if err != nil || !info.Mode().IsRegular() || info.Size() <= min {
+		return 0, false
+	}
+	if lines, err := parser.IsLineDelimited(t.filename, t.Lenient); err != nil || !lines {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// lineEnd returns the offset following the first newline at or after offset,
+// or the end of the file
+func lineEnd(f *os.File, offset int64, buf []byte) (int64, error) {
+	for {
+		n, err := f.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// jsonPart is a range of lines of a JSONL file
+type jsonPart struct {
+	table      *JSONTable
+	start, end int64
+}
+
+func (t *jsonPart) Iterate(ctx context.Context) (RowIterator, error) {
+	return t.IterateContaining(ctx, nil)
+}
+
+// IterateContaining is Iterate skipping the records lacking any of needles
+// (PrefilteredTable)
+func (t *jsonPart) IterateContaining(ctx context.Context, needles []string) (RowIterator, error) {
+	p, err := t.table.newParser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Range(t.start, t.end); err != nil {
+		p.Close()
+		return nil, err
+	}
+	p.Prefilter(needles)
+	return WithContext(ctx, &jsonIterator{parser: p}), nil
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// SQLite driver (pure Go)
+	_ "modernc.org/sqlite"
+)
+
+// sqliteExtensions are the file extensions recognized as SQLite databases
+var sqliteExtensions = []string{".db", ".sqlite", ".sqlite3"}
+
+func init() {
+	for _, ext := range sqliteExtensions {
+		RegisterExtension(ext, func(ref string) (Table, error) {
+			path, table, _ := ParseSQLiteSource(ref)
+			return NewSQLiteTable(path, table), nil
+		})
+	}
+}
+
+// ParseSQLiteSource splits a reference to a SQLite table, "app.db:users",
+// into the database file and the table. ok is false when the reference does
+// not name a SQLite database (a file with a .db, .sqlite or .sqlite3
+// extension); table is empty when the reference names only the database.
+func ParseSQLiteSource(ref string) (path, table string, ok bool) {
+	return splitSource(ref, sqliteExtensions)
+}
+
+// splitSource splits "file:name" when file has one of the extensions, ok
+// being false for other files
+func splitSource(ref string, extensions []string) (path, name string, ok bool) {
+	path = ref
+	if i := strings.LastIndexByte(ref, ':'); i >= 0 && hasExtension(ref[:i], extensions) {
+		path, name = ref[:i], ref[i+1:]
+	}
+	if !hasExtension(path, extensions) {
+		return "", "", false
+	}
+	return path, name, true
+}
+
+func hasExtension(path string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// SQLiteTable adapts a table of a SQLite database to the Table interface.
+// Rows are read as OrderedMap in column order, with INTEGER columns as
+// int64, REAL as float64, TEXT as string, BLOB as []byte and NULL as nil.
+// The database is opened read-only by every iterator, so Iterate is safe for
+// concurrent use.
+type SQLiteTable struct {
+	path  string
+	table string
+}
+
+// NewSQLiteTable creates a table over the table of the SQLite database at path
+func NewSQLiteTable(path, table string) *SQLiteTable {
+	return &SQLiteTable{path: path, table: table}
+}
+
+// Files returns the database file of the table
+func (t *SQLiteTable) Files() []string {
+	return []string{t.path}
+}
+
+func (t *SQLiteTable) Iterate(ctx context.Context) (RowIterator, error) {
+	// Opening a missing file would create an empty database
+	if _, err := os.Stat(t.path); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+(&url.URL{Path: t.path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", t.path, err)
+	}
+	if t.table == "" {
+		err := t.missingTable(db)
+		db.Close()
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT * FROM "`+strings.ReplaceAll(t.table, `"`, `""`)+`"`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read table %s of %s: %w", t.table, t.path, err)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		db.Close()
+		return nil, err
+	}
+	return &sqliteIterator{db: db, rows: rows, columns: columns}, nil
+}
+
+// missingTable reports a reference without a table, listing the tables of
+// the database
+func (t *SQLiteTable) missingTable(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", t.path, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", t.path, err)
+	}
+	return fmt.Errorf("no table given for %s (use %s:<table>; tables: %s)", t.path, t.path, strings.Join(names, ", "))
+}
+
+type sqliteIterator struct {
+	db      *sql.DB
+	rows    *sql.Rows
+	columns []string
+	current Row
+	err     error
+}
+
+func (it *sqliteIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	values := make([]interface{}, len(it.columns))
+	targets := make([]interface{}, len(it.columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := it.rows.Scan(targets...); err != nil {
+		it.err = err
+		return false
+	}
+	row := make(OrderedMap, len(it.columns))
+	for i, column := range it.columns {
+		row[i] = KeyVal{Key: column, Val: values[i]}
+	}
+	it.current = &JSONRow{data: row}
+	return true
+}
+
+func (it *sqliteIterator) Row() Row {
+	return it.current
+}
+
+func (it *sqliteIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *sqliteIterator) Close() error {
+	err := it.rows.Close()
+	if cerr := it.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// TypeNull is the type SchemaNode reports for null values
+const TypeNull = "null"
+
+// SchemaNode is the inferred structure of the values found at one place of
+// the data: their types, a sample value and, for objects and arrays, the
+// structure of their fields and elements
+type SchemaNode struct {
+	Name string
+	// Types lists the types seen (parser.TypeOf, or TypeNull), in the order
+	// they were first found
+	Types []string
+	// Sample is the first non-null scalar value seen, or nil
+	Sample interface{}
+	// Count is the number of values seen, Objects how many of them were objects
+	Count   int
+	Objects int
+	// Elements describes the elements of arrays, nil if no element was seen
+	Elements *SchemaNode
+
+	fields map[string]*SchemaNode
+}
+
+// NewSchemaNode returns an empty node
+func NewSchemaNode(name string) *SchemaNode {
+	return &SchemaNode{Name: name}
+}
+
+// Add merges a value into the node
+func (n *SchemaNode) Add(val interface{}) {
+	n.Count++
+	typ := parser.TypeOf(val)
+	if typ == "" {
+		typ = TypeNull
+	}
+	n.addType(typ)
+
+	switch v := val.(type) {
+	case parser.Record:
+		n.addObject(func(fn func(string, interface{})) {
+			for k, item := range v {
+				fn(k, item)
+			}
+		})
+	case map[string]interface{}:
+		n.addObject(func(fn func(string, interface{})) {
+			for k, item := range v {
+				fn(k, item)
+			}
+		})
+	case OrderedMap:
+		n.addObject(func(fn func(string, interface{})) {
+			for _, kv := range v {
+				fn(kv.Key, kv.Val)
+			}
+		})
+	case []interface{}:
+		for _, item := range v {
+			if n.Elements == nil {
+				n.Elements = NewSchemaNode("*")
+			}
+			n.Elements.Add(item)
+		}
+	case nil:
+	default:
+		if n.Sample == nil {
+			n.Sample = val
+		}
+	}
+}
+
+func (n *SchemaNode) addType(typ string) {
+	for _, t := range n.Types {
+		if t == typ {
+			return
+		}
+	}
+	n.Types = append(n.Types, typ)
+}
+
+func (n *SchemaNode) addObject(each func(fn func(string, interface{}))) {
+	n.Objects++
+	if n.fields == nil {
+		n.fields = make(map[string]*SchemaNode)
+	}
+	each(func(key string, val interface{}) {
+		child, ok := n.fields[key]
+		if !ok {
+			child = NewSchemaNode(key)
+			n.fields[key] = child
+		}
+		child.Add(val)
+	})
+}
+
+// Fields returns the fields seen in object values, sorted by name
+func (n *SchemaNode) Fields() []*SchemaNode {
+	fields := make([]*SchemaNode, 0, len(n.fields))
+	for _, f := range n.fields {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// Optional reports whether the field is missing from some of the objects of parent
+func (n *SchemaNode) Optional(parent *SchemaNode) bool {
+	return n.Count < parent.Objects
+}
+
+// TypeString joins the types seen, e.g. "int|string"
+func (n *SchemaNode) TypeString() string {
+	return strings.Join(n.Types, "|")
+}
+
+// DescribeTree scans up to sampleSize rows of a table and infers the nested
+// structure of the values at path (whole rows for "" or "."). Rows where the
+// path does not resolve are skipped. The returned count is the number of rows
+// scanned.
+func DescribeTree(t Table, path string, sampleSize int) (*SchemaNode, int, error) {
+	it, err := t.Iterate(context.Background())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer it.Close()
+
+	root := NewSchemaNode(".")
+	count := 0
+	for count < sampleSize && it.Next() {
+		count++
+		row := it.Row()
+		if path == "" || path == "." {
+			root.Add(row.Primitive())
+			continue
+		}
+		if val, err := row.Get(path); err == nil {
+			root.Add(val)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, count, err
+	}
+	return root, count, nil
+}
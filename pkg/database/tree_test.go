@@ -0,0 +1,168 @@
+package database_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// renderTree renders a node and its descendants one per line, as
+// "name: types = sample", optional fields marked with "?"
+func renderTree(node *database.SchemaNode) string {
+	var b strings.Builder
+	var render func(n, parent *database.SchemaNode, indent string)
+	render = func(n, parent *database.SchemaNode, indent string) {
+		name := n.Name
+		if parent != nil && n.Name != "*" && n.Optional(parent) {
+			name += "?"
+		}
+		fmt.Fprintf(&b, "%s%s: %s", indent, name, n.TypeString())
+		if n.Sample != nil {
+			fmt.Fprintf(&b, " = %v", n.Sample)
+		}
+		b.WriteString("\n")
+		for _, f := range n.Fields() {
+			render(f, n, indent+"  ")
+		}
+		if n.Elements != nil {
+			render(n.Elements, n, indent+"  ")
+		}
+	}
+	render(node, nil, "")
+	return b.String()
+}
+
+func treeTable() database.Table {
+	return database.NewSliceTable([]map[string]interface{}{
+		{
+			"id":    1,
+			"user":  map[string]interface{}{"name": "ann", "email": "ann@example.com"},
+			"tags":  []interface{}{"a", "b"},
+			"items": []interface{}{map[string]interface{}{"sku": "x", "qty": 1}, map[string]interface{}{"sku": "y"}},
+			"v":     1,
+		},
+		{
+			"id":    2,
+			"user":  map[string]interface{}{"name": "bob"},
+			"tags":  []interface{}{},
+			"items": []interface{}{map[string]interface{}{"sku": "z", "qty": 2.5}},
+			"v":     "one",
+		},
+		{"id": 3, "v": nil},
+	})
+}
+
+func TestDescribeTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		sample   int
+		count    int
+		expected string
+	}{
+		{
+			name:   "whole records",
+			path:   "",
+			sample: 100,
+			count:  3,
+			expected: `.: object
+  id: int = 1
+  items?: array
+    *: object
+      qty?: int|float = 1
+      sku: string = x
+  tags?: array
+    *: string = a
+  user?: object
+    email?: string = ann@example.com
+    name: string = ann
+  v: int|string|null = 1
+`,
+		},
+		{
+			name:   "nested path",
+			path:   "items",
+			sample: 100,
+			count:  3,
+			expected: `.: array
+  *: object
+    qty?: int|float = 1
+    sku: string = x
+`,
+		},
+		{
+			name:   "nested field",
+			path:   "user.email",
+			sample: 100,
+			count:  3,
+			expected: `.: string = ann@example.com
+`,
+		},
+		{
+			name:   "sampled rows only",
+			path:   ".",
+			sample: 1,
+			count:  1,
+			expected: `.: object
+  id: int = 1
+  items: array
+    *: object
+      qty?: int = 1
+      sku: string = x
+  tags: array
+    *: string = a
+  user: object
+    email: string = ann@example.com
+    name: string = ann
+  v: int = 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, count, err := database.DescribeTree(treeTable(), tt.path, tt.sample)
+			if err != nil {
+				t.Fatalf("DescribeTree failed: %v", err)
+			}
+			if count != tt.count {
+				t.Errorf("Expected %d row(s) scanned, got %d", tt.count, count)
+			}
+			if got := renderTree(root); got != tt.expected {
+				t.Errorf("Expected\n%s\ngot\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDescribeTreeCounts(t *testing.T) {
+	root, _, err := database.DescribeTree(treeTable(), "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]*database.SchemaNode{}
+	for _, f := range root.Fields() {
+		fields[f.Name] = f
+	}
+	if root.Count != 3 || root.Objects != 3 {
+		t.Errorf("Expected 3 objects at the root, got %d of %d", root.Objects, root.Count)
+	}
+	if n := fields["user"]; n.Count != 2 || !n.Optional(root) {
+		t.Errorf("Expected user in 2 of 3 records, got %d", n.Count)
+	}
+	// Empty arrays count as values without adding elements
+	if n := fields["tags"]; n.Count != 2 || n.Elements.Count != 2 {
+		t.Errorf("Expected 2 tags arrays holding 2 elements, got %d holding %d", n.Count, n.Elements.Count)
+	}
+	if n := fields["items"].Elements; n.Count != 3 || n.Objects != 3 {
+		t.Errorf("Expected 3 item objects, got %d of %d", n.Objects, n.Count)
+	}
+
+	// A path resolving nowhere describes nothing
+	root, count, err := database.DescribeTree(treeTable(), "missing", 100)
+	if err != nil || count != 3 || root.Count != 0 || len(root.Fields()) != 0 {
+		t.Errorf("DescribeTree(missing) = %s, %d, %v", renderTree(root), count, err)
+	}
+}
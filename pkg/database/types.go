@@ -1,5 +1,7 @@
 package database
 
+import "context"
+
 // Row represents a single record in the virtual table.
 // It wraps the underlying data (likely a map[string]interface{}).
 type Row interface {
@@ -12,6 +14,12 @@ type Row interface {
 	Primitive() interface{}
 }
 
+// CaseInsensitiveRow is implemented by rows that can resolve fields ignoring key case.
+type CaseInsensitiveRow interface {
+	// GetCaseInsensitive behaves like GetWithFilter but matches keys regardless of case.
+	GetCaseInsensitive(field string, filter interface{}) (interface{}, error)
+}
+
 // RowIterator allows iterating over rows in a table.
 type RowIterator interface {
 	// Next advances the iterator. Returns false if no more rows or error.
@@ -25,7 +33,56 @@ type RowIterator interface {
 }
 
 // Table represents a dataset that can be scanned.
+//
+// Implementations must be safe for concurrent Iterate calls, so that one Table
+// can be shared by parallel scans. Each returned iterator is independent and
+// used by a single goroutine; rows it yields must be treated as read-only.
 type Table interface {
-	// Iterate returns a new iterator for scanning the table.
-	Iterate() (RowIterator, error)
+	// Iterate returns a new iterator for scanning the table, which stops
+	// with the error of ctx once ctx is done.
+	Iterate(ctx context.Context) (RowIterator, error)
+}
+
+// SplitTable is implemented by tables that can be scanned in independent
+// parts, e.g. by parallel workers.
+type SplitTable interface {
+	Table
+	// Split returns tables over consecutive parts of about size bytes,
+	// which together yield the rows of the table in order. A table that
+	// cannot be split returns itself as the only part.
+	Split(size int64) ([]Table, error)
+}
+
+// PrefilteredTable is implemented by tables that can skip records before
+// decoding them, when their raw form lacks some bytes (parser.Parser.Prefilter).
+type PrefilteredTable interface {
+	Table
+	// IterateContaining is Iterate skipping the records whose raw form lacks
+	// any of needles. Rows lacking them may still be returned.
+	IterateContaining(ctx context.Context, needles []string) (RowIterator, error)
+}
+
+// FileTable is implemented by tables reading local files, so that a query
+// writing a file can refuse to overwrite its own input.
+type FileTable interface {
+	Table
+	// Files returns the paths of the files read by Iterate
+	Files() []string
+}
+
+// TableFiles returns the local files a table reads, nil when it reads none
+// (stdin, memory, a URL) or does not say
+func TableFiles(t Table) []string {
+	if ft, ok := t.(FileTable); ok {
+		return ft.Files()
+	}
+	return nil
+}
+
+// Sink is a writable destination for rows (e.g. a file a query writes INTO).
+type Sink interface {
+	// Write appends a row to the destination.
+	Write(row Row) error
+	// Close flushes pending output and releases resources.
+	Close() error
 }
@@ -0,0 +1,476 @@
+package database
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxExtensions are the file extensions recognized as Excel workbooks
+var xlsxExtensions = []string{".xlsx", ".xlsm"}
+
+func init() {
+	for _, ext := range xlsxExtensions {
+		RegisterExtension(ext, func(ref string) (Table, error) {
+			path, sheet, _ := ParseXLSXSource(ref)
+			return NewXLSXTable(path, sheet), nil
+		})
+	}
+}
+
+// ParseXLSXSource splits a reference to a worksheet, "sales.xlsx:Q1", into
+// the workbook file and the sheet name. ok is false when the reference does
+// not name a workbook (a file with a .xlsx or .xlsm extension); sheet is
+// empty when the reference names only the workbook, meaning its first sheet.
+func ParseXLSXSource(ref string) (path, sheet string, ok bool) {
+	return splitSource(ref, xlsxExtensions)
+}
+
+// XLSXTable adapts a worksheet of an Excel workbook to the Table interface.
+// The first row holds the column names (the column letter when a header is
+// blank) and every following non-empty row is a record, read as OrderedMap in
+// column order: numbers are float64, booleans bool, dates strings
+// ("2024-03-01", or "2024-03-01T08:30:00" with a time of day), text strings
+// and missing cells nil. Every iterator reopens the file, so Iterate is safe
+// for concurrent use.
+type XLSXTable struct {
+	path  string
+	sheet string
+}
+
+// NewXLSXTable creates a table over a sheet of the workbook at path, its
+// first sheet when sheet is empty
+func NewXLSXTable(path, sheet string) *XLSXTable {
+	return &XLSXTable{path: path, sheet: sheet}
+}
+
+// Files returns the workbook of the table
+func (t *XLSXTable) Files() []string {
+	return []string{t.path}
+}
+
+func (t *XLSXTable) Iterate(ctx context.Context) (RowIterator, error) {
+	archive, err := zip.OpenReader(t.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	it, err := t.open(&archive.Reader)
+	if err != nil {
+		archive.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", t.path, err)
+	}
+	it.archive = archive
+	return WithContext(ctx, it), nil
+}
+
+// open reads the workbook parts needed to decode the sheet and starts
+// streaming its rows
+func (t *XLSXTable) open(archive *zip.Reader) (*xlsxIterator, error) {
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	var book struct {
+		Pr struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := readXMLPart(files, "xl/workbook.xml", &book); err != nil {
+		return nil, err
+	}
+	if len(book.Sheets) == 0 {
+		return nil, fmt.Errorf("the workbook has no sheets")
+	}
+	sheet := book.Sheets[0]
+	if t.sheet != "" {
+		found := false
+		names := make([]string, len(book.Sheets))
+		for i, s := range book.Sheets {
+			names[i] = s.Name
+			if !found && strings.EqualFold(s.Name, t.sheet) {
+				sheet, found = s, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("sheet %q not found (sheets: %s)", t.sheet, strings.Join(names, ", "))
+		}
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := readXMLPart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	target := ""
+	for _, r := range rels.Relationships {
+		if r.ID == sheet.ID {
+			target = r.Target
+		}
+	}
+	// Targets are relative to xl/, or absolute within the package
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else {
+		target = path.Join("xl", target)
+	}
+	part, ok := files[target]
+	if !ok {
+		return nil, fmt.Errorf("sheet %q has no data", sheet.Name)
+	}
+
+	strs, err := readSharedStrings(files)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := readDateStyles(files)
+	if err != nil {
+		return nil, err
+	}
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if book.Pr.Date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	reader, err := part.Open()
+	if err != nil {
+		return nil, err
+	}
+	it := &xlsxIterator{
+		sheet:   reader,
+		decoder: xml.NewDecoder(reader),
+		strings: strs,
+		dates:   dates,
+		epoch:   epoch,
+	}
+	// The first non-empty row names the columns
+	var header []interface{}
+	for len(header) == 0 {
+		if header, ok = it.readRow(); !ok {
+			return it, nil
+		}
+	}
+	for col, v := range header {
+		name := strings.TrimSpace(fmt.Sprint(v))
+		if v == nil || name == "" {
+			name = columnName(col)
+		}
+		it.columns = append(it.columns, name)
+	}
+	return it, nil
+}
+
+// readXMLPart decodes a part of the workbook package
+func readXMLPart(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("not an Excel workbook (%s is missing)", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// readSharedStrings returns the shared strings, which cells of type "s" index
+func readSharedStrings(files map[string]*zip.File) ([]string, error) {
+	if _, ok := files["xl/sharedStrings.xml"]; !ok {
+		return nil, nil
+	}
+	var table struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if err := readXMLPart(files, "xl/sharedStrings.xml", &table); err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		strs[i] = item.String()
+	}
+	return strs, nil
+}
+
+// xlsxText is a string item: plain text, or runs of rich text
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.Text)
+	}
+	return b.String()
+}
+
+// readDateStyles reports, by cell style index, the styles displaying numbers
+// as dates
+func readDateStyles(files map[string]*zip.File) ([]bool, error) {
+	if _, ok := files["xl/styles.xml"]; !ok {
+		return nil, nil
+	}
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := readXMLPart(files, "xl/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	custom := make(map[int]bool, len(styles.NumFmts))
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = isDateFormat(f.Code)
+	}
+	dates := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		id := xf.NumFmtID
+		// Built-in date and time formats
+		dates[i] = (id >= 14 && id <= 22) || (id >= 45 && id <= 47) || custom[id]
+	}
+	return dates, nil
+}
+
+// isDateFormat reports whether a number format code displays a date or a
+// time, ignoring quoted text, escaped characters and [colors]
+func isDateFormat(code string) bool {
+	for i := 0; i < len(code); i++ {
+		switch code[i] {
+		case '"':
+			if j := strings.IndexByte(code[i+1:], '"'); j >= 0 {
+				i += j + 1
+			}
+		case '\\', '_', '*':
+			i++
+		case '[':
+			if j := strings.IndexByte(code[i:], ']'); j >= 0 {
+				i += j
+			}
+		case 'y', 'Y', 'm', 'M', 'd', 'D', 'h', 'H', 's', 'S':
+			return true
+		}
+	}
+	return false
+}
+
+// columnName returns the letters of a column index (0 is A, 26 is AA)
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
+
+// columnIndex returns the column index of a cell reference ("C7" is 2), or
+// -1 when it has no letters
+func columnIndex(ref string) int {
+	col := 0
+	i := 0
+	for ; i < len(ref); i++ {
+		c := ref[i] | 0x20 // lower case
+		if c < 'a' || c > 'z' {
+			break
+		}
+		col = col*26 + int(c-'a') + 1
+	}
+	return col - 1
+}
+
+type xlsxIterator struct {
+	archive *zip.ReadCloser
+	sheet   io.ReadCloser
+	decoder *xml.Decoder
+	strings []string
+	dates   []bool
+	epoch   time.Time
+	columns []string
+	current Row
+	err     error
+}
+
+// xlsxCell is a cell of a sheet row
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"`
+	Type   string   `xml:"t,attr"`
+	Style  int      `xml:"s,attr"`
+	Value  *string  `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+func (it *xlsxIterator) Next() bool {
+	for {
+		values, ok := it.readRow()
+		if !ok {
+			return false
+		}
+		if len(values) == 0 {
+			continue
+		}
+		row := make(OrderedMap, 0, max(len(values), len(it.columns)))
+		for col := 0; col < len(it.columns) || col < len(values); col++ {
+			var v interface{}
+			if col < len(values) {
+				v = values[col]
+			}
+			if col >= len(it.columns) {
+				if v == nil {
+					continue
+				}
+				row = append(row, KeyVal{Key: columnName(col), Val: v})
+				continue
+			}
+			row = append(row, KeyVal{Key: it.columns[col], Val: v})
+		}
+		it.current = &JSONRow{data: row}
+		return true
+	}
+}
+
+// readRow decodes the next row of the sheet into its values by column index
+// (nil for missing cells), without trailing missing cells. It returns false
+// at the end of the sheet or on an error.
+func (it *xlsxIterator) readRow() ([]interface{}, bool) {
+	if it.err != nil {
+		return nil, false
+	}
+	// Find the next <row>
+	for {
+		tok, err := it.decoder.Token()
+		if err == io.EOF {
+			return nil, false
+		}
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "row" {
+			break
+		}
+	}
+	var values []interface{}
+	for {
+		tok, err := it.decoder.Token()
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				if err := it.decoder.Skip(); err != nil {
+					it.err = err
+					return nil, false
+				}
+				continue
+			}
+			var cell xlsxCell
+			if err := it.decoder.DecodeElement(&cell, &t); err != nil {
+				it.err = err
+				return nil, false
+			}
+			col := len(values)
+			if c := columnIndex(cell.Ref); c >= 0 {
+				col = c
+			}
+			v, err := it.cellValue(cell)
+			if err != nil {
+				it.err = fmt.Errorf("cell %s: %w", cell.Ref, err)
+				return nil, false
+			}
+			if v == nil || col < len(values) {
+				continue
+			}
+			for len(values) < col {
+				values = append(values, nil)
+			}
+			values = append(values, v)
+		case xml.EndElement:
+			return values, true
+		}
+	}
+}
+
+// cellValue converts a cell to its record value, nil for an empty cell
+func (it *xlsxIterator) cellValue(cell xlsxCell) (interface{}, error) {
+	if cell.Type == "inlineStr" {
+		return cell.Inline.String(), nil
+	}
+	if cell.Value == nil {
+		return nil, nil
+	}
+	v := *cell.Value
+	switch cell.Type {
+	case "s":
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(it.strings) {
+			return nil, fmt.Errorf("invalid shared string %q", v)
+		}
+		return it.strings[i], nil
+	case "b":
+		return v == "1", nil
+	case "str", "e", "d":
+		return v, nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", v)
+	}
+	if cell.Style >= 0 && cell.Style < len(it.dates) && it.dates[cell.Style] {
+		return it.date(n), nil
+	}
+	return n, nil
+}
+
+// date formats a date serial number (days since the workbook epoch)
+func (it *xlsxIterator) date(serial float64) string {
+	days := math.Floor(serial)
+	// Round to the second: serials carry floating point noise
+	seconds := math.Round((serial - days) * 86400)
+	t := it.epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+func (it *xlsxIterator) Row() Row {
+	return it.current
+}
+
+func (it *xlsxIterator) Error() error {
+	return it.err
+}
+
+func (it *xlsxIterator) Close() error {
+	it.sheet.Close()
+	return it.archive.Close()
+}
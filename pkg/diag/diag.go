@@ -0,0 +1,144 @@
+// Package diag reports warnings and notices on stderr, either as text for
+// people or as JSON lines for pipeline monitoring. Results never go through
+// diag: stdout carries data only.
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a diagnostic
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelWarn:
+		return "warn"
+	case LevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// Output formats of a Reporter
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Diagnostic codes, stable identifiers for machine consumers
+const (
+	CodeError         = "error"
+	CodeSkippedRecord = "skipped_record"
+	CodeCoercion      = "coercion"
+	CodeTruncated     = "truncated"
+	CodeFileFailed    = "file_failed"
+	CodeFileConverted = "file_converted"
+	CodeSummary       = "summary"
+	CodePlan          = "plan"
+	CodeSchema        = "schema"
+	CodeUngrouped     = "ungrouped_field"
+	CodeUnsorted      = "unsorted_input"
+	CodeScanCost      = "scan_cost"
+	CodeListening     = "listening"
+)
+
+// Event is a single diagnostic, emitted as one JSON line in FormatJSON
+type Event struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Reporter writes the diagnostics up to a maximum level
+type Reporter struct {
+	W      io.Writer
+	Level  Level  // diagnostics above this level are dropped
+	Format string // FormatText (or empty) or FormatJSON
+
+	mu sync.Mutex
+}
+
+var std = &Reporter{W: os.Stderr, Level: LevelInfo, Format: FormatText}
+
+// Default returns the process-wide reporter configured by the command line
+func Default() *Reporter {
+	return std
+}
+
+// Enabled reports whether diagnostics of the given level are written
+func (r *Reporter) Enabled(level Level) bool {
+	return level <= r.Level
+}
+
+// Report writes a diagnostic. details are optional key/value pairs
+// (e.g. "file", "in.json", "row", 3) added to the JSON event.
+func (r *Reporter) Report(level Level, code, message string, details ...interface{}) {
+	if !r.Enabled(level) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Format != FormatJSON {
+		switch level {
+		case LevelError:
+			fmt.Fprintf(r.W, "Error: %s\n", message)
+		case LevelWarn:
+			fmt.Fprintf(r.W, "warning: %s\n", message)
+		default:
+			fmt.Fprintln(r.W, message)
+		}
+		return
+	}
+
+	event := Event{Time: time.Now().UTC(), Level: level.String(), Code: code, Message: message}
+	for i := 0; i+1 < len(details); i += 2 {
+		if event.Details == nil {
+			event.Details = make(map[string]interface{})
+		}
+		event.Details[fmt.Sprint(details[i])] = details[i+1]
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		data, _ = json.Marshal(Event{Time: event.Time, Level: event.Level, Code: code, Message: message})
+	}
+	r.W.Write(append(data, '\n'))
+}
+
+// Error reports an error on the default reporter
+func Error(err error) {
+	std.Report(LevelError, CodeError, err.Error())
+}
+
+// Warn reports a warning on the default reporter
+func Warn(code, message string, details ...interface{}) {
+	std.Report(LevelWarn, code, message, details...)
+}
+
+// Info reports a notice (e.g. a summary) on the default reporter
+func Info(code, message string, details ...interface{}) {
+	std.Report(LevelInfo, code, message, details...)
+}
+
+// Debug reports verbose details on the default reporter
+func Debug(code, message string, details ...interface{}) {
+	std.Report(LevelDebug, code, message, details...)
+}
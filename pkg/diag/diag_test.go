@@ -0,0 +1,71 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := &Reporter{W: &buf, Level: LevelWarn, Format: FormatText}
+
+	r.Report(LevelWarn, CodeCoercion, "lossy", "field", "big")
+	r.Report(LevelInfo, CodeSummary, "dropped")
+	if got := buf.String(); got != "warning: lossy\n" {
+		t.Errorf("text output = %q", got)
+	}
+
+	buf.Reset()
+	r.Format = FormatJSON
+	r.Report(LevelError, CodeError, "boom")
+	r.Report(LevelWarn, CodeSkippedRecord, "skipped", "row", 3)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events, got %q", buf.String())
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(lines[1]), &ev); err != nil {
+		t.Fatalf("invalid JSON event %q: %v", lines[1], err)
+	}
+	if ev.Level != "warn" || ev.Code != CodeSkippedRecord || ev.Message != "skipped" || ev.Details["row"] != float64(3) {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestStatsReport(t *testing.T) {
+	var buf bytes.Buffer
+	r := &Reporter{W: &buf, Level: LevelInfo, Format: FormatText}
+
+	var s Stats
+	s.Read.Add(10)
+	s.Bytes.Add(3 * 1024 * 1024 / 2)
+	s.Emitted.Add(4)
+	s.Report(r, 1500*time.Millisecond)
+	if got := buf.String(); got != "summary: read 10 record(s) (1.5 MiB), emitted 4 in 1.5s\n" {
+		t.Errorf("text output = %q", got)
+	}
+
+	buf.Reset()
+	s.Match(true)
+	s.Match(false)
+	s.Skipped.Add(2)
+	s.Report(r, 20*time.Millisecond)
+	if got := buf.String(); got != "summary: read 10 record(s) (1.5 MiB), matched 1, emitted 4, skipped 2 invalid in 20ms\n" {
+		t.Errorf("text output = %q", got)
+	}
+
+	buf.Reset()
+	r.Format = FormatJSON
+	s.Report(r, 20*time.Millisecond)
+	var ev Event
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("invalid JSON event %q: %v", buf.String(), err)
+	}
+	if ev.Code != CodeStats || ev.Details["matched"] != float64(1) || ev.Details["duration_ms"] != float64(20) {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
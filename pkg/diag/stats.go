@@ -0,0 +1,106 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CodeStats identifies the processing statistics footer (--summary)
+const CodeStats = "stats"
+
+// Stats counts the records flowing through a run. Counters are updated
+// concurrently by the parser, the plan iterators and the commands.
+type Stats struct {
+	Read     atomic.Int64 // records read from the inputs (prefiltered ones included)
+	Matched  atomic.Int64 // records passing a filter
+	Filtered atomic.Int64 // records dropped by a filter
+	Emitted  atomic.Int64 // results written
+	Skipped  atomic.Int64 // invalid records skipped
+	Bytes    atomic.Int64 // input bytes consumed
+
+	filtered atomic.Bool // whether any filter was evaluated
+}
+
+var stats Stats
+
+// Counters returns the process-wide statistics
+func Counters() *Stats {
+	return &stats
+}
+
+// Match counts a filter evaluation
+func (s *Stats) Match(matched bool) {
+	s.filtered.Store(true)
+	if matched {
+		s.Matched.Add(1)
+	} else {
+		s.Filtered.Add(1)
+	}
+}
+
+type statsKey struct{}
+
+// WithStats returns a context whose query execution counts its records in s
+// besides the process-wide statistics (see RunStats)
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// RunStats returns the statistics of the execution running with ctx, nil
+// when ctx carries none (WithStats)
+func RunStats(ctx context.Context) *Stats {
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
+
+// Add adds the counts of other to s
+func (s *Stats) Add(other *Stats) {
+	s.Read.Add(other.Read.Load())
+	s.Matched.Add(other.Matched.Load())
+	s.Filtered.Add(other.Filtered.Load())
+	s.Emitted.Add(other.Emitted.Load())
+	s.Skipped.Add(other.Skipped.Load())
+	s.Bytes.Add(other.Bytes.Load())
+	if other.filtered.Load() {
+		s.filtered.Store(true)
+	}
+}
+
+// Report writes the statistics as a single diagnostic at LevelInfo
+func (s *Stats) Report(r *Reporter, elapsed time.Duration) {
+	parts := []string{fmt.Sprintf("read %d record(s) (%s)", s.Read.Load(), formatBytes(s.Bytes.Load()))}
+	details := []interface{}{
+		"read", s.Read.Load(),
+		"emitted", s.Emitted.Load(),
+		"skipped", s.Skipped.Load(),
+		"bytes", s.Bytes.Load(),
+		"duration_ms", elapsed.Milliseconds(),
+	}
+	if s.filtered.Load() {
+		parts = append(parts, fmt.Sprintf("matched %d", s.Matched.Load()))
+		details = append(details, "matched", s.Matched.Load())
+	}
+	parts = append(parts, fmt.Sprintf("emitted %d", s.Emitted.Load()))
+	if n := s.Skipped.Load(); n > 0 {
+		parts = append(parts, fmt.Sprintf("skipped %d invalid", n))
+	}
+	msg := fmt.Sprintf("summary: %s in %s", strings.Join(parts, ", "), elapsed.Round(time.Millisecond))
+	r.Report(LevelInfo, CodeStats, msg, details...)
+}
+
+// formatBytes renders a byte count with a binary unit (e.g. 1.5 MiB)
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/bisegni/jsl/pkg/plan"
+)
+
+// bulkAction is the action line of an Elasticsearch _bulk request
+type bulkAction struct {
+	Index struct {
+		Index string `json:"_index,omitempty"`
+	} `json:"index"`
+}
+
+// executeBulk writes the rows as the body of an Elasticsearch _bulk
+// request: an index action line, then the row on one line
+func (e *Executor) executeBulk(ctx context.Context, rootNode plan.Node, w io.Writer) error {
+	iterator, err := e.iterate(ctx, rootNode)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	var action bulkAction
+	action.Index.Index = e.BulkIndex
+	header, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	header = append(header, '\n')
+
+	// Sources must stay on one line, whatever Pretty says
+	encoder := json.NewEncoder(w)
+	for iterator.Next() {
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if err := encoder.Encode(e.output(iterator.Row())); err != nil {
+			return err
+		}
+		countEmitted(ctx)
+	}
+	return iterator.Error()
+}
@@ -1,50 +1,237 @@
 package engine
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"io"
+	"text/template"
+	"time"
 
+	"github.com/bisegni/jsl/pkg/database"
 	"github.com/bisegni/jsl/pkg/plan"
 )
 
-// Executor runs a Query Plan
+// Output formats supported by Executor.Execute
+const (
+	// FormatJSONL writes one JSON value per line (default)
+	FormatJSONL = "jsonl"
+	// FormatJSONArray wraps all rows in a single JSON array, streamed row by row
+	FormatJSONArray = "json-array"
+	// FormatMsgpack writes one MessagePack value per row
+	FormatMsgpack = "msgpack"
+	// FormatCSV writes a header naming the fields of the first row, then a
+	// line per row (database.CSVSink)
+	FormatCSV = "csv"
+	// FormatTable renders the rows as an aligned text table
+	FormatTable = "table"
+	// FormatESBulk writes each row as an Elasticsearch _bulk index action
+	// followed by the row as the document source
+	FormatESBulk = "es-bulk"
+)
+
+// Output buffering defaults of NewExecutor
+const (
+	DefaultBufferSize    = 64 * 1024
+	DefaultFlushInterval = 100 * time.Millisecond
+)
+
+// Executor runs a Query Plan. An Executor is safe for concurrent use by
+// several goroutines as long as its fields are not changed, each executing
+// its own plan.
 type Executor struct {
 	Pretty bool
+	// Format is FormatJSONL (or empty), FormatJSONArray, FormatMsgpack,
+	// FormatCSV, FormatTable or FormatESBulk
+	Format string
+	// BulkIndex is the _index of the FormatESBulk actions (empty leaves it
+	// to the _bulk URL)
+	BulkIndex string
+	// Template, when set, renders every row as text instead of Format
+	// (ParseTemplate)
+	Template *template.Template
+	// TableWidth truncates the values of FormatTable wider than this many
+	// characters (0 = no limit)
+	TableWidth int
+	// SchemaHeader emits a "#jsl-schema" line typing the fields of all the
+	// rows so that a downstream jsl keeps the field types. The rows are
+	// spooled to a temporary file in TempDir until the last one is typed.
+	SchemaHeader bool
+	// TempDir holds the temporary files of SchemaHeader, os.TempDir() when
+	// empty
+	TempDir string
+	// NestOutput turns dotted keys of projected rows into nested objects
+	NestOutput bool
+	// Flatten turns nested objects of result rows into dotted keys
+	// (database.Flatten); it is the inverse of NestOutput
+	Flatten bool
+	// BufferSize is the number of output bytes buffered before a write
+	// (0 writes every row through)
+	BufferSize int
+	// FlushInterval bounds how long buffered output waits before it is
+	// flushed (0 flushes only when the buffer is full and at the end)
+	FlushInterval time.Duration
+	// MaxRows aborts the query with ErrTooManyRows once it produces more
+	// rows, e.g. when unwinding multiplies them (0 = no limit)
+	MaxRows int
+	// Formatters rewrite output values (timestamps, byte counts, decimals)
+	Formatters *database.Formatters
+	// Analyze records the rows produced and the time spent by every plan
+	// node in ExecStats.Nodes, timing each row of each node. The nodes are
+	// wrapped in place, so an analyzed plan is not to be executed again.
+	Analyze bool
+	// Hooks, when set, observe the execution of every plan node (plan.Hook),
+	// wrapping them in place as Analyze does
+	Hooks plan.Hooks
 }
 
 func NewExecutor() *Executor {
 	return &Executor{
-		Pretty: false,
+		Pretty:        false,
+		Format:        FormatJSONL,
+		TableWidth:    DefaultTableWidth,
+		BufferSize:    DefaultBufferSize,
+		FlushInterval: DefaultFlushInterval,
+	}
+}
+
+// Execute runs the query plan and writes output, stopping with the error of
+// ctx once ctx is done. It returns the statistics of the execution, those
+// counted until an error when it fails.
+func (e *Executor) Execute(ctx context.Context, rootNode plan.Node, w io.Writer) (ExecStats, error) {
+	switch e.Format {
+	case "", FormatJSONL:
+	case FormatJSONArray, FormatMsgpack, FormatCSV, FormatTable, FormatESBulk:
+		if e.SchemaHeader {
+			return ExecStats{}, fmt.Errorf("schema header requires %s output", FormatJSONL)
+		}
+	default:
+		return ExecStats{}, fmt.Errorf("unsupported output format '%s'", e.Format)
+	}
+	if e.Template != nil && e.Format != "" && e.Format != FormatJSONL {
+		return ExecStats{}, fmt.Errorf("a template cannot be combined with %s output", e.Format)
+	}
+	if e.Template != nil && e.SchemaHeader {
+		return ExecStats{}, fmt.Errorf("schema header requires %s output", FormatJSONL)
+	}
+
+	ctx, run := e.begin(ctx, rootNode)
+	if e.BufferSize <= 0 {
+		err := e.execute(ctx, run.root, w)
+		return run.end(), err
+	}
+	out := newFlushWriter(w, e.BufferSize, e.FlushInterval)
+	err := e.execute(ctx, run.root, out)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
 	}
+	return run.end(), err
 }
 
-// Execute runs the query plan and writes output
-func (e *Executor) Execute(rootNode plan.Node, w io.Writer) error {
-	// Execute the Plan
-	iterator, err := rootNode.Execute()
+// execute writes the results in the configured format
+func (e *Executor) execute(ctx context.Context, rootNode plan.Node, w io.Writer) error {
+	if e.Template != nil {
+		return e.executeTemplate(ctx, rootNode, w)
+	}
+	switch e.Format {
+	case FormatTable:
+		return e.executeTable(ctx, rootNode, w)
+	case FormatESBulk:
+		return e.executeBulk(ctx, rootNode, w)
+	}
+	if e.SchemaHeader {
+		return e.executeSchemaHeader(ctx, rootNode, w)
+	}
+	return e.executeSink(ctx, rootNode, e.sink(w), nil)
+}
+
+// sink returns the sink writing rows to w in the configured format
+func (e *Executor) sink(w io.Writer) database.Sink {
+	switch e.Format {
+	case FormatJSONArray:
+		return database.NewJSONArraySink(w, e.Pretty)
+	case FormatMsgpack:
+		return database.NewMsgpackSink(w)
+	case FormatCSV:
+		return database.NewCSVSink(w)
+	}
+	return database.NewJSONLinesSink(w, e.Pretty)
+}
+
+// executeSink streams the rows to a sink, closing it once the rows are
+// exhausted. The rows are typed by schema when it is set.
+func (e *Executor) executeSink(ctx context.Context, rootNode plan.Node, sink database.Sink, schema *schemaInference) error {
+	iterator, err := e.iterate(ctx, rootNode)
 	if err != nil {
 		return err
 	}
 	defer iterator.Close()
 
-	// Stream results
-	encoder := json.NewEncoder(w)
-	if e.Pretty {
-		encoder.SetIndent("", "  ")
-	} else {
-		encoder.SetIndent("", "")
-	}
-
 	for iterator.Next() {
-		row := iterator.Row().Primitive()
-		if err := encoder.Encode(row); err != nil {
+		row := e.output(iterator.Row())
+		if schema != nil {
+			schema.add(row)
+		}
+		if err := sink.Write(database.NewJSONRow(row)); err != nil {
 			return err
 		}
+		countEmitted(ctx)
 	}
 
 	if err := iterator.Error(); err != nil {
 		return err
 	}
+	return sink.Close()
+}
+
+// iterate executes the plan, applying the MaxRows guard
+func (e *Executor) iterate(ctx context.Context, rootNode plan.Node) (database.RowIterator, error) {
+	iterator, err := rootNode.Execute(ctx)
+	if err != nil || e.MaxRows <= 0 {
+		return iterator, err
+	}
+	return &rowLimitIterator{RowIterator: iterator, max: e.MaxRows}, nil
+}
+
+// output returns the value written for a row
+func (e *Executor) output(row database.Row) interface{} {
+	value := e.Formatters.Apply(row.Primitive())
+	if e.NestOutput {
+		return nestRow(value)
+	}
+	if e.Flatten {
+		return database.Flatten(value)
+	}
+	return value
+}
+
+// ExecuteInto runs the query plan and writes the rows to a sink, returning
+// the statistics of the execution (Emitted counting the rows written). The
+// sink is not closed.
+func (e *Executor) ExecuteInto(ctx context.Context, rootNode plan.Node, sink database.Sink) (ExecStats, error) {
+	ctx, run := e.begin(ctx, rootNode)
+	err := e.executeInto(ctx, run.root, sink)
+	return run.end(), err
+}
+
+func (e *Executor) executeInto(ctx context.Context, rootNode plan.Node, sink database.Sink) error {
+	iterator, err := e.iterate(ctx, rootNode)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	sink = database.NewFormattingSink(sink, e.Formatters)
 
-	return nil
+	for iterator.Next() {
+		row := iterator.Row()
+		if e.NestOutput {
+			row = database.NewJSONRow(nestRow(row.Primitive()))
+		} else if e.Flatten {
+			row = database.NewJSONRow(database.Flatten(row.Primitive()))
+		}
+		if err := sink.Write(row); err != nil {
+			return err
+		}
+		countEmitted(ctx)
+	}
+	return iterator.Error()
 }
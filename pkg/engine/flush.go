@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// flushWriter buffers output and flushes it when the buffer fills up or,
+// at the latest, interval after the first pending write. High-throughput
+// runs batch their writes while a slow trickle of rows is still written
+// promptly, even when the input blocks between rows.
+type flushWriter struct {
+	mu       sync.Mutex
+	buf      *bufio.Writer
+	interval time.Duration
+	timer    *time.Timer
+	err      error // error of a background flush, returned by the next call
+}
+
+func newFlushWriter(w io.Writer, size int, interval time.Duration) *flushWriter {
+	return &flushWriter{buf: bufio.NewWriterSize(w, size), interval: interval}
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err := f.buf.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if f.interval > 0 && f.timer == nil && f.buf.Buffered() > 0 {
+		f.timer = time.AfterFunc(f.interval, f.timedFlush)
+	}
+	return n, nil
+}
+
+func (f *flushWriter) timedFlush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timer = nil
+	if err := f.buf.Flush(); err != nil && f.err == nil {
+		f.err = err
+	}
+}
+
+// Flush writes the pending output and stops the flush timer
+func (f *flushWriter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return f.buf.Flush()
+}
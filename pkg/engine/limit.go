@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// ErrTooManyRows is returned when a query produces more rows than
+// Executor.MaxRows
+var ErrTooManyRows = errors.New("too many output rows")
+
+// rowLimitIterator stops with ErrTooManyRows once its input produces more
+// than max rows, before the extra row is written
+type rowLimitIterator struct {
+	database.RowIterator
+	max   int
+	count int
+	err   error
+}
+
+func (it *rowLimitIterator) Next() bool {
+	if it.err != nil || !it.RowIterator.Next() {
+		return false
+	}
+	it.count++
+	if it.count > it.max {
+		it.err = fmt.Errorf("%w: more than %d", ErrTooManyRows, it.max)
+		return false
+	}
+	return true
+}
+
+func (it *rowLimitIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.RowIterator.Error()
+}
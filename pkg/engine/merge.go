@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// RecordReader reads records one at a time, their keys in document order,
+// returning io.EOF after the last one (*parser.Parser)
+type RecordReader interface {
+	ReadOrdered() (parser.OrderedMap, error)
+}
+
+// MergeInput is a record stream merged by a Merger
+type MergeInput struct {
+	Name    string // named in errors and Unsorted
+	Records RecordReader
+}
+
+// Merger performs a streaming k-way merge of record streams, each sorted by
+// a field, holding only the current record of each.
+//
+// Numbers compare numerically and RFC3339 timestamps chronologically,
+// whatever their time zone (SortKey). Records without the field are written
+// as soon as they are read. Records with equal keys keep the order of the
+// inputs.
+type Merger struct {
+	// Field is the path the inputs are sorted by
+	Field string
+	// CaseInsensitive matches Field regardless of case
+	CaseInsensitive bool
+	// Unsorted, when set, is called once per input holding a key lower than
+	// one read before it (last); its records are still merged, the output
+	// then being only partially ordered
+	Unsorted func(input string, key, last interface{})
+}
+
+// Merge writes the records of the inputs to write in the order of Field
+func (m *Merger) Merge(inputs []MergeInput, write func(parser.OrderedMap) error) error {
+	q := query.NewQuery(m.Field)
+	q.CaseInsensitive = m.CaseInsensitive
+
+	h := make(mergeHeap, 0, len(inputs))
+	for i, input := range inputs {
+		s := &mergeSource{MergeInput: input, index: i}
+		ok, err := m.next(s, q)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, s)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		s := h[0]
+		if err := write(s.record); err != nil {
+			return err
+		}
+		ok, err := m.next(s, q)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// next reads the following record of the source, returning false at the end
+// of its records
+func (m *Merger) next(s *mergeSource, q *query.Query) (bool, error) {
+	record, err := s.Records.ReadOrdered()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", s.Name, err)
+	}
+
+	key := SortKey(q, record)
+	if key != nil {
+		if s.last != nil && query.CompareOrder(key, s.last, query.NullsFirst) < 0 {
+			if !s.warned && m.Unsorted != nil {
+				m.Unsorted(s.Name, key, s.last)
+			}
+			s.warned = true
+		} else {
+			s.last = key
+		}
+	}
+	s.record, s.key = record, key
+	return true, nil
+}
+
+// mergeSource is an input of a merge with its current record
+type mergeSource struct {
+	MergeInput
+	index  int
+	record parser.OrderedMap
+	key    interface{}
+	last   interface{} // highest key read so far
+	warned bool
+}
+
+// mergeHeap orders the sources by the key of their current record, then by
+// their position among the inputs
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if c := query.CompareOrder(h[i].key, h[j].key, query.NullsFirst); c != 0 {
+		return c < 0
+	}
+	return h[i].index < h[j].index
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// SortKey extracts the sort key of a record, reading RFC3339 strings as
+// timestamps so that offsets and fractional seconds order correctly
+func SortKey(q *query.Query, record interface{}) interface{} {
+	v, err := q.Extract(record)
+	if err != nil {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	return v
+}
@@ -0,0 +1,159 @@
+package engine_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bisegni/jsl/pkg/engine"
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// recordSlice reads records from a slice, failing after them when err is set
+type recordSlice struct {
+	records []parser.OrderedMap
+	err     error
+}
+
+func (r *recordSlice) ReadOrdered() (parser.OrderedMap, error) {
+	if len(r.records) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+// mergeInputs returns inputs named a, b, ... over JSON records
+func mergeInputs(t *testing.T, inputs [][]string) []engine.MergeInput {
+	t.Helper()
+	merged := make([]engine.MergeInput, len(inputs))
+	for i, lines := range inputs {
+		records := &recordSlice{}
+		for _, line := range lines {
+			record, err := parser.NewReaderParser("input", strings.NewReader(line)).ReadOrdered()
+			if err != nil {
+				t.Fatal(err)
+			}
+			records.records = append(records.records, record)
+		}
+		merged[i] = engine.MergeInput{Name: string(rune('a' + i)), Records: records}
+	}
+	return merged
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		ci       bool
+		inputs   [][]string
+		expected []string // the id of the records, in output order
+		unsorted []string // the inputs reported out of order
+	}{
+		{
+			name:     "numbers",
+			field:    "ts",
+			inputs:   [][]string{{`{"id":"a1","ts":1}`, `{"id":"a2","ts":4}`}, {`{"id":"b1","ts":2}`, `{"id":"b2","ts":3}`, `{"id":"b3","ts":10}`}},
+			expected: []string{"a1", "b1", "b2", "a2", "b3"},
+		},
+		{
+			name:     "ties keep the order of the inputs",
+			field:    "ts",
+			inputs:   [][]string{{`{"id":"a1","ts":1}`, `{"id":"a2","ts":2}`}, {`{"id":"b1","ts":1}`, `{"id":"b2","ts":2}`}, {`{"id":"c1","ts":1}`}},
+			expected: []string{"a1", "b1", "c1", "a2", "b2"},
+		},
+		{
+			name:  "RFC3339 timestamps in any time zone",
+			field: "ts",
+			inputs: [][]string{
+				{`{"id":"a1","ts":"2024-03-01T10:00:00Z"}`, `{"id":"a2","ts":"2024-03-01T12:00:00Z"}`},
+				// 09:30 and 11:00 UTC, sorting after a1 and before a2 only once normalised
+				{`{"id":"b1","ts":"2024-03-01T10:30:00+01:00"}`, `{"id":"b2","ts":"2024-03-01T06:00:00-05:00"}`},
+				{`{"id":"c1","ts":"2024-03-01T10:00:00.5Z"}`},
+			},
+			expected: []string{"b1", "a1", "c1", "b2", "a2"},
+		},
+		{
+			name:     "records without the field first",
+			field:    "meta.ts",
+			inputs:   [][]string{{`{"id":"a1","meta":{"ts":2}}`, `{"id":"a2"}`}, {`{"id":"b1","meta":{"ts":1}}`}},
+			expected: []string{"b1", "a1", "a2"},
+		},
+		{
+			name:     "case-insensitive field",
+			field:    "ts",
+			ci:       true,
+			inputs:   [][]string{{`{"id":"a1","TS":2}`}, {`{"id":"b1","Ts":1}`}},
+			expected: []string{"b1", "a1"},
+		},
+		{
+			name:     "unsorted input reported once, still merged",
+			field:    "ts",
+			inputs:   [][]string{{`{"id":"a1","ts":5}`, `{"id":"a2","ts":1}`, `{"id":"a3","ts":0}`}, {`{"id":"b1","ts":2}`, `{"id":"b2","ts":6}`}},
+			expected: []string{"b1", "a1", "a2", "a3", "b2"},
+			unsorted: []string{"a"},
+		},
+		{
+			name:     "empty inputs",
+			field:    "ts",
+			inputs:   [][]string{{}, {`{"id":"b1","ts":1}`}, {}},
+			expected: []string{"b1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unsorted []string
+			merger := &engine.Merger{
+				Field:           tt.field,
+				CaseInsensitive: tt.ci,
+				Unsorted: func(input string, key, last interface{}) {
+					unsorted = append(unsorted, input)
+				},
+			}
+			var ids []string
+			err := merger.Merge(mergeInputs(t, tt.inputs), func(record parser.OrderedMap) error {
+				id, _ := record.Get("id")
+				ids = append(ids, fmt.Sprint(id))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+			if !reflect.DeepEqual(unsorted, tt.unsorted) {
+				t.Errorf("Expected %v reported unsorted, got %v", tt.unsorted, unsorted)
+			}
+		})
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	inputs := mergeInputs(t, [][]string{{`{"ts":1}`}, {`{"ts":2}`}})
+	inputs[1].Records.(*recordSlice).err = errors.New("bad record")
+	merger := &engine.Merger{Field: "ts"}
+	err := merger.Merge(inputs, func(parser.OrderedMap) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "b: bad record") {
+		t.Errorf("Expected the read error named after its input, got %v", err)
+	}
+
+	inputs = mergeInputs(t, [][]string{{`{"ts":1}`, `{"ts":2}`}})
+	stop := errors.New("stop")
+	written := 0
+	err = merger.Merge(inputs, func(parser.OrderedMap) error {
+		written++
+		return stop
+	})
+	if !errors.Is(err, stop) || written != 1 {
+		t.Errorf("Expected the write error to stop the merge, got %v after %d record(s)", err, written)
+	}
+}
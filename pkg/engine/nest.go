@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// nestRow rebuilds nested objects from the dotted keys of a projected row,
+// e.g. {"supplier.country":"IT"} becomes {"supplier":{"country":"IT"}}.
+// Wildcard segments ("*", "$") are dropped, so "sensors.$.name" nests as
+// sensors.name. Only objects created here are descended into: a key that
+// would go through a projected value is kept flat.
+func nestRow(row interface{}) interface{} {
+	m, ok := row.(database.OrderedMap)
+	if !ok {
+		return row
+	}
+
+	n := &nester{created: make(map[string]bool)}
+	out := make(database.OrderedMap, 0, len(m))
+	for _, kv := range m {
+		if !n.insert(&out, "", nestedKey(kv.Key), kv.Val) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// nestedKey splits a dotted key into object keys, skipping wildcards. A
+// backtick-quoted key holding dots (a.`b.c`) is a single key.
+func nestedKey(key string) []string {
+	var parts []string
+	for _, p := range splitKey(key) {
+		if p == "" || p == "*" || p == "$" {
+			continue
+		}
+		if len(p) > 2 && p[0] == '`' && p[len(p)-1] == '`' {
+			p = p[1 : len(p)-1]
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return []string{key}
+	}
+	return parts
+}
+
+// splitKey splits a key on the dots outside backticks
+func splitKey(key string) []string {
+	if !strings.Contains(key, "`") {
+		return strings.Split(key, ".")
+	}
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '`':
+			quoted = !quoted
+		case key[i] == '.' && !quoted:
+			parts = append(parts, key[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, key[start:])
+}
+
+type nester struct {
+	created map[string]bool // prefixes of the objects created while nesting
+}
+
+// insert stores val under parts in m (found at prefix), creating intermediate
+// objects. It reports false when an intermediate key holds a projected value.
+func (n *nester) insert(m *database.OrderedMap, prefix string, parts []string, val interface{}) bool {
+	path := prefix + "." + parts[0]
+	for i := range *m {
+		kv := &(*m)[i]
+		if kv.Key != parts[0] {
+			continue
+		}
+		if len(parts) == 1 {
+			kv.Val = val
+			return true
+		}
+		child, ok := kv.Val.(database.OrderedMap)
+		if !ok || !n.created[path] {
+			return false
+		}
+		if !n.insert(&child, path, parts[1:], val) {
+			return false
+		}
+		kv.Val = child
+		return true
+	}
+
+	if len(parts) == 1 {
+		*m = append(*m, database.KeyVal{Key: parts[0], Val: val})
+		return true
+	}
+	child := database.OrderedMap{}
+	n.created[path] = true
+	n.insert(&child, path, parts[1:], val)
+	*m = append(*m, database.KeyVal{Key: parts[0], Val: child})
+	return true
+}
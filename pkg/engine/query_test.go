@@ -1,15 +1,32 @@
 package engine_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/engine"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/plan"
 	"github.com/bisegni/jsl/pkg/planner"
 	"github.com/bisegni/jsl/pkg/query"
+	"github.com/klauspost/compress/zstd"
 )
 
 func runQuery(t *testing.T, table database.Table, sql string) []map[string]interface{} {
@@ -26,7 +43,7 @@ func runQuery(t *testing.T, table database.Table, sql string) []map[string]inter
 
 	executor := engine.NewExecutor()
 	var buf bytes.Buffer
-	if err := executor.Execute(rootNode, &buf); err != nil {
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
 		t.Fatalf("Failed to execute query %q: %v", sql, err)
 	}
 
@@ -230,3 +247,1609 @@ func TestQueryFunctionality(t *testing.T) {
 		}
 	})
 }
+
+func TestInMemoryTables(t *testing.T) {
+	t.Run("Slice of maps", func(t *testing.T) {
+		table := database.NewSliceTable([]map[string]interface{}{
+			{"name": "Alice", "age": 30},
+			{"name": "Bob", "age": 25},
+		})
+		results := runQuery(t, table, "SELECT name WHERE age > 26")
+		if len(results) != 1 || results[0]["name"] != "Alice" {
+			t.Errorf("Expected Alice, got %v", results)
+		}
+	})
+
+	t.Run("Slice of structs", func(t *testing.T) {
+		type address struct {
+			City string `json:"city"`
+		}
+		type user struct {
+			Name    string   `json:"name"`
+			Age     int      `json:"age"`
+			Tags    []string `json:"tags,omitempty"`
+			Address *address `json:"address"`
+			secret  string
+		}
+		table := database.NewStructTable([]user{
+			{Name: "Alice", Age: 30, Tags: []string{"admin"}, Address: &address{City: "Rome"}, secret: "x"},
+			{Name: "Bob", Age: 25},
+		})
+
+		results := runQuery(t, table, "SELECT name, address.city AS city WHERE tags = 'admin'")
+		if len(results) != 1 || results[0]["name"] != "Alice" || results[0]["city"] != "Rome" {
+			t.Errorf("Expected Alice from Rome, got %v", results)
+		}
+
+		results = runQuery(t, table, "SELECT SUM(age)")
+		if len(results) != 1 || results[0]["SUM_age"].(float64) != 55 {
+			t.Errorf("Expected SUM 55, got %v", results)
+		}
+	})
+}
+
+func TestCorrelatedWildcard(t *testing.T) {
+	table := database.NewJSONTable("../../examples/sensors.jsonl")
+
+	for _, where := range []string{"sensors.*.type = 'temp'", "sensors.type = 'temp'", "sensors.$.type = 'temp'"} {
+		t.Run(where, func(t *testing.T) {
+			results := runQuery(t, table, "SELECT timestamp, sensors.$.name AS name WHERE "+where)
+			if len(results) != 6 {
+				t.Fatalf("Expected 6 results, got %d: %v", len(results), results)
+			}
+			for _, r := range results {
+				if r["name"] != "sensor_01" && r["name"] != "sensor_03" {
+					t.Errorf("Expected only temp sensors, got %v", r["name"])
+				}
+			}
+		})
+	}
+
+	t.Run("Combined conditions", func(t *testing.T) {
+		results := runQuery(t, table, "SELECT sensors.$.name AS name WHERE sensors.*.type = 'temp' AND sensors.*.room = 'kitchen'")
+		if len(results) != 3 {
+			t.Fatalf("Expected 3 results, got %d: %v", len(results), results)
+		}
+		for _, r := range results {
+			if r["name"] != "sensor_03" {
+				t.Errorf("Expected sensor_03, got %v", r["name"])
+			}
+		}
+	})
+}
+
+func TestNestOutput(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"name": "Laptop", "supplier": map[string]interface{}{"name": "TechCorp", "country": "USA", "tax.id": "T1"}, "tags": []interface{}{"a"}},
+	})
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT name, supplier.country, supplier.name", `{"name":"Laptop","supplier":{"country":"USA","name":"TechCorp"}}`},
+		// A quoted key holding a dot stays one key
+		{"SELECT supplier.`tax.id`, supplier.name", `{"supplier":{"tax.id":"T1","name":"TechCorp"}}`},
+		{"SELECT supplier.country AS `origin.country`, name", `{"origin":{"country":"USA"},"name":"Laptop"}`},
+		{"SELECT tags AS t, tags.x AS `t.x`", `{"t":["a"],"t.x":[]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Failed to parse query: %v", err)
+			}
+			rootNode, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Failed to create plan: %v", err)
+			}
+
+			executor := engine.NewExecutor()
+			executor.NestOutput = true
+			var buf bytes.Buffer
+			if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+				t.Fatalf("Failed to execute query: %v", err)
+			}
+			if got := strings.TrimSpace(buf.String()); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFlattenOutput(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"name": "Laptop", "supplier": map[string]interface{}{"name": "TechCorp", "address": map[string]interface{}{"country": "USA"}}, "tags": []interface{}{"a"}, "meta": map[string]interface{}{}},
+	})
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT name, supplier", `{"name":"Laptop","supplier.address.country":"USA","supplier.name":"TechCorp"}`},
+		{"SELECT supplier.address AS origin, meta", `{"origin.country":"USA","meta":{}}`},
+		{"SELECT name, supplier.name", `{"name":"Laptop","supplier.name":"TechCorp"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Failed to parse query: %v", err)
+			}
+			rootNode, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Failed to create plan: %v", err)
+			}
+
+			executor := engine.NewExecutor()
+			executor.Flatten = true
+			var buf bytes.Buffer
+			if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+				t.Fatalf("Failed to execute query: %v", err)
+			}
+			if got := strings.TrimSpace(buf.String()); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestConcurrentTableScans(t *testing.T) {
+	countRows := func(table database.Table) (int, error) {
+		q, err := query.ParseQuery("SELECT COUNT(*) AS n WHERE sensors.*.type = 'temp'")
+		if err != nil {
+			return 0, err
+		}
+		rootNode, err := planner.CreatePlan(q, table)
+		if err != nil {
+			return 0, err
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), rootNode, &buf); err != nil {
+			return 0, err
+		}
+		var out struct{ N int }
+		err = json.Unmarshal(buf.Bytes(), &out)
+		return out.N, err
+	}
+
+	scanConcurrently := func(t *testing.T, table database.Table) {
+		const workers = 8
+		counts := make(chan int, workers)
+		errs := make(chan error, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, err := countRows(table)
+				if err != nil {
+					errs <- err
+					return
+				}
+				counts <- n
+			}()
+		}
+		wg.Wait()
+		close(counts)
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("Concurrent scan failed: %v", err)
+		}
+		for n := range counts {
+			if n != 3 {
+				t.Errorf("Expected 3 rows per scan, got %d", n)
+			}
+		}
+	}
+
+	t.Run("File", func(t *testing.T) {
+		scanConcurrently(t, database.NewJSONTable("../../examples/sensors.jsonl"))
+	})
+
+	t.Run("Stdin", func(t *testing.T) {
+		data, err := os.ReadFile("../../examples/sensors.jsonl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			w.Write(data)
+			w.Close()
+		}()
+		stdin := os.Stdin
+		os.Stdin = r
+		defer func() { os.Stdin = stdin }()
+
+		// Every scan must see the whole stream, not what the others left
+		scanConcurrently(t, database.NewJSONTable("-"))
+	})
+}
+
+// TestConcurrentQueries runs every parsed query from several goroutines at
+// once through one Executor, as a server does; go test -race reports the
+// state they would share.
+func TestConcurrentQueries(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&content, "{\"id\":%d,\"kind\":\"K%d\",\"tags\":[\"t%d\"]}\n", i, i%7, i%3)
+	}
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	if err := os.WriteFile(file, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table := database.NewJSONTable(file)
+
+	executor := engine.NewExecutor()
+	executor.Formatters = &database.Formatters{}
+	if err := executor.Formatters.Add("id=fixed:1"); err != nil {
+		t.Fatal(err)
+	}
+	sqls := []string{
+		"SELECT id WHERE kind = 'k1' AND id > 10",
+		"SELECT kind AS k, COUNT(*) AS n GROUP BY k ORDER BY k",
+		"SELECT id WHERE kind CONTAINS '3' ORDER BY id DESC LIMIT 5",
+		"SELECT k FROM (SELECT kind AS k WHERE tags = 't1') WHERE k != 'K2' LIMIT 20",
+		"SELECT BUCKET(id, 500) AS b, COUNT(*) AS n GROUP BY BUCKET(id, 500)",
+	}
+	for _, workers := range []int{1, 4} {
+		for _, sql := range sqls {
+			q, err := query.ParseQuery(sql)
+			if err != nil {
+				t.Fatalf("Failed to parse query %q: %v", sql, err)
+			}
+			// Planning sets the engine options on the conditions of a copy
+			q.CaseInsensitive = true
+			q.IgnoreCase = true
+			q.Workers = workers
+
+			const goroutines = 8
+			outputs := make([]string, goroutines)
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					rootNode, err := planner.CreatePlan(q, table)
+					if err != nil {
+						t.Errorf("Failed to create plan for %q: %v", sql, err)
+						return
+					}
+					var buf bytes.Buffer
+					if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+						t.Errorf("Failed to execute query %q: %v", sql, err)
+					}
+					outputs[g] = buf.String()
+				}(g)
+			}
+			wg.Wait()
+
+			if outputs[0] == "" {
+				t.Errorf("Query %q (%d workers) returned no rows", sql, workers)
+			}
+			for g := 1; g < goroutines; g++ {
+				if outputs[g] != outputs[0] {
+					t.Errorf("Query %q (%d workers) returned different rows:\n%s\nvs\n%s", sql, workers, outputs[g], outputs[0])
+				}
+			}
+		}
+	}
+}
+
+// gatedTable yields its first row, then blocks until release is closed
+type gatedTable struct {
+	release chan struct{}
+}
+
+func (t *gatedTable) Iterate(ctx context.Context) (database.RowIterator, error) {
+	return &gatedIterator{release: t.release}, nil
+}
+
+type gatedIterator struct {
+	release chan struct{}
+	n       int
+}
+
+func (it *gatedIterator) Next() bool {
+	if it.n == 1 {
+		<-it.release
+	}
+	it.n++
+	return it.n <= 2
+}
+
+func (it *gatedIterator) Row() database.Row {
+	return database.NewJSONRow(map[string]interface{}{"n": it.n})
+}
+
+func (it *gatedIterator) Error() error { return nil }
+func (it *gatedIterator) Close() error { return nil }
+
+// syncBuffer is a bytes.Buffer safe for the executor's background flushes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestOutputFlushing(t *testing.T) {
+	run := func(interval time.Duration) (*syncBuffer, chan struct{}, chan error) {
+		table := &gatedTable{release: make(chan struct{})}
+		q, err := query.ParseQuery("SELECT n")
+		if err != nil {
+			t.Fatalf("Failed to parse query: %v", err)
+		}
+		rootNode, err := planner.CreatePlan(q, table)
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+
+		executor := engine.NewExecutor()
+		executor.FlushInterval = interval
+		out := &syncBuffer{}
+		done := make(chan error, 1)
+		go func() {
+			_, err := executor.Execute(context.Background(), rootNode, out)
+			done <- err
+		}()
+		return out, table.release, done
+	}
+
+	t.Run("Trickle is flushed by interval", func(t *testing.T) {
+		out, release, done := run(10 * time.Millisecond)
+		deadline := time.Now().Add(5 * time.Second)
+		for out.String() != "{\"n\":1}\n" {
+			if time.Now().After(deadline) {
+				t.Fatalf("First row not flushed while the input is blocked, got %q", out.String())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("Failed to execute query: %v", err)
+		}
+		if got := out.String(); got != "{\"n\":1}\n{\"n\":2}\n" {
+			t.Errorf("Unexpected output %q", got)
+		}
+	})
+
+	t.Run("Buffered until the end without interval", func(t *testing.T) {
+		out, release, done := run(0)
+		time.Sleep(50 * time.Millisecond)
+		if got := out.String(); got != "" {
+			t.Errorf("Expected buffered output, got %q", got)
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("Failed to execute query: %v", err)
+		}
+		if got := out.String(); got != "{\"n\":1}\n{\"n\":2}\n" {
+			t.Errorf("Unexpected output %q", got)
+		}
+	})
+}
+
+func TestPartitionedInto(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"name": "a", "day": "2024-01-01"},
+		{"name": "b", "day": "2024-01-02"},
+		{"name": "c", "day": "2024-01-01"},
+		{"name": "d", "day": "../x"},
+		{"name": "e"},
+	})
+	dir := t.TempDir()
+
+	sink, err := database.NewPartitionedSink(filepath.Join(dir, "out", "{day}.jsonl"), "day")
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	q, err := query.ParseQuery("SELECT name, day")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	stats, err := engine.NewExecutor().ExecuteInto(context.Background(), rootNode, sink)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+	if stats.Emitted != 5 || len(sink.Files()) != 4 {
+		t.Errorf("Expected 5 rows in 4 files, got %d rows in %v", stats.Emitted, sink.Files())
+	}
+
+	expected := map[string]string{
+		"2024-01-01.jsonl": "{\"name\":\"a\",\"day\":\"2024-01-01\"}\n{\"name\":\"c\",\"day\":\"2024-01-01\"}\n",
+		"2024-01-02.jsonl": "{\"name\":\"b\",\"day\":\"2024-01-02\"}\n",
+		".._x.jsonl":       "{\"name\":\"d\",\"day\":\"../x\"}\n",
+		"null.jsonl":       "{\"name\":\"e\",\"day\":null}\n",
+	}
+	for name, want := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, "out", name))
+		if err != nil {
+			t.Errorf("Missing partition %s: %v", name, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("Partition %s = %q, want %q", name, data, want)
+		}
+	}
+
+	if _, err := database.NewPartitionedSink(filepath.Join(dir, "out.jsonl"), "day"); err == nil {
+		t.Error("Expected error for a pattern without {day}")
+	}
+}
+
+func TestPartitionedIntoStar(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"name": "a", "day": "mon"},
+		{"name": "b", "day": "tue"},
+	})
+	dir := t.TempDir()
+	sink, err := database.NewPartitionedSink(filepath.Join(dir, "{day}.jsonl"), "day")
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	// The field is found in the record of the * column
+	q, err := query.ParseQuery("SELECT *, name AS n")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	if _, err := engine.NewExecutor().ExecuteInto(context.Background(), rootNode, sink); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+	if files := sink.Files(); len(files) != 2 || filepath.Base(files[0]) != "mon.jsonl" || filepath.Base(files[1]) != "tue.jsonl" {
+		t.Errorf("Expected mon.jsonl and tue.jsonl, got %v", files)
+	}
+}
+
+func TestMaxRows(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"id": 1, "tags": []interface{}{"a", "b", "c"}},
+		{"id": 2, "tags": []interface{}{"d", "e"}},
+	})
+	q, err := query.ParseQuery("SELECT id, UNNEST(tags)")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+
+	for _, format := range []string{engine.FormatJSONL, engine.FormatJSONArray} {
+		executor := engine.NewExecutor()
+		executor.Format = format
+		executor.MaxRows = 4
+		var buf bytes.Buffer
+		_, err := executor.Execute(context.Background(), rootNode, &buf)
+		if !errors.Is(err, engine.ErrTooManyRows) {
+			t.Errorf("%s: expected ErrTooManyRows, got %v", format, err)
+		}
+		if n := strings.Count(buf.String(), `"tags"`); n != 4 {
+			t.Errorf("%s: expected the first 4 rows before aborting, got %q", format, buf.String())
+		}
+	}
+
+	executor := engine.NewExecutor()
+	executor.MaxRows = 5
+	var buf bytes.Buffer
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Errorf("Expected 5 rows to fit the limit, got %v", err)
+	}
+}
+
+func TestValueFormatters(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"size": 1536, "ts": 1700000000, "price": 3.14159, "meta": map[string]interface{}{"size": 3 * 1024 * 1024}},
+	})
+	q, err := query.ParseQuery("SELECT size, ts, price, meta")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+
+	formatters := &database.Formatters{}
+	for _, spec := range []string{"size=bytes", "meta.size=bytes", "ts=rfc3339", "type:float=fixed:2"} {
+		if err := formatters.Add(spec); err != nil {
+			t.Fatalf("Add(%s) error = %v", spec, err)
+		}
+	}
+	executor := engine.NewExecutor()
+	executor.Formatters = formatters
+	var buf bytes.Buffer
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	expected := `{"size":"1.5 KiB","ts":"2023-11-14T22:13:20Z","price":3.14,"meta":{"size":"3.0 MiB"}}`
+	if got := strings.TrimSpace(buf.String()); got != expected {
+		t.Errorf("Execute() = %s, want %s", got, expected)
+	}
+
+	for _, spec := range []string{"size", "=bytes", "size=hex", "price=fixed:x", "type:object=bytes"} {
+		if err := (&database.Formatters{}).Add(spec); err == nil {
+			t.Errorf("Add(%s) expected an error", spec)
+		}
+	}
+}
+
+func TestMsgpackOutput(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"id": 1, "price": 2.5, "neg": -300, "big": int64(1) << 60, "ts": ts, "meta": map[string]interface{}{"tags": []interface{}{"a", nil, true}}},
+	})
+
+	q, err := query.ParseQuery("SELECT id, price, neg, big, ts, meta")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	executor := engine.NewExecutor()
+	executor.Format = engine.FormatMsgpack
+	var buf bytes.Buffer
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	// Read the output back as a MessagePack file
+	file := filepath.Join(t.TempDir(), "out.msgpack")
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := parser.NewParser(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	records, err := p.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read MessagePack output: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	expected := parser.Record{
+		"id": float64(1), "price": 2.5, "neg": float64(-300), "big": int64(1) << 60, "ts": ts,
+		"meta": map[string]interface{}{"tags": []interface{}{"a", nil, true}},
+	}
+	if got, want := fmt.Sprint(records[0]), fmt.Sprint(expected); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// Keys keep the projection order, small integers their compact form
+	out, err := database.AppendMsgpack(nil, database.OrderedMap{{Key: "id", Val: 1.0}, {Key: "a", Val: false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x82, 0xa2, 'i', 'd', 0x01, 0xa1, 'a', 0xc2}; !bytes.Equal(out, want) {
+		t.Errorf("Expected % x, got % x", want, out)
+	}
+}
+
+func TestCSVOutput(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"id": 1, "name": "a, \"b\"", "ts": ts, "meta": map[string]interface{}{"size": 1.5, "tags": []interface{}{"x"}}},
+		{"id": 2, "name": nil},
+	})
+
+	run := func(executor *engine.Executor, sql string) (string, error) {
+		q, err := query.ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("Failed to parse query: %v", err)
+		}
+		rootNode, err := planner.CreatePlan(q, table)
+		if err != nil {
+			t.Fatalf("Failed to create plan: %v", err)
+		}
+		var buf bytes.Buffer
+		_, err = executor.Execute(context.Background(), rootNode, &buf)
+		return buf.String(), err
+	}
+	executor := engine.NewExecutor()
+	executor.Format = engine.FormatCSV
+	out, err := run(executor, "SELECT id, name, ts, meta")
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	expected := "id,name,ts,meta.size,meta.tags\n1,\"a, \"\"b\"\"\",2024-01-02T03:04:05Z,1.5,\"[\"\"x\"\"]\"\n2,,,,\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	// The columns are those of the first row
+	if _, err := run(executor, "SELECT id, name, meta ORDER BY id DESC"); err == nil || !strings.Contains(err.Error(), "meta.size is not a CSV column") {
+		t.Errorf("Expected an error for a field outside the header, got %v", err)
+	}
+
+	// INTO a .csv file
+	file := filepath.Join(t.TempDir(), "out.csv")
+	sink, err := database.NewFileSink(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := query.ParseQuery("SELECT id ORDER BY id DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.NewExecutor().ExecuteInto(context.Background(), rootNode, sink); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "id\n2\n1\n" {
+		t.Errorf("Expected the ids as CSV, got %q", got)
+	}
+}
+
+func TestMultiFileTable(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"2026-01-01.jsonl": "{\"day\":1,\"level\":\"error\"}\n{\"day\":1,\"level\":\"info\"}\n",
+		"2026-01-02.json":  `[{"day":2,"level":"error"}]`,
+		"2025-12-31.jsonl": "{\"day\":0,\"level\":\"error\"}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched, err := database.ExpandPattern(filepath.Join(dir, "2026-01-*"))
+	if err != nil {
+		t.Fatalf("ExpandPattern failed: %v", err)
+	}
+	if len(matched) != 2 || filepath.Base(matched[0]) != "2026-01-01.jsonl" {
+		t.Fatalf("Expected the two January files in order, got %v", matched)
+	}
+
+	results := runQuery(t, database.NewMultiFileTable(matched), "SELECT day, COUNT(level) AS n WHERE level = 'error' GROUP BY day")
+	if got := fmt.Sprint(results); got != "[map[day:1 n:1] map[day:2 n:1]]" {
+		t.Errorf("Unexpected results %s", got)
+	}
+
+	// Paths that are not patterns are kept as they are
+	for _, name := range []string{"-", `[{"a":1}]`, "data.jsonl"} {
+		if got, err := database.ExpandPattern(name); err != nil || len(got) != 1 || got[0] != name {
+			t.Errorf("ExpandPattern(%q) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := database.ExpandPattern(filepath.Join(dir, "2027-*")); err == nil {
+		t.Error("Expected error for a pattern matching no file")
+	}
+
+	// A missing file fails the scan, naming the file
+	table := database.NewMultiFileTable([]string{matched[0], filepath.Join(dir, "missing.jsonl")})
+	iter, err := table.Iterate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	rows := 0
+	for iter.Next() {
+		rows++
+	}
+	if rows != 2 || iter.Error() == nil || !strings.Contains(iter.Error().Error(), "missing.jsonl") {
+		t.Errorf("Expected 2 rows and an error naming missing.jsonl, got %d rows and %v", rows, iter.Error())
+	}
+}
+
+func TestMetadataColumns(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.jsonl")
+	b := filepath.Join(dir, "b.json")
+	if err := os.WriteFile(a, []byte("{\"id\":1}\n\n{\"id\":2}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("[\n  {\"id\":3},\n  {\"id\":4, \"_line\":\"own\"}\n]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table := database.NewMultiFileTable([]string{a, b})
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		// The virtual _line shadows the record's own field
+		{"SELECT id, _line", "[map[_line:1 id:1] map[_line:3 id:2] map[_line:2 id:3] map[_line:3 id:4]]"},
+		{"SELECT id WHERE _file = '" + b + "' AND _line > 2", "[map[id:4]]"},
+		{"SELECT _file AS f, COUNT(id) AS n GROUP BY _file", fmt.Sprintf("[map[f:%s n:2] map[f:%s n:2]]", a, b)},
+		{"SELECT id, _file WHERE id > 2", fmt.Sprintf("[map[_file:%s id:3] map[_file:%s id:4]]", b, b)},
+		// The columns are not part of the records
+		{"SELECT * WHERE _line = 1", "[map[*:map[id:1]]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			if got := fmt.Sprint(runQuery(t, table, tt.sql)); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	table := database.NewJSONTable(`[{"z":1,"a":{"y":2,"b":3},"m":[{"q":1,"c":2}]}]`)
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT *", `{"*":{"z":1,"a":{"y":2,"b":3},"m":[{"q":1,"c":2}]}}`},
+		{"SELECT a WHERE a.y = 2", `{"a":{"y":2,"b":3}}`},
+		// Updated objects keep their order, new keys come last
+		{"UPDATE SET a.c = 4, k = true", `{"z":1,"a":{"y":2,"b":3,"c":4},"m":[{"q":1,"c":2}],"k":true}`},
+	}
+	for _, tt := range tests {
+		var rootNode plan.Node
+		if strings.HasPrefix(tt.sql, "UPDATE") {
+			u, err := query.ParseUpdate(tt.sql)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", tt.sql, err)
+			}
+			if rootNode, err = planner.CreateUpdatePlan(u, table); err != nil {
+				t.Fatalf("Failed to plan %q: %v", tt.sql, err)
+			}
+		} else {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", tt.sql, err)
+			}
+			if rootNode, err = planner.CreatePlan(q, table); err != nil {
+				t.Fatalf("Failed to plan %q: %v", tt.sql, err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), rootNode, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.sql, err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.sql, tt.expected, got)
+		}
+	}
+}
+
+func TestScalarRows(t *testing.T) {
+	table := database.NewJSONTable(`[3, 1, "x", 2, 3]`)
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT _value WHERE _value > 1", "{\"_value\":3}\n{\"_value\":2}\n{\"_value\":3}"},
+		{"SELECT COUNT(*), SUM(_value), MAX(_value)", `{"COUNT_*":5,"SUM__value":9,"MAX__value":"x"}`},
+		{"SELECT _value, COUNT(*) AS n WHERE _value >= 2 GROUP BY _value", "{\"_value\":2,\"n\":1}\n{\"_value\":3,\"n\":2}"},
+		{"SELECT _value ORDER BY _value LIMIT 2", "{\"_value\":1}\n{\"_value\":2}"},
+	}
+	for _, tt := range tests {
+		q, err := query.ParseQuery(tt.sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.sql, err)
+		}
+		rootNode, err := planner.CreatePlan(q, table)
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", tt.sql, err)
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), rootNode, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.sql, err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.sql, tt.expected, got)
+		}
+	}
+}
+
+func TestSQLiteTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER, name TEXT, score REAL, note TEXT)`,
+		`INSERT INTO users VALUES (1, 'ann', 9.5, NULL), (2, 'bob', 7, 'new'), (3, 'cy', 8.25, NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	source, table, ok := database.ParseSQLiteSource(path + ":users")
+	if !ok || source != path || table != "users" {
+		t.Fatalf("ParseSQLiteSource: got %q, %q, %v", source, table, ok)
+	}
+	if _, _, ok := database.ParseSQLiteSource("data.json"); ok {
+		t.Error("data.json is not a SQLite database")
+	}
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT id, name, score, note WHERE id = 1", `{"id":1,"name":"ann","score":9.5,"note":null}`},
+		{"SELECT name WHERE score > 8 ORDER BY score DESC", "{\"name\":\"ann\"}\n{\"name\":\"cy\"}"},
+		{"SELECT COUNT(*), SUM(id) WHERE note IS NULL", `{"COUNT_*":2,"SUM_id":4}`},
+	}
+	users := database.NewSQLiteTable(path, "users")
+	for _, tt := range tests {
+		q, err := query.ParseQuery(tt.sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.sql, err)
+		}
+		rootNode, err := planner.CreatePlan(q, users)
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", tt.sql, err)
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), rootNode, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.sql, err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.sql, tt.expected, got)
+		}
+	}
+
+	if _, err := database.NewSQLiteTable(path, "orders").Iterate(context.Background()); err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("expected a missing table error, got %v", err)
+	}
+	if _, err := database.NewSQLiteTable(path, "").Iterate(context.Background()); err == nil || !strings.Contains(err.Error(), "tables: users") {
+		t.Errorf("expected the tables to be listed, got %v", err)
+	}
+}
+
+func TestXLSXTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sales.xlsx")
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Notes" sheetId="1" r:id="rId1"/><sheet name="Q1" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":     `<sst><si><t>region</t></si><si><t>amount</t></si><si><r><t>No</t></r><r><t>rth</t></r></si><si><t>South</t></si></sst>`,
+		"xl/styles.xml":            `<styleSheet><numFmts><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/></numFmts><cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>note</t></is></c></row></sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="inlineStr"><is><t>day</t></is></c><c r="E1" t="inlineStr"><is><t>ok</t></is></c></row>` +
+			`<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2"><v>120.5</v></c><c r="C2" s="1"><v>45352</v></c><c r="E2" t="b"><v>1</v></c></row>` +
+			`<row r="3"><c r="A3" t="s"><v>3</v></c><c r="B3"><v>80</v></c><c r="C3" s="2"><v>45352.5</v></c><c r="D3" t="str"><v>x</v></c></row>` +
+			`<row r="4"/>` +
+			`<row r="5"><c r="A5" t="s"><v>2</v></c><c r="B5"><v>40</v></c></row>` +
+			`</sheetData></worksheet>`,
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range parts {
+		part, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	source, sheet, ok := database.ParseXLSXSource(path + ":Q1")
+	if !ok || source != path || sheet != "Q1" {
+		t.Fatalf("ParseXLSXSource: got %q, %q, %v", source, sheet, ok)
+	}
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT region, amount, day, D, ok WHERE amount > 100", `{"region":"North","amount":120.5,"day":"2024-03-01","D":null,"ok":true}`},
+		{"SELECT day, D WHERE region = 'South'", `{"day":"2024-03-01T12:00:00","D":"x"}`},
+		{"SELECT region, SUM(amount) AS total GROUP BY region ORDER BY region", "{\"region\":\"North\",\"total\":160.5}\n{\"region\":\"South\",\"total\":80}"},
+	}
+	q1 := database.NewXLSXTable(path, "Q1")
+	for _, tt := range tests {
+		q, err := query.ParseQuery(tt.sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.sql, err)
+		}
+		rootNode, err := planner.CreatePlan(q, q1)
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", tt.sql, err)
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), rootNode, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.sql, err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.sql, tt.expected, got)
+		}
+	}
+
+	// The first sheet by default
+	it, err := database.NewXLSXTable(path, "").Iterate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() {
+		t.Errorf("expected the Notes sheet to hold no records, got %v", it.Row().Primitive())
+	}
+	it.Close()
+	if _, err := database.NewXLSXTable(path, "Q2").Iterate(context.Background()); err == nil || !strings.Contains(err.Error(), "sheets: Notes, Q1") {
+		t.Errorf("expected the sheets to be listed, got %v", err)
+	}
+}
+
+func TestTableOutput(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"id": 1, "name": "Ann", "ts": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "meta": map[string]interface{}{"k": "<v>"}},
+		{"id": 250.5, "name": "a name far too long for the column", "ts": nil, "meta": "multi\nline"},
+	})
+
+	q, err := query.ParseQuery("SELECT id, name, ts, meta")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	executor := engine.NewExecutor()
+	executor.Format = engine.FormatTable
+	executor.TableWidth = 20
+	var buf bytes.Buffer
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	expected := `+-------+----------------------+----------------------+-------------+
+| id    | name                 | ts                   | meta        |
++-------+----------------------+----------------------+-------------+
+|     1 | Ann                  | 2024-01-02T03:04:05Z | {"k":"<v>"} |
+| 250.5 | a name far too lo... | null                 | multi\nline |
++-------+----------------------+----------------------+-------------+
+(2 rows)
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+
+	executor.SchemaHeader = true
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err == nil {
+		t.Error("expected the schema header to be refused with table output")
+	}
+}
+
+func TestSchemaHeader(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"id": float64(1), "price": float64(2), "ts": ts, "v": float64(1), "big": 1e300},
+		{"id": float64(2), "price": 2.5, "v": "one", "meta": map[string]interface{}{"k": "x"}},
+		{"id": float64(3), "price": nil, "ts": nil},
+	})
+	q, err := query.ParseQuery("SELECT id, price, ts, v, big, meta, missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := engine.NewExecutor()
+	executor.SchemaHeader = true
+	executor.TempDir = t.TempDir()
+	var buf bytes.Buffer
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	// Typed over every row: whole floats are ints, ints and floats make a
+	// float, mixed types and nulls only are left out
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := `#jsl-schema {"big":"float","id":"int","meta":"object","price":"float","ts":"timestamp"}`
+	if len(lines) != 4 || lines[0] != expected {
+		t.Fatalf("Expected %s and 3 rows, got\n%s", expected, buf.String())
+	}
+	if entries, err := os.ReadDir(executor.TempDir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected the spool file to be removed, got %v, %v", entries, err)
+	}
+
+	// The header reads back, keeping the ids integers
+	p := parser.NewReaderParser("header", strings.NewReader(buf.String()))
+	record, err := p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := record["id"].(int64); !ok || id != 1 {
+		t.Errorf("Expected id read back as int64 1, got %T %v", record["id"], record["id"])
+	}
+}
+
+func TestTemplateOutput(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"name": "Laptop", "price": 999.5, "supplier": map[string]interface{}{"country": "USA"}, "tags": []interface{}{"a", "b"}},
+		{"name": "Mouse", "price": 20, "supplier": map[string]interface{}{"country": "IT"}, "tags": []interface{}{}},
+	})
+
+	tests := []struct {
+		sql      string
+		template string
+		expected string
+	}{
+		{"SELECT name, price", "{{.name}}: {{.price}}", "Laptop: 999.5\nMouse: 20\n"},
+		{"SELECT name, supplier", "{{.name}} ({{.supplier.country}})\n", "Laptop (USA)\nMouse (IT)\n"},
+		{"SELECT name, supplier.country", `{{.name}}/{{index . "supplier.country"}}`, "Laptop/USA\nMouse/IT\n"},
+		{"SELECT name, supplier WHERE price > 100", "{{.name}} {{json .supplier}}", "Laptop {\"country\":\"USA\"}\n"},
+	}
+	for _, tt := range tests {
+		q, err := query.ParseQuery(tt.sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.sql, err)
+		}
+		rootNode, err := planner.CreatePlan(q, table)
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", tt.sql, err)
+		}
+		executor := engine.NewExecutor()
+		if executor.Template, err = engine.ParseTemplate(tt.template); err != nil {
+			t.Fatalf("Failed to parse template %q: %v", tt.template, err)
+		}
+		var buf bytes.Buffer
+		if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.sql, err)
+		}
+		if got := buf.String(); got != tt.expected {
+			t.Errorf("%s with %q: expected %q, got %q", tt.sql, tt.template, tt.expected, got)
+		}
+	}
+}
+
+func TestCompressedOutput(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{{"id": 1}, {"id": 2}})
+	q, err := query.ParseQuery("SELECT id")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		codec      string
+		decompress func(io.Reader) (io.Reader, error)
+		expected   string
+	}{
+		{"out.jsonl.gz", "", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }, "{\"id\":1}\n{\"id\":2}\n"},
+		{"out.json.zst", "", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }, "[\n{\"id\":1},\n{\"id\":2}\n]\n"},
+		{"out.jsonl", database.CompressGzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }, "{\"id\":1}\n{\"id\":2}\n"},
+	}
+	for _, tt := range tests {
+		filename := filepath.Join(dir, tt.name)
+		codec := tt.codec
+		if codec == "" {
+			codec = database.CompressionOf(filename)
+		}
+		sink, err := database.NewCompressedFileSink(filename, codec)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := engine.NewExecutor().ExecuteInto(context.Background(), rootNode, sink); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := tt.decompress(f)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(data) != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, data)
+		}
+	}
+
+	if _, err := database.NewCompressedFileSink(filepath.Join(dir, "out.jsonl"), "lz4"); err == nil {
+		t.Error("expected an unknown codec to be refused")
+	}
+}
+
+func TestESBulkOutput(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{
+		{"level": "error", "msg": "disk full", "meta": map[string]interface{}{"host": "a"}},
+		{"level": "info", "msg": "ok"},
+	})
+	q, err := query.ParseQuery("SELECT msg, meta WHERE level = 'error'")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	rootNode, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+
+	executor := engine.NewExecutor()
+	executor.Format = engine.FormatESBulk
+	executor.BulkIndex = "logs"
+	executor.Pretty = true
+	var buf bytes.Buffer
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	expected := "{\"index\":{\"_index\":\"logs\"}}\n{\"msg\":\"disk full\",\"meta\":{\"host\":\"a\"}}\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	executor.BulkIndex = ""
+	buf.Reset()
+	if _, err := executor.Execute(context.Background(), rootNode, &buf); err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	if got := strings.SplitN(buf.String(), "\n", 2)[0]; got != `{"index":{}}` {
+		t.Errorf("expected an action without index, got %s", got)
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&content, "{\"id\":%d,\"kind\":\"k%d\",\"tags\":[\"t%d\"]}\n", i, i%7, i%3)
+	}
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	if err := os.WriteFile(file, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(node plan.Node) string {
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), node, &buf); err != nil {
+			t.Fatalf("Failed to execute: %v", err)
+		}
+		return buf.String()
+	}
+	for _, sql := range []string{
+		"SELECT id, kind WHERE tags = 't1'",
+		"SELECT kind, COUNT(*) AS n, SUM(id) AS total GROUP BY kind",
+		"SELECT id WHERE id > 1500 ORDER BY id DESC LIMIT 5",
+		"SELECT id, kind WHERE kind = 'k3' LIMIT 3",
+		// Aggregated by the workers, then merged
+		"SELECT kind, MIN(id) AS lo, MAX(id) AS hi, AVG(id) AS a, FIRST(tags) AS f, COUNT(tags) AS t GROUP BY kind ORDER BY hi DESC",
+		"SELECT tags, COUNT(*) AS n WHERE id > 100 GROUP BY tags",
+		"SELECT COUNT(*) AS n, SUM(id) AS total WHERE id < 0",
+	} {
+		parse := func() *query.SelectQuery {
+			q, err := query.ParseQuery(sql)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", sql, err)
+			}
+			return q
+		}
+		sequential, err := planner.CreatePlan(parse(), database.NewJSONTable(file))
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", sql, err)
+		}
+		q := parse()
+		q.Workers = 4
+		parallel, err := planner.CreatePlan(q, database.NewJSONTable(file))
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", sql, err)
+		}
+		// Small parts split the file among the workers
+		var scan *plan.ParallelScanNode
+		for node := parallel; node != nil && scan == nil; {
+			if s, ok := node.(*plan.ParallelScanNode); ok {
+				scan = s
+			} else if children := node.Children(); len(children) > 0 {
+				node = children[0]
+			} else {
+				node = nil
+			}
+		}
+		if scan == nil {
+			t.Fatalf("%s: expected a parallel scan, got\n%s", sql, plan.FormatPlan(parallel))
+		}
+		scan.PartSize = 2048
+
+		if want, got := run(sequential), run(parallel); got != want {
+			t.Errorf("%s: parallel scan returned\n%s\nwant\n%s", sql, got, want)
+		}
+	}
+
+	// _line needs the line numbers of a sequential scan
+	q, err := query.ParseQuery("SELECT id, _line WHERE id = 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Workers = 4
+	node, err := planner.CreatePlan(q, database.NewJSONTable(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plan.FormatPlan(node), "ParallelScan") {
+		t.Errorf("expected a sequential scan for _line, got\n%s", plan.FormatPlan(node))
+	}
+}
+
+func TestSpill(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 500; i++ {
+		// Every 5th record lacks kind, grouping under null
+		kind := fmt.Sprintf(",\"kind\":\"k%d\"", i%11)
+		if i%5 == 0 {
+			kind = ""
+		}
+		fmt.Fprintf(&content, "{\"id\":%d%s,\"v\":%g,\"name\":\"n%d\",\"tags\":[],\"meta\":{}}\n", i, kind, float64(i%13)/2, i%17)
+	}
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	if err := os.WriteFile(file, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := t.TempDir()
+	run := func(sql string, memoryLimit int64) string {
+		q, err := query.ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", sql, err)
+		}
+		q.MemoryLimit, q.TempDir = memoryLimit, tempDir
+		node, err := planner.CreatePlan(q, database.NewJSONTable(file))
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", sql, err)
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), node, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", sql, err)
+		}
+		return buf.String()
+	}
+	for _, sql := range []string{
+		// Ties keep the input order
+		"SELECT id, kind, _line ORDER BY kind",
+		"SELECT id, v, tags, meta ORDER BY v DESC, name LIMIT 20",
+		"SELECT kind, COUNT(*) AS n, SUM(id) AS s, AVG(v) AS a, MIN(name) AS lo, MAX(id) AS hi, FIRST(name) AS f GROUP BY kind",
+		"SELECT name, COUNT(*) AS n GROUP BY name ORDER BY n DESC, name",
+		"SELECT COUNT(*) AS n, SUM(v) AS s",
+	} {
+		want := run(sql, 0)
+		// A limit of a byte spills every row
+		if got := run(sql, 1); got != want {
+			t.Errorf("%s: spilled query returned\n%s\nwant\n%s", sql, got, want)
+		}
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("spill files left in the temporary directory: %d", len(entries))
+	}
+}
+
+func TestMemoryTable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n")
+	table := database.NewMemoryTable(database.NewJSONTable(file))
+	run := func(sql string) string {
+		q, err := query.ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", sql, err)
+		}
+		node, err := planner.CreatePlan(q, table)
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", sql, err)
+		}
+		var buf bytes.Buffer
+		if _, err := engine.NewExecutor().Execute(context.Background(), node, &buf); err != nil {
+			t.Fatalf("Failed to execute %q: %v", sql, err)
+		}
+		return buf.String()
+	}
+
+	// A scan stopping early does not cache the rows
+	if got, want := run("SELECT id LIMIT 1"), "{\"id\":1}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	write("{\"id\":1}\n{\"id\":2}\n")
+	if got, want := run("SELECT COUNT(*) AS n"), "{\"n\":2}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Cached rows are read instead of the file, positioned or not
+	write("{\"id\":9}\n")
+	if got, want := run("SELECT id, _line WHERE id > 1"), "{\"id\":2,\"_line\":2}\n"; got != want {
+		t.Errorf("cached: got %q, want %q", got, want)
+	}
+	if got, want := run("SELECT COUNT(*) AS n"), "{\"n\":2}\n"; got != want {
+		t.Errorf("cached: got %q, want %q", got, want)
+	}
+
+	table.Reload()
+	if got, want := run("SELECT id, _line"), "{\"id\":9,\"_line\":1}\n"; got != want {
+		t.Errorf("reloaded: got %q, want %q", got, want)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var rows []map[string]interface{}
+	for i := 1; i <= 5; i++ {
+		rows = append(rows, map[string]interface{}{"id": i})
+	}
+	q, err := query.ParseQuery("SELECT id WHERE id > 2 LIMIT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := planner.CreatePlan(q, database.NewSliceTable(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node = plan.Analyze(node)
+	var buf bytes.Buffer
+	if _, err := engine.NewExecutor().Execute(context.Background(), node, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "{\"id\":3}\n{\"id\":4}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The limit stops the scan after the 4th row
+	times := regexp.MustCompile(`(time|self): [^,\]]+`)
+	got := times.ReplaceAllString(plan.FormatPlan(node), "$1: T")
+	want := `└─ Limit(count: 2)  [rows: 2, in: 2, time: T, self: T]
+   └─ Project(id)  [rows: 2, in: 2, time: T, self: T]
+      └─ Filter(expression: id > 2)  [rows: 2, in: 4, time: T, self: T]
+         └─ Scan(table: default)  [rows: 4, time: T]
+`
+	if got != want {
+		t.Errorf("analyzed plan:\n%s\nwant\n%s", got, want)
+	}
+	if stats, ok := plan.Stats(node); !ok || stats.Rows != 2 || stats.Time <= 0 {
+		t.Errorf("root stats = %+v, %v", stats, ok)
+	}
+}
+
+// cancelWriter cancels a query when its first row is written
+type cancelWriter struct {
+	bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.Buffer.Write(p)
+}
+
+func TestCancel(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&content, "{\"id\":%d,\"kind\":\"k%d\"}\n", i, i%7)
+	}
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	if err := os.WriteFile(file, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(sql string, workers int) plan.Node {
+		q, err := query.ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", sql, err)
+		}
+		q.Workers = workers
+		node, err := planner.CreatePlan(q, database.NewJSONTable(file))
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", sql, err)
+		}
+		return node
+	}
+
+	// A cancelled query stops before returning any row
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, sql := range []string{
+		"SELECT id",
+		"SELECT id ORDER BY id DESC",
+		"SELECT kind, COUNT(*) AS n GROUP BY kind",
+	} {
+		for _, workers := range []int{1, 4} {
+			var buf bytes.Buffer
+			_, err := engine.NewExecutor().Execute(ctx, build(sql, workers), &buf)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s (%d workers): expected context.Canceled, got %v", sql, workers, err)
+			}
+			if buf.Len() > 0 {
+				t.Errorf("%s (%d workers): expected no output, got %s", sql, workers, buf.String())
+			}
+		}
+	}
+
+	// Rows stop as soon as the query is cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	out := &cancelWriter{cancel: cancel}
+	executor := engine.NewExecutor()
+	executor.BufferSize = 0
+	if _, err := executor.Execute(ctx, build("SELECT id", 1), out); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if got := out.String(); got != "{\"id\":0}\n" {
+		t.Errorf("Expected the first row only, got %q", got)
+	}
+}
+
+func TestExecStats(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&content, "{\"id\":%d}\n", i)
+	}
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	if err := os.WriteFile(file, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4} {
+		q, err := query.ParseQuery("SELECT id WHERE id >= 1500")
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Workers = workers
+		node, err := planner.CreatePlan(q, database.NewJSONTable(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		executor := engine.NewExecutor()
+		executor.Analyze = true
+		var buf bytes.Buffer
+		// The statistics of the caller's context get the counts too
+		var outer diag.Stats
+		stats, err := executor.Execute(diag.WithStats(context.Background(), &outer), node, &buf)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if outer.Read.Load() != 2000 || outer.Emitted.Load() != 500 {
+			t.Errorf("%d workers: expected the context statistics to count 2000 read, 500 emitted, got %d, %d", workers, outer.Read.Load(), outer.Emitted.Load())
+		}
+		if stats.Scanned != 2000 || stats.Filtered != 1500 || stats.Emitted != 500 || stats.Bytes != int64(content.Len()) {
+			t.Errorf("%d workers: expected 2000 scanned, 1500 filtered, 500 emitted, %d bytes, got %+v", workers, content.Len(), stats)
+		}
+		if len(stats.Nodes) == 0 || stats.Nodes[0].Depth != 0 || stats.Nodes[0].Rows != 500 {
+			t.Errorf("%d workers: expected the root node to produce 500 rows, got %+v", workers, stats.Nodes)
+		}
+		if !strings.Contains(plan.FormatPlan(stats.Plan), "rows: 500") {
+			t.Errorf("%d workers: expected an annotated plan, got\n%s", workers, plan.FormatPlan(stats.Plan))
+		}
+	}
+}
+
+// recordingHooks records the calls of plan.Hooks
+type recordingHooks struct {
+	scans    []string
+	rows     map[string]int
+	finished []string
+}
+
+func (h *recordingHooks) OnScanStart(ctx context.Context, n plan.Node) {
+	h.scans = append(h.scans, n.Explain())
+}
+
+func (h *recordingHooks) OnRow(ctx context.Context, n plan.Node, row database.Row) {
+	h.rows[n.Explain()]++
+}
+
+func (h *recordingHooks) OnNodeFinish(ctx context.Context, n plan.Node, stats plan.NodeStats, err error) {
+	h.finished = append(h.finished, fmt.Sprintf("%s: %d %v", n.Explain(), stats.Rows, err))
+}
+
+func TestHooks(t *testing.T) {
+	table := database.NewSliceTable([]map[string]interface{}{{"a": 1}, {"a": 2}, {"a": 3}})
+	q, err := query.ParseQuery("SELECT a WHERE a > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooks := &recordingHooks{rows: map[string]int{}}
+	executor := engine.NewExecutor()
+	executor.Hooks = hooks
+	executor.Analyze = true
+	var buf bytes.Buffer
+	stats, err := executor.Execute(context.Background(), node, &buf)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if fmt.Sprint(hooks.scans) != "[Scan(table: default)]" {
+		t.Errorf("Expected one scan started, got %v", hooks.scans)
+	}
+	if hooks.rows["Scan(table: default)"] != 3 || hooks.rows["Project(a)"] != 2 {
+		t.Errorf("Expected 3 rows scanned and 2 projected, got %v", hooks.rows)
+	}
+	expected := "[Scan(table: default): 3 <nil> Filter(expression: a > 1): 2 <nil> Project(a): 2 <nil>]"
+	if got := fmt.Sprint(hooks.finished); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	// Analyzed nodes are reported without the hooks
+	if len(stats.Nodes) != 3 || stats.Nodes[0].Node.Explain() != "Project(a)" {
+		t.Errorf("Expected 3 analyzed nodes, got %+v", stats.Nodes)
+	}
+	if _, ok := stats.Nodes[0].Node.(*plan.ProjectNode); !ok {
+		t.Errorf("Expected a ProjectNode, got %T", stats.Nodes[0].Node)
+	}
+
+	// LogHooks log at debug level
+	if node, err = planner.CreatePlan(q, table); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	executor = engine.NewExecutor()
+	executor.Hooks = plan.LogHooks(logger)
+	if _, err := executor.Execute(context.Background(), node, io.Discard); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if got := logs.String(); !strings.Contains(got, "scan started") || strings.Count(got, "node finished") != 3 {
+		t.Errorf("Expected a scan and 3 nodes logged, got\n%s", got)
+	}
+}
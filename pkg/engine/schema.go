@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/plan"
+)
+
+// executeSchemaHeader writes the rows as JSON lines after a "#jsl-schema"
+// line typing their fields. The header depends on every row, which are
+// spooled to a temporary file until the last one is read.
+func (e *Executor) executeSchemaHeader(ctx context.Context, rootNode plan.Node, w io.Writer) error {
+	spool, err := os.CreateTemp(e.TempDir, "jsl-schema-*")
+	if err != nil {
+		return fmt.Errorf("failed to create schema spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	out := bufio.NewWriterSize(spool, 256*1024)
+	schema := newSchemaInference()
+	if err := e.executeSink(ctx, rootNode, e.sink(out), schema); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	header, err := schema.Schema().Header()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, spool)
+	return err
+}
+
+// maxExactInt bounds the whole float64 values typed as int: beyond it,
+// float64 no longer represents every integer
+const maxExactInt = 1 << 53
+
+// schemaInference types the top-level fields of result rows, over all of
+// them. A field holding both ints and floats is a float; one holding values
+// of other different types is left undeclared, as are fields only ever null.
+type schemaInference struct {
+	types map[string]string
+	mixed map[string]bool
+}
+
+func newSchemaInference() *schemaInference {
+	return &schemaInference{types: map[string]string{}, mixed: map[string]bool{}}
+}
+
+// add types the fields of a row
+func (s *schemaInference) add(row interface{}) {
+	switch v := row.(type) {
+	case database.OrderedMap:
+		for _, kv := range v {
+			s.addValue(kv.Key, kv.Val)
+		}
+	case parser.Record:
+		s.add(map[string]interface{}(v))
+	case map[string]interface{}:
+		for k, val := range v {
+			s.addValue(k, val)
+		}
+	}
+}
+
+func (s *schemaInference) addValue(field string, val interface{}) {
+	typ := valueType(val)
+	if typ == "" || s.mixed[field] {
+		return
+	}
+	switch prev, ok := s.types[field]; {
+	case !ok || prev == typ:
+		s.types[field] = typ
+	case isNumberType(prev) && isNumberType(typ):
+		s.types[field] = parser.TypeFloat
+	default:
+		delete(s.types, field)
+		s.mixed[field] = true
+	}
+}
+
+// Schema returns the types of the fields added so far
+func (s *schemaInference) Schema() parser.Schema {
+	return parser.Schema(s.types)
+}
+
+// valueType is parser.TypeOf, except that whole numbers decoded as float64
+// are ints
+func valueType(val interface{}) string {
+	if f, ok := val.(float64); ok && f == math.Trunc(f) && math.Abs(f) <= maxExactInt {
+		return parser.TypeInt
+	}
+	return parser.TypeOf(val)
+}
+
+func isNumberType(typ string) bool {
+	return typ == parser.TypeInt || typ == parser.TypeFloat
+}
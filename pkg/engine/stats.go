@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/plan"
+)
+
+// ExecStats are the statistics of a query execution, counted as the
+// process-wide diag.Counters reported by --summary
+type ExecStats struct {
+	// Scanned counts the records read from the input files (prefiltered
+	// ones included); tables read without the parser (SQLite, Excel, Go
+	// values) are not counted
+	Scanned int64
+	// Filtered counts the rows dropped by WHERE
+	Filtered int64
+	// Emitted counts the rows written
+	Emitted int64
+	// Bytes counts the input bytes read
+	Bytes int64
+	// Duration is the time spent executing the plan and writing the rows
+	Duration time.Duration
+	// Plan is the executed plan, which FormatPlan prints annotated with the
+	// statistics of every node under Executor.Analyze
+	Plan plan.Node
+	// Nodes are the statistics of the plan nodes in FormatPlan order, under
+	// Executor.Analyze
+	Nodes []NodeStats
+}
+
+// NodeStats are the statistics of a plan node
+type NodeStats struct {
+	Node  plan.Node
+	Depth int // depth of the node in the plan, 0 for the root
+	plan.NodeStats
+}
+
+// execution counts the statistics of a run of the executor
+type execution struct {
+	counters diag.Stats
+	outer    *diag.Stats // the statistics of the caller's context, or nil
+	start    time.Time
+	root     plan.Node
+	analyzed bool
+}
+
+// begin starts an execution of rootNode, returning the context and plan
+// counting its statistics (analyzed under Analyze, observed by Hooks). The
+// statistics a ctx carries (diag.WithStats) get the counts of the execution
+// once it ends.
+func (e *Executor) begin(ctx context.Context, rootNode plan.Node) (context.Context, *execution) {
+	run := &execution{start: time.Now(), root: rootNode, analyzed: e.Analyze, outer: diag.RunStats(ctx)}
+	if e.Hooks != nil {
+		run.root = plan.Hook(run.root, e.Hooks)
+	}
+	if e.Analyze {
+		run.root = plan.Analyze(run.root)
+	}
+	return diag.WithStats(ctx, &run.counters), run
+}
+
+// end ends the execution, returning its statistics
+func (run *execution) end() ExecStats {
+	if run.outer != nil {
+		run.outer.Add(&run.counters)
+	}
+	return run.stats()
+}
+
+// stats returns the statistics of the execution so far
+func (run *execution) stats() ExecStats {
+	stats := ExecStats{
+		Scanned:  run.counters.Read.Load(),
+		Filtered: run.counters.Filtered.Load(),
+		Emitted:  run.counters.Emitted.Load(),
+		Bytes:    run.counters.Bytes.Load(),
+		Duration: time.Since(run.start),
+		Plan:     run.root,
+	}
+	if run.analyzed {
+		plan.WalkStats(run.root, func(n plan.Node, depth int, s plan.NodeStats) {
+			stats.Nodes = append(stats.Nodes, NodeStats{Node: n, Depth: depth, NodeStats: s})
+		})
+	}
+	return stats
+}
+
+// countEmitted counts a row written in the process-wide statistics and in
+// those of the execution of ctx
+func countEmitted(ctx context.Context) {
+	diag.Counters().Emitted.Add(1)
+	if s := diag.RunStats(ctx); s != nil {
+		s.Emitted.Add(1)
+	}
+}
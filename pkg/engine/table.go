@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/plan"
+)
+
+// DefaultTableWidth is the TableWidth of NewExecutor
+const DefaultTableWidth = 40
+
+// tableCell is a rendered value of a table row
+type tableCell struct {
+	text   string
+	number bool
+}
+
+// executeTable renders the rows as an aligned text table. Columns are sized
+// to their widest value, so the rows are held until the end of the query.
+func (e *Executor) executeTable(ctx context.Context, rootNode plan.Node, w io.Writer) error {
+	iterator, err := e.iterate(ctx, rootNode)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	var columns []string
+	index := map[string]int{}
+	var rows []map[int]tableCell
+	for iterator.Next() {
+		row := map[int]tableCell{}
+		for _, kv := range tableFields(e.output(iterator.Row())) {
+			col, ok := index[kv.Key]
+			if !ok {
+				col = len(columns)
+				index[kv.Key] = col
+				columns = append(columns, kv.Key)
+			}
+			cell, err := e.renderCell(kv.Val)
+			if err != nil {
+				return err
+			}
+			row[col] = cell
+		}
+		rows = append(rows, row)
+		countEmitted(ctx)
+	}
+	if err := iterator.Error(); err != nil {
+		return err
+	}
+
+	widths := make([]int, len(columns))
+	for col, name := range columns {
+		widths[col] = utf8.RuneCountInString(e.truncate(name))
+	}
+	for _, row := range rows {
+		for col, cell := range row {
+			widths[col] = max(widths[col], utf8.RuneCountInString(e.truncate(cell.text)))
+		}
+	}
+
+	var b strings.Builder
+	if len(columns) > 0 {
+		border := tableBorder(widths)
+		b.WriteString(border)
+		header := make(map[int]tableCell, len(columns))
+		for col, name := range columns {
+			header[col] = tableCell{text: name}
+		}
+		e.tableLine(&b, header, widths)
+		b.WriteString(border)
+		for _, row := range rows {
+			e.tableLine(&b, row, widths)
+			if b.Len() >= DefaultBufferSize {
+				if _, err := io.WriteString(w, b.String()); err != nil {
+					return err
+				}
+				b.Reset()
+			}
+		}
+		b.WriteString(border)
+	}
+	if len(rows) == 1 {
+		b.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n", len(rows))
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// tableFields returns the columns of a result row: the keys of an object, in
+// order, or the row itself as the database.ValueColumn
+func tableFields(value interface{}) []database.KeyVal {
+	var m map[string]interface{}
+	switch v := value.(type) {
+	case database.OrderedMap:
+		return v
+	case parser.Record:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return []database.KeyVal{{Key: database.ValueColumn, Val: v}}
+	}
+	fields := make([]database.KeyVal, 0, len(m))
+	for k, v := range m {
+		fields = append(fields, database.KeyVal{Key: k, Val: v})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
+
+// renderCell renders a value: strings as they are, other values as compact JSON
+func (e *Executor) renderCell(value interface{}) (tableCell, error) {
+	if s, ok := value.(string); ok {
+		return tableCell{text: escapeControl(s)}, nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return tableCell{}, err
+	}
+	text := strings.TrimSuffix(buf.String(), "\n")
+	// Values encoded as JSON strings (timestamps) are shown unquoted
+	var s string
+	if strings.HasPrefix(text, `"`) && json.Unmarshal([]byte(text), &s) == nil {
+		return tableCell{text: escapeControl(s)}, nil
+	}
+	return tableCell{text: text, number: isNumber(value)}, nil
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case float64, float32, int, int64, int32, json.Number:
+		return true
+	}
+	return false
+}
+
+// escapeControl keeps a cell on one line
+func escapeControl(s string) string {
+	if !strings.ContainsAny(s, "\n\r\t") {
+		return s
+	}
+	return strings.NewReplacer("\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s)
+}
+
+// truncate shortens a cell wider than TableWidth, ending it with "..."
+func (e *Executor) truncate(s string) string {
+	if e.TableWidth <= 0 || utf8.RuneCountInString(s) <= e.TableWidth {
+		return s
+	}
+	runes := []rune(s)
+	if e.TableWidth <= 3 {
+		return string(runes[:e.TableWidth])
+	}
+	return string(runes[:e.TableWidth-3]) + "..."
+}
+
+// tableBorder returns the separator line of columns of the given widths
+func tableBorder(widths []int) string {
+	var b strings.Builder
+	for _, width := range widths {
+		b.WriteString("+")
+		b.WriteString(strings.Repeat("-", width+2))
+	}
+	b.WriteString("+\n")
+	return b.String()
+}
+
+// tableLine writes a row, numbers aligned right and other values left
+func (e *Executor) tableLine(b *strings.Builder, row map[int]tableCell, widths []int) {
+	for col, width := range widths {
+		cell := row[col]
+		text := e.truncate(cell.text)
+		pad := strings.Repeat(" ", width-utf8.RuneCountInString(text))
+		b.WriteString("| ")
+		if cell.number {
+			b.WriteString(pad + text)
+		} else {
+			b.WriteString(text + pad)
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString("|\n")
+}
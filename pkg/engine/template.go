@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"text/template"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/plan"
+)
+
+// templateFuncs are the functions available to row templates besides the
+// text/template builtins
+var templateFuncs = template.FuncMap{
+	// json renders a value as compact JSON ({{json .tags}})
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ParseTemplate parses a row template for Executor.Template. Fields are
+// addressed as {{.name}} or {{.meta.size}}, and dotted keys of projections as
+// {{index . "supplier.country"}}; {{json .field}} renders a value as JSON.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("row").Funcs(templateFuncs).Parse(text)
+}
+
+// executeTemplate renders every row through the template, ending each with
+// a newline unless the template output already does
+func (e *Executor) executeTemplate(ctx context.Context, rootNode plan.Node, w io.Writer) error {
+	iterator, err := e.iterate(ctx, rootNode)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	var buf bytes.Buffer
+	for iterator.Next() {
+		buf.Reset()
+		if err := e.Template.Execute(&buf, templateValue(e.output(iterator.Row()))); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte{'\n'}) {
+			buf.WriteByte('\n')
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		countEmitted(ctx)
+	}
+	return iterator.Error()
+}
+
+// templateValue converts the objects of a row to maps, which templates
+// address by key
+func templateValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case database.OrderedMap:
+		m := make(map[string]interface{}, len(val))
+		for _, kv := range val {
+			m[kv.Key] = templateValue(kv.Val)
+		}
+		return m
+	case parser.Record:
+		return templateValue(map[string]interface{}(val))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = templateValue(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = templateValue(item)
+		}
+		return items
+	}
+	return v
+}
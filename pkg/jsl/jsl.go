@@ -0,0 +1,206 @@
+// Package jsl embeds the jsl query engine in Go programs: it opens an input
+// as the jsl command line does and runs SQL queries or path extractions on
+// it, returning the rows as they are produced.
+//
+//	db, err := jsl.Open("events.jsonl")
+//	if err != nil {
+//		return err
+//	}
+//	rows, err := db.Query("SELECT user, COUNT(*) AS n GROUP BY user")
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		fmt.Println(rows.Row().Primitive())
+//	}
+//	return rows.Error()
+package jsl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/plan"
+	"github.com/bisegni/jsl/pkg/planner"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// DB is an input queried by SQL or path expressions. Its fields are the
+// engine options of the queries, set before running them. A DB is safe for
+// concurrent queries once its fields are set; Hooks are then called by
+// several goroutines at once.
+type DB struct {
+	table database.Table
+
+	// Catalog holds the tables a FROM can name besides files (may be nil)
+	Catalog *database.Catalog
+	// CaseInsensitive matches field names regardless of case
+	CaseInsensitive bool
+	// IgnoreCase compares string values in WHERE regardless of case
+	IgnoreCase bool
+	// Strict fails queries referencing a field absent from every record
+	Strict bool
+	// ArrayMatch is how WHERE conditions match arrays when they do not say:
+	// query.QuantifierAny (the default when empty), QuantifierAll or
+	// QuantifierNone
+	ArrayMatch string
+	// Input reads the files a FROM names, as Open reads the DB input
+	Input database.InputOptions
+	// Workers scans JSONL files with this many parallel workers (0 or 1
+	// scans sequentially)
+	Workers int
+	// MemoryLimit bounds the rows held by ORDER BY and GROUP BY before they
+	// spill to temporary files in TempDir (0 holds them all in memory)
+	MemoryLimit int64
+	TempDir     string
+	// Hooks, when set, observe the execution of every plan node, e.g. to
+	// record tracing spans (plan.Hooks, plan.LogHooks)
+	Hooks plan.Hooks
+}
+
+// Open returns a DB over an input: a JSON, JSONL or MessagePack file,
+// the files matched by a glob pattern ("logs/*.jsonl"), a SQLite table
+// ("app.db:users"), an Excel sheet ("sales.xlsx:Q1"), a URL once
+// database.EnableHTTP is called, the source of a provider registered with
+// database.RegisterScheme or database.RegisterExtension, inline JSON or
+// stdin ("-"). Files are read by every query, not when opened.
+func Open(source string) (*DB, error) {
+	return OpenWith(source, database.InputOptions{})
+}
+
+// OpenWith is Open reading the input, and the files a FROM names, with
+// opts: lenient JSON, skipped malformed lines, the records of an array at
+// a root path, size limits
+func OpenWith(source string, opts database.InputOptions) (*DB, error) {
+	table, err := database.OpenInput(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	db := NewDB(table)
+	db.Input = opts
+	return db, nil
+}
+
+// NewDB returns a DB over a table, e.g. a database.SliceTable of Go values
+func NewDB(table database.Table) *DB {
+	return &DB{table: table, MemoryLimit: plan.DefaultMemoryLimit}
+}
+
+// Query runs a SELECT statement, returning its result rows. A FROM naming
+// a Catalog table or another input (see Open) reads it instead of the DB
+// input; INTO is not supported, the caller writing the rows where
+// it needs.
+func (db *DB) Query(sql string) (database.RowIterator, error) {
+	return db.QueryContext(context.Background(), sql)
+}
+
+// QueryContext is Query whose rows stop with the error of ctx once ctx is
+// done
+func (db *DB) QueryContext(ctx context.Context, sql string) (database.RowIterator, error) {
+	node, err := db.Plan(sql)
+	if err != nil {
+		return nil, err
+	}
+	return node.Execute(ctx)
+}
+
+// Plan returns the execution plan of a SELECT statement, which
+// plan.FormatPlan explains and engine.Executor writes in the output
+// formats of the command line
+func (db *DB) Plan(sql string) (plan.Node, error) {
+	q, err := query.ParseQuery(sql)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %w", err)
+	}
+	if q.Into != "" {
+		return nil, fmt.Errorf("INTO is not supported by Query")
+	}
+	q.CaseInsensitive = db.CaseInsensitive
+	q.IgnoreCase = db.IgnoreCase
+	q.Strict = db.Strict
+	if db.ArrayMatch != "" {
+		if !query.IsQuantifier(db.ArrayMatch) {
+			return nil, fmt.Errorf("invalid ArrayMatch %q (use any, all or none)", db.ArrayMatch)
+		}
+		q.ArrayMatch = strings.ToUpper(db.ArrayMatch)
+	}
+	q.Workers = db.Workers
+	q.MemoryLimit, q.TempDir = db.MemoryLimit, db.TempDir
+
+	resolver := &planner.Resolver{Catalog: db.Catalog, Open: func(name string) (database.Table, error) {
+		return database.OpenFrom(name, db.Input)
+	}}
+	node, err := resolver.CreatePlan(q, db.table)
+	if err != nil {
+		return nil, fmt.Errorf("planning error: %w", err)
+	}
+	if db.Hooks != nil {
+		node = plan.Hook(node, db.Hooks)
+	}
+	return node, nil
+}
+
+// Extract returns the values a path resolves to in every record (".user.name",
+// ".items.*.price", or a pipeline ".items | .[] | .id"), one row per value.
+// Records the path does not resolve in are skipped.
+func (db *DB) Extract(path string) (database.RowIterator, error) {
+	return db.ExtractContext(context.Background(), path)
+}
+
+// ExtractContext is Extract whose values stop with the error of ctx once ctx
+// is done
+func (db *DB) ExtractContext(ctx context.Context, path string) (database.RowIterator, error) {
+	source, err := db.table.Iterate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := query.NewQuery(path)
+	q.CaseInsensitive = db.CaseInsensitive
+	q.IgnoreCase = db.IgnoreCase
+	return &extractIterator{source: source, query: q, pipeline: query.IsPipeline(path)}, nil
+}
+
+// extractIterator yields the values of a path in the rows of its source
+type extractIterator struct {
+	source   database.RowIterator
+	query    *query.Query
+	pipeline bool
+
+	pending []interface{} // the values of the current row not yet returned
+	row     database.Row
+}
+
+func (it *extractIterator) Next() bool {
+	for len(it.pending) == 0 {
+		if !it.source.Next() {
+			return false
+		}
+		record := it.source.Row().Primitive()
+		if it.pipeline {
+			// Each output of a pipeline is a value, as in jq
+			it.pending, _ = it.query.ExtractEach(record)
+			continue
+		}
+		if val, err := it.query.Extract(record); err == nil {
+			it.pending = append(it.pending, val)
+		}
+	}
+	it.row = database.NewJSONRow(it.pending[0])
+	it.pending = it.pending[1:]
+	return true
+}
+
+func (it *extractIterator) Row() database.Row {
+	return it.row
+}
+
+func (it *extractIterator) Error() error {
+	return it.source.Error()
+}
+
+func (it *extractIterator) Close() error {
+	return it.source.Close()
+}
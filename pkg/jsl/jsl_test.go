@@ -0,0 +1,342 @@
+package jsl_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/jsl"
+)
+
+// collect returns the rows of an iterator as JSON lines
+func collect(t *testing.T, rows database.RowIterator) string {
+	t.Helper()
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		data, err := json.Marshal(rows.Row().Primitive())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	if err := rows.Error(); err != nil {
+		t.Fatalf("Iteration failed: %v", err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDB(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "orders.jsonl")
+	content := `{"id":1,"user":"ann","items":[{"price":5},{"price":7}]}
+{"id":2,"user":"bob","items":[]}
+{"id":3,"User":"ann","items":[{"price":3}]}
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := jsl.Open(file)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	queries := []struct {
+		run      func() (database.RowIterator, error)
+		expected string
+	}{
+		{func() (database.RowIterator, error) { return db.Query("SELECT id WHERE user = 'ann'") }, `{"id":1}`},
+		{func() (database.RowIterator, error) { return db.Query("SELECT user, COUNT(*) AS n GROUP BY user") }, `{"user":"ann","n":1}
+{"user":"bob","n":1}
+{"user":null,"n":1}`},
+		{func() (database.RowIterator, error) { return db.Extract(".user") }, `"ann"
+"bob"`},
+		{func() (database.RowIterator, error) { return db.Extract(".items | .[] | .price") }, `5
+7
+3`},
+	}
+	for _, tt := range queries {
+		rows, err := tt.run()
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if got := collect(t, rows); got != tt.expected {
+			t.Errorf("Expected\n%s\ngot\n%s", tt.expected, got)
+		}
+	}
+
+	// Engine options apply to the next queries
+	db.CaseInsensitive = true
+	rows, err := db.Query("SELECT id WHERE user = 'ann'")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := collect(t, rows); got != "{\"id\":1}\n{\"id\":3}" {
+		t.Errorf("Expected both ann records, got\n%s", got)
+	}
+
+	// FROM reads another file, or a table of the catalog
+	other := filepath.Join(filepath.Dir(file), "users.jsonl")
+	if err := os.WriteFile(other, []byte(`{"name":"ann","age":30}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db.Catalog = database.NewCatalog()
+	db.Catalog.RegisterTable("ids", database.NewSliceTable([]map[string]interface{}{{"id": 7}}))
+	for sql, expected := range map[string]string{
+		"SELECT name FROM '" + other + "'": `{"name":"ann"}`,
+		"SELECT id FROM ids":               `{"id":7}`,
+	} {
+		rows, err := db.Query(sql)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if got := collect(t, rows); got != expected {
+			t.Errorf("%s: expected %s, got %s", sql, expected, got)
+		}
+	}
+
+	if _, err := db.Query("SELECT id INTO 'out.jsonl'"); err == nil {
+		t.Error("Expected an error for INTO")
+	}
+	if _, err := db.Query("SELEC id"); err == nil {
+		t.Error("Expected a parse error")
+	}
+}
+
+func TestNewDB(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	db := jsl.NewDB(database.NewStructTable([]user{{"ann", 30}, {"bob", 25}}))
+	rows, err := db.Query("SELECT name WHERE age > 26")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := collect(t, rows); got != `{"name":"ann"}` {
+		t.Errorf("Expected ann, got %s", got)
+	}
+}
+
+func TestConcurrentQueries(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	db := jsl.NewDB(database.NewStructTable([]user{{"ann", 30}, {"bob", 25}, {"cid", 41}}))
+	db.Catalog = database.NewCatalog()
+	db.Catalog.RegisterTable("users", database.NewSliceTable([]map[string]interface{}{{"name": "dan", "age": 52}}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Tables registered while queries run
+			db.Catalog.RegisterTable(fmt.Sprintf("t%d", i), database.NewSliceTable([]map[string]interface{}{{"i": i}}))
+			for sql, expected := range map[string]string{
+				"SELECT name WHERE age > 26 ORDER BY name": "{\"name\":\"ann\"}\n{\"name\":\"cid\"}",
+				"SELECT COUNT(*) AS n, AVG(age) AS a":      `{"n":3,"a":32}`,
+				"SELECT name FROM users":                   `{"name":"dan"}`,
+				fmt.Sprintf("SELECT i FROM t%d", i):        fmt.Sprintf(`{"i":%d}`, i),
+			} {
+				rows, err := db.Query(sql)
+				if err != nil {
+					t.Errorf("Query %q failed: %v", sql, err)
+					continue
+				}
+				if got := collect(t, rows); got != expected {
+					t.Errorf("%s: expected %s, got %s", sql, expected, got)
+				}
+			}
+			rows, err := db.Extract(".name")
+			if err != nil {
+				t.Errorf("Extract failed: %v", err)
+				return
+			}
+			if got := collect(t, rows); got != "\"ann\"\n\"bob\"\n\"cid\"" {
+				t.Errorf("Expected the three names, got %s", got)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := len(db.Catalog.List()); got != 9 {
+		t.Errorf("Expected 9 catalog tables, got %d", got)
+	}
+}
+
+func TestProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users.jsonl" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "{\"name\":\"ann\"}\n{\"name\":\"bob\"}\n")
+	}))
+	defer server.Close()
+
+	database.EnableHTTP()
+	database.RegisterScheme("mem", func(ref string) (database.Table, error) {
+		return database.NewSliceTable([]map[string]interface{}{{"ref": ref}}), nil
+	})
+
+	db, err := jsl.Open(server.URL + "/users.jsonl")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for sql, expected := range map[string]string{
+		"SELECT name WHERE name = 'bob'": `{"name":"bob"}`,
+		"SELECT ref FROM 'mem://x'":      `{"ref":"mem://x"}`,
+	} {
+		rows, err := db.Query(sql)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if got := collect(t, rows); got != expected {
+			t.Errorf("%s: expected %s, got %s", sql, expected, got)
+		}
+	}
+
+	missing, err := jsl.Open(server.URL + "/missing.jsonl")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := missing.Query("SELECT name"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a 404 error, got %v", err)
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	var mu sync.Mutex
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/bad.jsonl":
+			http.Error(w, "bad request", http.StatusBadRequest)
+		case failures > 0:
+			failures--
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			io.WriteString(w, "{\"n\":1}\n")
+		}
+	}))
+	defer server.Close()
+
+	// Transient failures are retried, up to Retries times
+	table := database.NewHTTPTable(server.URL + "/data.jsonl")
+	table.Backoff = time.Millisecond
+	rows, err := jsl.NewDB(table).Query("SELECT n")
+	if err != nil {
+		t.Fatalf("Expected the 503s to be retried, got %v", err)
+	}
+	if got := collect(t, rows); got != `{"n":1}` {
+		t.Errorf("Expected {\"n\":1}, got %s", got)
+	}
+	mu.Lock()
+	failures = 2
+	mu.Unlock()
+	table.Retries = 1
+	if _, err := jsl.NewDB(table).Query("SELECT n"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected a 503 after the retries, got %v", err)
+	}
+	mu.Lock()
+	failures = 0
+	mu.Unlock()
+	bad := database.NewHTTPTable(server.URL + "/bad.jsonl")
+	bad.Backoff = time.Hour // a retry would time the test out
+	if _, err := jsl.NewDB(bad).Query("SELECT n"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Expected a 400 not to be retried, got %v", err)
+	}
+}
+
+func TestHTTPHosts(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "{\"n\":1}\n")
+	}))
+	defer target.Close()
+	// localhost and 127.0.0.1 are distinct hosts to the allowlist
+	redirect := httptest.NewServer(http.RedirectHandler(strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound))
+	defer redirect.Close()
+
+	defer database.EnableHTTP()
+	database.EnableHTTP("127.0.0.1")
+	db, err := jsl.Open(target.URL + "/data.jsonl")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if rows, err := db.Query("SELECT n"); err != nil || collect(t, rows) != `{"n":1}` {
+		t.Errorf("Expected an allowed host to be fetched, got %v", err)
+	}
+	for _, ref := range []string{
+		strings.Replace(target.URL, "127.0.0.1", "localhost", 1) + "/data.jsonl",
+		redirect.URL + "/data.jsonl",
+	} {
+		if _, err := db.Query("SELECT n FROM '" + ref + "'"); err == nil || !strings.Contains(err.Error(), `host "localhost" is not allowed`) {
+			t.Errorf("%s: expected the host to be refused, got %v", ref, err)
+		}
+	}
+}
+
+func TestOpenWith(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "config.json")
+	other := filepath.Join(dir, "other.json")
+	for name, content := range map[string]string{
+		input: `{"items": [{"id": 1, "tags": ["a", "a"]}, {"id": 2, "tags": ["a", "b"],},]} // lenient`,
+		other: `{"items": [{"id": 3, "tags": ["a"]}]}`,
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The input options apply to the input and the files of FROM alike
+	db, err := jsl.OpenWith(input, database.InputOptions{Lenient: true, Root: []string{"items"}})
+	if err != nil {
+		t.Fatalf("OpenWith failed: %v", err)
+	}
+	db.ArrayMatch = "all"
+	for sql, expected := range map[string]string{
+		"SELECT id WHERE tags = 'a'":                  `{"id":1}`,
+		"SELECT id FROM '" + other + "'":              `{"id":3}`,
+		"SELECT COUNT(*) AS n WHERE tags = 'b'":       `{"n":0}`,
+		"SELECT id WHERE ANY(tags) = 'b' ORDER BY id": `{"id":2}`,
+	} {
+		rows, err := db.Query(sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		if got := collect(t, rows); got != expected {
+			t.Errorf("%s: expected %s, got %s", sql, expected, got)
+		}
+	}
+
+	db.ArrayMatch = "some"
+	if _, err := db.Query("SELECT id"); err == nil || !strings.Contains(err.Error(), "ArrayMatch") {
+		t.Errorf("Expected an invalid ArrayMatch to be refused, got %v", err)
+	}
+	db, err = jsl.Open(input)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rows, err := db.Query("SELECT id")
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Error()
+		rows.Close()
+	}
+	if err == nil {
+		t.Error("Expected Open to read strict JSON")
+	}
+}
@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// isLenientFile reports whether a file name has a JSON5 or JSONC extension
+func isLenientFile(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".json5") || strings.HasSuffix(name, ".jsonc")
+}
+
+// Lenient makes the parser accept config-style JSON (JSONC, a subset of
+// JSON5): // and /* */ comments, trailing commas in objects and arrays, and
+// unquoted object keys. It must be called before the first read, and is
+// implied for .json5 and .jsonc files. Comments and trailing commas are read
+// as spaces, so lines and offsets are those of the file unless keys are
+// unquoted.
+func (p *Parser) Lenient() {
+	if !p.lenient {
+		p.lenient = true
+		p.initReader()
+	}
+}
+
+// lenientReader rewrites lenient JSON into standard JSON as it is read.
+// MessagePack input passes through unchanged.
+type lenientReader struct {
+	r       *bufio.Reader
+	out     []byte // rewritten bytes not read yet
+	started bool
+	binary  bool
+
+	inString bool
+	escaped  bool
+	stack    []byte // enclosing '{' and '['
+	// expectKey is set where an object key may start
+	expectKey bool
+	// comma is set while a comma is held back until the next token tells
+	// whether it is trailing; held are the spaces read after it
+	comma bool
+	held  []byte
+}
+
+func newLenientReader(r io.Reader) *lenientReader {
+	return &lenientReader{r: bufio.NewReader(r)}
+}
+
+func (l *lenientReader) Read(b []byte) (int, error) {
+	for len(l.out) == 0 {
+		if err := l.step(); err != nil {
+			if err == io.EOF && l.comma {
+				// A comma before the end of the input is left to the decoder
+				l.flushComma(',')
+				continue
+			}
+			return 0, err
+		}
+	}
+	n := copy(b, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
+
+// step rewrites the next byte (or comment) of the input into out
+func (l *lenientReader) step() error {
+	if !l.started {
+		l.started = true
+		if b, err := l.r.Peek(1); err == nil && isMsgpackStart(b[0]) {
+			l.binary = true
+		}
+	}
+	if l.binary {
+		buf := make([]byte, 4096)
+		n, err := l.r.Read(buf)
+		l.out = buf[:n]
+		return err
+	}
+
+	c, err := l.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case l.inString:
+		l.out = append(l.out, c)
+		switch {
+		case l.escaped:
+			l.escaped = false
+		case c == '\\':
+			l.escaped = true
+		case c == '"':
+			l.inString = false
+		}
+	case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		l.space(c)
+	case c == '/':
+		next, err := l.r.Peek(1)
+		if err != nil || (next[0] != '/' && next[0] != '*') {
+			l.token(c)
+			return nil
+		}
+		l.r.ReadByte()
+		l.space(' ')
+		l.space(' ')
+		return l.comment(next[0] == '*')
+	default:
+		l.token(c)
+	}
+	return nil
+}
+
+// comment replaces the rest of a comment with spaces, keeping its newlines
+func (l *lenientReader) comment(block bool) error {
+	for {
+		if !block {
+			if b, err := l.r.Peek(1); err != nil || b[0] == '\n' {
+				return nil // the newline is read as whitespace
+			}
+		}
+		c, err := l.r.ReadByte()
+		if err != nil {
+			if block && err == io.EOF {
+				return fmt.Errorf("unterminated /* comment")
+			}
+			return err
+		}
+		if block && c == '*' {
+			if b, err := l.r.Peek(1); err == nil && b[0] == '/' {
+				l.r.ReadByte()
+				l.space(' ')
+				l.space(' ')
+				return nil
+			}
+		}
+		if c == '\n' || c == '\r' {
+			l.space(c)
+		} else {
+			l.space(' ')
+		}
+	}
+}
+
+// space writes whitespace, or holds it back with a held comma
+func (l *lenientReader) space(c byte) {
+	if l.comma {
+		l.held = append(l.held, c)
+	} else {
+		l.out = append(l.out, c)
+	}
+}
+
+// flushComma writes a held comma as c (a space when trailing) and the
+// spaces read after it
+func (l *lenientReader) flushComma(c byte) {
+	l.out = append(l.out, c)
+	l.out = append(l.out, l.held...)
+	l.held = l.held[:0]
+	l.comma = false
+}
+
+// token writes a byte outside strings, comments and whitespace
+func (l *lenientReader) token(c byte) {
+	if c == ',' {
+		if l.comma {
+			l.flushComma(',')
+		}
+		l.comma = true
+		return
+	}
+	if l.comma {
+		if c == '}' || c == ']' {
+			l.flushComma(' ')
+		} else {
+			l.flushComma(',')
+			l.expectKey = len(l.stack) > 0 && l.stack[len(l.stack)-1] == '{'
+		}
+	}
+
+	switch c {
+	case '{', '[':
+		l.stack = append(l.stack, c)
+		l.expectKey = c == '{'
+	case '}', ']':
+		if len(l.stack) > 0 {
+			l.stack = l.stack[:len(l.stack)-1]
+		}
+		l.expectKey = false
+	case '"':
+		l.inString = true
+		l.expectKey = false
+	default:
+		if l.expectKey && isIdentByte(c) {
+			l.expectKey = false
+			l.out = append(l.out, '"', c)
+			for {
+				b, err := l.r.Peek(1)
+				if err != nil || !isIdentByte(b[0]) {
+					break
+				}
+				l.r.ReadByte()
+				l.out = append(l.out, b[0])
+			}
+			l.out = append(l.out, '"')
+			return
+		}
+		l.expectKey = false
+	}
+	l.out = append(l.out, c)
+}
+
+// isIdentByte reports whether c can be part of an unquoted key: ASCII
+// letters, digits, '_', '$' and any byte of a non-ASCII character
+func isIdentByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '_' || c == '$' || c >= 0x80
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLimitExceeded is wrapped by the errors of inputs exceeding the parser
+// Limits
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limits bound what a parser reads, so that an input too large to process
+// (e.g. a multi-gigabyte single-line document) fails fast with a clear
+// error instead of exhausting memory. Zero values mean no limit.
+type Limits struct {
+	// MaxRecordSize bounds the bytes of a single top-level value
+	MaxRecordSize int64
+	// MaxRecords bounds the number of top-level values
+	MaxRecords int64
+	// MaxBytes bounds the size of the input
+	MaxBytes int64
+}
+
+// errRecordTooLarge and errInputTooLarge are returned by the counting
+// reader, and turned into descriptive errors by ReadValue
+var (
+	errRecordTooLarge = errors.New("record too large")
+	errInputTooLarge  = errors.New("input too large")
+)
+
+// SetLimits bounds what the parser reads. It must be called before the first
+// read, and fails right away when a regular file is larger than MaxBytes.
+func (p *Parser) SetLimits(limits Limits) error {
+	p.limits = limits
+	p.counter.maxBytes, p.counter.maxRecord = limits.MaxBytes, limits.MaxRecordSize
+	if limits.MaxBytes > 0 {
+		if f, ok := p.input.(*os.File); ok {
+			if info, err := f.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > limits.MaxBytes {
+				return p.inputTooLarge()
+			}
+		}
+	}
+	return nil
+}
+
+// startRecord records the offset where the next value starts, from which the
+// counting reader stops reading a value too large to be held in memory
+func (p *Parser) startRecord(offset int64) {
+	p.counter.recordStart = offset
+}
+
+// endRecord checks the size of the value read up to end
+func (p *Parser) endRecord(end int64) error {
+	if end-p.counter.recordStart > p.limits.MaxRecordSize {
+		return p.recordTooLarge()
+	}
+	return nil
+}
+
+// countRecord counts a value read against MaxRecords
+func (p *Parser) countRecord() error {
+	p.records++
+	if p.limits.MaxRecords > 0 && p.records > p.limits.MaxRecords {
+		return fmt.Errorf("%w: %s holds more than %d records", ErrLimitExceeded, p.name, p.limits.MaxRecords)
+	}
+	return nil
+}
+
+// limitError describes the errors of the counting reader, returning other
+// errors unchanged
+func (p *Parser) limitError(err error) error {
+	switch {
+	case errors.Is(err, errRecordTooLarge):
+		return p.recordTooLarge()
+	case errors.Is(err, errInputTooLarge):
+		return p.inputTooLarge()
+	}
+	return err
+}
+
+func (p *Parser) recordTooLarge() error {
+	offset := p.counter.recordStart
+	at := fmt.Sprintf("offset %d", p.fileOffset(offset))
+	if line, _, ok := p.counter.locate(offset); ok && !p.isMsgpack && p.rangeStart == 0 {
+		at = fmt.Sprintf("line %d, offset %d", line, offset)
+	}
+	return fmt.Errorf("%w: the record of %s at %s is larger than %d bytes", ErrLimitExceeded, p.name, at, p.limits.MaxRecordSize)
+}
+
+func (p *Parser) inputTooLarge() error {
+	return fmt.Errorf("%w: %s is larger than %d bytes", ErrLimitExceeded, p.name, p.limits.MaxBytes)
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// recentSize is how much of the input the counting reader keeps to locate
+// decoding errors. Errors further back than this (in a very large value) are
+// reported with their offset only.
+const recentSize = 64 * 1024
+
+// snippetSize bounds the content shown on each side of an error
+const snippetSize = 32
+
+// remember counts the newlines of b and keeps it as the latest input read
+func (c *countingReader) remember(b []byte) {
+	c.lines += int64(bytes.Count(b, []byte{'\n'}))
+	if len(b) >= recentSize {
+		c.recent = append(c.recent[:0], b[len(b)-recentSize:]...)
+		return
+	}
+	if len(c.recent)+len(b) > 2*recentSize {
+		keep := recentSize - len(b)
+		c.recent = append(c.recent[:0], c.recent[len(c.recent)-keep:]...)
+	}
+	c.recent = append(c.recent, b...)
+}
+
+// locate returns the line number (from 1) of a byte offset and the content
+// around it, when the offset is still among the latest input read
+func (c *countingReader) locate(offset int64) (line int64, snippet string, ok bool) {
+	start := c.n - int64(len(c.recent))
+	if offset < start || offset > c.n {
+		return 0, "", false
+	}
+	at := int(offset - start)
+	line = c.lines - int64(bytes.Count(c.recent[at:], []byte{'\n'})) + 1
+
+	from := bytes.LastIndexByte(c.recent[:at], '\n') + 1
+	to := len(c.recent)
+	if i := bytes.IndexByte(c.recent[at:], '\n'); i >= 0 {
+		to = at + i
+	}
+	prefix, suffix := "", ""
+	if at-from > snippetSize {
+		from, prefix = at-snippetSize, "..."
+	}
+	if to-at > snippetSize {
+		to, suffix = at+snippetSize, "..."
+	}
+	text := bytes.TrimRight(c.recent[from:to], "\r")
+	return line, prefix + string(text) + suffix, true
+}
+
+// decodeError describes a JSON decoding error with the line, the byte offset
+// and the content where it occurred
+func (p *Parser) decodeError(err error) error {
+	offset := p.decoderOffset()
+	if syntax, ok := err.(*json.SyntaxError); ok {
+		// The offending byte is the last one the decoder read
+		offset = p.decoderStart() + max(syntax.Offset-1, 0)
+	}
+	return p.errorAt(offset, err)
+}
+
+// errorAt describes a decoding error at an input offset
+func (p *Parser) errorAt(offset int64, err error) error {
+	kind := "JSON"
+	if p.isJSONL {
+		kind = "JSONL"
+	}
+	line, snippet, ok := p.counter.locate(offset)
+	offset = p.fileOffset(offset)
+	if !ok || (p.rangeStart > 0 && snippet == "") {
+		return fmt.Errorf("failed to decode %s record at offset %d: %w", kind, offset, err)
+	}
+	if p.rangeStart > 0 {
+		// Lines are only counted from the start of the range
+		return fmt.Errorf("failed to decode %s record at offset %d: %w (near %q)", kind, offset, err, snippet)
+	}
+	if snippet == "" {
+		return fmt.Errorf("failed to decode %s record at line %d, offset %d: %w", kind, line, offset, err)
+	}
+	return fmt.Errorf("failed to decode %s record at line %d, offset %d: %w (near %q)", kind, line, offset, err, snippet)
+}
+
+// decoderOffset returns the input offset the decoder has reached
+func (p *Parser) decoderOffset() int64 {
+	return p.decoderStart() + p.decoder.InputOffset()
+}
+
+// valueStart returns the input offset of the next value of the decoder,
+// past the whitespace (and array comma) it has buffered
+func (p *Parser) valueStart() int64 {
+	offset := p.decoderOffset()
+	if buffered, ok := p.decoder.Buffered().(io.ByteReader); ok {
+		for {
+			c, err := buffered.ReadByte()
+			if err != nil || (c != ' ' && c != '\n' && c != '\t' && c != '\r' && c != ',') {
+				break
+			}
+			offset++
+		}
+	}
+	return offset
+}
+
+// decoderStart returns the input offset of the first byte the decoder read:
+// what it read is everything before the data still buffered
+func (p *Parser) decoderStart() int64 {
+	end := p.counter.n - int64(p.bufReader.Buffered())
+	if buffered, ok := p.decoder.Buffered().(interface{ Len() int }); ok {
+		end -= int64(buffered.Len())
+	}
+	return end - p.decoder.InputOffset()
+}
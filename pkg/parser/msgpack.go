@@ -0,0 +1,302 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MessagePack extension types decoded as timestamps
+const (
+	// MsgpackTimestamp is the standard timestamp extension
+	MsgpackTimestamp = -1
+	// msgpackEventTime is the Fluentd EventTime extension (seconds and nanoseconds)
+	msgpackEventTime = 0
+)
+
+// maxSafeInt is the largest integer a float64 holds exactly
+const maxSafeInt = 1 << 53
+
+// isMsgpackFile reports whether a file name has a MessagePack extension
+func isMsgpackFile(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".msgpack") || strings.HasSuffix(name, ".mpk")
+}
+
+// isMsgpackStart reports whether a first byte starts a MessagePack map or
+// array, which no JSON document can start with
+func isMsgpackStart(b byte) bool {
+	return (b >= 0x80 && b <= 0x9f) || (b >= 0xdc && b <= 0xdf)
+}
+
+// IsMsgpack returns whether the parser is reading MessagePack
+func (p *Parser) IsMsgpack() bool {
+	return p.isMsgpack
+}
+
+// detectMsgpack switches to MessagePack when the input starts like it
+func (p *Parser) detectMsgpack() {
+	p.formatChecked = true
+	if b, err := p.bufReader.Peek(1); err == nil && isMsgpackStart(b[0]) {
+		p.isMsgpack = true
+	}
+}
+
+// readMsgpackValue reads the next top-level MessagePack value, recording
+// its offset when positions are tracked (MessagePack has no lines)
+func (p *Parser) readMsgpackValue() (interface{}, error) {
+	if _, err := p.bufReader.Peek(1); err != nil {
+		return nil, err
+	}
+	offset := p.counter.n - int64(p.bufReader.Buffered())
+	if p.positions {
+		p.offset, p.line = offset, 0
+	}
+	p.startRecord(offset)
+	value, err := decodeMsgpack(p.bufReader, p.ordered)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to decode MessagePack record at offset %d: %w", offset, err)
+	}
+	if p.limits.MaxRecordSize > 0 {
+		if err := p.endRecord(p.counter.n - int64(p.bufReader.Buffered())); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// decodeMsgpack decodes one MessagePack value. Numbers become float64 like
+// JSON numbers, integers beyond 2^53 int64 or uint64 to stay exact; binary
+// data becomes a string when it is valid UTF-8; map keys become strings.
+// Maps become OrderedMap when ordered is set.
+func decodeMsgpack(r *bufio.Reader, ordered bool) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return float64(c), nil
+	case c >= 0xe0:
+		return float64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return decodeMsgpackMap(r, int(c&0x0f), ordered)
+	case c >= 0x90 && c <= 0x9f:
+		return decodeMsgpackArray(r, int(c&0x0f), ordered)
+	case c >= 0xa0 && c <= 0xbf:
+		return decodeMsgpackString(r, int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8/16/32
+		n, err := readMsgpackLength(r, c-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readMsgpackBytes(r, n)
+		if err != nil {
+			return nil, err
+		}
+		if utf8.Valid(data) {
+			return string(data), nil
+		}
+		return data, nil
+	case 0xc7, 0xc8, 0xc9: // ext 8/16/32
+		n, err := readMsgpackLength(r, c-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackExt(r, n)
+	case 0xca:
+		b, err := readMsgpackBytes(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := readMsgpackBytes(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8/16/32/64
+		b, err := readMsgpackBytes(r, 1<<(c-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackUint(beUint(b)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8/16/32/64
+		size := 1 << (c - 0xd0)
+		b, err := readMsgpackBytes(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the value's width
+		shift := 64 - 8*size
+		return msgpackInt(int64(beUint(b)<<shift) >> shift), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1/2/4/8/16
+		return decodeMsgpackExt(r, 1<<(c-0xd4))
+	case 0xd9, 0xda, 0xdb: // str 8/16/32
+		n, err := readMsgpackLength(r, c-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackString(r, n)
+	case 0xdc, 0xdd: // array 16/32
+		n, err := readMsgpackLength(r, c-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, n, ordered)
+	case 0xde, 0xdf: // map 16/32
+		n, err := readMsgpackLength(r, c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, n, ordered)
+	}
+	return nil, fmt.Errorf("invalid MessagePack type byte 0x%02x", c)
+}
+
+// readMsgpackLength reads a big-endian length of 1, 2 or 4 bytes (sizeLog 0, 1 or 2)
+func readMsgpackLength(r *bufio.Reader, sizeLog byte) (int, error) {
+	b, err := readMsgpackBytes(r, 1<<sizeLog)
+	if err != nil {
+		return 0, err
+	}
+	return int(beUint(b)), nil
+}
+
+// readMsgpackBytes reads n bytes. The buffer grows with the data actually
+// read, so that a corrupt length cannot allocate gigabytes up front.
+func readMsgpackBytes(r *bufio.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func msgpackUint(v uint64) interface{} {
+	switch {
+	case v <= maxSafeInt:
+		return float64(v)
+	case v <= math.MaxInt64:
+		return int64(v)
+	}
+	return v
+}
+
+func msgpackInt(v int64) interface{} {
+	if v >= -maxSafeInt && v <= maxSafeInt {
+		return float64(v)
+	}
+	return v
+}
+
+func decodeMsgpackString(r *bufio.Reader, n int) (interface{}, error) {
+	b, err := readMsgpackBytes(r, n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func decodeMsgpackArray(r *bufio.Reader, n int, ordered bool) (interface{}, error) {
+	arr := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := decodeMsgpack(r, ordered)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+func decodeMsgpackMap(r *bufio.Reader, n int, ordered bool) (interface{}, error) {
+	var m map[string]interface{}
+	var om OrderedMap
+	if ordered {
+		om = make(OrderedMap, 0, min(n, 1024))
+	} else {
+		m = make(map[string]interface{}, min(n, 1024))
+	}
+	for i := 0; i < n; i++ {
+		k, err := decodeMsgpack(r, ordered)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeMsgpack(r, ordered)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		if ordered {
+			om = om.Set(key, v)
+		} else {
+			m[key] = v
+		}
+	}
+	if ordered {
+		return om, nil
+	}
+	return m, nil
+}
+
+// decodeMsgpackExt decodes an extension value of n bytes: timestamps become
+// time.Time, other types {"type": type, "data": bytes}
+func decodeMsgpackExt(r *bufio.Reader, n int) (interface{}, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readMsgpackBytes(r, n)
+	if err != nil {
+		return nil, err
+	}
+	typ := int8(t)
+	switch {
+	case typ == MsgpackTimestamp && n == 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case typ == MsgpackTimestamp && n == 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&0x3ffffffff), int64(v>>34)).UTC(), nil
+	case typ == MsgpackTimestamp && n == 12:
+		nsec := binary.BigEndian.Uint32(data[:4])
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(nsec)).UTC(), nil
+	case typ == msgpackEventTime && n == 8:
+		sec, nsec := binary.BigEndian.Uint32(data[:4]), binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	}
+	return map[string]interface{}{"type": float64(typ), "data": data}, nil
+}
@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// OrderedMap represents a map that preserves insertion order.
+// It is implemented as a slice of KeyVal pairs to keep it simple and lightweight for this use case.
+type KeyVal struct {
+	Key string
+	Val interface{}
+}
+
+type OrderedMap []KeyVal
+
+// MarshalJSON implements the json.Marshaler interface. Like a nil map, a
+// nil OrderedMap is null.
+func (om OrderedMap) MarshalJSON() ([]byte, error) {
+	if om == nil {
+		return []byte("null"), nil
+	}
+	b := marshalBuffers.Get().(*marshalBuffer)
+	b.buf.WriteByte('{')
+	for i, kv := range om {
+		if i > 0 {
+			b.buf.WriteByte(',')
+		}
+		if err := b.encode(kv.Key); err != nil {
+			return nil, err
+		}
+		b.buf.WriteByte(':')
+		if err := b.encode(kv.Val); err != nil {
+			return nil, err
+		}
+	}
+	b.buf.WriteByte('}')
+	out := bytes.Clone(b.buf.Bytes())
+	b.release()
+	return out, nil
+}
+
+// marshalBuffer is a buffer and an encoder writing to it, reused across
+// MarshalJSON calls through marshalBuffers
+type marshalBuffer struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+// maxPooledBuffer bounds the buffers kept for reuse, so that one large
+// object does not pin its buffer
+const maxPooledBuffer = 64 * 1024
+
+var marshalBuffers = sync.Pool{
+	New: func() interface{} {
+		b := &marshalBuffer{}
+		b.enc = json.NewEncoder(&b.buf)
+		return b
+	},
+}
+
+// encode appends the JSON encoding of v, like json.Marshal
+func (b *marshalBuffer) encode(v interface{}) error {
+	if err := b.enc.Encode(v); err != nil {
+		return err
+	}
+	b.buf.Truncate(b.buf.Len() - 1) // the newline ending the value
+	return nil
+}
+
+// release returns the buffer to the pool, unless it grew too large
+func (b *marshalBuffer) release() {
+	if b.buf.Cap() > maxPooledBuffer {
+		return
+	}
+	b.buf.Reset()
+	marshalBuffers.Put(b)
+}
+
+// Get returns the value for a key (O(N) lookup, but explicit for small projections)
+func (om OrderedMap) Get(key string) (interface{}, bool) {
+	for _, kv := range om {
+		if kv.Key == key {
+			return kv.Val, true
+		}
+	}
+	return nil, false
+}
+
+// Set returns om with the value of key replaced, or appended when key is
+// new. The backing array is shared with om.
+func (om OrderedMap) Set(key string, val interface{}) OrderedMap {
+	for i := range om {
+		if om[i].Key == key {
+			om[i].Val = val
+			return om
+		}
+	}
+	return append(om, KeyVal{Key: key, Val: val})
+}
+
+// Delete returns om without key, the following keys keeping their order.
+// The backing array is shared with om.
+func (om OrderedMap) Delete(key string) OrderedMap {
+	for i := range om {
+		if om[i].Key == key {
+			return append(om[:i], om[i+1:]...)
+		}
+	}
+	return om
+}
+
+// Range calls fn for each key/value pair in order until fn returns false
+func (om OrderedMap) Range(fn func(key string, val interface{}) bool) {
+	for _, kv := range om {
+		if !fn(kv.Key, kv.Val) {
+			return
+		}
+	}
+}
+
+// ToMap converts to a standard map (losing order)
+func (om OrderedMap) ToMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(om))
+	for _, kv := range om {
+		m[kv.Key] = kv.Val
+	}
+	return m
+}
+
+// String implements fmt.Stringer
+func (om OrderedMap) String() string {
+	b, _ := om.MarshalJSON()
+	return string(b)
+}
+
+// orderedDecoder decodes values like Decode into an interface{}, except
+// that objects become OrderedMap with their keys in document order. A
+// repeated key keeps its first position and last value. The members of the
+// objects and arrays being decoded are collected in scratch slices reused
+// across values, so that each object and array is allocated once, at its
+// final size.
+type orderedDecoder struct {
+	members  []KeyVal
+	elements []interface{}
+}
+
+// decode decodes the next value of dec
+func (d *orderedDecoder) decode(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := t.(json.Delim)
+	if !ok {
+		return t, nil
+	}
+	switch delim {
+	case '{':
+		start := len(d.members)
+		om, err := d.decodeObject(dec, start)
+		clear(d.members[start:])
+		d.members = d.members[:start]
+		return om, err
+	case '[':
+		start := len(d.elements)
+		arr, err := d.decodeArray(dec, start)
+		clear(d.elements[start:])
+		d.elements = d.elements[:start]
+		return arr, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
+
+// decodeObject decodes the members of an object into members[start:]
+func (d *orderedDecoder) decodeObject(dec *json.Decoder, start int) (interface{}, error) {
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected object key, got %v", t)
+		}
+		val, err := d.decode(dec)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		d.set(start, key, val)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return append(make(OrderedMap, 0, len(d.members)-start), d.members[start:]...), nil
+}
+
+// set is OrderedMap.Set on the object members[start:]
+func (d *orderedDecoder) set(start int, key string, val interface{}) {
+	for i := start; i < len(d.members); i++ {
+		if d.members[i].Key == key {
+			d.members[i].Val = val
+			return
+		}
+	}
+	d.members = append(d.members, KeyVal{Key: key, Val: val})
+}
+
+// decodeArray decodes the elements of an array into elements[start:]
+func (d *orderedDecoder) decodeArray(dec *json.Decoder, start int) (interface{}, error) {
+	for dec.More() {
+		val, err := d.decode(dec)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		d.elements = append(d.elements, val)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return append(make([]interface{}, 0, len(d.elements)-start), d.elements[start:]...), nil
+}
+
+// unexpectedEOF reports the end of the input inside a value as an error
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
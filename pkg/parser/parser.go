@@ -2,10 +2,15 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/diag"
 )
 
 // Record represents a single JSON object
@@ -13,9 +18,9 @@ type Record map[string]interface{}
 
 // Parser handles reading JSON and JSONL files
 type Parser struct {
-	file    *os.File
-	isJSONL bool
-	tmpFile string // Path to temporary file, if created
+	input   io.Reader // the file, stdin, or a reader (NewReaderParser)
+	name    string    // File name reported in record sources
+	isJSONL bool      // by extension, or sniffed from the content (detectJSONL)
 
 	// Stateful readers
 	decoder   *json.Decoder
@@ -24,6 +29,101 @@ type Parser struct {
 
 	startArrayChecked bool
 	inArray           bool
+	isArray           bool // the JSON input is a top-level array
+
+	headerChecked bool
+	jsonlChecked  bool
+	schema        Schema // Declared by an optional "#jsl-schema" header line
+
+	isMsgpack     bool // the input is a stream of MessagePack values
+	formatChecked bool
+
+	counter   *countingReader
+	bytesRead int64       // bytes consumed by earlier readers (before a rewind)
+	stats     *diag.Stats // counts the records and bytes read besides diag.Counters (CountInto)
+
+	ordered    bool         // decode objects as OrderedMap (PreserveOrder)
+	lenient    bool         // accept comments, trailing commas and unquoted keys (Lenient)
+	skipErrors ErrorHandler // skip malformed lines (SkipErrors)
+	root       []string     // stream the array at this path (Root)
+	limits     Limits       // (SetLimits)
+	records    int64        // values read, against limits.MaxRecords
+
+	rangeStart int64 // the input is the bytes [rangeStart, rangeEnd) of the file (Range)
+	rangeEnd   int64
+
+	needles        [][]byte       // skip JSONL records lacking one of these (Prefilter)
+	pending        []pendingValue // values of the last record read by readPrefiltered
+	nextPending    int
+	recordBuf      []byte         // the record read by readRecord, reused
+	recordReader   bytes.Reader   // reads the record decoded by decodeRecord
+	orderedDecoder orderedDecoder // scratch of PreserveOrder decoding
+
+	sources   bool // add a SourceField to object records (TrackSources)
+	positions bool // record the position of each value (TrackPositions)
+	offset    int64
+	line      int
+}
+
+// countingReader counts the bytes read from the input, and the offsets of
+// its newlines when lines are tracked
+type countingReader struct {
+	r io.Reader
+	n int64
+
+	trackLines bool
+	newlines   []int64 // offsets of the newlines not yet passed by lineAt
+	line       int
+
+	lines  int64  // newlines read
+	recent []byte // the latest input read, to locate errors
+
+	// Reads fail beyond maxBytes, or well beyond maxRecord bytes past
+	// recordStart (leaving room for the read-ahead of the decoders), when set
+	maxBytes    int64
+	maxRecord   int64
+	recordStart int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	// Reads stop at the limits, and fail past them: decoders may ignore the
+	// error of a read returning data
+	if c.maxBytes > 0 {
+		if c.n > c.maxBytes {
+			return 0, errInputTooLarge
+		}
+		b = b[:min(int64(len(b)), c.maxBytes+1-c.n)]
+	}
+	if c.maxRecord > 0 {
+		limit := c.recordStart + 2*c.maxRecord + sniffSize
+		if c.n > limit {
+			return 0, errRecordTooLarge
+		}
+		b = b[:min(int64(len(b)), limit+1-c.n)]
+	}
+	n, err := c.r.Read(b)
+	if c.trackLines {
+		for i, ch := range b[:n] {
+			if ch == '\n' {
+				c.newlines = append(c.newlines, c.n+int64(i))
+			}
+		}
+	}
+	c.remember(b[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// lineAt returns the line number (from 1) of a byte offset; offsets must
+// not decrease between calls
+func (c *countingReader) lineAt(offset int64) int {
+	passed := 0
+	for passed < len(c.newlines) && c.newlines[passed] < offset {
+		passed++
+	}
+	c.newlines = c.newlines[passed:]
+	c.line += passed
+	return c.line + 1
 }
 
 // NewParser creates a new parser for the given file
@@ -31,79 +131,293 @@ type Parser struct {
 // - Empty string or "-" reads from stdin
 // - Strings starting with '{' or '[' are treated as inline JSON
 func NewParser(filename string) (*Parser, error) {
-	var file *os.File
-	var err error
-	var isJSONL bool
-	var tmpFile string
-
-	// Handle inline JSON (starts with { or [)
+	// Inline JSON (starts with { or [) is read from memory
 	if len(filename) > 0 && (filename[0] == '{' || filename[0] == '[') {
-		// Create a temporary file to store inline JSON
-		tmpFileHandle, err := os.CreateTemp("", "jsl-inline-*.json")
-		if err != nil {
-			return nil, fmt.Errorf("failed to create temp file: %w", err)
-		}
-		tmpFile = tmpFileHandle.Name()
-		if _, err := tmpFileHandle.WriteString(filename); err != nil {
-			tmpFileHandle.Close()
-			os.Remove(tmpFile)
-			return nil, fmt.Errorf("failed to write inline JSON: %w", err)
-		}
-		// Seek back to the beginning
-		if _, err := tmpFileHandle.Seek(0, 0); err != nil {
-			tmpFileHandle.Close()
-			os.Remove(tmpFile)
-			return nil, fmt.Errorf("failed to seek: %w", err)
-		}
-		file = tmpFileHandle
-		isJSONL = false
-	} else if filename == "" || filename == "-" {
-		// Read from stdin
-		file = os.Stdin
-		isJSONL = false // Default to false, will try auto-detect if needed? No, logic below.
-	} else {
-		// Regular file
-		file, err = os.Open(filename)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open file: %w", err)
-		}
-		// Try to detect if it's JSONL by checking file extension
-		isJSONL = len(filename) >= 6 && filename[len(filename)-6:] == ".jsonl"
+		return NewReaderParser("<inline>", strings.NewReader(filename)), nil
+	}
+	if filename == "" || filename == "-" {
+		// Read from stdin, sniffing JSONL from the content on the first read
+		return NewReaderParser("<stdin>", os.Stdin), nil
 	}
 
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
 	p := &Parser{
-		file:    file,
-		isJSONL: isJSONL,
-		tmpFile: tmpFile,
+		input: file,
+		name:  filename,
+		// Try to detect if it's JSONL by checking file extension
+		isJSONL:       len(filename) >= 6 && filename[len(filename)-6:] == ".jsonl",
+		isMsgpack:     isMsgpackFile(filename),
+		formatChecked: isMsgpackFile(filename),
+		lenient:       isLenientFile(filename),
 	}
-
 	p.initReader()
 	return p, nil
 }
 
+// NewReaderParser creates a parser reading JSON, JSONL or MessagePack from r,
+// the format being sniffed from the content. name is reported in record
+// sources and errors. Close closes r if it is an io.Closer; rewinding (as
+// ReadAllValues does) needs r to be an io.Seeker.
+func NewReaderParser(name string, r io.Reader) *Parser {
+	p := &Parser{input: r, name: name}
+	p.initReader()
+	return p
+}
+
 func (p *Parser) initReader() {
-	// Always use bufio.Reader to allow peeking and json.Decoder for robust parsing
-	p.bufReader = bufio.NewReader(p.file)
+	// Always use bufio.Reader to allow peeking and json.Decoder for robust parsing.
+	// A rewound input is read again, so only the longest read is counted.
+	if p.counter != nil {
+		p.bytesRead = max(p.bytesRead, p.counter.n)
+	}
+	r := p.input
+	if p.rangeEnd > 0 {
+		r = io.LimitReader(p.input, p.rangeEnd-p.rangeStart)
+	}
+	if p.lenient && !p.isMsgpack {
+		r = newLenientReader(r)
+	}
+	p.counter = &countingReader{
+		r:          r,
+		trackLines: p.positions || p.skipErrors != nil,
+		maxBytes:   p.limits.MaxBytes,
+		maxRecord:  p.limits.MaxRecordSize,
+	}
+	p.bufReader = bufio.NewReaderSize(p.counter, sniffSize)
 	p.decoder = json.NewDecoder(p.bufReader)
 }
 
-// Close closes the underlying file and cleans up any temporary files
+// CountInto makes the parser count the records and bytes it reads in s
+// (the statistics of a query execution) besides the process-wide
+// diag.Counters. A nil s counts in diag.Counters alone.
+func (p *Parser) CountInto(s *diag.Stats) {
+	p.stats = s
+}
+
+// countRead counts a record read
+func (p *Parser) countRead() {
+	diag.Counters().Read.Add(1)
+	if p.stats != nil {
+		p.stats.Read.Add(1)
+	}
+}
+
+// Close closes the underlying file or reader
 func (p *Parser) Close() error {
-	err := p.file.Close()
-	// Clean up temporary file if it exists
-	if p.tmpFile != "" {
-		os.Remove(p.tmpFile)
+	diag.Counters().Bytes.Add(max(p.bytesRead, p.counter.n))
+	if p.stats != nil {
+		p.stats.Bytes.Add(max(p.bytesRead, p.counter.n))
+	}
+	p.bytesRead, p.counter.n = 0, 0
+	if c, ok := p.input.(io.Closer); ok {
+		return c.Close()
 	}
-	return err
+	return nil
 }
 
-// IsJSONL returns whether the parser is treating the file as JSONL
+// IsJSONL returns whether the parser is treating the file as JSONL: a
+// .jsonl file, or an input read so far whose content looks like JSONL
+// (see detectJSONL)
 func (p *Parser) IsJSONL() bool {
 	return p.isJSONL
 }
 
-// Read reads the next record from the file.
+// sniffSize is the read buffer size, which bounds the first line that
+// detectJSONL can inspect
+const sniffSize = 64 * 1024
+
+// detectJSONL switches to JSONL when the first line of the input holds a
+// complete value and another value starts on a later line, whatever the
+// file name. A longer first line leaves the input read as JSON, which
+// decodes concatenated objects alike.
+func (p *Parser) detectJSONL() error {
+	p.jsonlChecked = true
+	if p.isJSONL {
+		return nil
+	}
+	start, end := -1, -1
+	for n := 1; ; n++ {
+		b, err := p.bufReader.Peek(n)
+		if err == io.EOF || err == bufio.ErrBufferFull {
+			return nil // a single line, or too long to tell
+		}
+		if err != nil {
+			return err
+		}
+		c := b[n-1]
+		if c == ' ' || c == '\n' || c == '\t' || c == '\r' {
+			if c == '\n' && start >= 0 && end < 0 {
+				end = n - 1
+			}
+			continue
+		}
+		if start < 0 {
+			start = n - 1
+		} else if end >= 0 {
+			p.isJSONL = json.Valid(b[start:end])
+			return nil
+		}
+	}
+}
+
+// IsArray reports whether the JSON input read so far is a top-level array
+// (as opposed to one or more concatenated documents)
+func (p *Parser) IsArray() bool {
+	return p.isArray
+}
+
+// Schema returns the schema declared by the input's header line, or nil
+func (p *Parser) Schema() Schema {
+	return p.schema
+}
+
+// readSchemaHeader consumes an optional "#jsl-schema" line at the start of the input
+func (p *Parser) readSchemaHeader() error {
+	p.headerChecked = true
+	for {
+		b, err := p.bufReader.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		c := b[0]
+		if c == ' ' || c == '\n' || c == '\t' || c == '\r' {
+			p.bufReader.ReadByte() // consume whitespace
+			continue
+		}
+		if c != '#' {
+			return nil
+		}
+		line, err := p.bufReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		schema, err := ParseSchemaHeader(line)
+		if err != nil {
+			return err
+		}
+		p.schema = schema
+		diag.Debug(diag.CodeSchema, fmt.Sprintf("using schema header %s", strings.TrimSpace(line)), "schema", map[string]string(schema))
+		// Keep numbers exact so integer fields survive the round trip
+		p.decoder.UseNumber()
+		return nil
+	}
+}
+
+// Read reads the next record from the file. Records must be objects; use
+// ReadValue for streams of arrays or scalars.
 func (p *Parser) Read() (Record, error) {
+	v, err := p.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := v.(OrderedMap); ok {
+		return Record(m.ToMap()), nil
+	}
+	record, ok := v.(Record)
+	if !ok && v != nil {
+		return nil, fmt.Errorf("expected a JSON object, got %s", TypeOf(v))
+	}
+	return record, nil
+}
+
+// ReadOrdered is Read keeping the keys of the record in document order (see
+// PreserveOrder)
+func (p *Parser) ReadOrdered() (OrderedMap, error) {
+	p.PreserveOrder()
+	v, err := p.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := v.(OrderedMap)
+	if !ok && v != nil {
+		return nil, fmt.Errorf("expected a JSON object, got %s", TypeOf(v))
+	}
+	return record, nil
+}
+
+// ReadValue reads the next top-level value, of any JSON type. Objects are
+// returned as Record, or as OrderedMap with PreserveOrder.
+func (p *Parser) ReadValue() (interface{}, error) {
+	if !p.formatChecked {
+		p.detectMsgpack()
+	}
+	var value interface{}
+	var err error
+	if p.isMsgpack && p.root != nil {
+		return nil, fmt.Errorf("a root path is not supported for MessagePack input")
+	}
+	if p.isMsgpack {
+		value, err = p.readMsgpackValue()
+	} else {
+		value, err = p.readJSONValue()
+	}
+	if err != nil {
+		return nil, p.limitError(err)
+	}
+	if err := p.countRecord(); err != nil {
+		return nil, err
+	}
+	switch m := value.(type) {
+	case map[string]interface{}:
+		record := Record(m)
+		if p.schema != nil {
+			if err := p.schema.Apply(record); err != nil {
+				return nil, err
+			}
+		}
+		if p.sources {
+			record[SourceField] = p.source()
+		}
+		value = record
+	case OrderedMap:
+		if p.schema != nil {
+			if err := p.schema.ApplyOrdered(m); err != nil {
+				return nil, err
+			}
+		}
+		if p.sources {
+			m = m.Set(SourceField, p.source())
+		}
+		value = m
+	}
+	p.countRead()
+	return value, nil
+}
+
+// readJSONValue reads the next JSON value
+func (p *Parser) readJSONValue() (interface{}, error) {
+	if !p.headerChecked {
+		if err := p.readSchemaHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.root != nil {
+		if p.startArrayChecked && !p.inArray {
+			return nil, io.EOF // the root array has ended
+		}
+		if !p.startArrayChecked {
+			if _, err := p.bufReader.Peek(1); err != nil {
+				return nil, err
+			}
+			if err := p.enterRoot(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !p.jsonlChecked && p.root == nil {
+		if err := p.detectJSONL(); err != nil {
+			return nil, err
+		}
+	}
+
 	if !p.isJSONL {
 		// Standard JSON logic: handle optional opening '['
 		if !p.startArrayChecked {
@@ -123,6 +437,7 @@ func (p *Parser) Read() (Record, error) {
 				}
 				if c == '[' {
 					p.inArray = true
+					p.isArray = true
 					if _, err := p.decoder.Token(); err != nil {
 						return nil, err
 					}
@@ -131,35 +446,72 @@ func (p *Parser) Read() (Record, error) {
 				break
 			}
 		}
+	}
 
-		if p.inArray {
-			if !p.decoder.More() {
-				// Consume closing ']'
-				t, err := p.decoder.Token()
-				if err != nil {
-					return nil, err
-				}
-				if delim, ok := t.(json.Delim); ok && delim == ']' {
-					p.inArray = false
-					return nil, io.EOF
-				}
-				return nil, fmt.Errorf("expected array end, got %v", t)
+	if p.inArray {
+		if !p.decoder.More() {
+			// Consume closing ']'
+			t, err := p.decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if delim, ok := t.(json.Delim); ok && delim == ']' {
+				p.inArray = false
+				return nil, io.EOF
 			}
+			return nil, fmt.Errorf("expected array end, got %v", t)
 		}
 	}
 
+	if p.skipErrors != nil && !p.inArray {
+		return p.readLine()
+	}
+	if p.prefiltered() {
+		return p.readPrefiltered()
+	}
+
 	// Decode next item (works for both single JSON object, JSON array element, and multi-line JSONL)
-	var record Record
-	if err := p.decoder.Decode(&record); err != nil {
+	if p.limits.MaxRecordSize > 0 {
+		p.startRecord(p.valueStart())
+	}
+	var value interface{}
+	var err error
+	if p.positions {
+		value, err = p.decodePositioned()
+	} else {
+		value, err = p.decode(p.decoder)
+	}
+	if err != nil {
 		if err == io.EOF {
 			return nil, io.EOF
 		}
-		if p.isJSONL {
-			return nil, fmt.Errorf("failed to decode JSONL record: %w", err)
+		if errors.Is(err, errRecordTooLarge) || errors.Is(err, errInputTooLarge) {
+			return nil, err
 		}
-		return nil, fmt.Errorf("failed to decode JSON record: %w", err)
+		return nil, p.decodeError(err)
 	}
-	return record, nil
+	if p.limits.MaxRecordSize > 0 {
+		if err := p.endRecord(p.decoderOffset()); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// PreserveOrder makes the parser decode objects as OrderedMap, keeping their
+// keys in document order, instead of Record and plain maps
+func (p *Parser) PreserveOrder() {
+	p.ordered = true
+}
+
+// decode decodes the next JSON value of dec
+func (p *Parser) decode(dec *json.Decoder) (interface{}, error) {
+	if p.ordered {
+		return p.orderedDecoder.decode(dec)
+	}
+	var value interface{}
+	err := dec.Decode(&value)
+	return value, err
 }
 
 // ReadAll reads all records from the file
@@ -178,12 +530,61 @@ func (p *Parser) ReadAll() ([]Record, error) {
 	return p.readJSON()
 }
 
-// readJSON reads a single JSON file
-func (p *Parser) readJSON() ([]Record, error) {
-	p.file.Seek(0, 0)
+// ReadAllOrdered is ReadAll keeping the keys of the records in document
+// order (see PreserveOrder)
+func (p *Parser) ReadAllOrdered() ([]OrderedMap, error) {
+	p.PreserveOrder()
+	values, err := p.ReadAllValues()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]OrderedMap, 0, len(values))
+	for _, v := range values {
+		record, ok := v.(OrderedMap)
+		if !ok && v != nil {
+			return nil, fmt.Errorf("expected a JSON object, got %s", TypeOf(v))
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReadAllValues reads all top-level values from the file, of any JSON type
+// (see ReadValue)
+func (p *Parser) ReadAllValues() ([]interface{}, error) {
+	p.rewind()
+	var values []interface{}
+	for {
+		v, err := p.ReadValue()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// rewind restarts reading from the beginning of the file
+func (p *Parser) rewind() {
+	if s, ok := p.input.(io.Seeker); ok {
+		s.Seek(p.rangeStart, io.SeekStart)
+	}
 	p.initReader()
 	p.startArrayChecked = false
 	p.inArray = false
+	p.headerChecked = p.rangeStart > 0
+	p.schema = nil
+	p.formatChecked = p.isMsgpack
+	p.records = 0
+	p.pending, p.nextPending = nil, 0
+}
+
+// readJSON reads a single JSON file
+func (p *Parser) readJSON() ([]Record, error) {
+	p.rewind()
 
 	var allRecords []Record
 	for {
@@ -201,8 +602,7 @@ func (p *Parser) readJSON() ([]Record, error) {
 
 // readJSONL reads a JSONL (JSON Lines) file
 func (p *Parser) readJSONL() ([]Record, error) {
-	p.file.Seek(0, 0)
-	p.initReader()
+	p.rewind()
 
 	var records []Record
 	for {
@@ -234,17 +634,27 @@ func (p *Parser) ForEachRecord(fn func(Record) error) error {
 	return nil
 }
 
+// Object is a record type the writers accept: Record, or OrderedMap to keep
+// the key order of records read with PreserveOrder
+type Object interface {
+	Record | OrderedMap
+}
+
 // WriteJSON writes records as a JSON array
-func WriteJSON(w io.Writer, records []Record, pretty bool) error {
+func WriteJSON[R Object](w io.Writer, records []R, pretty bool) error {
 	encoder := json.NewEncoder(w)
 	if pretty {
 		encoder.SetIndent("", "  ")
 	}
-	return encoder.Encode(records)
+	if err := encoder.Encode(records); err != nil {
+		return err
+	}
+	diag.Counters().Emitted.Add(int64(len(records)))
+	return nil
 }
 
 // WriteJSONL writes records as JSON Lines
-func WriteJSONL(w io.Writer, records []Record, pretty bool) error {
+func WriteJSONL[R Object](w io.Writer, records []R, pretty bool) error {
 	encoder := json.NewEncoder(w)
 	if pretty {
 		encoder.SetIndent("", "  ")
@@ -253,6 +663,7 @@ func WriteJSONL(w io.Writer, records []Record, pretty bool) error {
 		if err := encoder.Encode(record); err != nil {
 			return err
 		}
+		diag.Counters().Emitted.Add(1)
 	}
 	return nil
 }
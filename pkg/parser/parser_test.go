@@ -1,10 +1,15 @@
 package parser
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewParser(t *testing.T) {
@@ -225,6 +230,614 @@ func TestReadJSONLMalformed(t *testing.T) {
 	}
 }
 
+func TestReadValues(t *testing.T) {
+	tmpDir := t.TempDir()
+	jsonlFile := filepath.Join(tmpDir, "values.jsonl")
+
+	content := `[{"name": "Alice"}, {"name": "Bob"}]
+{"name": "Charlie"}
+42
+"text"`
+	if err := os.WriteFile(jsonlFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser, err := NewParser(jsonlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parser.Close()
+
+	values, err := parser.ReadAllValues()
+	if err != nil {
+		t.Fatalf("ReadAllValues failed: %v", err)
+	}
+	if len(values) != 4 {
+		t.Fatalf("Expected 4 values, got %d", len(values))
+	}
+	if arr, ok := values[0].([]interface{}); !ok || len(arr) != 2 {
+		t.Errorf("Expected first value to be a 2-element array, got %v", values[0])
+	}
+	if rec, ok := values[1].(Record); !ok || rec["name"] != "Charlie" {
+		t.Errorf("Expected second value to be a Record, got %T", values[1])
+	}
+	if values[2] != float64(42) || values[3] != "text" {
+		t.Errorf("Expected scalars 42 and text, got %v and %v", values[2], values[3])
+	}
+
+	// Read only accepts objects
+	if _, err := parser.ReadAll(); err == nil {
+		t.Error("Expected ReadAll to reject non-object records")
+	}
+}
+
+func TestTrackSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"records.jsonl", "{\"id\": 1}\n\n  {\"id\": 2}\n{\"id\": 3}\n"},
+		{"records.json", "#jsl-schema {\"id\":\"int\"}\n[\n  {\"id\": 1},\n\n  {\"id\": 2},\n  {\"id\": 3}\n]"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(tmpDir, tc.name)
+			if err := os.WriteFile(file, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			parser, err := NewParser(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer parser.Close()
+			parser.TrackSources()
+
+			records, err := parser.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+			if len(records) != 3 {
+				t.Fatalf("Expected 3 records, got %d", len(records))
+			}
+			lines := strings.Split(tc.content, "\n")
+			for i, rec := range records {
+				source, ok := rec[SourceField].(map[string]interface{})
+				if !ok {
+					t.Fatalf("Record %d has no source: %v", i, rec)
+				}
+				if source["file"] != file {
+					t.Errorf("Record %d: expected file %s, got %v", i, file, source["file"])
+				}
+				offset := source["offset"].(int64)
+				line := source["line"].(int)
+				if !strings.Contains(lines[line-1], fmt.Sprintf(`{"id": %d}`, i+1)) {
+					t.Errorf("Record %d: line %d is %q", i, line, lines[line-1])
+				}
+				raw, err := ReadAt(file, offset)
+				if err != nil {
+					t.Fatalf("ReadAt failed: %v", err)
+				}
+				if want := fmt.Sprintf(`{"id": %d}`, i+1); string(raw) != want {
+					t.Errorf("Record %d: expected %s at offset %d, got %s", i, want, offset, raw)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMsgpack(t *testing.T) {
+	data := []byte{
+		// {"id": 1, "name": "a", "ts": timestamp 1700000000, "big": 2^60}
+		0x84, 0xa2, 'i', 'd', 0x01, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'a',
+		0xa2, 't', 's', 0xd6, 0xff, 0x65, 0x53, 0xf1, 0x00,
+		0xa3, 'b', 'i', 'g', 0xcf, 0x10, 0, 0, 0, 0, 0, 0, 0,
+		// {"id": -2, 1: [true, nil, 1.5], "raw": bin, "et": EventTime 1.5s}
+		0x84, 0xa2, 'i', 'd', 0xfe, 0x01, 0x93, 0xc3, 0xc0, 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa3, 'r', 'a', 'w', 0xc4, 0x02, 0xff, 0xfe,
+		0xa2, 'e', 't', 0xd7, 0x00, 0, 0, 0, 1, 0x1d, 0xcd, 0x65, 0x00,
+		// a scalar
+		0xa5, 'h', 'e', 'l', 'l', 'o',
+	}
+
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "events.msgpack")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Other files, like standard input, are recognized by their first byte
+	sniffed := filepath.Join(tmpDir, "events.bin")
+	if err := os.WriteFile(sniffed, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{file, sniffed} {
+		parser, err := NewParser(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer parser.Close()
+
+		values, err := parser.ReadAllValues()
+		if err != nil {
+			t.Fatalf("ReadAllValues failed: %v", err)
+		}
+		if !parser.IsMsgpack() {
+			t.Errorf("%s: expected MessagePack input", name)
+		}
+		if len(values) != 3 {
+			t.Fatalf("Expected 3 values, got %d", len(values))
+		}
+		first, ok := values[0].(Record)
+		if !ok {
+			t.Fatalf("Expected a Record, got %T", values[0])
+		}
+		if first["id"] != float64(1) || first["name"] != "a" || first["big"] != int64(1)<<60 {
+			t.Errorf("Unexpected record %v", first)
+		}
+		if ts, ok := first["ts"].(time.Time); !ok || !ts.Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("Expected timestamp, got %v", first["ts"])
+		}
+		second := values[1].(Record)
+		if fmt.Sprint(second["id"], second["1"], second["raw"]) != "-2 [true <nil> 1.5] [255 254]" {
+			t.Errorf("Unexpected record %v", second)
+		}
+		if et, ok := second["et"].(time.Time); !ok || !et.Equal(time.Unix(1, 500000000)) {
+			t.Errorf("Expected EventTime, got %v", second["et"])
+		}
+		if values[2] != "hello" {
+			t.Errorf("Expected scalar hello, got %v", values[2])
+		}
+	}
+
+	// A truncated value is an error
+	truncated := filepath.Join(tmpDir, "truncated.msgpack")
+	if err := os.WriteFile(truncated, data[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewParser(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parser.Close()
+	if _, err := parser.ReadAllValues(); err == nil {
+		t.Error("Expected error for truncated MessagePack input")
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	tmpDir := t.TempDir()
+	jsonFile := filepath.Join(tmpDir, "ordered.jsonl")
+	content := `#jsl-schema {"id":"int"}
+{"z":1,"id":7,"a":{"y":true,"b":[{"q":1,"c":2}]},"z":3}
+`
+	if err := os.WriteFile(jsonFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// {"z": 1, "a": {"y": 2, "b": 3}}
+	msgpackFile := filepath.Join(tmpDir, "ordered.msgpack")
+	data := []byte{0x82, 0xa1, 'z', 0x01, 0xa1, 'a', 0x82, 0xa1, 'y', 0x02, 0xa1, 'b', 0x03}
+	if err := os.WriteFile(msgpackFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		// A repeated key keeps its first position and its last value
+		{jsonFile, `{"z":3,"id":7,"a":{"y":true,"b":[{"q":1,"c":2}]}}`},
+		{msgpackFile, `{"z":1,"a":{"y":2,"b":3}}`},
+	}
+	for _, tt := range tests {
+		p, err := NewParser(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		records, err := p.ReadAllOrdered()
+		if err != nil {
+			t.Fatalf("%s: ReadAllOrdered failed: %v", tt.file, err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("%s: expected 1 record, got %d", tt.file, len(records))
+		}
+		if got := records[0].String(); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.file, tt.expected, got)
+		}
+	}
+
+	// The schema still applies to ordered records
+	p, err := NewParser(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.PreserveOrder()
+	v, err := p.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := v.(OrderedMap).Get("id"); id != int64(7) {
+		t.Errorf("Expected id int64(7), got %T(%v)", id, id)
+	}
+
+	// Read still returns records, without the order
+	p, err = NewParser(`{"b":1,"a":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.PreserveOrder()
+	record, err := p.Read()
+	if err != nil || record["a"] != float64(2) {
+		t.Errorf("Expected a record, got %v, %v", record, err)
+	}
+}
+
+func TestPreserveOrderReuse(t *testing.T) {
+	// Records decoded through reused buffers must not share them
+	lines := []string{
+		`{"k":"x<y","a":{},"b":[],"c":[{"d":[1,{"e":null}]}]}`,
+		`{"k":"second","a":{"f":[[]]},"b":[true]}`,
+		`{"k":"third longer line","k":"last"}`,
+	}
+	for _, prefilter := range []bool{false, true} {
+		p := NewReaderParser("<memory>", strings.NewReader(strings.Join(lines, "\n")))
+		p.PreserveOrder()
+		if prefilter {
+			p.Prefilter([]string{`"k"`})
+		}
+		var records []OrderedMap
+		for {
+			v, err := p.ReadValue()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, v.(OrderedMap))
+		}
+		expected := []string{
+			`{"k":"x\u003cy","a":{},"b":[],"c":[{"d":[1,{"e":null}]}]}`,
+			`{"k":"second","a":{"f":[[]]},"b":[true]}`,
+			`{"k":"last"}`,
+		}
+		if len(records) != len(expected) {
+			t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+		}
+		for i, record := range records {
+			if got := record.String(); got != expected[i] {
+				t.Errorf("prefilter %v: expected %s, got %s", prefilter, expected[i], got)
+			}
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{a: 1, b_2: [1, 2,], $c: {d: true,},}", `{"$c":{"d":true},"a":1,"b_2":[1,2]}`},
+		{"{ // header\n\"a\": 1 /* inline */, /* before key */ b: null}", `{"a":1,"b":null}`},
+		// Comment markers, commas and identifiers inside strings stay as they are
+		{`{"url": "http://x/*y*/", "s": "a,}", k: "b: c"}`, `{"k":"b: c","s":"a,}","url":"http://x/*y*/"}`},
+		{"[{a: 1}, {a: 2},]", `{"a":1}{"a":2}`},
+	}
+	for _, tt := range tests {
+		p, err := NewParser(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		p.Lenient()
+		values, err := p.ReadAllValues()
+		if err != nil {
+			t.Errorf("%q: ReadAllValues failed: %v", tt.input, err)
+			continue
+		}
+		var got strings.Builder
+		for _, v := range values {
+			data, _ := json.Marshal(v)
+			got.Write(data)
+		}
+		if got.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, got.String())
+		}
+	}
+
+	// Strict parsing rejects the same input
+	p, err := NewParser("{a: 1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if _, err := p.ReadValue(); err == nil {
+		t.Error("Expected an error for an unquoted key without Lenient")
+	}
+
+	// .jsonc files are lenient, and comments keep the record lines
+	file := filepath.Join(t.TempDir(), "settings.jsonc")
+	content := "/* settings\n   v2 */\n{a: 1,}\n// next\n{a: 2}\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewParser(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.TrackPositions()
+	for _, line := range []int{3, 5} {
+		if _, err := p.ReadValue(); err != nil {
+			t.Fatalf("ReadValue failed: %v", err)
+		}
+		if _, got := p.Position(); got != line {
+			t.Errorf("Expected record at line %d, got %d", line, got)
+		}
+	}
+
+	p, err = NewParser(`{"a": /* never closed`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Lenient()
+	if _, err := p.ReadValue(); err == nil || !strings.Contains(err.Error(), "unterminated") {
+		t.Errorf("Expected an unterminated comment error, got %v", err)
+	}
+}
+
+func TestSkipErrors(t *testing.T) {
+	content := `#jsl-schema {"id":"int"}
+{"id":1}
+{"id":2,
+
+{"id":3} trailing
+{"id":4}`
+	file := filepath.Join(t.TempDir(), "corrupt.jsonl")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewParser(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	var skipped []string
+	p.SkipErrors(func(name string, line int, data []byte, err error) {
+		skipped = append(skipped, fmt.Sprintf("%d:%s", line, data))
+	})
+	p.TrackPositions()
+
+	var ids, lines []string
+	for {
+		record, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		_, line := p.Position()
+		ids = append(ids, fmt.Sprint(record["id"]))
+		lines = append(lines, fmt.Sprint(line))
+	}
+	if got := strings.Join(ids, ","); got != "1,4" {
+		t.Errorf("Expected records 1,4, got %s", got)
+	}
+	if got := strings.Join(lines, ","); got != "2,6" {
+		t.Errorf("Expected lines 2,6, got %s", got)
+	}
+	if got := strings.Join(skipped, "|"); got != `3:{"id":2,|5:{"id":3} trailing` {
+		t.Errorf("Unexpected skipped lines %s", got)
+	}
+
+	// Without SkipErrors the first malformed line fails the read
+	p, err = NewParser(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if _, err := p.ReadAllValues(); err == nil {
+		t.Error("Expected a decoding error")
+	}
+}
+
+func TestDecodeErrorLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		ordered bool
+		want    string
+	}{
+		{"jsonl", "{\"a\":1}\n{\"a\":2,,\"b\":3}\n", false,
+			`at line 2, offset 15: invalid character ',' looking for beginning of object key string (near "{\"a\":2,,\"b\":3}")`},
+		{"ordered", "{\"a\":1}\n{\"a\" 2}\n", true,
+			`at line 2, offset 13: invalid character '2' after object key (near "{\"a\" 2}")`},
+		{"array", "[{\"a\":1},\n {\"a\": tru}]", false,
+			`at line 2, offset 20: invalid character '}' in literal true (expecting 'e') (near " {\"a\": tru}]")`},
+		{"long line", "{\"a\":\"" + strings.Repeat("x", 50) + "\",\"b\":?,\"c\":\"" + strings.Repeat("y", 50) + "\"}", false,
+			`at line 1, offset 62: invalid character '?' looking for beginning of value (near "...xxxxxxxxxxxxxxxxxxxxxxxxxx\",\"b\":?,\"c\":\"yyyyyyyyyyyyyyyyyyyyyyyyy...")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "bad.jsonl")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := NewParser(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if tt.ordered {
+				p.PreserveOrder()
+			}
+			_, err = p.ReadAllValues()
+			if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+				t.Errorf("Expected an error ending with %s, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRoot(t *testing.T) {
+	content := `{"meta": {"skip": [1, {"items": []}]}, "data": {"pages": [{}, {"items": [
+  {"id": 1},
+  {"id": 2}
+]}]}, "after": "ignored"}`
+	file := filepath.Join(t.TempDir(), "dump.json")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		root []string
+		want string
+	}{
+		{[]string{"data", "pages", "1", "items"}, "1,2"},
+		{[]string{"meta", "skip"}, "1,map[items:[]]"},
+		{[]string{"data", "missing"}, "root .data.missing not found"},
+		{[]string{"data", "pages", "2"}, "root .data.pages.2 not found"},
+		{[]string{"data"}, "root .data is not an array"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.root, "."), func(t *testing.T) {
+			p, err := NewParser(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			p.Root(tt.root)
+			p.TrackPositions()
+
+			var got []string
+			for {
+				value, err := p.ReadValue()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					got = []string{err.Error()}
+					break
+				}
+				if record, ok := value.(Record); ok && record["id"] != nil {
+					_, line := p.Position()
+					if line != len(got)+2 {
+						t.Errorf("Expected record %v on line %d, got %d", record, len(got)+2, line)
+					}
+					value = record["id"]
+				}
+				got = append(got, fmt.Sprint(value))
+			}
+			if s := strings.Join(got, ","); s != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, s)
+			}
+		})
+	}
+}
+
+func TestDetectJSONL(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		jsonl   bool
+		values  int
+	}{
+		{"records.txt", "{\"a\": 1}\n{\"a\": 2}\n", true, 2},
+		{"arrays", "\n[1, 2]\n\n[3]\n", true, 2},
+		{"header.log", "#jsl-schema {\"a\":\"int\"}\n{\"a\": 1}\n{\"a\": 2}", true, 2},
+		{"array.txt", "[{\"a\": 1}, {\"a\": 2}]\n", false, 2},
+		{"pretty.txt", "{\n  \"a\": 1\n}\n{\n  \"a\": 2\n}\n", false, 2},
+		{"single.txt", "{\"a\": 1}\n", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := NewParser(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			values, err := p.ReadAllValues()
+			if err != nil {
+				t.Fatalf("ReadAllValues failed: %v", err)
+			}
+			if len(values) != tt.values {
+				t.Errorf("Expected %d values, got %d", tt.values, len(values))
+			}
+			if p.IsJSONL() != tt.jsonl {
+				t.Errorf("Expected IsJSONL %v, got %v", tt.jsonl, p.IsJSONL())
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.json")
+	if err := os.WriteFile(small, []byte(`[{"a":1}, {"a":22}, {"a":3}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	huge := filepath.Join(dir, "huge.jsonl")
+	line := `{"a":"` + strings.Repeat("x", 1<<20) + `"}`
+	if err := os.WriteFile(huge, []byte("{\"a\":1}\n"+line+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		file   string
+		limits Limits
+		skip   bool
+		want   string
+	}{
+		{"within limits", small, Limits{MaxRecordSize: 8, MaxRecords: 3, MaxBytes: 28}, false, ""},
+		{"record size", small, Limits{MaxRecordSize: 7}, false, "the record of " + small + " at line 1, offset 10 is larger than 7 bytes"},
+		{"records", small, Limits{MaxRecords: 2}, false, small + " holds more than 2 records"},
+		{"bytes", small, Limits{MaxBytes: 27}, false, small + " is larger than 27 bytes"},
+		// The huge line is not read whole, even when skipping malformed lines
+		{"huge line", huge, Limits{MaxRecordSize: 1024}, false, "at line 2, offset 8 is larger than 1024 bytes"},
+		{"huge skipped line", huge, Limits{MaxRecordSize: 1024}, true, "at line 2, offset 8 is larger than 1024 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewParser(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if tt.skip {
+				p.SkipErrors(func(string, int, []byte, error) {})
+			}
+			err = p.SetLimits(tt.limits)
+			if err == nil {
+				_, err = p.ReadAllValues()
+			}
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrLimitExceeded) || !strings.HasSuffix(err.Error(), tt.want) {
+				t.Fatalf("Expected a limit error ending with %q, got %v", tt.want, err)
+			}
+			if p.counter.n > 1<<19 {
+				t.Errorf("Expected the read to stop early, read %d bytes", p.counter.n)
+			}
+		})
+	}
+}
+
 func TestReadJSONLEmptyLines(t *testing.T) {
 	tmpDir := t.TempDir()
 	jsonlFile := filepath.Join(tmpDir, "empty_lines.jsonl")
@@ -275,6 +888,26 @@ func TestInlineJSON(t *testing.T) {
 	}
 }
 
+func TestReaderParser(t *testing.T) {
+	parser := NewReaderParser("<memory>", strings.NewReader("{\"a\": 1}\n{\"a\": 2}\n"))
+	defer parser.Close()
+	parser.TrackSources()
+
+	records, err := parser.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(records) != 2 || records[1]["a"] != float64(2) {
+		t.Fatalf("Expected the 2 records of the reader, got %v", records)
+	}
+	if !parser.IsJSONL() {
+		t.Error("Expected the reader to be detected as JSONL")
+	}
+	if source, _ := records[0][SourceField].(map[string]interface{}); source["file"] != "<memory>" {
+		t.Errorf("Expected the source to name the reader, got %v", records[0][SourceField])
+	}
+}
+
 func TestEmptyFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	jsonFile := filepath.Join(tmpDir, "empty.json")
@@ -392,3 +1025,153 @@ func TestReadStreaming(t *testing.T) {
 		}
 	})
 }
+
+func TestReadSchemaHeader(t *testing.T) {
+	tmpDir := t.TempDir()
+	jsonlFile := filepath.Join(tmpDir, "typed.jsonl")
+	content := `#jsl-schema {"id":"int","ts":"timestamp"}
+{"id": 9007199254740993, "ts": "2024-01-02T03:04:05Z", "score": 1.5, "tags": [1, 2]}
+{"id": 2, "ts": "2024-01-03T00:00:00Z", "score": 2}`
+	if err := os.WriteFile(jsonlFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser, err := NewParser(jsonlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parser.Close()
+
+	records, err := parser.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if parser.Schema()["id"] != TypeInt {
+		t.Errorf("Expected schema to declare id as int, got %v", parser.Schema())
+	}
+	if id, ok := records[0]["id"].(int64); !ok || id != 9007199254740993 {
+		t.Errorf("Expected exact int64 id, got %T %v", records[0]["id"], records[0]["id"])
+	}
+	if ts, ok := records[0]["ts"].(time.Time); !ok || ts.Year() != 2024 {
+		t.Errorf("Expected timestamp, got %T %v", records[0]["ts"], records[0]["ts"])
+	}
+	if _, ok := records[0]["score"].(float64); !ok {
+		t.Errorf("Expected undeclared number to stay float64, got %T", records[0]["score"])
+	}
+	if _, ok := records[0]["tags"].([]interface{})[0].(float64); !ok {
+		t.Errorf("Expected nested numbers to stay float64")
+	}
+
+	if _, err := ParseSchemaHeader(`#jsl-schema {"id":"uuid"}`); err == nil {
+		t.Error("Expected error for unknown schema type")
+	}
+}
+
+func TestRange(t *testing.T) {
+	content := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":,}\n"
+	file := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := IsLineDelimited(file, false)
+	if err != nil || !lines {
+		t.Fatalf("expected JSONL content to be line delimited, got %v, %v", lines, err)
+	}
+
+	read := func(start, end int64) (string, error) {
+		p, err := NewParser(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		if err := p.Range(start, end); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for {
+			v, err := p.Read()
+			if err == io.EOF {
+				return strings.Join(ids, ","), nil
+			}
+			if err != nil {
+				return strings.Join(ids, ","), err
+			}
+			ids = append(ids, fmt.Sprint(v["id"]))
+		}
+	}
+	if got, err := read(0, 18); got != "1,2" || err != nil {
+		t.Errorf("range [0, 18): got %q, %v", got, err)
+	}
+	if got, err := read(18, 27); got != "3" || err != nil {
+		t.Errorf("range [18, 27): got %q, %v", got, err)
+	}
+	want := `failed to decode JSONL record at offset 33: invalid character ',' looking for beginning of value (near "{\"id\":,}")`
+	if got, err := read(18, int64(len(content))); got != "3" || err == nil || err.Error() != want {
+		t.Errorf("range [18, end): got %q, %v, want the error %s", got, err, want)
+	}
+
+	document := filepath.Join(t.TempDir(), "doc.json")
+	if err := os.WriteFile(document, []byte("[\n{\"id\":1},\n{\"id\":2}\n]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := IsLineDelimited(document, false); err != nil || lines {
+		t.Errorf("expected a JSON array not to be line delimited, got %v, %v", lines, err)
+	}
+}
+
+func TestPrefilter(t *testing.T) {
+	content := "{\"a\":\"x\",\"n\":1}\n{\"a\":\"y\",\"n\":2}\n{\n  \"a\": \"x\",\n  \"n\": 3\n}\n" +
+		"{\"a\":\"y\",\"n\":4} {\"a\":\"x\",\"n\":5}\n{\"a\":\"\\u0078\",\"n\":6}\n{\"a\":\"y\",\"n\":7}\n"
+	file := filepath.Join(t.TempDir(), "data.jsonl")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewParser(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.TrackPositions()
+	p.Prefilter([]string{"x"})
+
+	// Records spanning lines, several on a line and escaped ones are decoded
+	var got []string
+	for {
+		v, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, line := p.Position()
+		got = append(got, fmt.Sprintf("%v@%d", v["n"], line))
+	}
+	want := "1@1 3@3 4@7 5@7 6@8"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if p.records != 7 {
+		t.Errorf("expected 7 records counted, got %d", p.records)
+	}
+
+	// Decoding errors keep their location
+	file = filepath.Join(t.TempDir(), "bad.jsonl")
+	if err := os.WriteFile(file, []byte("{\"a\":\"y\"}\n{\"a\":\"x\",}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewParser(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Prefilter([]string{"x"})
+	_, err = p.Read()
+	wantErr := `failed to decode JSONL record at line 2, offset 19: invalid character '}' looking for beginning of object key string (near "{\"a\":\"x\",}")`
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got %v, want %s", err, wantErr)
+	}
+}
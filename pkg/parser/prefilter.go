@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Prefilter makes the parser skip, without decoding them, the records of
+// JSON Lines input whose raw bytes lack any of needles: searching a line is
+// much cheaper than decoding it, which pays off for selective queries over
+// large logs. A record holding an escape sequence is always decoded, as a
+// needle may be escaped in it, so the records read must still be tested.
+// Skipped records count as read, and against Limits.MaxRecords.
+//
+// Prefiltering applies to JSONL input without a schema header, Root or
+// SkipErrors; other inputs are decoded in full. It must be called before the
+// first read.
+func (p *Parser) Prefilter(needles []string) {
+	p.needles = nil
+	for _, needle := range needles {
+		if needle != "" {
+			p.needles = append(p.needles, []byte(needle))
+		}
+	}
+}
+
+// prefiltered reports whether values are read by readPrefiltered
+func (p *Parser) prefiltered() bool {
+	return len(p.needles) > 0 && p.isJSONL && p.schema == nil && p.root == nil && p.skipErrors == nil
+}
+
+// mayHold reports whether a raw record may hold every needle
+func (p *Parser) mayHold(record []byte) bool {
+	if bytes.IndexByte(record, '\\') >= 0 {
+		return true
+	}
+	for _, needle := range p.needles {
+		if !bytes.Contains(record, needle) {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingValue is a value decoded from a record, and the offset where it
+// starts; a line may hold several values
+type pendingValue struct {
+	value  interface{}
+	offset int64
+}
+
+// readPrefiltered reads the next value of JSON Lines input, skipping the
+// records that cannot hold the needles
+func (p *Parser) readPrefiltered() (interface{}, error) {
+	for p.nextPending >= len(p.pending) {
+		p.pending, p.nextPending = p.pending[:0], 0
+		start, record, err := p.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if !p.mayHold(record) {
+			if err := p.countRecord(); err != nil {
+				return nil, err
+			}
+			p.countRead()
+			continue
+		}
+		if err := p.decodeRecord(start, record); err != nil {
+			return nil, err
+		}
+	}
+	next := p.pending[p.nextPending]
+	p.pending[p.nextPending] = pendingValue{}
+	p.nextPending++
+	if p.positions {
+		p.offset, p.line = next.offset, p.counter.lineAt(next.offset)
+	}
+	return next.value, nil
+}
+
+// readRecord reads the next line holding a value, and the lines that follow
+// it while the value spans them. It returns the record and its offset. The
+// record is read into a buffer that the next call reuses.
+func (p *Parser) readRecord() (int64, []byte, error) {
+	var scanner recordScanner
+	start := int64(-1)
+	record, skip := p.recordBuf[:0], 0
+	for {
+		offset := p.counter.n - int64(p.bufReader.Buffered())
+		if start < 0 {
+			p.startRecord(offset)
+		}
+		n := len(record)
+		var err error
+		record, err = p.appendLine(record)
+		p.recordBuf = record
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		data := record[n:]
+		if start < 0 {
+			line := bytes.TrimLeft(data, " \t\r\n")
+			if len(line) == 0 {
+				if err != nil {
+					return 0, nil, err
+				}
+				record = record[:0]
+				continue
+			}
+			skip = len(data) - len(line)
+			start = offset + int64(skip)
+			p.startRecord(start)
+		}
+		// A value left incomplete at the end of the input fails to decode
+		if scanner.scan(data) || err != nil {
+			record = bytes.TrimRight(record[skip:], " \t\r\n")
+			if p.limits.MaxRecordSize > 0 {
+				if err := p.endRecord(start + int64(len(record))); err != nil {
+					return 0, nil, err
+				}
+			}
+			return start, record, nil
+		}
+	}
+}
+
+// appendLine appends the next line of the input, with its newline, to buf
+func (p *Parser) appendLine(buf []byte) ([]byte, error) {
+	for {
+		data, err := p.bufReader.ReadSlice('\n')
+		buf = append(buf, data...)
+		if err != bufio.ErrBufferFull {
+			return buf, err
+		}
+	}
+}
+
+// decodeRecord decodes the values of a record read at offset start
+func (p *Parser) decodeRecord(start int64, record []byte) error {
+	p.recordReader.Reset(record)
+	dec := json.NewDecoder(&p.recordReader)
+	for {
+		at := dec.InputOffset()
+		rest := bytes.TrimLeft(record[at:], " \t\r\n")
+		if len(rest) == 0 {
+			return nil
+		}
+		value, err := p.decode(dec)
+		if err != nil {
+			offset := start + dec.InputOffset()
+			if syntax, ok := err.(*json.SyntaxError); ok {
+				offset = start + max(syntax.Offset-1, 0)
+			}
+			return p.errorAt(offset, unexpectedEOF(err))
+		}
+		p.pending = append(p.pending, pendingValue{value: value, offset: start + int64(len(record)-len(rest))})
+	}
+}
+
+// recordScanner follows the nesting of the lines of a record, to tell where
+// a value spanning several lines ends
+type recordScanner struct {
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+// scan reads the next line of a record, reporting whether the values begun
+// are complete
+func (s *recordScanner) scan(line []byte) bool {
+	for _, c := range line {
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case s.inString:
+			if c == '\\' {
+				s.escaped = true
+			} else if c == '"' {
+				s.inString = false
+			}
+		case c == '"':
+			s.inString = true
+		case c == '{' || c == '[':
+			s.depth++
+		case c == '}' || c == ']':
+			s.depth--
+		}
+	}
+	return s.depth <= 0 && !s.inString
+}
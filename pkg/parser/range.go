@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+)
+
+// Range restricts the parser to the bytes [start, end) of a JSONL file, so
+// that the parts of a large file can be decoded in parallel. The range must
+// hold whole lines. Errors report offsets in the file but, past the first
+// range, no line numbers. It must be called before the first read.
+func (p *Parser) Range(start, end int64) error {
+	seeker, ok := p.input.(io.Seeker)
+	if p.isMsgpack || !ok || end < start {
+		return fmt.Errorf("cannot read a range of %s", p.name)
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	p.rangeStart, p.rangeEnd = start, end
+	p.isJSONL, p.jsonlChecked, p.formatChecked = true, true, true
+	// A schema header can only be on the first line
+	p.headerChecked = start > 0
+	p.initReader()
+	return nil
+}
+
+// fileOffset returns the offset in the file of an offset of the input read
+func (p *Parser) fileOffset(offset int64) int64 {
+	return p.rangeStart + offset
+}
+
+// IsLineDelimited reports whether a file is read as JSONL without a schema
+// header, so that its lines can be decoded independently (Range). It reads
+// no more than the start of the file.
+func IsLineDelimited(filename string, lenient bool) (bool, error) {
+	p, err := NewParser(filename)
+	if err != nil {
+		return false, err
+	}
+	// Closed directly: probing is not reading, for the counters
+	if c, ok := p.input.(io.Closer); ok {
+		defer c.Close()
+	}
+	if lenient {
+		p.Lenient()
+	}
+	p.detectMsgpack()
+	if p.isMsgpack {
+		return false, nil
+	}
+	if err := p.readSchemaHeader(); err != nil || p.schema != nil {
+		return false, err
+	}
+	if err := p.detectJSONL(); err != nil {
+		return false, err
+	}
+	return p.isJSONL, nil
+}
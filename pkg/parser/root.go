@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Root makes the parser stream the elements of the array at path (object
+// keys, or indexes of enclosing arrays) in the first JSON document, instead
+// of the document itself: {"meta": ..., "items": [...]} read with root
+// ["items"] yields the items one at a time. Values before the array are
+// skipped, and the input ends with the array. It must be called before the
+// first read.
+func (p *Parser) Root(path []string) {
+	p.root = path
+}
+
+// enterRoot moves the decoder inside the array at the root path
+func (p *Parser) enterRoot() error {
+	p.startArrayChecked = true
+	for i, key := range p.root {
+		t, err := p.decoder.Token()
+		if err != nil {
+			return p.rootError(err)
+		}
+		found := false
+		switch t {
+		case json.Delim('{'):
+			for p.decoder.More() {
+				k, err := p.decoder.Token()
+				if err != nil {
+					return p.rootError(err)
+				}
+				if k == key {
+					found = true
+					break
+				}
+				if err := p.skipValue(); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 {
+				break
+			}
+			for ; index > 0 && p.decoder.More(); index-- {
+				if err := p.skipValue(); err != nil {
+					return err
+				}
+			}
+			found = p.decoder.More()
+		}
+		if !found {
+			return fmt.Errorf("root %s not found", formatRoot(p.root[:i+1]))
+		}
+	}
+
+	t, err := p.decoder.Token()
+	if err != nil {
+		return p.rootError(err)
+	}
+	if t != json.Delim('[') {
+		return fmt.Errorf("root %s is not an array", formatRoot(p.root))
+	}
+	p.inArray = true
+	p.isArray = true
+	return nil
+}
+
+// skipValue decodes and discards the next value
+func (p *Parser) skipValue() error {
+	var raw json.RawMessage
+	if err := p.decoder.Decode(&raw); err != nil {
+		return p.rootError(err)
+	}
+	return nil
+}
+
+// rootError reports a decoding error met before the root array
+func (p *Parser) rootError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return p.decodeError(err)
+}
+
+// formatRoot formats a root path as a dotted path
+func formatRoot(path []string) string {
+	return "." + strings.Join(path, ".")
+}
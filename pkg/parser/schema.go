@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/diag"
+)
+
+// SchemaHeaderPrefix marks the optional first line of a stream that describes
+// the types of the fields in the records that follow, e.g.
+//
+//	#jsl-schema {"id":"int","ts":"timestamp"}
+//
+// jsl emits it with --schema-header and consumes it transparently, so chained
+// invocations keep integers and timestamps instead of re-inferring them.
+const SchemaHeaderPrefix = "#jsl-schema"
+
+// Field types understood by schema headers
+const (
+	TypeInt       = "int"
+	TypeFloat     = "float"
+	TypeString    = "string"
+	TypeBool      = "bool"
+	TypeTimestamp = "timestamp"
+	TypeObject    = "object"
+	TypeArray     = "array"
+)
+
+// Schema maps top-level field names to their declared types
+type Schema map[string]string
+
+// ParseSchemaHeader parses a "#jsl-schema {...}" line
+func ParseSchemaHeader(line string) (Schema, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, SchemaHeaderPrefix) {
+		return nil, fmt.Errorf("invalid schema header: %q", line)
+	}
+	body := strings.TrimSpace(strings.TrimPrefix(line, SchemaHeaderPrefix))
+
+	var schema Schema
+	if err := json.Unmarshal([]byte(body), &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema header: %w", err)
+	}
+	for field, typ := range schema {
+		switch typ {
+		case TypeInt, TypeFloat, TypeString, TypeBool, TypeTimestamp, TypeObject, TypeArray:
+		default:
+			return nil, fmt.Errorf("invalid schema header: unknown type %q for field '%s'", typ, field)
+		}
+	}
+	return schema, nil
+}
+
+// Header renders the schema as a header line (without trailing newline)
+func (s Schema) Header() (string, error) {
+	body, err := json.Marshal(map[string]string(s))
+	if err != nil {
+		return "", err
+	}
+	return SchemaHeaderPrefix + " " + string(body), nil
+}
+
+// Apply converts the values of a record decoded with json.Decoder.UseNumber
+// to the types declared by the schema. Numbers of undeclared fields become float64.
+func (s Schema) Apply(record Record) error {
+	for field, val := range record {
+		converted, err := s.convert(field, val)
+		if err != nil {
+			return err
+		}
+		record[field] = converted
+	}
+	return nil
+}
+
+// ApplyOrdered is Apply for a record decoded with PreserveOrder
+func (s Schema) ApplyOrdered(record OrderedMap) error {
+	for i, kv := range record {
+		converted, err := s.convert(kv.Key, kv.Val)
+		if err != nil {
+			return err
+		}
+		record[i].Val = converted
+	}
+	return nil
+}
+
+func (s Schema) convert(field string, val interface{}) (interface{}, error) {
+	switch s[field] {
+	case TypeInt:
+		if n, ok := val.(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': expected int, got %s", field, n)
+			}
+			return i, nil
+		}
+	case TypeTimestamp:
+		if str, ok := val.(string); ok {
+			ts, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': expected timestamp, got %q", field, str)
+			}
+			return ts, nil
+		}
+	}
+	return normalizeNumbers(field, val), nil
+}
+
+// normalizeNumbers turns json.Number values back into float64, recursively.
+// Integers that float64 cannot represent exactly are reported as coercions.
+func normalizeNumbers(field string, val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		if i, err := v.Int64(); err == nil && int64(f) != i {
+			diag.Warn(diag.CodeCoercion,
+				fmt.Sprintf("field '%s': integer %s loses precision as float (declare it int in the schema header)", field, v),
+				"field", field, "value", v.String())
+		}
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalizeNumbers(field+"."+k, item)
+		}
+		return v
+	case OrderedMap:
+		for i, kv := range v {
+			v[i].Val = normalizeNumbers(field+"."+kv.Key, kv.Val)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(field, item)
+		}
+		return v
+	default:
+		return val
+	}
+}
+
+// TypeOf returns the schema type of a value, or "" if it has none (e.g. null)
+func TypeOf(v interface{}) string {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TypeInt
+	case float32, float64:
+		return TypeFloat
+	case json.Number:
+		if _, err := v.(json.Number).Int64(); err == nil {
+			return TypeInt
+		}
+		return TypeFloat
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	case time.Time:
+		return TypeTimestamp
+	case []interface{}:
+		return TypeArray
+	case nil:
+		return ""
+	default:
+		return TypeObject
+	}
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ErrorHandler receives the lines a parser skips (see SkipErrors): the
+// input name, the line number (from 1), the line and the decoding error
+type ErrorHandler func(name string, line int, data []byte, err error)
+
+// SkipErrors makes the parser pass the lines that fail to decode to handler
+// and go on with the next line, instead of failing. Outside a top-level
+// array, each line is then one record: a record spanning several lines is
+// skipped line by line. It must be called before the first read.
+func (p *Parser) SkipErrors(handler ErrorHandler) {
+	p.skipErrors = handler
+	p.counter.trackLines = true
+}
+
+// readLine reads the next line holding a valid value, skipping the others
+func (p *Parser) readLine() (interface{}, error) {
+	for {
+		offset := p.counter.n - int64(p.bufReader.Buffered())
+		p.startRecord(offset)
+		data, err := p.bufReader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line := bytes.TrimSpace(data)
+		if len(line) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		start := offset + int64(len(data)-len(bytes.TrimLeft(data, " \t\r\n")))
+		lineNumber := p.counter.lineAt(start)
+		if p.limits.MaxRecordSize > 0 {
+			p.startRecord(start)
+			if err := p.endRecord(start + int64(len(line))); err != nil {
+				return nil, err
+			}
+		}
+
+		value, decodeErr := p.decodeLine(line)
+		if decodeErr != nil {
+			p.skipErrors(p.name, lineNumber, line, decodeErr)
+			continue
+		}
+		if p.positions {
+			p.offset, p.line = start, lineNumber
+		}
+		return value, nil
+	}
+}
+
+// decodeLine decodes a line holding exactly one value
+func (p *Parser) decodeLine(line []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	if p.schema != nil {
+		dec.UseNumber()
+	}
+	value, err := p.decode(dec)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if rest := bytes.TrimLeft(line[dec.InputOffset():], " \t\r"); len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected %q after the value", rest[0])
+	}
+	return value, nil
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SourceField is the field TrackSources adds to object records
+const SourceField = "_source"
+
+// TrackSources makes the parser add a SourceField to every object record,
+// locating the record in its input: {"file": name, "offset": byte offset,
+// "line": line number}. The offset and line are those of the record's first
+// byte (MessagePack records have no line); standard input is named "<stdin>"
+// and inline JSON "<inline>".
+func (p *Parser) TrackSources() {
+	p.TrackPositions()
+	p.sources = true
+}
+
+// TrackPositions makes Position report where each value read starts. It
+// must be called before the first read.
+func (p *Parser) TrackPositions() {
+	p.positions = true
+	p.counter.trackLines = true
+}
+
+// Name returns the name of the input: the file name, "<stdin>" or "<inline>"
+func (p *Parser) Name() string {
+	return p.name
+}
+
+// Position returns the byte offset and line number (from 1) where the last
+// value read starts, when positions are tracked. The line is 0 when unknown
+// (MessagePack input).
+func (p *Parser) Position() (offset int64, line int) {
+	return p.offset, p.line
+}
+
+// source returns the SourceField of the last value read
+func (p *Parser) source() map[string]interface{} {
+	source := map[string]interface{}{"file": p.name, "offset": p.offset}
+	if p.line > 0 {
+		source["line"] = p.line
+	}
+	return source
+}
+
+// decodePositioned decodes the next value, recording its position
+func (p *Parser) decodePositioned() (interface{}, error) {
+	var raw json.RawMessage
+	if err := p.decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	// The value ends where the data buffered after it begins
+	end := p.counter.n - int64(p.bufReader.Buffered())
+	if buffered, ok := p.decoder.Buffered().(interface{ Len() int }); ok {
+		end -= int64(buffered.Len())
+	}
+	p.offset = end - int64(len(raw))
+	p.line = p.counter.lineAt(p.offset)
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if p.schema != nil {
+		dec.UseNumber()
+	}
+	return p.decode(dec)
+}
+
+// ReadAt returns the raw JSON value starting at a byte offset of a file, as
+// located by TrackSources. A MessagePack value is returned encoded as JSON.
+func ReadAt(filename string, offset int64) (json.RawMessage, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to offset %d: %w", offset, err)
+	}
+	if isMsgpackFile(filename) {
+		value, err := decodeMsgpack(bufio.NewReader(file), true)
+		if err != nil {
+			return nil, fmt.Errorf("no MessagePack value at offset %d of %s: %w", offset, filename, err)
+		}
+		return json.Marshal(value)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(file).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("no JSON value at offset %d of %s: %w", offset, filename, err)
+	}
+	return raw, nil
+}
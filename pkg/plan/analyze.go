@@ -0,0 +1,129 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// NodeStats are the runtime statistics of a plan node recorded by Analyze
+type NodeStats struct {
+	// Rows counts the rows the node produced
+	Rows int64
+	// Time is the time spent executing the node, its inputs included
+	Time time.Duration
+}
+
+// Analyze wraps every node of the plan so that its rows and execution time
+// are recorded. Once the returned plan has run, FormatPlan prints each node
+// annotated with its statistics (see Stats).
+func Analyze(root Node) Node {
+	mapInputs(root, Analyze)
+	if _, ok := root.(*analyzedNode); ok {
+		return root
+	}
+	return &analyzedNode{Node: root}
+}
+
+// Stats returns the statistics recorded for a node of a plan returned by
+// Analyze, ok being false for other nodes
+func Stats(n Node) (stats NodeStats, ok bool) {
+	analyzed, ok := n.(*analyzedNode)
+	if !ok {
+		return NodeStats{}, false
+	}
+	return analyzed.stats, true
+}
+
+// WalkStats calls f with every node of a plan returned by Analyze, in the
+// order FormatPlan prints them, passing the node without the wrappers of
+// Analyze, Trace and Hook, its depth in the plan (0 for root) and its statistics
+func WalkStats(root Node, f func(n Node, depth int, stats NodeStats)) {
+	walkStats(root, 0, f)
+}
+
+func walkStats(n Node, depth int, f func(Node, int, NodeStats)) {
+	if analyzed, ok := n.(*analyzedNode); ok {
+		f(unwrap(analyzed.Node), depth, analyzed.stats)
+	}
+	for _, child := range n.Children() {
+		walkStats(child, depth+1, f)
+	}
+}
+
+// analyzedNode is a transparent wrapper recording the statistics of the
+// wrapped node. The plan executes in one goroutine (ParallelScanNode merges
+// its workers), so the statistics need no locking.
+type analyzedNode struct {
+	Node
+	stats NodeStats
+}
+
+func (n *analyzedNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	start := time.Now()
+	it, err := n.Node.Execute(ctx)
+	n.stats.Time += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	return &analyzedIterator{RowIterator: it, node: n}, nil
+}
+
+// Explain annotates the explanation of the node with the rows it produced
+// and read from its inputs, and the time spent in it, its inputs excluded
+func (n *analyzedNode) Explain() string {
+	inputs, inputTime := int64(0), time.Duration(0)
+	children := n.Children()
+	for _, child := range children {
+		if stats, ok := Stats(child); ok {
+			inputs += stats.Rows
+			inputTime += stats.Time
+		}
+	}
+	annotation := fmt.Sprintf("rows: %d", n.stats.Rows)
+	if len(children) > 0 {
+		annotation += fmt.Sprintf(", in: %d", inputs)
+	}
+	annotation += ", time: " + formatDuration(n.stats.Time)
+	if len(children) > 0 {
+		annotation += ", self: " + formatDuration(max(n.stats.Time-inputTime, 0))
+	}
+	return n.Node.Explain() + "  [" + annotation + "]"
+}
+
+// formatDuration rounds a duration to three significant digits or so
+// (1.23s, 45.6ms, 789µs)
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
+
+type analyzedIterator struct {
+	database.RowIterator
+	node *analyzedNode
+}
+
+func (it *analyzedIterator) Next() bool {
+	start := time.Now()
+	ok := it.RowIterator.Next()
+	it.node.stats.Time += time.Since(start)
+	if ok {
+		it.node.stats.Rows++
+	}
+	return ok
+}
+
+func (it *analyzedIterator) Close() error {
+	start := time.Now()
+	err := it.RowIterator.Close()
+	it.node.stats.Time += time.Since(start)
+	return err
+}
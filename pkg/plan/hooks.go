@@ -0,0 +1,137 @@
+package plan
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// Hooks observe the execution of a plan wrapped by Hook, e.g. to record
+// OpenTelemetry spans or log at debug level. The hooks of a node are called
+// from the goroutine reading its rows; nodes read by the workers of a
+// parallel scan are not hooked separately. Hooks shared by plans executing
+// concurrently must be safe for concurrent use.
+type Hooks interface {
+	// OnScanStart is called before a scan node (Scan, ParallelScan) opens
+	// its table
+	OnScanStart(ctx context.Context, n Node)
+	// OnRow is called with every row a node produces
+	OnRow(ctx context.Context, n Node, row database.Row)
+	// OnNodeFinish is called once the rows of a node are closed, with the
+	// rows it produced and the time spent in it (its inputs included), and
+	// the error that ended its rows or failed its execution
+	OnNodeFinish(ctx context.Context, n Node, stats NodeStats, err error)
+}
+
+// NopHooks implements Hooks doing nothing, to be embedded by the hooks that
+// need only some of the calls
+type NopHooks struct{}
+
+func (NopHooks) OnScanStart(context.Context, Node)                    {}
+func (NopHooks) OnRow(context.Context, Node, database.Row)            {}
+func (NopHooks) OnNodeFinish(context.Context, Node, NodeStats, error) {}
+
+// LogHooks returns hooks logging the scans started and the nodes finished
+// to logger at debug level (rows are not logged)
+func LogHooks(logger *slog.Logger) Hooks {
+	return &logHooks{logger: logger}
+}
+
+type logHooks struct {
+	NopHooks
+	logger *slog.Logger
+}
+
+func (h *logHooks) OnScanStart(ctx context.Context, n Node) {
+	h.logger.DebugContext(ctx, "scan started", "node", n.Explain())
+}
+
+func (h *logHooks) OnNodeFinish(ctx context.Context, n Node, stats NodeStats, err error) {
+	attrs := []any{"node", n.Explain(), "rows", stats.Rows, "duration", stats.Time}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	h.logger.DebugContext(ctx, "node finished", attrs...)
+}
+
+// Hook wraps every node of the plan so that h observes its execution. The
+// nodes passed to h are those of the plan before Hook.
+func Hook(root Node, h Hooks) Node {
+	mapInputs(root, func(input Node) Node {
+		return Hook(input, h)
+	})
+	return &hookedNode{Node: root, hooks: h}
+}
+
+// hookedNode is a transparent wrapper calling hooks around the execution of
+// the wrapped node
+type hookedNode struct {
+	Node
+	hooks Hooks
+}
+
+func (n *hookedNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	switch unwrap(n.Node).(type) {
+	case *ScanNode, *ParallelScanNode:
+		n.hooks.OnScanStart(ctx, n.Node)
+	}
+	start := time.Now()
+	it, err := n.Node.Execute(ctx)
+	if err != nil {
+		n.hooks.OnNodeFinish(ctx, n.Node, NodeStats{Time: time.Since(start)}, err)
+		return nil, err
+	}
+	return &hookedIterator{RowIterator: it, node: n, ctx: ctx, stats: NodeStats{Time: time.Since(start)}}, nil
+}
+
+// unwrap returns the node wrapped by Trace, Analyze and Hook
+func unwrap(n Node) Node {
+	for {
+		switch node := n.(type) {
+		case *tracedNode:
+			n = node.Node
+		case *analyzedNode:
+			n = node.Node
+		case *hookedNode:
+			n = node.Node
+		default:
+			return n
+		}
+	}
+}
+
+type hookedIterator struct {
+	database.RowIterator
+	node     *hookedNode
+	ctx      context.Context
+	stats    NodeStats
+	finished bool
+}
+
+func (it *hookedIterator) Next() bool {
+	start := time.Now()
+	ok := it.RowIterator.Next()
+	it.stats.Time += time.Since(start)
+	if ok {
+		it.stats.Rows++
+		it.node.hooks.OnRow(it.ctx, it.node.Node, it.RowIterator.Row())
+	}
+	return ok
+}
+
+func (it *hookedIterator) Close() error {
+	start := time.Now()
+	err := it.RowIterator.Close()
+	it.stats.Time += time.Since(start)
+	if !it.finished {
+		it.finished = true
+		finishErr := it.RowIterator.Error()
+		if finishErr == nil {
+			finishErr = err
+		}
+		it.node.hooks.OnNodeFinish(it.ctx, it.node.Node, it.stats, finishErr)
+	}
+	return err
+}
@@ -1,41 +1,116 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/bisegni/jsl/pkg/query"
 )
 
+// getField resolves a field on a row, honoring case-insensitive matching when requested
+func getField(row database.Row, path string, filter query.Expression, caseInsensitive bool) (interface{}, error) {
+	if caseInsensitive {
+		if r, ok := row.(database.CaseInsensitiveRow); ok {
+			return r.GetCaseInsensitive(path, filter)
+		}
+	}
+	return row.GetWithFilter(path, filter)
+}
+
+// toRecord converts a row primitive to a map that expressions can evaluate
+func toRecord(primitive interface{}) (map[string]interface{}, bool) {
+	switch v := primitive.(type) {
+	case parser.Record:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	case database.OrderedMap:
+		return v.ToMap(), true
+	default:
+		return nil, false
+	}
+}
+
+// recordBuffer converts rows to the records that expressions evaluate. The
+// maps it builds (for ordered objects, rows that are not objects and the
+// virtual columns of a database.PositionedRow) are reused across rows, so
+// a record is only valid until the next call.
+type recordBuffer struct {
+	scratch map[string]interface{}
+}
+
+// record is toRecord of a row's primitive, adding the virtual columns of a
+// database.PositionedRow so that expressions can test them. A row that is
+// not an object is tested as its database.ValueColumn.
+func (b *recordBuffer) record(row database.Row) map[string]interface{} {
+	positioned, isPositioned := row.(*database.PositionedRow)
+	switch v := row.Primitive().(type) {
+	case parser.Record:
+		if !isPositioned {
+			return v
+		}
+		b.reset(len(v) + 2)
+		for k, val := range v {
+			b.scratch[k] = val
+		}
+	case map[string]interface{}:
+		if !isPositioned {
+			return v
+		}
+		b.reset(len(v) + 2)
+		for k, val := range v {
+			b.scratch[k] = val
+		}
+	case database.OrderedMap:
+		b.reset(len(v) + 2)
+		for _, kv := range v {
+			b.scratch[kv.Key] = kv.Val
+		}
+	default:
+		b.reset(3)
+		b.scratch[database.ValueColumn] = v
+	}
+	if isPositioned {
+		for k, val := range positioned.Columns() {
+			b.scratch[k] = val
+		}
+	}
+	return b.scratch
+}
+
+// reset empties the scratch map, allocating it with room for size keys
+func (b *recordBuffer) reset(size int) {
+	if b.scratch == nil {
+		b.scratch = make(map[string]interface{}, size)
+		return
+	}
+	clear(b.scratch)
+}
+
 // --- Filter Iterator ---
 
 type filterIterator struct {
 	source     database.RowIterator
 	expression query.Expression
+	records    recordBuffer
+	stats      *diag.Stats // of the execution (diag.RunStats), may be nil
 }
 
 func (it *filterIterator) Next() bool {
 	for it.source.Next() {
 		// Convert Row back to Record for Match
-		primitive := it.source.Row().Primitive()
-
-		var record map[string]interface{}
-		switch v := primitive.(type) {
-		case parser.Record:
-			record = v
-		case map[string]interface{}:
-			record = v
-		case database.OrderedMap:
-			record = v.ToMap()
-		default:
-			continue
+		matched := it.expression.Evaluate(it.records.record(it.source.Row()))
+		diag.Counters().Match(matched)
+		if it.stats != nil {
+			it.stats.Match(matched)
 		}
-
-		if it.expression.Evaluate(record) {
+		if matched {
 			return true
 		}
 	}
@@ -57,97 +132,169 @@ func (it *filterIterator) Close() error {
 // --- Project Iterator ---
 
 type projectIterator struct {
-	source      database.RowIterator
-	fields      []query.Field
-	filter      query.Expression
-	currentRow  database.Row
-	pendingRows []database.Row
+	source          database.RowIterator
+	fields          []query.Field
+	filter          query.Expression
+	caseInsensitive bool
+	sources         bool
+	currentRow      database.Row
+	pendingRows     []database.Row
+	values          []fieldVal // the values of the fields, reused across rows
+}
+
+// fieldVal is the value of a projected field for a source row
+type fieldVal struct {
+	key      string
+	val      interface{}
+	isArray  bool
+	arrayVal []interface{}
 }
 
 func (it *projectIterator) Next() bool {
-	// 1. Check if we have pending rows from significant unwinding
-	if len(it.pendingRows) > 0 {
-		it.currentRow = it.pendingRows[0]
-		it.pendingRows = it.pendingRows[1:]
-		return true
+	for {
+		// 1. Check if we have pending rows from significant unwinding
+		if len(it.pendingRows) > 0 {
+			it.currentRow = it.pendingRows[0]
+			it.pendingRows = it.pendingRows[1:]
+			return true
+		}
+
+		// 2. Fetch corresponding next row from source
+		if !it.source.Next() {
+			return false
+		}
+		if it.project(it.source.Row()) {
+			return true
+		}
+	}
+}
+
+// project computes the output rows of a source row, setting currentRow and
+// queueing any further unwound rows. It returns false when the row produces
+// no output (UNNEST of an empty or missing array).
+func (it *projectIterator) project(srcRow database.Row) bool {
+	if it.values == nil {
+		it.values = make([]fieldVal, len(it.fields))
 	}
+	fVals := it.values
 
-	// 2. Fetch corresponding next row from source
-	if it.source.Next() {
-		srcRow := it.source.Row()
+	allArraysLength := -1
+	consistentArrays := true
+	hasArrays := false
+	unnestLength := -1
 
-		type fieldVal struct {
-			key      string
-			val      interface{}
-			isArray  bool
-			arrayVal []interface{}
+	for i, f := range it.fields {
+		key := f.Alias
+		if key == "" {
+			key = f.Path
 		}
 
-		fVals := make([]fieldVal, len(it.fields))
-
-		allArraysLength := -1
-		consistentArrays := true
-		hasArrays := false
+		val, err := getField(srcRow, f.Path, it.filter, it.caseInsensitive)
+		if err != nil {
+			val = nil
+		}
+		if f.Func != "" {
+			val = query.ApplyFunc(f.Func, val)
+		}
 
-		for i, f := range it.fields {
-			key := f.Alias
-			if key == "" {
-				key = f.Path
+		fv := fieldVal{key: key, val: val}
+
+		if f.Unnest {
+			// UNNEST outputs one row per element, a scalar is a single element
+			switch v := val.(type) {
+			case nil:
+				fv.arrayVal = nil
+			case []interface{}:
+				fv.arrayVal = v
+			default:
+				fv.arrayVal = []interface{}{v}
 			}
-
-			val, err := srcRow.GetWithFilter(f.Path, it.filter)
-			if err != nil {
-				val = nil
+			fv.isArray = true
+			unnestLength = max(unnestLength, len(fv.arrayVal))
+		} else if sliceVal, ok := val.([]interface{}); ok {
+			fv.isArray = true
+			fv.arrayVal = sliceVal
+			hasArrays = true
+
+			if allArraysLength == -1 {
+				allArraysLength = len(sliceVal)
+			} else if allArraysLength != len(sliceVal) {
+				consistentArrays = false
 			}
+		}
+		fVals[i] = fv
+	}
 
-			fv := fieldVal{key: key, val: val}
-
-			if sliceVal, ok := val.([]interface{}); ok {
-				fv.isArray = true
-				fv.arrayVal = sliceVal
-				hasArrays = true
+	// 3. Unwind Logic: explicit UNNEST fields are zipped (shorter ones padded
+	// with null) and leave the other arrays whole, otherwise arrays of the
+	// same length are unwound together
+	rows := 0
+	if unnestLength >= 0 {
+		if unnestLength == 0 {
+			return false
+		}
+		rows = unnestLength
+	} else if hasArrays && consistentArrays && allArraysLength > 0 {
+		rows = allArraysLength
+	}
 
-				if allArraysLength == -1 {
-					allArraysLength = len(sliceVal)
-				} else if allArraysLength != len(sliceVal) {
-					consistentArrays = false
-				}
-			}
-			fVals[i] = fv
-		}
-
-		// 3. Unwind Logic
-		if hasArrays && consistentArrays && allArraysLength > 0 {
-			// Generate N rows
-			for i := 0; i < allArraysLength; i++ {
-				// Build OrderedMap
-				newRow := make(database.OrderedMap, len(it.fields))
-				for j, fv := range fVals {
-					var v interface{}
-					if fv.isArray {
+	if rows > 0 {
+		// Generate N rows
+		for i := 0; i < rows; i++ {
+			// Build OrderedMap
+			newRow := make(database.OrderedMap, len(it.fields))
+			for j, fv := range fVals {
+				var v interface{}
+				switch {
+				case fv.isArray && (unnestLength < 0 || it.fields[j].Unnest):
+					if i < len(fv.arrayVal) {
 						v = fv.arrayVal[i]
-					} else {
-						v = fv.val
 					}
-					newRow[j] = database.KeyVal{Key: fv.key, Val: v}
+				default:
+					v = fv.val
 				}
-				it.pendingRows = append(it.pendingRows, database.NewJSONRow(newRow))
+				newRow[j] = database.KeyVal{Key: fv.key, Val: v}
 			}
-
-			it.currentRow = it.pendingRows[0]
-			it.pendingRows = it.pendingRows[1:]
-			return true
+			it.pendingRows = append(it.pendingRows, database.NewJSONRow(it.withSource(newRow, srcRow)))
 		}
 
-		// 4. Fallback: Return as is
-		newRow := make(database.OrderedMap, len(it.fields))
-		for i, fv := range fVals {
-			newRow[i] = database.KeyVal{Key: fv.key, Val: fv.val}
-		}
-		it.currentRow = database.NewJSONRow(newRow)
+		it.currentRow = it.pendingRows[0]
+		it.pendingRows = it.pendingRows[1:]
 		return true
 	}
-	return false
+
+	// 4. Fallback: Return as is
+	newRow := make(database.OrderedMap, len(it.fields))
+	for i, fv := range fVals {
+		newRow[i] = database.KeyVal{Key: fv.key, Val: fv.val}
+	}
+	it.currentRow = database.NewJSONRow(it.withSource(newRow, srcRow))
+	return true
+}
+
+// withSource carries the parser.SourceField of the source row over to a
+// projected row, when sources are tracked
+func (it *projectIterator) withSource(row database.OrderedMap, srcRow database.Row) database.OrderedMap {
+	if !it.sources {
+		return row
+	}
+	if source := rowSource(srcRow); source != nil {
+		row = append(row, database.KeyVal{Key: parser.SourceField, Val: source})
+	}
+	return row
+}
+
+// rowSource returns the parser.SourceField of a row, or nil
+func rowSource(row database.Row) interface{} {
+	if m, ok := row.Primitive().(database.OrderedMap); ok {
+		source, _ := m.Get(parser.SourceField)
+		return source
+	}
+	source, err := row.Get(parser.SourceField)
+	if err != nil {
+		return nil
+	}
+	return source
 }
 
 func (it *projectIterator) Row() database.Row {
@@ -162,29 +309,171 @@ func (it *projectIterator) Close() error {
 	return it.source.Close()
 }
 
+// --- Delete Iterator ---
+
+type deleteIterator struct {
+	source  database.RowIterator
+	filter  query.Expression
+	records recordBuffer
+}
+
+func (it *deleteIterator) Next() bool {
+	for it.source.Next() {
+		if it.filter == nil {
+			continue // DELETE without WHERE removes everything
+		}
+		if !it.filter.Evaluate(it.records.record(it.source.Row())) {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *deleteIterator) Row() database.Row {
+	return it.source.Row()
+}
+
+func (it *deleteIterator) Error() error {
+	return it.source.Error()
+}
+
+func (it *deleteIterator) Close() error {
+	return it.source.Close()
+}
+
+// --- Update Iterator ---
+
+type updateIterator struct {
+	source      database.RowIterator
+	assignments []query.Assignment
+	filter      query.Expression
+	current     database.Row
+	records     recordBuffer
+}
+
+func (it *updateIterator) Next() bool {
+	if !it.source.Next() {
+		return false
+	}
+	row := it.source.Row()
+	it.current = row
+
+	if !database.IsObject(row.Primitive()) {
+		return true // non-object rows pass through
+	}
+	if it.filter != nil {
+		if !it.filter.Evaluate(it.records.record(row)) {
+			return true
+		}
+	}
+
+	// Assignments see the original values, so SET a = b, b = a swaps
+	updated := row.Primitive()
+	for _, a := range it.assignments {
+		val := a.Value
+		if a.Source != "" {
+			v, err := row.Get(a.Source)
+			if err != nil {
+				v = nil
+			}
+			val = v
+		}
+		updated = setPath(updated, query.SplitPath(a.Path), val)
+	}
+	it.current = database.NewJSONRow(updated)
+	return true
+}
+
+func (it *updateIterator) Row() database.Row {
+	return it.current
+}
+
+func (it *updateIterator) Error() error {
+	return it.source.Error()
+}
+
+func (it *updateIterator) Close() error {
+	return it.source.Close()
+}
+
+// setPath returns a copy of m with the value at keys replaced, creating
+// intermediate objects as needed. Objects along the path are copied, not
+// modified; ordered ones (database.OrderedMap) keep their key order.
+func setPath(m interface{}, keys []string, val interface{}) interface{} {
+	if om, ok := m.(database.OrderedMap); ok {
+		out := append(make(database.OrderedMap, 0, len(om)+1), om...)
+		if len(keys) == 0 {
+			return out
+		}
+		if len(keys) == 1 {
+			return out.Set(keys[0], val)
+		}
+		child, _ := out.Get(keys[0])
+		return out.Set(keys[0], setPath(child, keys[1:], val))
+	}
+
+	src, _ := toRecord(m)
+	out := make(map[string]interface{}, len(src)+1)
+	for k, v := range src {
+		out[k] = v
+	}
+	if len(keys) == 0 {
+		return out
+	}
+	if len(keys) == 1 {
+		out[keys[0]] = val
+		return out
+	}
+	out[keys[0]] = setPath(out[keys[0]], keys[1:], val)
+	return out
+}
+
 // --- Aggregate Iterator ---
 
 type aggregateIterator struct {
-	input        Node
-	groupByField string
-	fields       []query.Field
+	ctx             context.Context
+	input           Node
+	groupByField    string
+	bucket          *query.Bucket
+	fields          []query.Field
+	caseInsensitive bool
+	sources         bool
+	memoryLimit     int64
+	tempDir         string
+	parallel        bool
 
 	results []database.Row
+	sorted  database.RowIterator // the results, when the groups spilled
 	index   int
+	err     error
 }
 
 func (it *aggregateIterator) Next() bool {
 	// Initialize on first call
 	if it.results == nil {
+		if it.err != nil {
+			return false
+		}
 		if err := it.init(); err != nil {
+			it.err = err
 			return false
 		}
 	}
+	if it.sorted != nil {
+		if it.sorted.Next() {
+			return true
+		}
+		it.err = it.sorted.Error()
+		return false
+	}
 	it.index++
 	return it.index < len(it.results)
 }
 
 func (it *aggregateIterator) Row() database.Row {
+	if it.sorted != nil {
+		return it.sorted.Row()
+	}
 	if it.index >= 0 && it.index < len(it.results) {
 		return it.results[it.index]
 	}
@@ -192,59 +481,75 @@ func (it *aggregateIterator) Row() database.Row {
 }
 
 func (it *aggregateIterator) Error() error {
-	return nil // Initialization error handled in Next() ?? TODO: persist error
+	return it.err
 }
 
 func (it *aggregateIterator) Close() error {
+	if it.sorted != nil {
+		return it.sorted.Close()
+	}
 	return nil
 }
 
 func (it *aggregateIterator) init() error {
-	sourceIter, err := it.input.Execute()
+	if scan, analyzed := it.parallelInput(); scan != nil {
+		return it.initParallel(scan, analyzed)
+	}
+
+	sourceIter, err := it.input.Execute(it.ctx)
 	if err != nil {
 		return err
 	}
 	defer sourceIter.Close()
 
-	groups := make(map[string]*groupState)
-	var groupKeys []string
+	// Groups beyond the memory limit spill their partial aggregates
+	groups := it.newGroupSet(it.memoryLimit)
+	defer groups.close()
 	hasData := false
 
-	extract := func(row database.Row, path string) (interface{}, error) {
-		return row.Get(path)
-	}
+	// HISTOGRAM ranges depend on the minimum and maximum value, so its
+	// rows are buffered until these are known
+	var rows []database.Row
+	var lo, hi float64
+	histogram := it.bucket != nil && it.bucket.Func == query.FuncHistogram
 
+	seen := false
 	for sourceIter.Next() {
 		hasData = true
 		row := sourceIter.Row()
-
-		var groupKey string
-		if it.groupByField != "" {
-			val, err := extract(row, it.groupByField)
-			if err == nil {
-				groupKey = fmt.Sprintf("%v", val)
-			} else {
-				groupKey = "null"
+		if !histogram {
+			if err := groups.add(row, lo, hi); err != nil {
+				return err
 			}
-		} else {
-			groupKey = ""
+			continue
 		}
-
-		state, exists := groups[groupKey]
-		if !exists {
-			state = newGroupState(it.fields)
-			groups[groupKey] = state
-			groupKeys = append(groupKeys, groupKey)
+		rows = append(rows, row)
+		if val, err := it.extract(row, it.groupByField); err == nil {
+			if v, ok := it.bucket.Number(val); ok {
+				if !seen || v < lo {
+					lo = v
+				}
+				if !seen || v > hi {
+					hi = v
+				}
+				seen = true
+			}
 		}
-
-		state.update(row, extract)
 	}
 
 	if err := sourceIter.Error(); err != nil {
 		return err
 	}
+	for _, row := range rows {
+		if err := groups.add(row, lo, hi); err != nil {
+			return err
+		}
+	}
+	return it.finish(groups, hasData)
+}
 
-	// Build results
+// finish builds the results of the groups
+func (it *aggregateIterator) finish(groups *groupSet, hasData bool) error {
 	it.results = []database.Row{}
 	it.index = -1
 
@@ -258,31 +563,282 @@ func (it *aggregateIterator) init() error {
 			}
 		}
 		if hasAgg {
-			state := newGroupState(it.fields)
-			it.results = append(it.results, state.finalize("", ""))
+			state := newGroupState(it.fields, it.sources)
+			it.results = append(it.results, state.finalize(nil, ""))
 			return nil
 		}
 	}
 
-	sort.Strings(groupKeys)
+	if groups.spill != nil {
+		if err := groups.spill.write(groups.keys, groups.values, groups.states); err != nil {
+			return err
+		}
+		sorted, err := groups.spill.merge(it.fields, it.groupByField, it.sources, it.memoryLimit)
+		if err != nil {
+			return err
+		}
+		it.sorted = sorted
+		return nil
+	}
+
+	// Groups are ordered by value, the null group last
+	groupKeys, groupValues := groups.keys, groups.values
+	sort.SliceStable(groupKeys, func(i, j int) bool {
+		return query.CompareOrder(groupValues[groupKeys[i]], groupValues[groupKeys[j]], query.NullsLast) < 0
+	})
 
 	for _, key := range groupKeys {
-		state := groups[key]
-		it.results = append(it.results, state.finalize(key, it.groupByField))
+		state := groups.states[key]
+		it.results = append(it.results, state.finalize(groupValues[key], it.groupByField))
+	}
+
+	return nil
+}
+
+func (it *aggregateIterator) extract(row database.Row, path string) (interface{}, error) {
+	return getField(row, path, nil, it.caseInsensitive)
+}
+
+// groupOf returns the key and value of the group of a row, lo and hi being
+// the range of a HISTOGRAM
+func (it *aggregateIterator) groupOf(row database.Row, lo, hi float64) (string, interface{}) {
+	if it.groupByField == "" {
+		return "", nil
+	}
+	// Missing fields and nulls form a single null group
+	val, err := it.extract(row, it.groupByField)
+	if err != nil || val == nil {
+		return "", nil
+	}
+	if it.bucket != nil {
+		v, ok := it.bucket.Number(val)
+		if !ok {
+			return "", nil
+		}
+		val = it.bucket.Lower(v, lo, hi)
+	}
+	return fmt.Sprintf("%T:%v", val, val), val
+}
+
+// groupSet holds the groups of an aggregation in the order of their first
+// row, spilling their partial aggregates to temporary files beyond
+// memoryLimit
+type groupSet struct {
+	it          *aggregateIterator
+	memoryLimit int64
+
+	states map[string]*groupState
+	keys   []string
+	values map[string]interface{}
+	size   int64
+	seq    int64
+	spill  *groupSpill
+}
+
+func (it *aggregateIterator) newGroupSet(memoryLimit int64) *groupSet {
+	return &groupSet{
+		it:          it,
+		memoryLimit: memoryLimit,
+		states:      make(map[string]*groupState),
+		values:      make(map[string]interface{}),
 	}
+}
+
+// get returns the group of key, creating it
+func (g *groupSet) get(key string, value interface{}) *groupState {
+	state, exists := g.states[key]
+	if !exists {
+		state = newGroupState(g.it.fields, g.it.sources)
+		state.first = g.seq
+		g.seq++
+		g.states[key] = state
+		g.keys = append(g.keys, key)
+		g.values[key] = value
+		g.size += groupSize(key, value, state)
+	}
+	return state
+}
+
+// add aggregates a row into its group
+func (g *groupSet) add(row database.Row, lo, hi float64) error {
+	key, value := g.it.groupOf(row, lo, hi)
+	g.get(key, value).update(row, g.it.extract)
+	if g.memoryLimit <= 0 {
+		return nil
+	}
+	if g.it.sources {
+		g.size += valueSize(rowSource(row))
+	}
+	return g.checkLimit()
+}
+
+// merge adds the groups of the next part of the input, aggregated apart
+func (g *groupSet) merge(part *groupSet) error {
+	for _, key := range part.keys {
+		partial := part.states[key]
+		g.get(key, part.values[key]).merge(partial)
+		if g.memoryLimit <= 0 {
+			continue
+		}
+		if g.it.sources {
+			g.size += valueSize(partial.sources)
+		}
+		if err := g.checkLimit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// checkLimit spills the groups when they exceed the memory limit
+func (g *groupSet) checkLimit() error {
+	if g.size <= g.memoryLimit {
+		return nil
+	}
+	if g.spill == nil {
+		g.spill = &groupSpill{dir: g.it.tempDir}
+	}
+	if err := g.spill.write(g.keys, g.values, g.states); err != nil {
+		return err
+	}
+	g.states = make(map[string]*groupState)
+	g.keys = nil
+	g.values = make(map[string]interface{})
+	g.size = 0
+	return nil
+}
+
+// close removes the spilled groups
+func (g *groupSet) close() {
+	if g.spill != nil {
+		g.spill.close()
+	}
+}
+
+// --- Sorted Aggregate Iterator ---
+
+// sortedAggregateIterator aggregates an input sorted by the group field, in
+// which the rows of a group are contiguous: a group is returned as soon as
+// the first row of the next one is read, only the current group being held
+type sortedAggregateIterator struct {
+	agg   *aggregateIterator // the grouping of the rows
+	input database.RowIterator
+
+	state *groupState // the current group, nil before the first row
+	key   string
+	value interface{}
+	last  interface{} // the value of the last non-null group
+	order int         // the sign of the comparison of consecutive groups, 0 until known
+	nulls bool        // the null group ended
+
+	row  database.Row
+	done bool
+	err  error
+}
+
+func (it *sortedAggregateIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	if it.input == nil {
+		input, err := it.agg.input.Execute(it.agg.ctx)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.input = input
+	}
+	for it.input.Next() {
+		row := it.input.Row()
+		key, value := it.agg.groupOf(row, 0, 0)
+		if it.state != nil && key == it.key {
+			it.state.update(row, it.agg.extract)
+			continue
+		}
+		var ended database.Row
+		if it.state != nil {
+			ended = it.state.finalize(it.value, it.agg.groupByField)
+		}
+		if err := it.start(key, value); err != nil {
+			it.err = err
+			return false
+		}
+		it.state.update(row, it.agg.extract)
+		if ended != nil {
+			it.row = ended
+			return true
+		}
+	}
+	if err := it.input.Error(); err != nil {
+		it.err = err
+		return false
+	}
+	it.done = true
+	if it.state == nil {
+		return false
+	}
+	it.row = it.state.finalize(it.value, it.agg.groupByField)
+	it.state = nil
+	return true
+}
+
+// start begins the group of the next key, failing when it breaks the order
+// of the previous groups
+func (it *sortedAggregateIterator) start(key string, value interface{}) error {
+	if it.state != nil && it.value == nil {
+		it.nulls = true
+	}
+	field := query.DisplayPath(it.agg.groupByField)
+	switch {
+	case value == nil && it.nulls:
+		return fmt.Errorf("input is not sorted by %s: null rows are not contiguous", field)
+	case value != nil && it.last != nil:
+		order := query.CompareOrder(it.last, value, query.NullsLast)
+		if it.order == 0 {
+			it.order = order
+		} else if order != 0 && order != it.order {
+			return fmt.Errorf("input is not sorted by %s: %v follows %v", field, value, it.last)
+		}
+	}
+	if value != nil {
+		it.last = value
+	}
+	it.key, it.value = key, value
+	it.state = newGroupState(it.agg.fields, it.agg.sources)
+	return nil
+}
+
+func (it *sortedAggregateIterator) Row() database.Row {
+	return it.row
+}
+
+func (it *sortedAggregateIterator) Error() error {
+	return it.err
+}
+
+func (it *sortedAggregateIterator) Close() error {
+	if it.input != nil {
+		return it.input.Close()
+	}
 	return nil
 }
 
 type groupState struct {
 	fields []query.Field
 	aggs   map[string]fieldAggregator
+	first  int64 // the order of the group's first row among the groups
+
+	// trackSources collects the parser.SourceField of the grouped rows
+	trackSources bool
+	sources      []interface{}
 }
 
-func newGroupState(fields []query.Field) *groupState {
+func newGroupState(fields []query.Field, trackSources bool) *groupState {
 	s := &groupState{
-		fields: fields,
-		aggs:   make(map[string]fieldAggregator),
+		fields:       fields,
+		aggs:         make(map[string]fieldAggregator),
+		trackSources: trackSources,
+		sources:      []interface{}{},
 	}
 	for i, f := range s.fields {
 		if f.Aggregate != "" {
@@ -292,22 +848,47 @@ func newGroupState(fields []query.Field) *groupState {
 	return s
 }
 
+// merge adds the partial aggregates of the same group over later rows
+func (s *groupState) merge(other *groupState) {
+	s.sources = append(s.sources, other.sources...)
+	for key, agg := range s.aggs {
+		agg.Merge(other.aggs[key].Partial())
+	}
+}
+
 func keyFor(index int) string {
 	return strconv.Itoa(index)
 }
 
 func (s *groupState) update(row database.Row, extractor func(database.Row, string) (interface{}, error)) {
+	if s.trackSources {
+		// Sources of rows aggregated by a subquery are already a list
+		switch source := rowSource(row).(type) {
+		case nil:
+		case []interface{}:
+			s.sources = append(s.sources, source...)
+		default:
+			s.sources = append(s.sources, source)
+		}
+	}
 	for i, f := range s.fields {
+		if f.Path == "*" && strings.EqualFold(f.Aggregate, "COUNT") {
+			s.aggs[keyFor(i)].Add(true) // COUNT(*) counts rows, whatever their type
+			continue
+		}
 		if f.Aggregate != "" {
 			val, err := extractor(row, f.Path)
 			if err == nil {
+				if f.Func != "" {
+					val = query.ApplyFunc(f.Func, val)
+				}
 				s.aggs[keyFor(i)].Add(val)
 			}
 		}
 	}
 }
 
-func (s *groupState) finalize(groupKey string, groupByField string) database.Row {
+func (s *groupState) finalize(groupValue interface{}, groupByField string) database.Row {
 	result := make(database.OrderedMap, len(s.fields))
 	for i, f := range s.fields {
 		key := f.Alias
@@ -319,13 +900,16 @@ func (s *groupState) finalize(groupKey string, groupByField string) database.Row
 			val = s.aggs[keyFor(i)].Result()
 		} else {
 			if f.Path == groupByField {
-				val = groupKey
+				val = groupValue
 			} else {
 				val = nil
 			}
 		}
 		result[i] = database.KeyVal{Key: key, Val: val}
 	}
+	if s.trackSources {
+		result = append(result, database.KeyVal{Key: parser.SourceField, Val: s.sources})
+	}
 	return database.NewJSONRow(result)
 }
 
@@ -333,6 +917,19 @@ func (s *groupState) finalize(groupKey string, groupByField string) database.Row
 type fieldAggregator interface {
 	Add(val interface{})
 	Result() interface{}
+	// Partial returns the partial result, which Merge adds to another
+	// aggregator of the same function (e.g. of another part of the input)
+	Partial() aggState
+	Merge(s aggState)
+}
+
+// aggState is the partial result of an aggregator, as merged or spilled to
+// disk
+type aggState struct {
+	val   interface{}
+	set   bool
+	sum   float64
+	count int
 }
 
 func createAggregator(funcName string) fieldAggregator {
@@ -347,6 +944,8 @@ func createAggregator(funcName string) fieldAggregator {
 		return &countAggregator{}
 	case "SUM":
 		return &sumAggregator{}
+	case "FIRST":
+		return &firstAggregator{}
 	default:
 		return &countAggregator{}
 	}
@@ -373,7 +972,7 @@ func (a *maxAggregator) Add(v interface{}) {
 		a.set = true
 		return
 	}
-	if compareGreater(v, a.val) {
+	if query.CompareOrder(v, a.val, query.NullsLast) > 0 {
 		a.val = v
 	}
 }
@@ -382,6 +981,14 @@ func (a *maxAggregator) Result() interface{} {
 	return a.val
 }
 
+func (a *maxAggregator) Partial() aggState { return aggState{val: a.val, set: a.set} }
+
+func (a *maxAggregator) Merge(s aggState) {
+	if s.set {
+		a.Add(s.val)
+	}
+}
+
 // MIN
 type minAggregator struct {
 	val interface{}
@@ -403,7 +1010,7 @@ func (a *minAggregator) Add(v interface{}) {
 		a.set = true
 		return
 	}
-	if compareLess(v, a.val) {
+	if query.CompareOrder(v, a.val, query.NullsLast) < 0 {
 		a.val = v
 	}
 }
@@ -412,6 +1019,14 @@ func (a *minAggregator) Result() interface{} {
 	return a.val
 }
 
+func (a *minAggregator) Partial() aggState { return aggState{val: a.val, set: a.set} }
+
+func (a *minAggregator) Merge(s aggState) {
+	if s.set {
+		a.Add(s.val)
+	}
+}
+
 // AVG
 type avgAggregator struct {
 	sum   float64
@@ -442,6 +1057,13 @@ func (a *avgAggregator) Result() interface{} {
 	return a.sum / float64(a.count)
 }
 
+func (a *avgAggregator) Partial() aggState { return aggState{sum: a.sum, count: a.count} }
+
+func (a *avgAggregator) Merge(s aggState) {
+	a.sum += s.sum
+	a.count += s.count
+}
+
 // COUNT
 type countAggregator struct {
 	count int
@@ -461,6 +1083,12 @@ func (a *countAggregator) Result() interface{} {
 	return a.count
 }
 
+func (a *countAggregator) Partial() aggState { return aggState{count: a.count} }
+
+func (a *countAggregator) Merge(s aggState) {
+	a.count += s.count
+}
+
 // SUM
 type sumAggregator struct {
 	sum float64
@@ -486,6 +1114,34 @@ func (a *sumAggregator) Result() interface{} {
 	return a.sum
 }
 
+func (a *sumAggregator) Partial() aggState { return aggState{sum: a.sum} }
+
+func (a *sumAggregator) Merge(s aggState) {
+	a.sum += s.sum
+}
+
+// FIRST keeps the first non-null value of the group, arrays included
+type firstAggregator struct {
+	val interface{}
+}
+
+func (a *firstAggregator) Add(v interface{}) {
+	if a.val == nil {
+		a.val = v
+	}
+}
+
+func (a *firstAggregator) Result() interface{} {
+	return a.val
+}
+
+func (a *firstAggregator) Partial() aggState { return aggState{val: a.val} }
+
+// Merge keeps the value of the earlier rows, merged first
+func (a *firstAggregator) Merge(s aggState) {
+	a.Add(s.val)
+}
+
 // Helpers
 func toFloat64(v interface{}) (float64, bool) {
 	switch val := v.(type) {
@@ -506,25 +1162,3 @@ func toFloat64(v interface{}) (float64, bool) {
 		return 0, false
 	}
 }
-
-func compareGreater(a, b interface{}) bool {
-	af, aok := toFloat64(a)
-	bf, bok := toFloat64(b)
-	if aok && bok {
-		return af > bf
-	}
-	as := fmt.Sprintf("%v", a)
-	bs := fmt.Sprintf("%v", b)
-	return as > bs
-}
-
-func compareLess(a, b interface{}) bool {
-	af, aok := toFloat64(a)
-	bf, bok := toFloat64(b)
-	if aok && bok {
-		return af < bf
-	}
-	as := fmt.Sprintf("%v", a)
-	bs := fmt.Sprintf("%v", b)
-	return as < bs
-}
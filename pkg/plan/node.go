@@ -1,12 +1,31 @@
 package plan
 
 import (
+	"context"
 	"github.com/bisegni/jsl/pkg/database"
 )
 
 // Node represents an execution node in the query plan
 type Node interface {
-	Execute() (database.RowIterator, error)
+	// Execute returns the rows of the node, whose iteration stops with the
+	// error of ctx once ctx is done (e.g. the query is cancelled)
+	Execute(ctx context.Context) (database.RowIterator, error)
 	Children() []Node
 	Explain() string
 }
+
+// InputFiles returns the local files read by the scans of a plan (see
+// database.TableFiles)
+func InputFiles(root Node) []string {
+	var files []string
+	switch n := unwrap(root).(type) {
+	case *ScanNode:
+		files = database.TableFiles(n.Table)
+	case *ParallelScanNode:
+		files = database.TableFiles(n.Table)
+	}
+	for _, child := range root.Children() {
+		files = append(files, InputFiles(child)...)
+	}
+	return files
+}
@@ -1,6 +1,7 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -8,21 +9,54 @@ import (
 	"github.com/bisegni/jsl/pkg/query"
 )
 
-// AggregateNode handles GroupBy and Aggregations
+// AggregateNode handles GroupBy and Aggregations. Groups beyond MemoryLimit
+// spill their partial aggregates to temporary files, which are aggregated a
+// partition of the groups at a time; HISTOGRAM buckets hold their input rows
+// in memory. With Sorted the groups are contiguous in the input and
+// returned as they end, in input order, one group at a time being held.
 type AggregateNode struct {
 	Input        Node
 	GroupByField string
-	Fields       []query.Field
+	// Bucket groups the GroupByField values into ranges, or nil
+	Bucket *query.Bucket
+	Fields []query.Field
+	// CaseInsensitive resolves field paths regardless of key case
+	CaseInsensitive bool
+	// Sources lists the parser.SourceField of the rows of each group
+	Sources bool
+	// MemoryLimit bounds the estimated size of the groups held in memory (0 =
+	// no limit)
+	MemoryLimit int64
+	// TempDir holds the spilled groups, os.TempDir() when empty
+	TempDir string
+	// Parallel makes the workers of a ParallelScanNode Input aggregate the
+	// parts they scan, the partial aggregates of the parts being merged
+	// (not for HISTOGRAM, whose ranges depend on every row)
+	Parallel bool
+	// Sorted streams the groups of an input sorted by GroupByField, failing
+	// on rows out of order (not for HISTOGRAM)
+	Sorted bool
 }
 
-func (n *AggregateNode) Execute() (database.RowIterator, error) {
+func (n *AggregateNode) Execute(ctx context.Context) (database.RowIterator, error) {
 	// We need to implement the aggregation logic here or delegate to a separate implementation
 	// For now, let's assume we implement `aggregateIterator` in this package.
-	return &aggregateIterator{
-		input:        n.Input,
-		groupByField: n.GroupByField,
-		fields:       n.Fields,
-	}, nil
+	agg := &aggregateIterator{
+		ctx:             ctx,
+		input:           n.Input,
+		groupByField:    n.GroupByField,
+		bucket:          n.Bucket,
+		fields:          n.Fields,
+		caseInsensitive: n.CaseInsensitive,
+		sources:         n.Sources,
+		memoryLimit:     n.MemoryLimit,
+		tempDir:         n.TempDir,
+		parallel:        n.Parallel,
+	}
+	if n.Sorted && n.GroupByField != "" && (n.Bucket == nil || n.Bucket.Func != query.FuncHistogram) {
+		return &sortedAggregateIterator{agg: agg}, nil
+	}
+	return agg, nil
 }
 
 func (n *AggregateNode) Children() []Node {
@@ -35,8 +69,18 @@ func (n *AggregateNode) Explain() string {
 		fieldStrings = append(fieldStrings, f.String())
 	}
 	group := n.GroupByField
+	if n.Bucket != nil {
+		group = n.Bucket.String()
+	}
 	if group == "" {
 		group = "global"
 	}
-	return fmt.Sprintf("Aggregate(group: %s, fields: [%s])", group, strings.Join(fieldStrings, ", "))
+	explain := fmt.Sprintf("Aggregate(group: %s, fields: [%s]", group, strings.Join(fieldStrings, ", "))
+	if n.Parallel {
+		explain += ", parallel"
+	}
+	if n.Sorted {
+		explain += ", sorted"
+	}
+	return explain + ")"
 }
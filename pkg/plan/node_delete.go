@@ -0,0 +1,32 @@
+package plan
+
+import (
+	"context"
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// DeleteNode drops rows matching Filter (all rows if nil) and passes the others through
+type DeleteNode struct {
+	Input  Node
+	Filter query.Expression
+}
+
+func (n *DeleteNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &deleteIterator{source: inputIter, filter: query.CompileExpression(n.Filter)}, nil
+}
+
+func (n *DeleteNode) Children() []Node {
+	return []Node{n.Input}
+}
+
+func (n *DeleteNode) Explain() string {
+	if n.Filter == nil {
+		return "Delete(where: all)"
+	}
+	return "Delete(where: " + n.Filter.String() + ")"
+}
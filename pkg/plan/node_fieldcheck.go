@@ -0,0 +1,126 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// FieldCheckNode passes rows through unchanged, but fails if any of Fields
+// is not found in a single row. It catches typos (e.g. "pricee") that would
+// otherwise yield null-only output. The rows read until every field has
+// been found are held, so that no row is output before the check passes,
+// however few rows the query goes on to read (LIMIT).
+type FieldCheckNode struct {
+	Input  Node
+	Fields []string
+	// CaseInsensitive resolves field paths regardless of key case
+	CaseInsensitive bool
+}
+
+func (n *FieldCheckNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldCheckIterator{
+		source:          inputIter,
+		fields:          n.Fields,
+		caseInsensitive: n.CaseInsensitive,
+		seen:            make(map[string]bool, len(n.Fields)),
+	}, nil
+}
+
+func (n *FieldCheckNode) Children() []Node {
+	return []Node{n.Input}
+}
+
+func (n *FieldCheckNode) Explain() string {
+	return fmt.Sprintf("FieldCheck(fields: [%s])", strings.Join(n.Fields, ", "))
+}
+
+type fieldCheckIterator struct {
+	source          database.RowIterator
+	fields          []string
+	caseInsensitive bool
+	seen            map[string]bool
+	checked         bool
+	pending         []database.Row // rows read by the check, not yet returned
+	current         database.Row
+	err             error
+}
+
+func (it *fieldCheckIterator) Next() bool {
+	if !it.checked {
+		it.checked = true
+		it.check()
+	}
+	if it.err != nil {
+		return false
+	}
+	if len(it.pending) > 0 {
+		it.current = it.pending[0]
+		it.pending[0] = nil
+		it.pending = it.pending[1:]
+		return true
+	}
+	if !it.source.Next() {
+		return false
+	}
+	it.current = it.source.Row()
+	return true
+}
+
+// check reads rows until every field has been found in one, holding them,
+// and fails if the input ends first
+func (it *fieldCheckIterator) check() {
+	missing, count := 0, 0
+	for _, f := range it.fields {
+		if _, ok := it.seen[f]; !ok {
+			it.seen[f] = false
+			missing++
+		}
+	}
+	for missing > 0 && it.source.Next() {
+		count++
+		row := it.source.Row()
+		it.pending = append(it.pending, row)
+		for _, f := range it.fields {
+			if it.seen[f] {
+				continue
+			}
+			if _, err := getField(row, f, nil, it.caseInsensitive); err == nil {
+				it.seen[f] = true
+				missing--
+			}
+		}
+	}
+	if missing == 0 || count == 0 || it.source.Error() != nil {
+		return
+	}
+	var names []string
+	for _, f := range it.fields {
+		if !it.seen[f] {
+			names = append(names, f)
+		}
+	}
+	it.err = fmt.Errorf("unknown field(s) %s: not present in any of %d scanned record(s)", strings.Join(names, ", "), count)
+	it.pending = nil
+}
+
+func (it *fieldCheckIterator) Row() database.Row {
+	return it.current
+}
+
+func (it *fieldCheckIterator) Error() error {
+	if err := it.source.Error(); err != nil {
+		return err
+	}
+	return it.err
+}
+
+func (it *fieldCheckIterator) Close() error {
+	return it.source.Close()
+}
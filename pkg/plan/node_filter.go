@@ -1,7 +1,9 @@
 package plan
 
 import (
+	"context"
 	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
 	"github.com/bisegni/jsl/pkg/query"
 )
 
@@ -11,12 +13,12 @@ type FilterNode struct {
 	Expression query.Expression
 }
 
-func (n *FilterNode) Execute() (database.RowIterator, error) {
-	inputIter, err := n.Input.Execute()
+func (n *FilterNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &filterIterator{source: inputIter, expression: n.Expression}, nil
+	return &filterIterator{source: inputIter, expression: query.CompileExpression(n.Expression), stats: diag.RunStats(ctx)}, nil
 }
 
 func (n *FilterNode) Children() []Node {
@@ -0,0 +1,69 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bisegni/jsl/pkg/database"
+)
+
+// LimitNode returns at most Count rows of its input (LIMIT n). The input is
+// not read past the last returned row, and is closed as soon as the rows
+// are exhausted, releasing its files before the iterator itself is closed.
+type LimitNode struct {
+	Input Node
+	Count int
+}
+
+func (n *LimitNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &limitIterator{source: inputIter, remaining: n.Count}, nil
+}
+
+func (n *LimitNode) Children() []Node {
+	return []Node{n.Input}
+}
+
+func (n *LimitNode) Explain() string {
+	return fmt.Sprintf("Limit(count: %d)", n.Count)
+}
+
+type limitIterator struct {
+	source    database.RowIterator
+	remaining int
+	closed    bool
+	closeErr  error
+}
+
+func (it *limitIterator) Next() bool {
+	if it.remaining <= 0 {
+		it.closeSource()
+		return false
+	}
+	it.remaining--
+	return it.source.Next()
+}
+
+// closeSource closes the input once
+func (it *limitIterator) closeSource() {
+	if !it.closed {
+		it.closed = true
+		it.closeErr = it.source.Close()
+	}
+}
+
+func (it *limitIterator) Row() database.Row {
+	return it.source.Row()
+}
+
+func (it *limitIterator) Error() error {
+	return it.source.Error()
+}
+
+func (it *limitIterator) Close() error {
+	it.closeSource()
+	return it.closeErr
+}
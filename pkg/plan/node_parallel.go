@@ -0,0 +1,302 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// ParallelPartSize is the size of the parts a ParallelScanNode splits its
+// table into: large enough to amortize the setup of a part, small enough to
+// balance the workers and bound the rows held for the ordered merge
+const ParallelPartSize = 4 << 20
+
+// ParallelScanNode scans a table split into parts (database.SplitTable) with
+// a pool of workers, each decoding a part and applying the Filter to it. The
+// rows are yielded in table order, as a ScanNode followed by a FilterNode
+// would: a part is held until the parts before it are consumed, and workers
+// run at most two parts per worker ahead of the consumer.
+type ParallelScanNode struct {
+	TableName string
+	Table     database.SplitTable
+	Workers   int
+	// Filter, when set, keeps the rows it matches
+	Filter query.Expression
+	// PartSize is the size of the parts, ParallelPartSize when 0
+	PartSize int64
+	// Contains prefilters the records of the parts (see ScanNode)
+	Contains []string
+}
+
+func (n *ParallelScanNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	parts, err := n.split()
+	if err != nil {
+		return nil, err
+	}
+	filter := query.CompileExpression(n.Filter)
+	if len(parts) == 1 {
+		// Nothing to parallelize
+		source, err := iterateContaining(ctx, parts[0], n.Contains)
+		if err != nil || filter == nil {
+			return source, err
+		}
+		return &filterIterator{source: source, expression: filter, stats: diag.RunStats(ctx)}, nil
+	}
+	return newParallelIterator(parts, n.workers(len(parts)), func(part database.Table, stop <-chan struct{}) partRows {
+		var rows []database.Row
+		err := n.scan(ctx, part, filter, stop, func(row database.Row) error {
+			rows = append(rows, row)
+			return nil
+		})
+		return partRows{rows: rows, count: len(rows), err: err}
+	}), nil
+}
+
+// split splits the table into parts of PartSize
+func (n *ParallelScanNode) split() ([]database.Table, error) {
+	size := n.PartSize
+	if size <= 0 {
+		size = ParallelPartSize
+	}
+	return n.Table.Split(size)
+}
+
+// workers returns the number of workers scanning parts
+func (n *ParallelScanNode) workers(parts int) int {
+	return min(max(n.Workers, 1), parts)
+}
+
+// scan passes the rows of a part matching filter (the compiled Filter) to f,
+// until the scan is stopped
+func (n *ParallelScanNode) scan(ctx context.Context, part database.Table, filter query.Expression, stop <-chan struct{}, f func(database.Row) error) error {
+	source, err := iterateContaining(ctx, part, n.Contains)
+	if err != nil {
+		return err
+	}
+	var records recordBuffer
+	stats := diag.RunStats(ctx)
+	for source.Next() {
+		select {
+		case <-stop:
+			source.Close()
+			return nil
+		default:
+		}
+		row := source.Row()
+		if filter != nil {
+			matched := filter.Evaluate(records.record(row))
+			diag.Counters().Match(matched)
+			if stats != nil {
+				stats.Match(matched)
+			}
+			if !matched {
+				continue
+			}
+		}
+		if err := f(row); err != nil {
+			source.Close()
+			return err
+		}
+	}
+	err = source.Error()
+	if closeErr := source.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (n *ParallelScanNode) Children() []Node {
+	return nil
+}
+
+func (n *ParallelScanNode) Explain() string {
+	explain := fmt.Sprintf("ParallelScan(table: %s, workers: %d", n.TableName, n.Workers)
+	if len(n.Contains) > 0 {
+		explain += ", contains: " + quoteAll(n.Contains)
+	}
+	if n.Filter != nil {
+		explain += ", filter: " + n.Filter.String()
+	}
+	return explain + ")"
+}
+
+// partRows are the rows of a part kept by the filter, or the groups they
+// form when aggregated by the workers, or the error that stopped its scan
+type partRows struct {
+	rows   []database.Row
+	groups *groupSet
+	count  int // the rows kept
+	err    error
+}
+
+// parallelIterator merges the rows of the parts scanned by the workers, in
+// part order
+type parallelIterator struct {
+	results []chan partRows // one per part, receiving its rows once scanned
+	window  chan struct{}   // a slot per part scanned ahead of the consumer
+	stop    chan struct{}   // closed by Close to abandon the scan
+	workers sync.WaitGroup
+
+	next    int // the part being consumed
+	rows    []database.Row
+	current database.Row
+	err     error
+	closed  bool
+}
+
+// newParallelIterator scans the parts with scan, run by the workers
+func newParallelIterator(parts []database.Table, workers int, scan func(part database.Table, stop <-chan struct{}) partRows) *parallelIterator {
+	it := &parallelIterator{
+		results: make([]chan partRows, len(parts)),
+		window:  make(chan struct{}, 2*workers),
+		stop:    make(chan struct{}),
+	}
+	for i := range it.results {
+		it.results[i] = make(chan partRows, 1)
+	}
+
+	// Parts are handed out in order, as the window allows
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range parts {
+			select {
+			case it.window <- struct{}{}:
+			case <-it.stop:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-it.stop:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		it.workers.Add(1)
+		go func() {
+			defer it.workers.Done()
+			for i := range jobs {
+				it.results[i] <- scan(parts[i], it.stop)
+			}
+		}()
+	}
+	return it
+}
+
+// nextPart waits for the next part to be scanned, ok being false after the
+// last one
+func (it *parallelIterator) nextPart() (part partRows, ok bool) {
+	if it.next >= len(it.results) {
+		return partRows{}, false
+	}
+	part = <-it.results[it.next]
+	<-it.window
+	it.next++
+	return part, true
+}
+
+func (it *parallelIterator) Next() bool {
+	for len(it.rows) == 0 {
+		if it.err != nil {
+			return false
+		}
+		part, ok := it.nextPart()
+		if !ok {
+			return false
+		}
+		it.rows, it.err = part.rows, part.err
+	}
+	it.current = it.rows[0]
+	it.rows[0] = nil
+	it.rows = it.rows[1:]
+	return true
+}
+
+func (it *parallelIterator) Row() database.Row {
+	return it.current
+}
+
+func (it *parallelIterator) Error() error {
+	return it.err
+}
+
+// Close stops the workers and waits for them to release their parts
+func (it *parallelIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	close(it.stop)
+	it.workers.Wait()
+	return nil
+}
+
+// parallelInput returns the ParallelScanNode input of an aggregation whose
+// workers aggregate their parts, and the node recording its statistics
+// under Analyze. A traced or hooked input is aggregated row by row, to
+// pass on its rows.
+func (it *aggregateIterator) parallelInput() (*ParallelScanNode, *analyzedNode) {
+	if !it.parallel {
+		return nil, nil
+	}
+	input := it.input
+	analyzed, isAnalyzed := input.(*analyzedNode)
+	if isAnalyzed {
+		input = analyzed.Node
+	}
+	scan, ok := input.(*ParallelScanNode)
+	if !ok {
+		return nil, nil
+	}
+	return scan, analyzed
+}
+
+// initParallel aggregates each part of the scan in the worker scanning it,
+// then merges the groups of the parts in order, so that the groups keep the
+// order of their first row as when aggregated row by row
+func (it *aggregateIterator) initParallel(scan *ParallelScanNode, analyzed *analyzedNode) error {
+	parts, err := scan.split()
+	if err != nil {
+		return err
+	}
+	filter := query.CompileExpression(scan.Filter)
+	source := newParallelIterator(parts, scan.workers(len(parts)), func(part database.Table, stop <-chan struct{}) partRows {
+		groups := it.newGroupSet(0)
+		count := 0
+		err := scan.scan(it.ctx, part, filter, stop, func(row database.Row) error {
+			count++
+			return groups.add(row, 0, 0)
+		})
+		return partRows{groups: groups, count: count, err: err}
+	})
+	defer source.Close()
+
+	groups := it.newGroupSet(it.memoryLimit)
+	defer groups.close()
+	hasData := false
+	for {
+		start := time.Now()
+		part, ok := source.nextPart()
+		if analyzed != nil {
+			analyzed.stats.Time += time.Since(start)
+			analyzed.stats.Rows += int64(part.count)
+		}
+		if !ok {
+			break
+		}
+		if part.err != nil {
+			return part.err
+		}
+		hasData = hasData || part.count > 0
+		if err := groups.merge(part.groups); err != nil {
+			return err
+		}
+	}
+	return it.finish(groups, hasData)
+}
@@ -1,6 +1,7 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -13,14 +14,24 @@ type ProjectNode struct {
 	Input  Node
 	Fields []query.Field
 	Filter query.Expression
+	// CaseInsensitive resolves field paths regardless of key case
+	CaseInsensitive bool
+	// Sources keeps the parser.SourceField of the input rows
+	Sources bool
 }
 
-func (n *ProjectNode) Execute() (database.RowIterator, error) {
-	inputIter, err := n.Input.Execute()
+func (n *ProjectNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &projectIterator{source: inputIter, fields: n.Fields, filter: n.Filter}, nil
+	return &projectIterator{
+		source:          inputIter,
+		fields:          n.Fields,
+		filter:          n.Filter,
+		caseInsensitive: n.CaseInsensitive,
+		sources:         n.Sources,
+	}, nil
 }
 
 func (n *ProjectNode) Children() []Node {
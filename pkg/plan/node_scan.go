@@ -1,7 +1,9 @@
 package plan
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/bisegni/jsl/pkg/database"
 )
@@ -10,10 +12,27 @@ import (
 type ScanNode struct {
 	TableName string
 	Table     database.Table
+	// Positioned resolves the virtual _file and _line columns on the rows
+	// of tables supporting them (database.PositionedTable)
+	Positioned bool
+	// Contains skips the records lacking any of these strings before decoding
+	// them, on tables supporting it (database.PrefilteredTable)
+	Contains []string
 }
 
-func (n *ScanNode) Execute() (database.RowIterator, error) {
-	return n.Table.Iterate()
+func (n *ScanNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	if t, ok := n.Table.(database.PositionedTable); ok && n.Positioned {
+		return t.IteratePositioned(ctx)
+	}
+	return iterateContaining(ctx, n.Table, n.Contains)
+}
+
+// iterateContaining iterates a table, prefiltered on needles when supported
+func iterateContaining(ctx context.Context, table database.Table, needles []string) (database.RowIterator, error) {
+	if t, ok := table.(database.PrefilteredTable); ok && len(needles) > 0 {
+		return t.IterateContaining(ctx, needles)
+	}
+	return table.Iterate(ctx)
 }
 
 func (n *ScanNode) Children() []Node {
@@ -21,5 +40,20 @@ func (n *ScanNode) Children() []Node {
 }
 
 func (n *ScanNode) Explain() string {
+	if n.Positioned {
+		return fmt.Sprintf("Scan(table: %s, columns: %s, %s)", n.TableName, database.FileColumn, database.LineColumn)
+	}
+	if len(n.Contains) > 0 {
+		return fmt.Sprintf("Scan(table: %s, contains: %s)", n.TableName, quoteAll(n.Contains))
+	}
 	return fmt.Sprintf("Scan(table: %s)", n.TableName)
 }
+
+// quoteAll lists strings as SQL literals
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + v + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
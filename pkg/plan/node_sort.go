@@ -0,0 +1,109 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// SortNode orders the rows of its input (ORDER BY). Each key names a column
+// of the input rows, or a path into them; rows with equal keys keep their
+// input order. The whole input is read before the first row is returned,
+// rows beyond MemoryLimit spilling to sorted temporary files that are
+// merged as the rows are returned.
+type SortNode struct {
+	Input Node
+	Keys  []query.OrderKey
+	// MemoryLimit bounds the estimated size of the rows held in memory (0 =
+	// no limit)
+	MemoryLimit int64
+	// TempDir holds the spilled rows, os.TempDir() when empty
+	TempDir string
+}
+
+func (n *SortNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer inputIter.Close()
+
+	sorter := &rowSorter{
+		compare: func(a, b []interface{}) int { return query.CompareRows(a, b, n.Keys) },
+		limit:   n.MemoryLimit,
+		dir:     n.TempDir,
+	}
+	for inputIter.Next() {
+		row := inputIter.Row()
+		keys := make([]interface{}, len(n.Keys))
+		for i, key := range n.Keys {
+			keys[i] = columnValue(row, key.Path)
+		}
+		if err := sorter.add(row, keys); err != nil {
+			sorter.close()
+			return nil, err
+		}
+	}
+	if err := inputIter.Error(); err != nil {
+		sorter.close()
+		return nil, err
+	}
+	return sorter.iterator()
+}
+
+func (n *SortNode) Children() []Node {
+	return []Node{n.Input}
+}
+
+func (n *SortNode) Explain() string {
+	keys := make([]string, len(n.Keys))
+	for i, key := range n.Keys {
+		keys[i] = key.String()
+	}
+	return fmt.Sprintf("Sort(keys: %s)", strings.Join(keys, ", "))
+}
+
+// columnValue returns the value of a column of a projected row ("supplier.country"
+// is a key of its own there), falling back to the path into the row; a
+// missing value is null
+func columnValue(row database.Row, path string) interface{} {
+	if m, ok := row.Primitive().(database.OrderedMap); ok {
+		if val, ok := m.Get(query.DisplayPath(path)); ok {
+			return val
+		}
+	}
+	val, err := row.Get(path)
+	if err != nil {
+		return nil
+	}
+	return val
+}
+
+// sliceIterator returns rows held in memory
+type sliceIterator struct {
+	rows []database.Row
+	next int
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.next >= len(it.rows) {
+		return false
+	}
+	it.next++
+	return true
+}
+
+func (it *sliceIterator) Row() database.Row {
+	return it.rows[it.next-1]
+}
+
+func (it *sliceIterator) Error() error {
+	return nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package plan
+
+import (
+	"context"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// UnnestNode outputs a copy of each input row per element of the UNNEST
+// Field, the element set at the alias of the field, so that GROUP BY can
+// group the elements: "SELECT UNNEST(TOKENIZE(msg)) AS w, COUNT(*) GROUP BY
+// w" counts the rows of each word. A row whose array is empty or missing
+// produces none.
+type UnnestNode struct {
+	Input Node
+	Field query.Field
+	// CaseInsensitive resolves the field path regardless of key case
+	CaseInsensitive bool
+}
+
+func (n *UnnestNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &unnestIterator{source: inputIter, field: n.Field, caseInsensitive: n.CaseInsensitive}, nil
+}
+
+func (n *UnnestNode) Children() []Node {
+	return []Node{n.Input}
+}
+
+func (n *UnnestNode) Explain() string {
+	return "Unnest(field: " + n.Field.String() + ")"
+}
+
+type unnestIterator struct {
+	source          database.RowIterator
+	field           query.Field
+	caseInsensitive bool
+	row             database.Row
+	elements        []interface{}
+	current         database.Row
+}
+
+func (it *unnestIterator) Next() bool {
+	for len(it.elements) == 0 {
+		if !it.source.Next() {
+			return false
+		}
+		it.row = it.source.Row()
+		val, err := getField(it.row, it.field.Path, nil, it.caseInsensitive)
+		if err != nil {
+			val = nil
+		}
+		if it.field.Func != "" {
+			val = query.ApplyFunc(it.field.Func, val)
+		}
+		switch v := val.(type) {
+		case nil:
+			it.elements = nil
+		case []interface{}:
+			it.elements = v
+		default:
+			it.elements = []interface{}{v}
+		}
+	}
+	it.current = database.NewJSONRow(withKey(it.row.Primitive(), it.field.Alias, it.elements[0]))
+	it.elements = it.elements[1:]
+	return true
+}
+
+// withKey returns a copy of a record with key set to val, the record being
+// replaced by an object holding key alone when it is not an object
+func withKey(record interface{}, key string, val interface{}) interface{} {
+	switch v := record.(type) {
+	case database.OrderedMap:
+		return append(make(database.OrderedMap, 0, len(v)+1), v...).Set(key, val)
+	case map[string]interface{}:
+		return copyWithKey(v, key, val)
+	case parser.Record:
+		return copyWithKey(v, key, val)
+	}
+	return database.OrderedMap{{Key: key, Val: val}}
+}
+
+func copyWithKey(m map[string]interface{}, key string, val interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	c[key] = val
+	return c
+}
+
+func (it *unnestIterator) Row() database.Row {
+	return it.current
+}
+
+func (it *unnestIterator) Error() error {
+	return it.source.Error()
+}
+
+func (it *unnestIterator) Close() error {
+	return it.source.Close()
+}
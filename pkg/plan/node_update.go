@@ -0,0 +1,41 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// UpdateNode rewrites rows matching Filter (all rows if nil) and passes the others through
+type UpdateNode struct {
+	Input       Node
+	Assignments []query.Assignment
+	Filter      query.Expression
+}
+
+func (n *UpdateNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	inputIter, err := n.Input.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &updateIterator{source: inputIter, assignments: n.Assignments, filter: query.CompileExpression(n.Filter)}, nil
+}
+
+func (n *UpdateNode) Children() []Node {
+	return []Node{n.Input}
+}
+
+func (n *UpdateNode) Explain() string {
+	var sets []string
+	for _, a := range n.Assignments {
+		sets = append(sets, a.String())
+	}
+	where := "all"
+	if n.Filter != nil {
+		where = n.Filter.String()
+	}
+	return fmt.Sprintf("Update(set: [%s], where: %s)", strings.Join(sets, ", "), where)
+}
@@ -0,0 +1,834 @@
+package plan
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// DefaultMemoryLimit is the memory budget of the rows held by a sort or
+// an aggregation before they spill to temporary files
+const DefaultMemoryLimit = 1 << 30
+
+// spillFile is a temporary file written once, then read back from the start
+type spillFile struct {
+	file *os.File
+	w    *bufio.Writer
+	r    *bufio.Reader
+	buf  []byte
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	file, err := os.CreateTemp(dir, "jsl-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &spillFile{file: file, w: bufio.NewWriterSize(file, 256*1024)}, nil
+}
+
+// write appends an entry encoded by encode
+func (f *spillFile) write(encode func(b []byte) ([]byte, error)) error {
+	var err error
+	if f.buf, err = encode(f.buf[:0]); err != nil {
+		return err
+	}
+	_, err = f.w.Write(f.buf)
+	return err
+}
+
+// rewind ends the writing and reads the file from the start
+func (f *spillFile) rewind() error {
+	if err := f.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.r = bufio.NewReaderSize(f.file, 256*1024)
+	return nil
+}
+
+// Close removes the file
+func (f *spillFile) Close() error {
+	err := f.file.Close()
+	if rmErr := os.Remove(f.file.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// mergeFanIn is the number of runs merged at once: beyond it, the runs are
+// merged in several passes, keeping the files open bounded
+const mergeFanIn = 64
+
+// sortRow is a row and the values it is sorted on
+type sortRow struct {
+	row  database.Row
+	keys []interface{}
+}
+
+// rowSorter stably sorts rows on their keys within a memory budget: the rows
+// are held until their estimated size exceeds limit, then sorted and written
+// to a temporary file (a run), the runs being merged as the rows are read.
+// A limit of 0 holds every row in memory.
+type rowSorter struct {
+	compare func(a, b []interface{}) int
+	limit   int64
+	dir     string
+	fanIn   int // runs merged at once, mergeFanIn if 0
+
+	rows []sortRow
+	size int64
+	runs []*spillFile
+}
+
+// add adds a row, spilling the rows held when over the budget
+func (s *rowSorter) add(row database.Row, keys []interface{}) error {
+	s.rows = append(s.rows, sortRow{row: row, keys: keys})
+	if s.limit <= 0 {
+		return nil
+	}
+	s.size += valueSize(row.Primitive()) + valueSize(keys)
+	if s.size > s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *rowSorter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return s.compare(s.rows[i].keys, s.rows[j].keys) < 0
+	})
+}
+
+// spill writes the rows held as a sorted run
+func (s *rowSorter) spill() error {
+	s.sortRows()
+	run, err := newSpillFile(s.dir)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	for i, r := range s.rows {
+		if err := run.write(func(b []byte) ([]byte, error) { return appendSortRow(b, r) }); err != nil {
+			return fmt.Errorf("failed to write spill file: %w", err)
+		}
+		s.rows[i] = sortRow{}
+	}
+	if err := run.rewind(); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.rows, s.size = s.rows[:0], 0
+	return nil
+}
+
+// iterator returns the rows in order
+func (s *rowSorter) iterator() (database.RowIterator, error) {
+	s.sortRows()
+	if len(s.runs) == 0 {
+		rows := make([]database.Row, len(s.rows))
+		for i, r := range s.rows {
+			rows[i] = r.row
+		}
+		return &sliceIterator{rows: rows}, nil
+	}
+	if err := s.compact(); err != nil {
+		s.close()
+		return nil, err
+	}
+	it := &mergeIterator{compare: s.compare, runs: s.runs, memory: s.rows}
+	for i := range s.runs {
+		if err := it.advance(i); err != nil {
+			it.Close()
+			return nil, err
+		}
+	}
+	// The rows held sort after the runs, which were spilled before them
+	if err := it.advance(len(s.runs)); err != nil {
+		it.Close()
+		return nil, err
+	}
+	heap.Init(it)
+	return it, nil
+}
+
+// compact merges consecutive runs fanIn at a time into longer runs, in as
+// many passes as needed for at most fanIn to remain. Rows with equal keys
+// keep the order of their runs.
+func (s *rowSorter) compact() error {
+	fanIn := s.fanIn
+	if fanIn <= 0 {
+		fanIn = mergeFanIn
+	}
+	for len(s.runs) > fanIn {
+		merged := make([]*spillFile, 0, (len(s.runs)+fanIn-1)/fanIn)
+		for start := 0; start < len(s.runs); start += fanIn {
+			end := min(start+fanIn, len(s.runs))
+			if end-start == 1 {
+				merged = append(merged, s.runs[start])
+				continue
+			}
+			run, err := s.mergeRuns(s.runs[start:end])
+			if err != nil {
+				s.runs = append(merged, s.runs[end:]...)
+				return err
+			}
+			merged = append(merged, run)
+		}
+		s.runs = merged
+	}
+	return nil
+}
+
+// mergeRuns merges runs into a new one, removing them
+func (s *rowSorter) mergeRuns(runs []*spillFile) (*spillFile, error) {
+	it := &mergeIterator{compare: s.compare, runs: runs}
+	defer it.Close()
+	for i := range runs {
+		if err := it.advance(i); err != nil {
+			return nil, err
+		}
+	}
+	heap.Init(it)
+
+	run, err := newSpillFile(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for it.Next() {
+		if err := run.write(func(b []byte) ([]byte, error) { return appendSortRow(b, it.current) }); err != nil {
+			run.Close()
+			return nil, fmt.Errorf("failed to write spill file: %w", err)
+		}
+	}
+	if err := it.Error(); err != nil {
+		run.Close()
+		return nil, err
+	}
+	if err := run.rewind(); err != nil {
+		run.Close()
+		return nil, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	return run, nil
+}
+
+// close removes the runs of a sort abandoned before iterator
+func (s *rowSorter) close() {
+	for _, run := range s.runs {
+		run.Close()
+	}
+	s.runs = nil
+}
+
+// mergeIterator merges sorted runs, and the rows left in memory as the last
+// run. Rows with equal keys come from the earlier run first, keeping the sort
+// stable.
+type mergeIterator struct {
+	compare func(a, b []interface{}) int
+	runs    []*spillFile
+	memory  []sortRow
+
+	heads   []sortRow // the next row of each source
+	order   []int     // heap of the sources with a next row
+	current sortRow
+	err     error
+}
+
+// advance reads the next row of source i, dropping the source at its end
+func (it *mergeIterator) advance(i int) error {
+	if len(it.heads) == 0 {
+		it.heads = make([]sortRow, len(it.runs)+1)
+	}
+	if i == len(it.runs) {
+		if len(it.memory) == 0 {
+			return nil
+		}
+		it.heads[i] = it.memory[0]
+		it.memory[0] = sortRow{}
+		it.memory = it.memory[1:]
+	} else {
+		r, err := readSortRow(it.runs[i].r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read spill file: %w", err)
+		}
+		it.heads[i] = r
+	}
+	it.order = append(it.order, i)
+	return nil
+}
+
+func (it *mergeIterator) Len() int { return len(it.order) }
+func (it *mergeIterator) Less(i, j int) bool {
+	a, b := it.order[i], it.order[j]
+	if c := it.compare(it.heads[a].keys, it.heads[b].keys); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+func (it *mergeIterator) Swap(i, j int)      { it.order[i], it.order[j] = it.order[j], it.order[i] }
+func (it *mergeIterator) Push(x interface{}) { it.order = append(it.order, x.(int)) }
+func (it *mergeIterator) Pop() interface{} {
+	last := it.order[len(it.order)-1]
+	it.order = it.order[:len(it.order)-1]
+	return last
+}
+
+func (it *mergeIterator) Next() bool {
+	if it.err != nil || len(it.order) == 0 {
+		return false
+	}
+	i := heap.Pop(it).(int)
+	it.current = it.heads[i]
+	it.heads[i] = sortRow{}
+	n := len(it.order)
+	if it.err = it.advance(i); it.err != nil {
+		return false
+	}
+	if len(it.order) > n {
+		heap.Fix(it, len(it.order)-1)
+	}
+	return true
+}
+
+func (it *mergeIterator) Row() database.Row {
+	return it.current.row
+}
+
+func (it *mergeIterator) Error() error {
+	return it.err
+}
+
+// Close removes the runs
+func (it *mergeIterator) Close() error {
+	var err error
+	for _, run := range it.runs {
+		if closeErr := run.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	it.runs, it.order = nil, nil
+	return err
+}
+
+// appendSortRow encodes a row and its keys; the virtual columns of a
+// database.PositionedRow are kept
+func appendSortRow(b []byte, r sortRow) ([]byte, error) {
+	b, err := appendRow(b, r.row)
+	if err != nil {
+		return nil, err
+	}
+	return appendValue(b, r.keys)
+}
+
+func readSortRow(r *bufio.Reader) (sortRow, error) {
+	row, err := readRow(r)
+	if err != nil {
+		return sortRow{}, err
+	}
+	keys, err := readValue(r)
+	if err != nil {
+		return sortRow{}, unexpectedEOF(err)
+	}
+	k, _ := keys.([]interface{})
+	return sortRow{row: row, keys: k}, nil
+}
+
+func appendRow(b []byte, row database.Row) ([]byte, error) {
+	if p, ok := row.(*database.PositionedRow); ok {
+		b = append(b, 1)
+		b = appendString(b, p.File)
+		b = binary.AppendVarint(b, int64(p.Line))
+		return appendValue(b, p.Row.Primitive())
+	}
+	return appendValue(append(b, 0), row.Primitive())
+}
+
+func readRow(r *bufio.Reader) (database.Row, error) {
+	positioned, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if positioned == 0 {
+		data, err := readValue(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return database.NewJSONRow(data), nil
+	}
+	file, err := readString(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	line, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	data, err := readValue(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &database.PositionedRow{Row: database.NewJSONRow(data), File: file, Line: int(line)}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Type tags of the spilled values
+const (
+	spillNil byte = iota
+	spillFalse
+	spillTrue
+	spillFloat
+	spillInt
+	spillInt64
+	spillUint64
+	spillString
+	spillNumber
+	spillTime
+	spillBytes
+	spillArray
+	spillOrdered
+	spillRecord
+	spillMap
+)
+
+// appendValue encodes a value so that readValue returns it with its Go type
+// (int stays int, an empty array is not nil, a time keeps its zone). Types
+// outside those of decoded records go through their JSON encoding.
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, spillNil), nil
+	case bool:
+		if v {
+			return append(b, spillTrue), nil
+		}
+		return append(b, spillFalse), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, spillFloat), math.Float64bits(v)), nil
+	case int:
+		return binary.AppendVarint(append(b, spillInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(b, spillInt64), v), nil
+	case uint64:
+		return binary.AppendUvarint(append(b, spillUint64), v), nil
+	case string:
+		return appendString(append(b, spillString), v), nil
+	case json.Number:
+		return appendString(append(b, spillNumber), string(v)), nil
+	case time.Time:
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(append(b, spillTime), string(data)), nil
+	case []byte:
+		return appendString(append(b, spillBytes), string(v)), nil
+	case []interface{}:
+		b = binary.AppendUvarint(append(b, spillArray), uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = appendValue(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case database.OrderedMap:
+		b = binary.AppendUvarint(append(b, spillOrdered), uint64(len(v)))
+		for _, kv := range v {
+			b = appendString(b, kv.Key)
+			var err error
+			if b, err = appendValue(b, kv.Val); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case parser.Record:
+		return appendMap(append(b, spillRecord), v)
+	case map[string]interface{}:
+		return appendMap(append(b, spillMap), v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot spill %T: %w", v, err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return appendValue(b, generic)
+}
+
+func appendMap(b []byte, m map[string]interface{}) ([]byte, error) {
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	for k, v := range m {
+		b = appendString(b, k)
+		var err error
+		if b, err = appendValue(b, v); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readValue decodes a value encoded by appendValue
+func readValue(r *bufio.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case spillNil:
+		return nil, nil
+	case spillFalse:
+		return false, nil
+	case spillTrue:
+		return true, nil
+	case spillFloat:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf[:])), nil
+	case spillInt:
+		v, err := binary.ReadVarint(r)
+		return int(v), err
+	case spillInt64:
+		return binary.ReadVarint(r)
+	case spillUint64:
+		return binary.ReadUvarint(r)
+	case spillString:
+		return readString(r)
+	case spillNumber:
+		s, err := readString(r)
+		return json.Number(s), err
+	case spillTime:
+		s, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		err = t.UnmarshalBinary([]byte(s))
+		return t, err
+	case spillBytes:
+		s, err := readString(r)
+		return []byte(s), err
+	case spillArray:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case spillOrdered:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		m := make(database.OrderedMap, n)
+		for i := range m {
+			if m[i].Key, err = readString(r); err != nil {
+				return nil, err
+			}
+			if m[i].Val, err = readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case spillRecord, spillMap:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[k], err = readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		if tag == spillRecord {
+			return parser.Record(m), nil
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("invalid spilled value tag %d", tag)
+}
+
+// valueSize estimates the memory held by a value
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		return 16 + int64(len(v))
+	case []byte:
+		return 24 + int64(len(v))
+	case []interface{}:
+		size := int64(24)
+		for _, item := range v {
+			size += 16 + valueSize(item)
+		}
+		return size
+	case database.OrderedMap:
+		size := int64(24)
+		for _, kv := range v {
+			size += 32 + int64(len(kv.Key)) + valueSize(kv.Val)
+		}
+		return size
+	case parser.Record:
+		return valueSize(map[string]interface{}(v))
+	case map[string]interface{}:
+		size := int64(48)
+		for k, item := range v {
+			size += 48 + int64(len(k)) + valueSize(item)
+		}
+		return size
+	}
+	return 16
+}
+
+// spillPartitions is the number of files the groups of an aggregation
+// spill to
+const spillPartitions = 32
+
+// groupSpill holds the partial aggregates of groups spilled to temporary
+// files, partitioned on a hash of the group key so that each partition can
+// then be aggregated in memory on its own
+type groupSpill struct {
+	dir   string
+	parts [spillPartitions]*spillFile
+}
+
+// groupSize estimates the memory held by a new group
+func groupSize(key string, value interface{}, state *groupState) int64 {
+	return 128 + int64(len(key)) + valueSize(value) + 64*int64(len(state.aggs))
+}
+
+// write spills groups, in the order of keys
+func (g *groupSpill) write(keys []string, values map[string]interface{}, groups map[string]*groupState) error {
+	for _, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		i := h.Sum32() % spillPartitions
+		if g.parts[i] == nil {
+			part, err := newSpillFile(g.dir)
+			if err != nil {
+				return err
+			}
+			g.parts[i] = part
+		}
+		err := g.parts[i].write(func(b []byte) ([]byte, error) {
+			return appendGroup(b, key, values[key], groups[key])
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write spill file: %w", err)
+		}
+	}
+	return nil
+}
+
+// merge aggregates the spilled groups a partition at a time, returning the
+// results in the order of an aggregation in memory: by group value, then
+// by first row
+func (g *groupSpill) merge(fields []query.Field, groupByField string, sources bool, limit int64) (database.RowIterator, error) {
+	sorter := &rowSorter{compare: compareGroups, limit: limit, dir: g.dir}
+	for i, part := range g.parts {
+		if part == nil {
+			continue
+		}
+		if err := part.rewind(); err != nil {
+			sorter.close()
+			return nil, fmt.Errorf("failed to write spill file: %w", err)
+		}
+		groups := make(map[string]*groupState)
+		values := make(map[string]interface{})
+		var keys []string
+		for {
+			group, err := readGroup(part.r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				sorter.close()
+				return nil, fmt.Errorf("failed to read spill file: %w", err)
+			}
+			state, ok := groups[group.key]
+			if !ok {
+				state = newGroupState(fields, sources)
+				state.first = group.first
+				groups[group.key] = state
+				values[group.key] = group.value
+				keys = append(keys, group.key)
+			}
+			// Partial aggregates are merged in the order they were spilled
+			state.first = min(state.first, group.first)
+			state.sources = append(state.sources, group.sources...)
+			n := 0
+			for j := range fields {
+				if agg, ok := state.aggs[keyFor(j)]; ok && n < len(group.aggs) {
+					agg.Merge(group.aggs[n])
+					n++
+				}
+			}
+		}
+		for _, key := range keys {
+			state := groups[key]
+			if err := sorter.add(state.finalize(values[key], groupByField), []interface{}{values[key], state.first}); err != nil {
+				sorter.close()
+				return nil, err
+			}
+		}
+		part.Close()
+		g.parts[i] = nil
+	}
+	return sorter.iterator()
+}
+
+// close removes the partitions
+func (g *groupSpill) close() {
+	for i, part := range g.parts {
+		if part != nil {
+			part.Close()
+			g.parts[i] = nil
+		}
+	}
+}
+
+// compareGroups orders aggregated rows on their group value, the null group
+// last, then on the order of their first row
+func compareGroups(a, b []interface{}) int {
+	if c := query.CompareOrder(a[0], b[0], query.NullsLast); c != 0 {
+		return c
+	}
+	return cmp.Compare(a[1].(int64), b[1].(int64))
+}
+
+// spilledGroup is a group read back from a partition
+type spilledGroup struct {
+	key     string
+	value   interface{}
+	first   int64
+	sources []interface{}
+	aggs    []aggState
+}
+
+func appendGroup(b []byte, key string, value interface{}, state *groupState) ([]byte, error) {
+	b = appendString(b, key)
+	b, err := appendValue(b, value)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.AppendVarint(b, state.first)
+	if b, err = appendValue(b, state.sources); err != nil {
+		return nil, err
+	}
+	var aggs []aggState
+	for i := range state.fields {
+		if agg, ok := state.aggs[keyFor(i)]; ok {
+			aggs = append(aggs, agg.Partial())
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(aggs)))
+	for _, s := range aggs {
+		if b, err = appendValue(b, s.val); err != nil {
+			return nil, err
+		}
+		if b, err = appendValue(b, s.set); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(s.sum))
+		b = binary.AppendVarint(b, int64(s.count))
+	}
+	return b, nil
+}
+
+func readGroup(r *bufio.Reader) (spilledGroup, error) {
+	var g spilledGroup
+	var err error
+	if g.key, err = readString(r); err != nil {
+		return g, err
+	}
+	fail := func(err error) (spilledGroup, error) { return g, unexpectedEOF(err) }
+	if g.value, err = readValue(r); err != nil {
+		return fail(err)
+	}
+	if g.first, err = binary.ReadVarint(r); err != nil {
+		return fail(err)
+	}
+	sources, err := readValue(r)
+	if err != nil {
+		return fail(err)
+	}
+	g.sources, _ = sources.([]interface{})
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fail(err)
+	}
+	g.aggs = make([]aggState, n)
+	for i := range g.aggs {
+		s := &g.aggs[i]
+		if s.val, err = readValue(r); err != nil {
+			return fail(err)
+		}
+		set, err := readValue(r)
+		if err != nil {
+			return fail(err)
+		}
+		s.set, _ = set.(bool)
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return fail(err)
+		}
+		s.sum = math.Float64frombits(binary.BigEndian.Uint64(buf[:]))
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return fail(err)
+		}
+		s.count = int(count)
+	}
+	return g, nil
+}
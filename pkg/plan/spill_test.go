@@ -0,0 +1,64 @@
+package plan
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+func TestRowSorterMergePasses(t *testing.T) {
+	compare := func(a, b []interface{}) int { return query.CompareOrder(a[0], b[0], query.NullsFirst) }
+	for _, fanIn := range []int{2, 3, 7, 0} {
+		dir := t.TempDir()
+		// A limit of one byte spills every row to a run of its own
+		s := &rowSorter{compare: compare, limit: 1, dir: dir, fanIn: fanIn}
+		const rows = 100
+		for i := 0; i < rows; i++ {
+			k := (i * 7) % 10
+			if err := s.add(database.NewJSONRow(map[string]interface{}{"k": k, "seq": i}), []interface{}{k}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(s.runs) != rows {
+			t.Fatalf("Expected %d runs, got %d", rows, len(s.runs))
+		}
+
+		it, err := s.iterator()
+		if err != nil {
+			t.Fatalf("fan-in %d: iterator failed: %v", fanIn, err)
+		}
+		maxRuns := fanIn
+		if maxRuns == 0 {
+			maxRuns = mergeFanIn
+		}
+		if len(s.runs) > maxRuns {
+			t.Errorf("fan-in %d: expected at most %d runs left to merge, got %d", fanIn, maxRuns, len(s.runs))
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != len(s.runs) {
+			t.Errorf("fan-in %d: expected the merged runs to be removed, %d file(s) left for %d run(s)", fanIn, len(entries), len(s.runs))
+		}
+
+		// Sorted on k, rows with equal keys in the order they were added
+		count, lastK, lastSeq := 0, -1, -1
+		for it.Next() {
+			m := it.Row().Primitive().(map[string]interface{})
+			k, seq := m["k"].(int), m["seq"].(int)
+			if k < lastK || (k == lastK && seq < lastSeq) {
+				t.Fatalf("fan-in %d: row k=%d seq=%d after k=%d seq=%d", fanIn, k, seq, lastK, lastSeq)
+			}
+			count, lastK, lastSeq = count+1, k, seq
+		}
+		if err := it.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if count != rows {
+			t.Errorf("fan-in %d: expected %d rows, got %d", fanIn, rows, count)
+		}
+		it.Close()
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("fan-in %d: expected the runs to be removed, %d file(s) left", fanIn, len(entries))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
+)
+
+// Tracer logs the rows produced by plan nodes, to debug where rows get lost
+type Tracer struct {
+	W io.Writer
+	// Limit caps the number of rows logged per node (0 means no limit)
+	Limit int
+	// Nodes selects which nodes to trace, by kind ("Filter") or id ("2").
+	// Empty traces every node.
+	Nodes []string
+
+	mu sync.Mutex
+}
+
+// Trace wraps every node of the plan so that the rows it produces are logged.
+// Nodes are numbered in pre-order starting at 1 (the root).
+func Trace(root Node, t *Tracer) Node {
+	next := 0
+	return trace(root, t, &next)
+}
+
+func trace(n Node, t *Tracer, next *int) Node {
+	*next++
+	id := *next
+
+	mapInputs(n, func(input Node) Node {
+		return trace(input, t, next)
+	})
+
+	kind := nodeKind(n)
+	if !t.selects(id, kind) {
+		return n
+	}
+	return &tracedNode{Node: n, id: id, kind: kind, tracer: t}
+}
+
+// mapInputs replaces the inputs of a node (through the wrappers of Trace,
+// Analyze and Hook) with f of them, in order
+func mapInputs(n Node, f func(Node) Node) {
+	switch node := n.(type) {
+	case *FilterNode:
+		node.Input = f(node.Input)
+	case *ProjectNode:
+		node.Input = f(node.Input)
+	case *AggregateNode:
+		node.Input = f(node.Input)
+	case *UpdateNode:
+		node.Input = f(node.Input)
+	case *DeleteNode:
+		node.Input = f(node.Input)
+	case *FieldCheckNode:
+		node.Input = f(node.Input)
+	case *UnnestNode:
+		node.Input = f(node.Input)
+	case *SortNode:
+		node.Input = f(node.Input)
+	case *LimitNode:
+		node.Input = f(node.Input)
+	case *tracedNode:
+		mapInputs(node.Node, f)
+	case *analyzedNode:
+		mapInputs(node.Node, f)
+	case *hookedNode:
+		mapInputs(node.Node, f)
+	}
+}
+
+// nodeKind returns the node name used in Explain (e.g. "Filter")
+func nodeKind(n Node) string {
+	explain := n.Explain()
+	if i := strings.Index(explain, "("); i > 0 {
+		return explain[:i]
+	}
+	return explain
+}
+
+func (t *Tracer) selects(id int, kind string) bool {
+	if len(t.Nodes) == 0 {
+		return true
+	}
+	for _, sel := range t.Nodes {
+		sel = strings.TrimSpace(sel)
+		if strings.EqualFold(sel, "all") || strings.EqualFold(sel, kind) || sel == strconv.Itoa(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracer) log(id int, kind string, seq int, row database.Row) {
+	data, err := json.Marshal(row.Primitive())
+	if err != nil {
+		data = []byte(fmt.Sprintf("%q", err.Error()))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.W, "[trace] #%d %s row %d: %s\n", id, kind, seq, data)
+}
+
+// tracedNode is a transparent wrapper logging the rows of the wrapped node
+type tracedNode struct {
+	Node
+	id     int
+	kind   string
+	tracer *Tracer
+}
+
+func (n *tracedNode) Execute(ctx context.Context) (database.RowIterator, error) {
+	it, err := n.Node.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedIterator{RowIterator: it, node: n}, nil
+}
+
+type tracedIterator struct {
+	database.RowIterator
+	node  *tracedNode
+	count int
+}
+
+func (it *tracedIterator) Next() bool {
+	if !it.RowIterator.Next() {
+		return false
+	}
+	it.count++
+	limit := it.node.tracer.Limit
+	if limit <= 0 || it.count <= limit {
+		it.node.tracer.log(it.node.id, it.node.kind, it.count, it.RowIterator.Row())
+	} else if it.count == limit+1 {
+		diag.Info(diag.CodeTruncated,
+			fmt.Sprintf("trace of #%d %s truncated after %d row(s) (see --trace-limit)", it.node.id, it.node.kind, limit),
+			"node", it.node.id, "kind", it.node.kind, "limit", limit)
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package plan_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/plan"
+	"github.com/bisegni/jsl/pkg/query"
+)
+
+// tracePlan returns the plan of "SELECT a WHERE a > 1 LIMIT 2" over a = 1..5,
+// its nodes numbered 1 Limit, 2 Project, 3 Filter, 4 Scan
+func tracePlan() plan.Node {
+	rows := make([]map[string]interface{}, 5)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"a": i + 1}
+	}
+	scan := &plan.ScanNode{TableName: "t", Table: database.NewSliceTable(rows)}
+	filter := &plan.FilterNode{Input: scan, Expression: query.ParseExpression("a > 1")}
+	project := &plan.ProjectNode{Input: filter, Fields: []query.Field{{Path: "a"}}}
+	return &plan.LimitNode{Input: project, Count: 2}
+}
+
+// runTraced executes a traced plan, returning its rows as JSON lines
+func runTraced(t *testing.T, root plan.Node) string {
+	t.Helper()
+	it, err := root.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	defer it.Close()
+	var rows []string
+	for it.Next() {
+		data, err := json.Marshal(it.Row().Primitive())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, string(data))
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("Iteration failed: %v", err)
+	}
+	return strings.Join(rows, "\n")
+}
+
+func TestTrace(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    []string
+		limit    int
+		expected string
+	}{
+		{
+			name:  "every node",
+			nodes: nil,
+			expected: `[trace] #4 Scan row 1: {"a":1}
+[trace] #4 Scan row 2: {"a":2}
+[trace] #3 Filter row 1: {"a":2}
+[trace] #2 Project row 1: {"a":2}
+[trace] #1 Limit row 1: {"a":2}
+[trace] #4 Scan row 3: {"a":3}
+[trace] #3 Filter row 2: {"a":3}
+[trace] #2 Project row 2: {"a":3}
+[trace] #1 Limit row 2: {"a":3}
+`,
+		},
+		{
+			name:  "by id",
+			nodes: []string{"3"},
+			expected: `[trace] #3 Filter row 1: {"a":2}
+[trace] #3 Filter row 2: {"a":3}
+`,
+		},
+		{
+			name:  "by kind, regardless of case",
+			nodes: []string{"scan", " Limit"},
+			expected: `[trace] #4 Scan row 1: {"a":1}
+[trace] #4 Scan row 2: {"a":2}
+[trace] #1 Limit row 1: {"a":2}
+[trace] #4 Scan row 3: {"a":3}
+[trace] #1 Limit row 2: {"a":3}
+`,
+		},
+		{
+			name:     "no match",
+			nodes:    []string{"Sort", "9"},
+			expected: "",
+		},
+		{
+			name:  "row limit per node",
+			nodes: []string{"Scan", "Filter"},
+			limit: 1,
+			expected: `[trace] #4 Scan row 1: {"a":1}
+[trace] #3 Filter row 1: {"a":2}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			root := plan.Trace(tracePlan(), &plan.Tracer{W: &out, Limit: tt.limit, Nodes: tt.nodes})
+			// Tracing leaves the rows unchanged
+			if rows := runTraced(t, root); rows != "{\"a\":2}\n{\"a\":3}" {
+				t.Errorf("Unexpected rows %s", rows)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("Expected trace\n%s\ngot\n%s", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestTraceLimitNotice(t *testing.T) {
+	var notices bytes.Buffer
+	r := diag.Default()
+	savedW, savedFormat := r.W, r.Format
+	r.W, r.Format = &notices, diag.FormatText
+	defer func() { r.W, r.Format = savedW, savedFormat }()
+
+	var out bytes.Buffer
+	runTraced(t, plan.Trace(tracePlan(), &plan.Tracer{W: &out, Limit: 1, Nodes: []string{"Scan"}}))
+	if got := strings.Count(out.String(), "\n"); got != 1 {
+		t.Errorf("Expected a single traced row, got\n%s", out.String())
+	}
+	// Reported once, when the second row is not traced
+	if got := notices.String(); strings.Count(got, "truncated") != 1 || !strings.Contains(got, "#4 Scan truncated after 1 row(s)") {
+		t.Errorf("Expected one truncation notice for the scan, got %q", got)
+	}
+}
+
+func TestTraceFile(t *testing.T) {
+	// As --trace-file does, the trace goes to a file
+	name := filepath.Join(t.TempDir(), "trace.log")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTraced(t, plan.Trace(tracePlan(), &plan.Tracer{W: f, Nodes: []string{"1"}}))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[trace] #1 Limit row 1: {\"a\":2}\n[trace] #1 Limit row 2: {\"a\":3}\n"
+	if string(data) != expected {
+		t.Errorf("Expected trace file\n%s\ngot\n%s", expected, data)
+	}
+}
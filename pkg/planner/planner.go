@@ -1,42 +1,167 @@
 package planner
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/diag"
+	"github.com/bisegni/jsl/pkg/parser"
 	"github.com/bisegni/jsl/pkg/plan"
 	"github.com/bisegni/jsl/pkg/query"
 )
 
-// CreatePlan converts a Query IR into an Execution Plan
+// CreatePlan converts a Query IR into an Execution Plan. Every FROM naming
+// a table scans rootTable; Resolver.CreatePlan resolves the names.
+//
+// q is not changed (a clone is planned), so that a parsed query can be
+// planned again or by several goroutines at once. Each plan returned is
+// executed by one goroutine at a time.
 func CreatePlan(q *query.SelectQuery, rootTable database.Table) (plan.Node, error) {
+	return createPlan(q.Clone(), rootTable, nil)
+}
+
+// Resolver resolves the tables named by FROM clauses ("FROM events",
+// "FROM 'other.jsonl'")
+type Resolver struct {
+	// Catalog holds the tables looked up first by name
+	Catalog *database.Catalog
+	// Open returns the table of a name that is not in the catalog, or nil
+	// when it names no file either
+	Open func(name string) (database.Table, error)
+}
+
+// CreatePlan is CreatePlan scanning the table a FROM names: a catalog
+// table, else the file Open opens, else rootTable (the default input)
+func (r *Resolver) CreatePlan(q *query.SelectQuery, rootTable database.Table) (plan.Node, error) {
+	return createPlan(q.Clone(), rootTable, r)
+}
+
+// table returns the table a FROM names, rootTable when r resolves nothing
+func (r *Resolver) table(name string, rootTable database.Table) (database.Table, error) {
+	if r == nil {
+		return rootTable, nil
+	}
+	if r.Catalog != nil {
+		if table, err := r.Catalog.GetTable(name); err == nil {
+			return table, nil
+		}
+	}
+	if r.Open != nil {
+		table, err := r.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("FROM %s: %w", name, err)
+		}
+		if table != nil {
+			return table, nil
+		}
+	}
+	return rootTable, nil
+}
+
+func createPlan(q *query.SelectQuery, rootTable database.Table, r *Resolver) (plan.Node, error) {
 	// 1. Resolve Input (FROM)
 	var inputNode plan.Node
+	var scan *plan.ScanNode
 
 	if q.FromQuery != nil {
-		// Recursive subquery
-		subPlan, err := CreatePlan(q.FromQuery, rootTable)
+		if q.FromQuery.Into != "" {
+			return nil, fmt.Errorf("INTO is only allowed in the outermost query")
+		}
+		// Recursive subquery (inherits engine options)
+		if q.CaseInsensitive {
+			q.FromQuery.CaseInsensitive = true
+		}
+		if q.Strict {
+			q.FromQuery.Strict = true
+		}
+		if q.IgnoreCase {
+			q.FromQuery.IgnoreCase = true
+		}
+		if q.Sources {
+			q.FromQuery.Sources = true
+		}
+		if q.FromQuery.ArrayMatch == "" {
+			q.FromQuery.ArrayMatch = q.ArrayMatch
+		}
+		if q.FromQuery.Workers == 0 {
+			q.FromQuery.Workers = q.Workers
+		}
+		if q.FromQuery.SortedBy == "" {
+			q.FromQuery.SortedBy = q.SortedBy
+		}
+		if q.FromQuery.MemoryLimit == 0 {
+			q.FromQuery.MemoryLimit, q.FromQuery.TempDir = q.MemoryLimit, q.TempDir
+		}
+		subPlan, err := createPlan(q.FromQuery, rootTable, r)
 		if err != nil {
 			return nil, err
 		}
 		inputNode = subPlan
 	} else if q.FromTable != "" {
 		// Named table
-		inputNode = &plan.ScanNode{TableName: q.FromTable, Table: rootTable}
+		table, err := r.table(q.FromTable, rootTable)
+		if err != nil {
+			return nil, err
+		}
+		scan = &plan.ScanNode{TableName: q.FromTable, Table: table}
+		inputNode = scan
 	} else {
 		// Default input
-		inputNode = &plan.ScanNode{TableName: "default", Table: rootTable}
+		scan = &plan.ScanNode{TableName: "default", Table: rootTable}
+		inputNode = scan
 	}
 
 	var currentNode plan.Node = inputNode
 
+	// Aliases of the SELECT list can be referenced in WHERE and GROUP BY
+	if err := q.ResolveAliases(); err != nil {
+		return nil, err
+	}
+	if scan != nil {
+		scan.Positioned = referencesMetadata(referencedFields(q), q.OrderBy)
+	}
+
+	// Strict mode: verify referenced fields exist in the input
+	if q.Strict {
+		if fields := referencedFields(q); len(fields) > 0 {
+			currentNode = &plan.FieldCheckNode{
+				Input:           currentNode,
+				Fields:          fields,
+				CaseInsensitive: q.CaseInsensitive,
+			}
+		}
+	}
+
 	// 2. Apply WHERE (Filter)
 	if q.Filter != nil {
+		if q.CaseInsensitive {
+			query.SetCaseInsensitive(q.Filter, true)
+		}
+		if q.IgnoreCase {
+			query.SetIgnoreCase(q.Filter, true)
+		}
+		if q.ArrayMatch != "" {
+			query.SetDefaultQuantifier(q.Filter, q.ArrayMatch)
+		}
 		currentNode = &plan.FilterNode{
 			Input:      currentNode,
 			Expression: q.Filter,
 		}
 	}
+	if scan != nil && q.Filter != nil && !scan.Positioned && !q.Strict {
+		// Strict mode checks the fields of every record, skipped or not
+		scan.Contains = requiredStrings(q.Filter)
+	}
+	currentNode = parallelScan(q, scan, currentNode)
 
 	// 3. Apply GroupBy / Aggregation
+	for _, f := range q.Fields {
+		if f.Bucket != nil && !f.Bucket.Equal(q.GroupBucket) {
+			return nil, fmt.Errorf("%s requires GROUP BY %s", f.Bucket, f.Bucket)
+		}
+	}
 	hasAggregation := q.GroupBy != ""
 	if !hasAggregation {
 		for _, f := range q.Fields {
@@ -47,20 +172,320 @@ func CreatePlan(q *query.SelectQuery, rootTable database.Table) (plan.Node, erro
 		}
 	}
 
+	// ORDER BY on source fields sorts the rows before they are projected
+	orderKeys, sortSource := sortKeys(q, hasAggregation)
+	if len(q.OrderBy) > 0 && sortSource {
+		currentNode = &plan.SortNode{Input: currentNode, Keys: orderKeys, MemoryLimit: q.MemoryLimit, TempDir: q.TempDir}
+	}
+
+	if q.GroupUnnest != nil {
+		currentNode = &plan.UnnestNode{Input: currentNode, Field: *q.GroupUnnest, CaseInsensitive: q.CaseInsensitive}
+	}
+
 	if hasAggregation {
+		fields, err := groupedFields(q)
+		if err != nil {
+			return nil, err
+		}
+		q.Fields = fields
+		// The workers of a parallel scan aggregate the parts they scan
+		_, parallel := currentNode.(*plan.ParallelScanNode)
+		if q.GroupBucket != nil && q.GroupBucket.Func == query.FuncHistogram {
+			parallel = false
+		}
+		// Groups of sorted rows are contiguous, and streamed in input order
+		sorted := sortedInput(q, scan, currentNode)
+		if sorted {
+			parallel = false
+		}
 		currentNode = &plan.AggregateNode{
-			Input:        currentNode,
-			GroupByField: q.GroupBy,
-			Fields:       q.Fields,
+			Input:           currentNode,
+			GroupByField:    q.GroupBy,
+			Bucket:          q.GroupBucket,
+			Fields:          q.Fields,
+			CaseInsensitive: q.CaseInsensitive,
+			Sources:         q.Sources,
+			MemoryLimit:     q.MemoryLimit,
+			TempDir:         q.TempDir,
+			Parallel:        parallel,
+			Sorted:          sorted,
 		}
 	} else if len(q.Fields) > 0 {
 		// Projection
 		currentNode = &plan.ProjectNode{
-			Input:  currentNode,
-			Fields: q.Fields,
-			Filter: q.Filter,
+			Input:           currentNode,
+			Fields:          q.Fields,
+			Filter:          q.Filter,
+			CaseInsensitive: q.CaseInsensitive,
+			Sources:         q.Sources,
 		}
 	}
 
+	// 4. Apply ORDER BY on the result columns (aggregates, computed values)
+	if len(q.OrderBy) > 0 && !sortSource {
+		currentNode = &plan.SortNode{Input: currentNode, Keys: orderKeys, MemoryLimit: q.MemoryLimit, TempDir: q.TempDir}
+	}
+
+	// 5. Apply LIMIT, which stops pulling rows from the input once reached
+	if q.Limit != nil {
+		currentNode = &plan.LimitNode{Input: currentNode, Count: *q.Limit}
+	}
+
 	return currentNode, nil
 }
+
+// parallelScan replaces the scan of a table that can be split, and the
+// filter right above it, with a ParallelScanNode when the query has workers.
+// Scans resolving the _file and _line columns stay sequential.
+func parallelScan(q *query.SelectQuery, scan *plan.ScanNode, node plan.Node) plan.Node {
+	if scan == nil || q.Workers <= 1 || scan.Positioned {
+		return node
+	}
+	table, ok := scan.Table.(database.SplitTable)
+	if !ok {
+		return node
+	}
+	parallel := &plan.ParallelScanNode{TableName: scan.TableName, Table: table, Workers: q.Workers, Contains: scan.Contains}
+	switch n := node.(type) {
+	case *plan.ScanNode:
+		return parallel
+	case *plan.FilterNode:
+		if n.Input == scan {
+			parallel.Filter = n.Expression
+			return parallel
+		}
+		// Strict mode checks the fields of every record before the filter
+		if check, ok := n.Input.(*plan.FieldCheckNode); ok && check.Input == scan {
+			check.Input = parallel
+		}
+	case *plan.FieldCheckNode:
+		n.Input = parallel
+	}
+	return node
+}
+
+// sortedInput reports whether the rows aggregated by a query arrive sorted
+// by its GROUP BY field: the scanned table is declared sorted by it
+// (SortedBy), or a subquery ends sorting by it. HISTOGRAM buckets, which
+// depend on every row, are never streamed.
+func sortedInput(q *query.SelectQuery, scan *plan.ScanNode, node plan.Node) bool {
+	if q.GroupBy == "" || (q.GroupBucket != nil && q.GroupBucket.Func == query.FuncHistogram) {
+		return false
+	}
+	if scan != nil {
+		return samePath(q, q.SortedBy, q.GroupBy)
+	}
+	if limit, ok := node.(*plan.LimitNode); ok {
+		node = limit.Input
+	}
+	// Rows sorted before their projection are sorted by the column of the
+	// key, when selected as is
+	column := func(path string) string { return path }
+	if project, ok := node.(*plan.ProjectNode); ok {
+		node = project.Input
+		column = func(path string) string {
+			for _, f := range project.Fields {
+				if f.Path == path && f.Func == "" && f.Aggregate == "" && !f.Unnest {
+					if f.Alias != "" {
+						return f.Alias
+					}
+					return f.Path
+				}
+			}
+			return ""
+		}
+	}
+	sort, ok := node.(*plan.SortNode)
+	return ok && len(sort.Keys) > 0 && samePath(q, column(sort.Keys[0].Path), q.GroupBy)
+}
+
+// samePath reports whether two paths name the same field, regardless of
+// case for case-insensitive queries
+func samePath(q *query.SelectQuery, a, b string) bool {
+	a, b = query.DisplayPath(strings.TrimPrefix(a, ".")), query.DisplayPath(b)
+	if q.CaseInsensitive {
+		return strings.EqualFold(a, b)
+	}
+	return a != "" && a == b
+}
+
+// requiredStrings returns the string literals held by every record matching
+// a filter, which records lacking them in their raw JSON can be skipped for
+// before decoding: the values of the equality conditions it ANDs. Literals
+// equal to numbers or booleans ('42' = 42), and conditions comparing
+// virtual columns, ignoring case, quantified with ALL or NONE, or on paths
+// transforming the value (.type(), pipelines) are left out.
+func requiredStrings(expr query.Expression) []string {
+	switch e := expr.(type) {
+	case *query.AndExpression:
+		return append(requiredStrings(e.Left), requiredStrings(e.Right)...)
+	case *query.Condition:
+		f := e.Filter
+		literal, ok := f.Value.(string)
+		if !ok || (f.Operator != "=" && f.Operator != "==") || f.IgnoreCase ||
+			(f.Quantifier != "" && f.Quantifier != query.QuantifierAny) {
+			return nil
+		}
+		if _, err := strconv.ParseFloat(literal, 64); err == nil || literal == "" || literal == "true" || literal == "false" {
+			return nil
+		}
+		path := query.DisplayPath(f.Field)
+		if database.IsMetadataColumn(path) || path == parser.SourceField || strings.HasPrefix(path, parser.SourceField+".") ||
+			strings.Contains(path, "(") || query.IsPipeline(f.Field) {
+			return nil
+		}
+		return []string{literal}
+	}
+	return nil
+}
+
+// sortKeys returns the ORDER BY keys and whether they apply to the source
+// rows. Without aggregation the rows are sorted before the projection, so
+// that any source field can be a key, aliases naming their selected path
+// ("SELECT price AS p ORDER BY p"). Aggregated rows, and aliases of computed
+// values, are sorted on the result columns.
+func sortKeys(q *query.SelectQuery, hasAggregation bool) ([]query.OrderKey, bool) {
+	if hasAggregation {
+		return resultColumns(q), false
+	}
+	keys := make([]query.OrderKey, len(q.OrderBy))
+	for i, key := range q.OrderBy {
+		keys[i] = key
+		for _, f := range q.Fields {
+			if f.Alias == "" || f.Alias != query.DisplayPath(key.Path) {
+				continue
+			}
+			if f.Func != "" || f.Unnest || f.Bucket != nil {
+				return resultColumns(q), false
+			}
+			keys[i].Path = f.Path
+			break
+		}
+	}
+	return keys, true
+}
+
+// resultColumns maps ORDER BY keys naming a selected path to the column
+// of that path, so that "SELECT price AS p ORDER BY price" sorts on p
+func resultColumns(q *query.SelectQuery) []query.OrderKey {
+	keys := make([]query.OrderKey, len(q.OrderBy))
+	for i, key := range q.OrderBy {
+		keys[i] = key
+		for _, f := range q.Fields {
+			path := query.DisplayPath(f.Path)
+			if f.Alias != "" && f.Alias != path && f.Aggregate == "" && f.Func == "" && path == query.DisplayPath(key.Path) {
+				keys[i].Path = "`" + f.Alias + "`"
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// groupedFields checks that every field of an aggregating query is grouped
+// or aggregated. Other fields would only be null: in strict mode they are
+// an error, otherwise they take the FIRST() value of their group with a
+// warning.
+func groupedFields(q *query.SelectQuery) ([]query.Field, error) {
+	fields := make([]query.Field, len(q.Fields))
+	copy(fields, q.Fields)
+	for i, f := range fields {
+		if f.Aggregate != "" || f.Bucket != nil || f.Path == q.GroupBy ||
+			(q.CaseInsensitive && strings.EqualFold(f.Path, q.GroupBy)) {
+			continue
+		}
+		name := query.DisplayPath(f.Path)
+		if q.Strict {
+			if q.GroupBy == "" {
+				return nil, fmt.Errorf("field %s must be aggregated when selected with aggregates", name)
+			}
+			return nil, fmt.Errorf("field %s must be aggregated or appear in GROUP BY %s", name, query.DisplayPath(q.GroupBy))
+		}
+		diag.Warn(diag.CodeUngrouped, fmt.Sprintf("%s is neither grouped nor aggregated, using FIRST(%s)", name, name), "field", name)
+		fields[i].Aggregate = "FIRST"
+	}
+	return fields, nil
+}
+
+// referencedFields lists the distinct input paths a query reads (wildcard-only paths excluded)
+func referencedFields(q *query.SelectQuery) []string {
+	var paths []string
+	for _, f := range q.Fields {
+		paths = append(paths, f.Path)
+	}
+	if q.Filter != nil {
+		paths = append(paths, query.ExpressionFields(q.Filter)...)
+	}
+	if q.GroupBy != "" {
+		paths = append(paths, q.GroupBy)
+	}
+
+	seen := make(map[string]bool)
+	if q.GroupUnnest != nil {
+		// The alias is set by the UnnestNode, from the path of the field
+		seen[q.GroupUnnest.Alias] = true
+		paths = append(paths, q.GroupUnnest.Path)
+	}
+	var fields []string
+	for _, p := range paths {
+		if p == "" || p == "*" || p == "$" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		fields = append(fields, p)
+	}
+	return fields
+}
+
+// referencesMetadata reports whether a query reads the virtual _file or
+// _line column of the rows it scans
+func referencesMetadata(fields []string, orderBy []query.OrderKey) bool {
+	for _, key := range orderBy {
+		fields = append(fields, key.Path)
+	}
+	for _, f := range fields {
+		if database.IsMetadataColumn(query.DisplayPath(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateUpdatePlan converts an UPDATE statement into an Execution Plan
+func CreateUpdatePlan(u *query.UpdateQuery, rootTable database.Table) (plan.Node, error) {
+	if len(u.Assignments) == 0 {
+		return nil, fmt.Errorf("UPDATE requires at least one SET assignment")
+	}
+	tableName := u.Table
+	if tableName == "" {
+		tableName = "default"
+	}
+	var fields []string
+	if u.Filter != nil {
+		fields = query.ExpressionFields(u.Filter)
+	}
+	for _, a := range u.Assignments {
+		fields = append(fields, a.Source)
+	}
+	return &plan.UpdateNode{
+		Input:       &plan.ScanNode{TableName: tableName, Table: rootTable, Positioned: referencesMetadata(fields, nil)},
+		Assignments: u.Assignments,
+		Filter:      u.Filter,
+	}, nil
+}
+
+// CreateDeletePlan converts a DELETE statement into an Execution Plan
+func CreateDeletePlan(d *query.DeleteQuery, rootTable database.Table) (plan.Node, error) {
+	tableName := d.Table
+	if tableName == "" {
+		tableName = "default"
+	}
+	var fields []string
+	if d.Filter != nil {
+		fields = query.ExpressionFields(d.Filter)
+	}
+	return &plan.DeleteNode{
+		Input:  &plan.ScanNode{TableName: tableName, Table: rootTable, Positioned: referencesMetadata(fields, nil)},
+		Filter: d.Filter,
+	}, nil
+}
@@ -1,10 +1,15 @@
 package planner_test
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/bisegni/jsl/pkg/database"
+	"github.com/bisegni/jsl/pkg/parser"
+	"github.com/bisegni/jsl/pkg/plan"
 	"github.com/bisegni/jsl/pkg/planner"
 	"github.com/bisegni/jsl/pkg/query"
 )
@@ -14,7 +19,7 @@ type MockTable struct {
 	rows []database.Row
 }
 
-func (m *MockTable) Iterate() (database.RowIterator, error) {
+func (m *MockTable) Iterate(ctx context.Context) (database.RowIterator, error) {
 	return &MockIterator{rows: m.rows, index: -1}, nil
 }
 
@@ -87,7 +92,7 @@ func TestNestedQueries(t *testing.T) {
 				t.Fatalf("Plan failed: %v", err)
 			}
 
-			iter, err := p.Execute()
+			iter, err := p.Execute(context.Background())
 			if err != nil {
 				t.Fatalf("Execute failed: %v", err)
 			}
@@ -144,3 +149,840 @@ func convertRowToString(v interface{}) string {
 	// Fallback
 	return fmt.Sprintf("%v", v)
 }
+
+func TestCaseInsensitiveFields(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "name", Val: "Alice"}, {Key: "Age", Val: 30}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "NAME", Val: "Bob"}, {Key: "age", Val: 25}}),
+	}}
+
+	q, err := query.ParseQuery("SELECT Name, AGE WHERE Age > 26")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	q.CaseInsensitive = true
+
+	p, err := planner.CreatePlan(q, table)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	iter, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	defer iter.Close()
+
+	var results []string
+	for iter.Next() {
+		results = append(results, convertRowToString(iter.Row().Primitive()))
+	}
+	if len(results) != 1 || results[0] != `{"Name":Alice,"AGE":30}` {
+		t.Errorf("Unexpected results: %v", results)
+	}
+}
+
+func TestUpdatePlan(t *testing.T) {
+	original := map[string]interface{}{"a": 1, "b": 10, "meta": map[string]interface{}{"x": 1}}
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(original),
+		database.NewJSONRow(map[string]interface{}{"a": 2, "b": 20}),
+	}}
+
+	u, err := query.ParseUpdate("UPDATE SET flag = FALSE, meta.copy = b, a = 0 WHERE b > 15 OR meta.x = 1")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	p, err := planner.CreateUpdatePlan(u, table)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	iter, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	defer iter.Close()
+
+	var rows []map[string]interface{}
+	for iter.Next() {
+		rows = append(rows, iter.Row().Primitive().(map[string]interface{}))
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	if rows[0]["flag"] != false || rows[0]["a"] != float64(0) {
+		t.Errorf("Unexpected first row: %v", rows[0])
+	}
+	if meta := rows[0]["meta"].(map[string]interface{}); meta["copy"] != 10 || meta["x"] != 1 {
+		t.Errorf("Unexpected nested assignment: %v", meta)
+	}
+	if meta := rows[1]["meta"].(map[string]interface{}); meta["copy"] != 20 {
+		t.Errorf("Expected intermediate object to be created, got %v", meta)
+	}
+	if _, changed := original["flag"]; changed || len(original["meta"].(map[string]interface{})) != 1 {
+		t.Errorf("Input row was modified: %v", original)
+	}
+}
+
+func TestDeletePlan(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "a", Val: 1}, {Key: "b", Val: 10}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "a", Val: 2}, {Key: "b", Val: 20}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "a", Val: 3}, {Key: "b", Val: 30}}),
+	}}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"DELETE WHERE b > 15 AND b < 25", []string{`{"a":1,"b":10}`, `{"a":3,"b":30}`}},
+		{"DELETE FROM t WHERE missing = 1", []string{`{"a":1,"b":10}`, `{"a":2,"b":20}`, `{"a":3,"b":30}`}},
+		{"DELETE", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			d, err := query.ParseDelete(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p, err := planner.CreateDeletePlan(d, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if fmt.Sprint(results) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, results)
+			}
+		})
+	}
+}
+
+func TestStrictUnknownFields(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "a", Val: 1}, {Key: "b", Val: 10}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "a", Val: 2}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "a", Val: 3}, {Key: "d", Val: 1}}),
+	}}
+
+	tests := []struct {
+		query   string
+		wantErr bool
+		rows    int
+	}{
+		{"SELECT a, b WHERE a > 0", false, 3},
+		{"SELECT a, bb", true, 0},
+		{"SELECT a WHERE c = 1", true, 0},
+		{"SELECT COUNT(a) GROUP BY z", true, 0},
+		// The check does not depend on how many rows LIMIT reads
+		{"SELECT zz LIMIT 1", true, 0},
+		{"SELECT a, bb LIMIT 1", true, 0},
+		{"SELECT a, b LIMIT 1", false, 1},
+		{"SELECT d LIMIT 2", false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			q.Strict = true
+			p, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+			rows := 0
+			for iter.Next() {
+				rows++
+			}
+			if (iter.Error() != nil) != tt.wantErr {
+				t.Errorf("Error() = %v, wantErr %v", iter.Error(), tt.wantErr)
+			}
+			if rows != tt.rows {
+				t.Errorf("Expected %d row(s) before the check ended, got %d", tt.rows, rows)
+			}
+		})
+	}
+}
+
+func TestAliasReferences(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "name", Val: "Laptop"}, {Key: "price", Val: 1200}, {Key: "info", Val: map[string]interface{}{"cat": "pc"}}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "name", Val: "Mouse"}, {Key: "price", Val: 20}, {Key: "info", Val: map[string]interface{}{"cat": "acc"}}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "name", Val: "Pad"}, {Key: "price", Val: 10}, {Key: "info", Val: map[string]interface{}{"cat": "acc"}}}),
+	}}
+
+	tests := []struct {
+		sql      string
+		expected []string
+	}{
+		{"SELECT name, price AS p WHERE p > 100", []string{`{"name":Laptop,"p":1200}`}},
+		{"SELECT name, info AS i WHERE i.cat = 'pc'", []string{`{"name":Laptop,"i":map[cat:pc]}`}},
+		{"SELECT info.cat AS c, COUNT(name) AS n GROUP BY c", []string{`{"c":acc,"n":2}`, `{"c":pc,"n":1}`}},
+		{"SELECT name AS n WHERE price > 15", []string{`{"n":Laptop}`, `{"n":Mouse}`}},
+		// An alias shadows the source field, a bracketed key does not
+		{"SELECT name AS price WHERE price > 'N'", []string{`{"price":Pad}`}},
+		{`SELECT name AS price WHERE ["price"] > 15`, []string{`{"price":Laptop}`, `{"price":Mouse}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if strings.Join(results, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Unexpected results: %v", results)
+			}
+		})
+	}
+
+	q, err := query.ParseQuery("SELECT COUNT(name) AS n WHERE n > 1")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, err := planner.CreatePlan(q, table); err == nil || !strings.Contains(err.Error(), "aggregate alias") {
+		t.Errorf("Expected aggregate alias error, got %v", err)
+	}
+}
+
+func TestPlanKeepsQuery(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "name", Val: "Laptop"}, {Key: "price", Val: 1200}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "name", Val: "Mouse"}, {Key: "price", Val: 20}}),
+	}}
+	q, err := query.ParseQuery("SELECT n, p FROM (SELECT name AS n, price AS p WHERE n = 'laptop' OR p < 100) WHERE p > 15 ORDER BY n LIMIT 5")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	q.IgnoreCase = true
+	q.ArrayMatch = query.QuantifierAll
+	parsed := q.Clone()
+
+	// A parsed query is planned as often as needed, with the same rows
+	for i := 0; i < 2; i++ {
+		p, err := planner.CreatePlan(q, table)
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		iter, err := p.Execute(context.Background())
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		var results []string
+		for iter.Next() {
+			results = append(results, convertRowToString(iter.Row().Primitive()))
+		}
+		iter.Close()
+		if got := strings.Join(results, "\n"); got != "{\"n\":Laptop,\"p\":1200}\n{\"n\":Mouse,\"p\":20}" {
+			t.Errorf("Plan %d: unexpected results %v", i, results)
+		}
+		if !reflect.DeepEqual(q, parsed) {
+			t.Fatalf("Planning changed the query: %+v", q)
+		}
+	}
+}
+
+func TestBucketGroupBy(t *testing.T) {
+	var rows []database.Row
+	for _, price := range []interface{}{5.0, 99.0, 100.0, 250.0, 300.0, nil, "n/a"} {
+		rows = append(rows, database.NewJSONRow(database.OrderedMap{{Key: "price", Val: price}}))
+	}
+	table := &MockTable{rows: rows}
+
+	tests := []struct {
+		sql      string
+		expected []string
+	}{
+		{"SELECT BUCKET(price, 100) AS r, COUNT(price) AS n GROUP BY BUCKET(price, 100)",
+			[]string{`{"r":0,"n":2}`, `{"r":100,"n":1}`, `{"r":200,"n":1}`, `{"r":300,"n":1}`, `{"r":<nil>,"n":1}`}},
+		{"SELECT BUCKET(price, 200) AS r, MAX(price) AS m GROUP BY r",
+			[]string{`{"r":0,"m":100}`, `{"r":200,"m":300}`, `{"r":<nil>,"m":n/a}`}},
+		{"SELECT HISTOGRAM(price, 3), COUNT(price) AS n GROUP BY HISTOGRAM(price, 3)",
+			[]string{`{"HISTOGRAM_price":5,"n":3}`, `{"HISTOGRAM_price":201.66666666666666,"n":2}`, `{"HISTOGRAM_price":<nil>,"n":1}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if strings.Join(results, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Unexpected results: %v", results)
+			}
+		})
+	}
+
+	for _, sql := range []string{
+		"SELECT BUCKET(price, 100), COUNT(price) GROUP BY price",
+		"SELECT BUCKET(price, 100), COUNT(price) GROUP BY BUCKET(price, 50)",
+		"SELECT BUCKET(price, 100) AS r WHERE r > 1 GROUP BY BUCKET(price, 100)",
+	} {
+		q, err := query.ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if _, err := planner.CreatePlan(q, table); err == nil {
+			t.Errorf("Expected planning error for %q", sql)
+		}
+	}
+	for _, sql := range []string{
+		"SELECT COUNT(price) GROUP BY BUCKET(price, 0)",
+		"SELECT COUNT(price) GROUP BY HISTOGRAM(price, 2.5)",
+		"SELECT COUNT(price) GROUP BY BUCKET(price)",
+		"SELECT COUNT(price) GROUP BY LOWER(price)",
+	} {
+		if _, err := query.ParseQuery(sql); err == nil {
+			t.Errorf("Expected parse error for %q", sql)
+		}
+	}
+}
+
+func TestTokenizeUnnest(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "id", Val: 1}, {Key: "message", Val: "Disk full on /dev/sda"}, {Key: "tags", Val: []interface{}{"a", "b"}}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "id", Val: 2}, {Key: "message", Val: "disk error: DISK full"}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "id", Val: 3}, {Key: "message", Val: nil}, {Key: "tags", Val: "c"}}),
+	}}
+
+	tests := []struct {
+		sql      string
+		expected []string
+	}{
+		{"SELECT word, COUNT(word) AS n FROM (SELECT UNNEST(TOKENIZE(message)) AS word) GROUP BY word",
+			[]string{`{"word":dev,"n":1}`, `{"word":disk,"n":3}`, `{"word":error,"n":1}`, `{"word":full,"n":2}`, `{"word":on,"n":1}`, `{"word":sda,"n":1}`}},
+		// GROUP BY the alias expands UNNEST before grouping
+		{"SELECT UNNEST(TOKENIZE(message)) AS w, COUNT(*) FROM f GROUP BY w",
+			[]string{`{"w":dev,"COUNT_*":1}`, `{"w":disk,"COUNT_*":3}`, `{"w":error,"COUNT_*":1}`, `{"w":full,"COUNT_*":2}`, `{"w":on,"COUNT_*":1}`, `{"w":sda,"COUNT_*":1}`}},
+		{"SELECT UNNEST(tags) AS t, SUM(id) AS ids GROUP BY t", []string{`{"t":a,"ids":1}`, `{"t":b,"ids":1}`, `{"t":c,"ids":3}`}},
+		{"SELECT COUNT(TOKENIZE(message)) AS words", []string{`{"words":9}`}},
+		{"SELECT id, UNNEST(tags)", []string{`{"id":1,"tags":a}`, `{"id":1,"tags":b}`, `{"id":3,"tags":c}`}},
+		{"SELECT UNNEST(tags) AS t, UNNEST(TOKENIZE(message)) WHERE id = 1",
+			[]string{`{"t":a,"TOKENIZE_message":disk}`, `{"t":b,"TOKENIZE_message":full}`, `{"t":<nil>,"TOKENIZE_message":on}`, `{"t":<nil>,"TOKENIZE_message":dev}`, `{"t":<nil>,"TOKENIZE_message":sda}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if strings.Join(results, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Unexpected results: %v", results)
+			}
+		})
+	}
+
+	for _, sql := range []string{
+		"SELECT UNNEST(LOWER(message))",
+		"SELECT TOKENIZE(message, id)",
+		"SELECT COUNT(UNNEST(tags))",
+	} {
+		if _, err := query.ParseQuery(sql); err == nil {
+			t.Errorf("Expected parse error for %q", sql)
+		}
+	}
+	q, err := query.ParseQuery("SELECT TOKENIZE(message) AS w WHERE w = 'disk'")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, err := planner.CreatePlan(q, table); err == nil {
+		t.Errorf("Expected alias error")
+	}
+}
+
+func TestUngroupedFields(t *testing.T) {
+	table := &MockTable{rows: []database.Row{
+		database.NewJSONRow(database.OrderedMap{{Key: "type", Val: "a"}, {Key: "val", Val: 1}, {Key: "name", Val: nil}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "type", Val: "a"}, {Key: "val", Val: 2}, {Key: "name", Val: "x"}}),
+		database.NewJSONRow(database.OrderedMap{{Key: "type", Val: "b"}, {Key: "val", Val: 3}, {Key: "name", Val: "y"}}),
+	}}
+
+	tests := []struct {
+		sql      string
+		strict   bool
+		expected []string
+		wantErr  bool
+	}{
+		{"SELECT type, name, COUNT(val) AS n GROUP BY type", false, []string{`{"type":a,"name":x,"n":2}`, `{"type":b,"name":y,"n":1}`}, false},
+		{"SELECT FIRST(name) AS first, SUM(val) AS total", false, []string{`{"first":x,"total":6}`}, false},
+		{"SELECT type, name, COUNT(val) GROUP BY type", true, nil, true},
+		{"SELECT name, COUNT(val)", true, nil, true},
+		{"SELECT type, COUNT(val) AS n GROUP BY type", true, []string{`{"type":a,"n":2}`, `{"type":b,"n":1}`}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			q.Strict = tt.strict
+			p, err := planner.CreatePlan(q, table)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreatePlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if strings.Join(results, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Unexpected results: %v", results)
+			}
+		})
+	}
+}
+
+// countingTable counts the rows read from it, and its closed iterators
+type countingTable struct {
+	MockTable
+	read   int
+	closed int
+}
+
+func (c *countingTable) Iterate(ctx context.Context) (database.RowIterator, error) {
+	return &countingIterator{MockIterator: &MockIterator{rows: c.rows, index: -1}, table: c}, nil
+}
+
+type countingIterator struct {
+	*MockIterator
+	table *countingTable
+}
+
+func (it *countingIterator) Next() bool {
+	if !it.MockIterator.Next() {
+		return false
+	}
+	it.table.read++
+	return true
+}
+
+func (it *countingIterator) Close() error {
+	it.table.closed++
+	return it.MockIterator.Close()
+}
+
+func TestLimit(t *testing.T) {
+	var rows []database.Row
+	for i := 1; i <= 10; i++ {
+		rows = append(rows, database.NewJSONRow(database.OrderedMap{{Key: "a", Val: i}, {Key: "b", Val: i % 2}}))
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+		read     int
+	}{
+		{"SELECT a LIMIT 3", `[{"a":1} {"a":2} {"a":3}]`, 3},
+		{"SELECT a WHERE b = 0 LIMIT 2", `[{"a":2} {"a":4}]`, 4},
+		{"SELECT a LIMIT 0", `[]`, 0},
+		{"SELECT a FROM (SELECT a LIMIT 5) WHERE a > 3", `[{"a":4} {"a":5}]`, 5},
+		{"SELECT b, COUNT(a) AS n GROUP BY b LIMIT 1", `[{"b":0,"n":5}]`, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			table := &countingTable{MockTable: MockTable{rows: rows}}
+			p, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if got := fmt.Sprint(results); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+			if table.read != tt.read {
+				t.Errorf("Expected %d rows read, got %d", tt.read, table.read)
+			}
+			// The scan is closed once the rows are exhausted, and only once
+			if table.closed != 1 {
+				t.Errorf("Expected the scan closed before the plan, got %d close(s)", table.closed)
+			}
+			iter.Close()
+			if table.closed != 1 {
+				t.Errorf("Expected the scan closed once, got %d close(s)", table.closed)
+			}
+		})
+	}
+
+	if _, err := query.ParseQuery("SELECT a LIMIT -1"); err == nil {
+		t.Error("Expected an error for a negative LIMIT")
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	data := []struct {
+		name     string
+		category interface{}
+		price    interface{}
+	}{
+		{"b", "x", 10}, {"a", "y", 5}, {"c", "x", 20}, {"d", "x", 10}, {"e", "y", nil},
+	}
+	var rows []database.Row
+	for _, d := range data {
+		rows = append(rows, database.NewJSONRow(database.OrderedMap{{Key: "name", Val: d.name}, {Key: "category", Val: d.category}, {Key: "price", Val: d.price}}))
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT name ORDER BY name DESC", `[{"name":e} {"name":d} {"name":c} {"name":b} {"name":a}]`},
+		// Equal keys keep the input order (b before d), nulls sort last
+		{"SELECT name ORDER BY price", `[{"name":a} {"name":b} {"name":d} {"name":c} {"name":e}]`},
+		// Nulls stay last in descending order, unless NULLS FIRST
+		{"SELECT name ORDER BY category ASC, price DESC, name", `[{"name":c} {"name":b} {"name":d} {"name":a} {"name":e}]`},
+		{"SELECT name, price AS p ORDER BY price DESC LIMIT 2", `[{"name":c,"p":20} {"name":b,"p":10}]`},
+		{"SELECT name ORDER BY price DESC NULLS FIRST", `[{"name":e} {"name":c} {"name":b} {"name":d} {"name":a}]`},
+		{"SELECT name ORDER BY price nulls first", `[{"name":e} {"name":a} {"name":b} {"name":d} {"name":c}]`},
+		{"SELECT name ORDER BY price ASC NULLS LAST, name DESC", `[{"name":a} {"name":d} {"name":b} {"name":c} {"name":e}]`},
+		{"SELECT category, COUNT(name) AS n GROUP BY category ORDER BY n DESC", `[{"category":x,"n":3} {"category":y,"n":2}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p, err := planner.CreatePlan(q, &MockTable{rows: rows})
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if got := fmt.Sprint(results); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSources(t *testing.T) {
+	data := []struct {
+		name     string
+		category string
+		price    int
+	}{
+		{"a", "x", 10}, {"b", "y", 5}, {"c", "x", 20},
+	}
+	var rows []database.Row
+	for i, d := range data {
+		rows = append(rows, database.NewJSONRow(database.OrderedMap{
+			{Key: "name", Val: d.name}, {Key: "category", Val: d.category}, {Key: "price", Val: d.price},
+			{Key: parser.SourceField, Val: fmt.Sprintf("line%d", i+1)},
+		}))
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT name WHERE price > 5", `[{"name":a,"_source":line1} {"name":c,"_source":line3}]`},
+		{"SELECT category, MAX(price) AS m GROUP BY category", `[{"category":x,"m":20,"_source":[line1 line3]} {"category":y,"m":5,"_source":[line2]}]`},
+		// Sources survive subqueries, aggregated ones staying a flat list
+		{"SELECT n FROM (SELECT name AS n WHERE price < 20)", `[{"n":a,"_source":line1} {"n":b,"_source":line2}]`},
+		{"SELECT COUNT(m) AS groups FROM (SELECT category, MAX(price) AS m GROUP BY category)", `[{"groups":2,"_source":[line1 line3 line2]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			q.Sources = true
+			p, err := planner.CreatePlan(q, &MockTable{rows: rows})
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if got := fmt.Sprint(results); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPrefilterStrings(t *testing.T) {
+	tests := []struct {
+		query    string
+		strict   bool
+		expected []string
+	}{
+		{"SELECT id WHERE level = 'error'", false, []string{"error"}},
+		{"SELECT id WHERE level = 'error' AND (host = 'web1' AND code > 500)", false, []string{"error", "web1"}},
+		{"SELECT id WHERE level = 'error' OR host = 'web1'", false, nil},
+		{"SELECT id WHERE NOT level = 'error'", false, nil},
+		{"SELECT id WHERE level != 'error'", false, nil},
+		// Numeric and boolean strings equal numbers and booleans
+		{"SELECT id WHERE code = '500' AND ok = 'true'", false, nil},
+		{"SELECT id WHERE ALL(tags) = 'a' AND NONE(tags) = 'b'", false, nil},
+		{"SELECT id WHERE _file = 'a.jsonl'", false, nil},
+		{"SELECT id, _line WHERE level = 'error'", false, nil},
+		{"SELECT id WHERE level = 'error'", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			q.Strict = tt.strict
+			node, err := planner.CreatePlan(q, &MockTable{})
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			for len(node.Children()) > 0 {
+				node = node.Children()[0]
+			}
+			scan, ok := node.(*plan.ScanNode)
+			if !ok {
+				t.Fatalf("Expected a scan, got %s", node.Explain())
+			}
+			if fmt.Sprint(scan.Contains) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, scan.Contains)
+			}
+		})
+	}
+}
+
+func TestSortedGroupBy(t *testing.T) {
+	var rows []database.Row
+	for i := 0; i < 10; i++ {
+		rows = append(rows, database.NewJSONRow(database.OrderedMap{{Key: "a", Val: i}, {Key: "g", Val: float64(i / 3)}}))
+	}
+
+	tests := []struct {
+		query    string
+		sortedBy string
+		expected string
+		read     int
+	}{
+		// Each group is returned once the first row of the next one is read
+		{"SELECT g, COUNT(*) AS n, SUM(a) AS s GROUP BY g LIMIT 2", "g", `[{"g":0,"n":3,"s":3} {"g":1,"n":3,"s":12}]`, 7},
+		{"SELECT g, COUNT(*) AS n GROUP BY g", ".g", `[{"g":0,"n":3} {"g":1,"n":3} {"g":2,"n":3} {"g":3,"n":1}]`, 10},
+		{"SELECT g, COUNT(*) AS n GROUP BY g LIMIT 1", "", `[{"g":0,"n":3}]`, 10},
+		// The buckets of sorted values are sorted too
+		{"SELECT BUCKET(a, 5) AS b, COUNT(*) AS n GROUP BY BUCKET(a, 5) LIMIT 1", "a", `[{"b":0,"n":5}]`, 6},
+		// A subquery sorting by the group field
+		{"SELECT g, MAX(a) AS m FROM (SELECT a, g ORDER BY g DESC) GROUP BY g LIMIT 2", "", `[{"g":3,"m":9} {"g":2,"m":8}]`, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			q.SortedBy = tt.sortedBy
+			table := &countingTable{MockTable: MockTable{rows: rows}}
+			p, err := planner.CreatePlan(q, table)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if err := iter.Error(); err != nil {
+				t.Fatalf("Iteration failed: %v", err)
+			}
+			if got := fmt.Sprint(results); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+			if table.read != tt.read {
+				t.Errorf("Expected %d rows read, got %d", tt.read, table.read)
+			}
+		})
+	}
+
+	// Rows out of order fail the query
+	unsorted := append([]database.Row{}, rows...)
+	unsorted = append(unsorted, rows[0])
+	q, err := query.ParseQuery("SELECT g, COUNT(*) AS n GROUP BY g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.SortedBy = "g"
+	p, err := planner.CreatePlan(q, &MockTable{rows: unsorted})
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if !strings.Contains(plan.FormatPlan(p), "sorted") {
+		t.Errorf("Expected a sorted aggregate, got\n%s", plan.FormatPlan(p))
+	}
+	iter, err := p.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	defer iter.Close()
+	for iter.Next() {
+	}
+	if err := iter.Error(); err == nil || !strings.Contains(err.Error(), "not sorted by g") {
+		t.Errorf("Expected an error for unsorted input, got %v", err)
+	}
+}
+
+func TestFromResolver(t *testing.T) {
+	row := func(v int) database.Row {
+		return database.NewJSONRow(database.OrderedMap{{Key: "a", Val: v}})
+	}
+	catalog := database.NewCatalog()
+	catalog.RegisterTable("events", &MockTable{rows: []database.Row{row(1)}})
+	resolver := &planner.Resolver{
+		Catalog: catalog,
+		Open: func(name string) (database.Table, error) {
+			switch name {
+			case "other.jsonl":
+				return &MockTable{rows: []database.Row{row(2)}}, nil
+			case "broken.db:t":
+				return nil, fmt.Errorf("no such table")
+			}
+			return nil, nil
+		},
+	}
+	root := &MockTable{rows: []database.Row{row(3)}}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT a", `[{"a":3}]`},
+		{"SELECT a FROM events", `[{"a":1}]`},
+		{"SELECT a FROM 'other.jsonl'", `[{"a":2}]`},
+		{"SELECT a FROM (SELECT a FROM events)", `[{"a":1}]`},
+		// Names resolving to nothing scan the default input
+		{"SELECT a FROM t", `[{"a":3}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p, err := resolver.CreatePlan(q, root)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			iter, err := p.Execute(context.Background())
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			defer iter.Close()
+			var results []string
+			for iter.Next() {
+				results = append(results, convertRowToString(iter.Row().Primitive()))
+			}
+			if got := fmt.Sprint(results); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	q, err := query.ParseQuery("SELECT a FROM 'broken.db:t'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.CreatePlan(q, root); err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("Expected the open error, got %v", err)
+	}
+}
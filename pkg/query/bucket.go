@@ -0,0 +1,78 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Bucketing functions of GROUP BY
+const (
+	// FuncBucket groups into fixed-width ranges: BUCKET(price, 100)
+	FuncBucket = "BUCKET"
+	// FuncHistogram groups into n equal ranges between the minimum and the
+	// maximum value: HISTOGRAM(price, 10)
+	FuncHistogram = "HISTOGRAM"
+)
+
+// IsBucketFunc reports whether name is BUCKET or HISTOGRAM
+func IsBucketFunc(name string) bool {
+	name = strings.ToUpper(name)
+	return name == FuncBucket || name == FuncHistogram
+}
+
+// Bucket groups the numeric values of Path into ranges, each identified by
+// its lower bound. Non-numeric values fall in the null group.
+type Bucket struct {
+	Func string  // FuncBucket or FuncHistogram
+	Path string  // Field holding the values
+	Arg  float64 // Range width (BUCKET) or number of ranges (HISTOGRAM)
+}
+
+func (b *Bucket) String() string {
+	return fmt.Sprintf("%s(%s, %s)", b.Func, b.Path, strconv.FormatFloat(b.Arg, 'f', -1, 64))
+}
+
+// Equal reports whether both buckets define the same ranges
+func (b *Bucket) Equal(other *Bucket) bool {
+	return b != nil && other != nil && *b == *other
+}
+
+// Number returns the value bucketed for val (numbers and numeric strings)
+func (b *Bucket) Number(val interface{}) (float64, bool) {
+	return toFloat64(val)
+}
+
+// Lower returns the lower bound of the range holding v. lo and hi are the
+// minimum and maximum of all the values, which HISTOGRAM divides.
+func (b *Bucket) Lower(v, lo, hi float64) float64 {
+	if b.Func == FuncBucket {
+		return math.Floor(v/b.Arg) * b.Arg
+	}
+	if hi <= lo {
+		return lo
+	}
+	width := (hi - lo) / b.Arg
+	i := math.Min(math.Floor((v-lo)/width), b.Arg-1) // the maximum closes the last range
+	return lo + i*width
+}
+
+// newBucket builds a Bucket from a BUCKET(path, width) or HISTOGRAM(path, n) call
+func newBucket(fn *ASTFunction) (*Bucket, error) {
+	name := strings.ToUpper(fn.Name)
+	if len(fn.Args) != 2 || fn.Args[0].Value == nil || fn.Args[1].Literal == nil || fn.Args[1].Literal.Number == nil {
+		if name == FuncBucket {
+			return nil, fmt.Errorf("%s expects a field and a width, e.g. %s(price, 100)", name, name)
+		}
+		return nil, fmt.Errorf("%s expects a field and a number of buckets, e.g. %s(price, 10)", name, name)
+	}
+	b := &Bucket{Func: name, Path: fn.Args[0].Value.String(), Arg: *fn.Args[1].Literal.Number}
+	switch {
+	case name == FuncBucket && b.Arg <= 0:
+		return nil, fmt.Errorf("%s width must be positive, got %v", name, b.Arg)
+	case name == FuncHistogram && (b.Arg < 1 || b.Arg != math.Trunc(b.Arg)):
+		return nil, fmt.Errorf("%s needs a positive whole number of buckets, got %v", name, b.Arg)
+	}
+	return b, nil
+}
@@ -0,0 +1,252 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Truth is the result of a predicate under SQL three-valued logic.
+// Comparisons involving null (or missing) values are Unknown, and only True
+// rows pass a WHERE clause.
+type Truth int
+
+const (
+	False Truth = iota
+	True
+	Unknown
+)
+
+func truthOf(b bool) Truth {
+	if b {
+		return True
+	}
+	return False
+}
+
+// Not negates t, NOT UNKNOWN being UNKNOWN
+func (t Truth) Not() Truth {
+	switch t {
+	case True:
+		return False
+	case False:
+		return True
+	}
+	return Unknown
+}
+
+// And combines t and o, FALSE winning over UNKNOWN
+func (t Truth) And(o Truth) Truth {
+	if t == False || o == False {
+		return False
+	}
+	if t == Unknown || o == Unknown {
+		return Unknown
+	}
+	return True
+}
+
+// Or combines t and o, TRUE winning over UNKNOWN
+func (t Truth) Or(o Truth) Truth {
+	if t == True || o == True {
+		return True
+	}
+	if t == Unknown || o == Unknown {
+		return Unknown
+	}
+	return False
+}
+
+func (t Truth) String() string {
+	switch t {
+	case True:
+		return "TRUE"
+	case False:
+		return "FALSE"
+	}
+	return "UNKNOWN"
+}
+
+// NullOrder selects where null values are placed when sorting
+type NullOrder int
+
+const (
+	NullsLast NullOrder = iota
+	NullsFirst
+)
+
+// Compare compares two values, returning ok=false when the comparison is
+// UNKNOWN: either value is null, or their types cannot be compared (e.g. a
+// number and an object). Numbers compare numerically, including numeric
+// strings compared with numbers or with each other; timestamps compare
+// chronologically (RFC3339 strings are accepted against a time.Time);
+// other strings compare lexically and false sorts before true.
+func Compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	_, aTime := a.(time.Time)
+	_, bTime := b.(time.Time)
+	if aTime || bTime {
+		at, aok := toTime(a)
+		bt, bok := toTime(b)
+		if !aok || !bok {
+			return 0, false
+		}
+		return at.Compare(bt), true
+	}
+
+	ab, aBool := a.(bool)
+	bb, bBool := b.(bool)
+	if aBool || bBool {
+		if !aBool || !bBool {
+			return 0, false
+		}
+		return cmp.Compare(boolRank(ab), boolRank(bb)), true
+	}
+
+	af, aok := toFloat64(a)
+	bf, bok := toFloat64(b)
+	if aok && bok {
+		return cmp.Compare(af, bf), true
+	}
+
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return strings.Compare(as, bs), true
+	}
+	return 0, false
+}
+
+// CompareOrder totally orders values for sorting: nulls go first or last as
+// requested, comparable values follow Compare, and values of incomparable
+// types are grouped by type (booleans, numbers, strings, timestamps, arrays,
+// objects).
+func CompareOrder(a, b interface{}, nulls NullOrder) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		if nulls == NullsFirst {
+			return -1
+		}
+		return 1
+	case b == nil:
+		if nulls == NullsFirst {
+			return 1
+		}
+		return -1
+	}
+
+	if c, ok := Compare(a, b); ok {
+		return c
+	}
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// testEqual compares for equality; strings are equal only if identical
+// ("007" != "7"), other values fall back to their textual representation.
+func testEqual(a, b interface{}) Truth {
+	if a == nil || b == nil {
+		return Unknown
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return truthOf(as == bs)
+	}
+	if c, ok := Compare(a, b); ok {
+		return truthOf(c == 0)
+	}
+	return truthOf(fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b))
+}
+
+// testOrder applies an ordering operator (>, >=, <, <=)
+func testOrder(a, b interface{}, op string) Truth {
+	c, ok := Compare(a, b)
+	if !ok {
+		return Unknown
+	}
+	return orderTruth(op, c)
+}
+
+// orderTruth applies a comparison operator to the result of a comparison
+func orderTruth(op string, c int) Truth {
+	switch op {
+	case "=", "==":
+		return truthOf(c == 0)
+	case "!=":
+		return truthOf(c != 0)
+	case ">":
+		return truthOf(c > 0)
+	case ">=":
+		return truthOf(c >= 0)
+	case "<":
+		return truthOf(c < 0)
+	case "<=":
+		return truthOf(c <= 0)
+	}
+	return False
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case string:
+		f, err := strconv.ParseFloat(val, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, val)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case bool:
+		return 0
+	case float64, float32, int, int64, int32:
+		return 1
+	case string:
+		return 2
+	case time.Time:
+		return 3
+	case []interface{}:
+		return 4
+	default:
+		return 5
+	}
+}
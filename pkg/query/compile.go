@@ -0,0 +1,171 @@
+package query
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// DefaultPathCacheSize is the number of compiled paths kept by Compile
+const DefaultPathCacheSize = 1024
+
+// CompiledPath is a path parsed once into its segments, with the filter
+// segments (type=temp, (type=temp AND value>20)) parsed as well. It is
+// immutable, so one CompiledPath can serve any number of records and
+// goroutines.
+type CompiledPath struct {
+	Path    string
+	parts   []string
+	filters map[string]*FilterExpr
+	// exprs holds the boolean filter segments, parsed without case options
+	exprs map[string]Expression
+	// regexps holds the regex key filters (*~/^temp_\d+$/), case-sensitive
+	regexps map[string]*regexp.Regexp
+}
+
+// Compile returns the compiled form of path. Compiled paths are kept in an
+// LRU cache keyed by the path string, so repeated paths are parsed once.
+func Compile(path string) *CompiledPath {
+	return pathCache.get(path)
+}
+
+func compilePath(path string) *CompiledPath {
+	c := &CompiledPath{Path: path, parts: parsePath(path)}
+	for _, part := range c.parts {
+		if _, quoted := quotedKey(part); quoted {
+			continue
+		}
+		if len(part) > 1 && part[0] == '(' && part[len(part)-1] == ')' {
+			if c.exprs == nil {
+				c.exprs = make(map[string]Expression)
+			}
+			c.exprs[part] = ParseExpression(part[1 : len(part)-1])
+		} else if op, expr, ok := splitKeyFilter(part); ok && op == KeyRegex {
+			if re, err := regexp.Compile(expr); err == nil {
+				if c.regexps == nil {
+					c.regexps = make(map[string]*regexp.Regexp)
+				}
+				c.regexps[part] = re
+			}
+		} else if IsFilterExpression(part) {
+			if fe := ParseFilterExpression(part); fe != nil {
+				if c.filters == nil {
+					c.filters = make(map[string]*FilterExpr)
+				}
+				c.filters[part] = fe
+			}
+		}
+	}
+	return c
+}
+
+// Parts returns a copy of the segments of the path
+func (c *CompiledPath) Parts() []string {
+	return append([]string(nil), c.parts...)
+}
+
+// prepared returns the query with the compiled form of its current path,
+// which NewQuery sets up front. A query built as a literal, or whose Path
+// was changed, is compiled through the cache; q itself is never modified.
+func (q *Query) prepared() *Query {
+	if q.compiled != nil && q.compiled.Path == q.Path {
+		return q
+	}
+	p := *q
+	p.compiled = Compile(q.Path)
+	return &p
+}
+
+// filterSegment returns the parsed form of a "field=value" segment, or nil
+// if part is not one. The segments of compiled paths are parsed up front.
+func (q *Query) filterSegment(part string) *FilterExpr {
+	if q.compiled != nil {
+		return q.compiled.filters[part]
+	}
+	if !IsFilterExpression(part) {
+		return nil
+	}
+	return ParseFilterExpression(part)
+}
+
+// exprSegment returns the expression of a "(...)" segment with the case
+// options of the query applied
+func (q *Query) exprSegment(part string) Expression {
+	if q.compiled != nil && !q.CaseInsensitive && !q.IgnoreCase {
+		if expr, ok := q.compiled.exprs[part]; ok {
+			return expr
+		}
+	}
+	// The options are set on the expression, so it must not be shared
+	expr := ParseExpression(part[1 : len(part)-1])
+	SetCaseInsensitive(expr, q.CaseInsensitive)
+	SetIgnoreCase(expr, q.IgnoreCase)
+	return expr
+}
+
+// regexSegment returns the compiled expression of a regex key filter
+func (q *Query) regexSegment(part, expr string) (*regexp.Regexp, error) {
+	if q.compiled != nil && !q.IgnoreCase {
+		if re, ok := q.compiled.regexps[part]; ok {
+			return re, nil
+		}
+	}
+	if q.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key regex in '%s': %w", part, err)
+	}
+	return re, nil
+}
+
+// lruCache is a fixed-size cache of compiled paths, safe for concurrent use
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // most recently used first
+	items    map[string]*list.Element
+}
+
+var pathCache = newLRUCache(DefaultPathCacheSize)
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(path string) *CompiledPath {
+	c.mu.Lock()
+	if e, ok := c.items[path]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*CompiledPath)
+	}
+	c.mu.Unlock()
+
+	// Compile outside the lock; a concurrent miss on the same path only
+	// compiles it twice
+	compiled := compilePath(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[path]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*CompiledPath)
+	}
+	c.items[path] = c.order.PushFront(compiled)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*CompiledPath).Path)
+	}
+	return compiled
+}
+
+// len returns the number of cached paths
+func (c *lruCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
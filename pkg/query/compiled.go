@@ -0,0 +1,232 @@
+package query
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// CompileExpression returns an expression equivalent to expr for evaluating
+// it over many records: the field path of every condition is resolved once
+// (plain dotted paths into direct key lookups), and its literal once into a
+// comparator specialized to its type, leaving the generic comparison to
+// values of other types. Later changes to the conditions of expr do not
+// affect the compiled expression, which is safe for concurrent use.
+func CompileExpression(expr Expression) Expression {
+	if expr == nil {
+		return nil
+	}
+	if _, ok := expr.(*compiledExpression); ok {
+		return expr
+	}
+	return &compiledExpression{source: expr, test: compileTest(expr)}
+}
+
+type compiledExpression struct {
+	source Expression
+	test   func(parser.Record) Truth
+}
+
+func (c *compiledExpression) Evaluate(record parser.Record) bool {
+	return c.test(record) == True
+}
+
+func (c *compiledExpression) Test(record parser.Record) Truth {
+	return c.test(record)
+}
+
+func (c *compiledExpression) String() string {
+	return c.source.String()
+}
+
+func compileTest(expr Expression) func(parser.Record) Truth {
+	switch e := expr.(type) {
+	case *Condition:
+		return e.Filter.compile()
+	case *AndExpression:
+		left, right := compileTest(e.Left), compileTest(e.Right)
+		return func(record parser.Record) Truth {
+			l := left(record)
+			if l == False {
+				return False
+			}
+			return l.And(right(record))
+		}
+	case *OrExpression:
+		left, right := compileTest(e.Left), compileTest(e.Right)
+		return func(record parser.Record) Truth {
+			l := left(record)
+			if l == True {
+				return True
+			}
+			return l.Or(right(record))
+		}
+	case *NotExpression:
+		inner := compileTest(e.Expr)
+		return func(record parser.Record) Truth {
+			return inner(record).Not()
+		}
+	}
+	return expr.Test
+}
+
+// compile returns Test of the filter with its path and literal resolved
+func (f *Filter) compile() func(parser.Record) Truth {
+	get := f.compileGetter()
+	scalar := f.compileScalar()
+	var test func(interface{}) Truth
+	switch {
+	case f.isNullCheck():
+		test = scalar
+	case f.Quantifier == QuantifierAll:
+		test = func(value interface{}) Truth { return testAll(value, scalar) }
+	case f.Quantifier == QuantifierNone:
+		test = func(value interface{}) Truth { return testAny(value, scalar).Not() }
+	default:
+		test = func(value interface{}) Truth { return testAny(value, scalar) }
+	}
+	noneMissing := f.Quantifier == QuantifierNone && !f.isNullCheck()
+	return func(record parser.Record) Truth {
+		value, ok := get(record)
+		if !ok {
+			if noneMissing {
+				return True
+			}
+			value = nil // missing fields behave like null
+		}
+		return test(value)
+	}
+}
+
+// compileGetter returns the extraction of the filter field from a record,
+// ok being false when the field is missing
+func (f *Filter) compileGetter() func(parser.Record) (interface{}, bool) {
+	if keys, ok := plainPath(f.Field); ok && !f.CaseInsensitive {
+		return func(record parser.Record) (interface{}, bool) {
+			return lookupPath(record, keys)
+		}
+	}
+	q := NewQuery(f.Field)
+	q.CaseInsensitive = f.CaseInsensitive
+	q.IgnoreCase = f.IgnoreCase
+	return func(record parser.Record) (interface{}, bool) {
+		value, err := q.Extract(record)
+		return value, err == nil
+	}
+}
+
+// plainPath returns the keys of a path made of plain keys only (a.b,
+// a.`b.c`), which Extract resolves by key lookups and by mapping over
+// arrays: no wildcard, index, slice, filter or function segments
+func plainPath(path string) ([]string, bool) {
+	if path == "" || path == "." || IsPipeline(path) {
+		return nil, false
+	}
+	parts := parsePath(path)
+	keys := make([]string, len(parts))
+	for i, part := range parts {
+		if key, quoted := quotedKey(part); quoted {
+			if strings.ContainsAny(part, ":#") {
+				return nil, false
+			}
+			keys[i] = key
+			continue
+		}
+		if !isPlainKey(part) {
+			return nil, false
+		}
+		keys[i] = part
+	}
+	return keys, len(keys) > 0
+}
+
+// lookupPath resolves plain keys as Extract does: arrays met on the way are
+// mapped over, keeping the elements holding the rest of the path
+func lookupPath(data interface{}, keys []string) (interface{}, bool) {
+	for i, key := range keys {
+		var val interface{}
+		var ok bool
+		switch v := data.(type) {
+		case parser.Record:
+			val, ok = v[key]
+		case map[string]interface{}:
+			val, ok = v[key]
+		case Object:
+			val, ok = v.Get(key)
+		case []interface{}:
+			results := make([]interface{}, 0, len(v))
+			for _, item := range v {
+				if val, ok := lookupPath(item, keys[i:]); ok {
+					results = append(results, val)
+				}
+			}
+			return results, true
+		}
+		if !ok {
+			return nil, false
+		}
+		data = val
+	}
+	return data, true
+}
+
+// compileScalar returns testScalar of the filter, specialized to the type
+// of its literal
+func (f *Filter) compileScalar() func(interface{}) Truth {
+	switch f.Operator {
+	case OpIsNull:
+		return func(value interface{}) Truth { return truthOf(value == nil) }
+	case OpIsNotNull:
+		return func(value interface{}) Truth { return truthOf(value != nil) }
+	}
+	if f.Value == nil {
+		return func(interface{}) Truth { return Unknown }
+	}
+	op, ignoreCase := f.Operator, f.IgnoreCase
+	target := f.Value
+	if ignoreCase {
+		target = foldCase(target)
+	}
+	generic := func(value interface{}) Truth {
+		if value == nil {
+			return Unknown
+		}
+		if ignoreCase {
+			value = foldCase(value)
+		}
+		return compareScalar(op, value, target)
+	}
+
+	switch t := target.(type) {
+	case float64:
+		if op == "contains" {
+			break
+		}
+		return func(value interface{}) Truth {
+			if v, ok := value.(float64); ok {
+				return orderTruth(op, cmp.Compare(v, t))
+			}
+			return generic(value)
+		}
+	case string:
+		// Numeric strings compare numerically
+		if _, numeric := toFloat64(t); numeric {
+			break
+		}
+		return func(value interface{}) Truth {
+			v, ok := value.(string)
+			if !ok {
+				return generic(value)
+			}
+			if ignoreCase {
+				v = strings.ToLower(v)
+			}
+			if op == "contains" {
+				return truthOf(strings.Contains(v, t))
+			}
+			return orderTruth(op, strings.Compare(v, t))
+		}
+	}
+	return generic
+}
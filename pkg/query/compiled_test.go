@@ -0,0 +1,98 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+func TestCompileExpression(t *testing.T) {
+	records := []parser.Record{
+		{
+			"name":  "Bob",
+			"score": float64(10),
+			"code":  "007",
+			"nil":   nil,
+			"ts":    "2026-01-15T10:00:00Z",
+			"tags":  []interface{}{"work", "Home"},
+			"user":  map[string]interface{}{"city": "Rome", "age": float64(40)},
+			"items": []interface{}{
+				map[string]interface{}{"price": float64(5), "sku": "a-1"},
+				map[string]interface{}{"price": float64(50)},
+				"loose",
+			},
+			"a.b": "dotted",
+		},
+		{"name": float64(3), "score": "12", "tags": []interface{}{}},
+		{},
+	}
+	queries := []string{
+		"SELECT * WHERE score > 5",
+		"SELECT * WHERE score = 10 AND name = 'Bob'",
+		"SELECT * WHERE score = '10'",
+		"SELECT * WHERE score != 12",
+		"SELECT * WHERE name > 'alice'",
+		"SELECT * WHERE name <= 'Bob'",
+		"SELECT * WHERE name > 5",
+		"SELECT * WHERE name ~= 'o'",
+		"SELECT * WHERE code = '7'",
+		"SELECT * WHERE code = 7",
+		"SELECT * WHERE nil = 'x' OR missing > 5",
+		"SELECT * WHERE NOT (nil IS NULL OR missing = 1)",
+		"SELECT * WHERE missing IS NULL AND score IS NOT NULL",
+		"SELECT * WHERE ts < '2026-02-01T00:00:00Z'",
+		"SELECT * WHERE tags = 'home'",
+		"SELECT * WHERE ALL(tags) ~= 'o'",
+		"SELECT * WHERE NONE(tags) = 'work'",
+		"SELECT * WHERE NONE(missing) = 'x'",
+		"SELECT * WHERE user.city = 'Rome' AND user.age >= 40",
+		"SELECT * WHERE items.price > 10",
+		"SELECT * WHERE items.sku = 'a-1'",
+		"SELECT * WHERE items[0].price = 5",
+		"SELECT * WHERE items.*.price < 10",
+		"SELECT * WHERE `a.b` = 'dotted'",
+	}
+
+	for _, ignoreCase := range []bool{false, true} {
+		for _, query := range queries {
+			q, err := ParseQuery(query)
+			if err != nil {
+				t.Fatalf("ParseQuery(%s) failed: %v", query, err)
+			}
+			SetIgnoreCase(q.Filter, ignoreCase)
+			compiled := CompileExpression(q.Filter)
+			if compiled.String() != q.Filter.String() {
+				t.Errorf("String() = %s, want %s", compiled, q.Filter)
+			}
+			for i, record := range records {
+				if got, want := compiled.Test(record), q.Filter.Test(record); got != want {
+					t.Errorf("%s (ignore case %v) on record %d: compiled %v, want %v", query, ignoreCase, i, got, want)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkFilter(b *testing.B, compile bool) {
+	q, err := ParseQuery("SELECT * WHERE user.age > 30 AND status = 'active' OR name ~= 'bo'")
+	if err != nil {
+		b.Fatal(err)
+	}
+	expr := q.Filter
+	if compile {
+		expr = CompileExpression(expr)
+	}
+	record := parser.Record{
+		"name":   "alice",
+		"status": "active",
+		"user":   map[string]interface{}{"age": float64(25)},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		expr.Evaluate(record)
+	}
+}
+
+func BenchmarkFilterInterpreted(b *testing.B) { benchmarkFilter(b, false) }
+
+func BenchmarkFilterCompiled(b *testing.B) { benchmarkFilter(b, true) }
@@ -8,7 +8,10 @@ import (
 
 // Expression is a boolean expression that can be evaluated against a record
 type Expression interface {
+	// Evaluate reports whether the record satisfies the expression (Test is True)
 	Evaluate(record parser.Record) bool
+	// Test evaluates the expression with three-valued logic
+	Test(record parser.Record) Truth
 	String() string
 }
 
@@ -21,6 +24,10 @@ func (c *Condition) Evaluate(record parser.Record) bool {
 	return c.Filter.Match(record)
 }
 
+func (c *Condition) Test(record parser.Record) Truth {
+	return c.Filter.Test(record)
+}
+
 func (c *Condition) String() string {
 	return c.Filter.String()
 }
@@ -32,7 +39,15 @@ type AndExpression struct {
 }
 
 func (a *AndExpression) Evaluate(record parser.Record) bool {
-	return a.Left.Evaluate(record) && a.Right.Evaluate(record)
+	return a.Test(record) == True
+}
+
+func (a *AndExpression) Test(record parser.Record) Truth {
+	left := a.Left.Test(record)
+	if left == False {
+		return False
+	}
+	return left.And(a.Right.Test(record))
 }
 
 func (a *AndExpression) String() string {
@@ -46,13 +61,149 @@ type OrExpression struct {
 }
 
 func (o *OrExpression) Evaluate(record parser.Record) bool {
-	return o.Left.Evaluate(record) || o.Right.Evaluate(record)
+	return o.Test(record) == True
+}
+
+func (o *OrExpression) Test(record parser.Record) Truth {
+	left := o.Left.Test(record)
+	if left == True {
+		return True
+	}
+	return left.Or(o.Right.Test(record))
 }
 
 func (o *OrExpression) String() string {
 	return "(" + o.Left.String() + " OR " + o.Right.String() + ")"
 }
 
+// NotExpression represents Logical NOT (NOT UNKNOWN is UNKNOWN)
+type NotExpression struct {
+	Expr Expression
+}
+
+func (n *NotExpression) Evaluate(record parser.Record) bool {
+	return n.Test(record) == True
+}
+
+func (n *NotExpression) Test(record parser.Record) Truth {
+	return n.Expr.Test(record).Not()
+}
+
+func (n *NotExpression) String() string {
+	return "NOT " + n.Expr.String()
+}
+
+// SetCaseInsensitive enables or disables case-insensitive key matching
+// on every condition of the expression tree
+func SetCaseInsensitive(expr Expression, ci bool) {
+	switch e := expr.(type) {
+	case *Condition:
+		e.Filter.CaseInsensitive = ci
+	case *AndExpression:
+		SetCaseInsensitive(e.Left, ci)
+		SetCaseInsensitive(e.Right, ci)
+	case *OrExpression:
+		SetCaseInsensitive(e.Left, ci)
+		SetCaseInsensitive(e.Right, ci)
+	case *NotExpression:
+		SetCaseInsensitive(e.Expr, ci)
+	}
+}
+
+// SetIgnoreCase enables or disables case-insensitive value comparison
+// on every condition of the expression tree
+func SetIgnoreCase(expr Expression, ignore bool) {
+	switch e := expr.(type) {
+	case *Condition:
+		e.Filter.IgnoreCase = ignore
+	case *AndExpression:
+		SetIgnoreCase(e.Left, ignore)
+		SetIgnoreCase(e.Right, ignore)
+	case *OrExpression:
+		SetIgnoreCase(e.Left, ignore)
+		SetIgnoreCase(e.Right, ignore)
+	case *NotExpression:
+		SetIgnoreCase(e.Expr, ignore)
+	}
+}
+
+// ExpressionFields returns the field paths referenced by an expression, in order of appearance
+func ExpressionFields(expr Expression) []string {
+	switch e := expr.(type) {
+	case *Condition:
+		return []string{e.Filter.Field}
+	case *AndExpression:
+		return append(ExpressionFields(e.Left), ExpressionFields(e.Right)...)
+	case *OrExpression:
+		return append(ExpressionFields(e.Left), ExpressionFields(e.Right)...)
+	case *NotExpression:
+		return ExpressionFields(e.Expr)
+	}
+	return nil
+}
+
+// MapFields replaces the field of every condition of the expression tree
+// with the result of fn, stopping at the first error
+func MapFields(expr Expression, fn func(field string) (string, error)) error {
+	switch e := expr.(type) {
+	case *Condition:
+		field, err := fn(e.Filter.Field)
+		if err != nil {
+			return err
+		}
+		e.Filter.Field = field
+	case *AndExpression:
+		if err := MapFields(e.Left, fn); err != nil {
+			return err
+		}
+		return MapFields(e.Right, fn)
+	case *OrExpression:
+		if err := MapFields(e.Left, fn); err != nil {
+			return err
+		}
+		return MapFields(e.Right, fn)
+	case *NotExpression:
+		return MapFields(e.Expr, fn)
+	}
+	return nil
+}
+
+// CloneExpression returns a copy of the expression tree whose conditions
+// can be changed (MapFields, SetCaseInsensitive, ...) without changing expr
+func CloneExpression(expr Expression) Expression {
+	switch e := expr.(type) {
+	case *Condition:
+		filter := *e.Filter
+		return &Condition{Filter: &filter}
+	case *AndExpression:
+		return &AndExpression{Left: CloneExpression(e.Left), Right: CloneExpression(e.Right)}
+	case *OrExpression:
+		return &OrExpression{Left: CloneExpression(e.Left), Right: CloneExpression(e.Right)}
+	case *NotExpression:
+		return &NotExpression{Expr: CloneExpression(e.Expr)}
+	}
+	return expr
+}
+
+// SetDefaultQuantifier sets the quantifier of every condition that does not
+// specify one explicitly (e.g. through ALL(path))
+func SetDefaultQuantifier(expr Expression, quantifier string) {
+	switch e := expr.(type) {
+	case *Condition:
+		if e.Filter.Quantifier == "" {
+			e.Filter.Quantifier = quantifier
+		}
+	case *AndExpression:
+		SetDefaultQuantifier(e.Left, quantifier)
+		SetDefaultQuantifier(e.Right, quantifier)
+	case *OrExpression:
+		SetDefaultQuantifier(e.Left, quantifier)
+		SetDefaultQuantifier(e.Right, quantifier)
+	case *NotExpression:
+		SetDefaultQuantifier(e.Expr, quantifier)
+	}
+}
+
 // ParseExpression parses a boolean expression string (e.g., "A=1 AND B=2")
 // Precedence: AND binds tighter than OR?
 // SQL precedence: NOT > AND > OR.
@@ -61,6 +212,12 @@ func (o *OrExpression) String() string {
 func ParseExpression(input string) Expression {
 	input = strings.TrimSpace(input)
 
+	// The WHERE grammar handles nesting and NOT; the split strategy below
+	// remains for values it does not accept unquoted (email=a@b.c)
+	if expr, err := ParseFilter(input); err == nil {
+		return expr
+	}
+
 	// 1. Split by OR (lowest precedence)
 	// We need to be careful not to split inside quotes.
 	// For simplicity, assuming operators are surrounded by spaces or distinct.
@@ -107,9 +264,7 @@ func ParseExpression(input string) Expression {
 			Filter: &Filter{Field: "error", Operator: "=", Value: "invalid"},
 		}
 	}
-	return &Condition{
-		Filter: NewFilter(filterExpr.Field, filterExpr.Operator, filterExpr.Value),
-	}
+	return &Condition{Filter: filterExpr.Filter()}
 }
 
 // splitByOperator splits string by operator, ignoring quotes context if possible
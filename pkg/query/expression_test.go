@@ -1,6 +1,8 @@
 package query
 
 import (
+	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/bisegni/jsl/pkg/parser"
@@ -77,3 +79,224 @@ func TestBooleanLogic(t *testing.T) {
 		})
 	}
 }
+
+func TestArrayQuantifiers(t *testing.T) {
+	record := parser.Record{
+		"tags":   []interface{}{"work", "home"},
+		"scores": []interface{}{float64(60), float64(75)},
+		"empty":  []interface{}{},
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		arrayMatch string
+		expected   bool
+	}{
+		{"Implicit ANY", "SELECT * WHERE tags = 'work'", "", true},
+		{"Explicit ANY", "SELECT * WHERE ANY(tags) = 'home'", "", true},
+		{"ALL true", "SELECT * WHERE ALL(scores) > 50", "", true},
+		{"ALL false", "SELECT * WHERE ALL(scores) > 70", "", false},
+		{"ALL on empty array", "SELECT * WHERE ALL(empty) = 'x'", "", true},
+		{"NONE true", "SELECT * WHERE NONE(tags) = 'x'", "", true},
+		{"NONE false", "SELECT * WHERE none(tags) = 'work'", "", false},
+		{"NONE on missing field", "SELECT * WHERE NONE(missing) = 'x'", "", true},
+		{"Default ALL", "SELECT * WHERE tags != 'x'", QuantifierAll, true},
+		{"Default ALL overridden", "SELECT * WHERE ANY(scores) > 70", QuantifierAll, true},
+		{"Default ALL false", "SELECT * WHERE scores > 70", QuantifierAll, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery failed: %v", err)
+			}
+			if tt.arrayMatch != "" {
+				SetDefaultQuantifier(q.Filter, tt.arrayMatch)
+			}
+			if result := q.Filter.Evaluate(record); result != tt.expected {
+				t.Errorf("Evaluate(%s) = %v, want %v", q.Filter, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	record := parser.Record{
+		"name":  "bob",
+		"score": float64(10),
+		"nil":   nil,
+		"ts":    "2026-01-15T10:00:00Z",
+	}
+
+	tests := []struct {
+		query    string
+		expected Truth
+	}{
+		{"SELECT * WHERE score > 5", True},
+		{"SELECT * WHERE missing > 5", Unknown},
+		{"SELECT * WHERE nil = 'x'", Unknown},
+		{"SELECT * WHERE nil != 'x'", Unknown},
+		{"SELECT * WHERE NOT missing > 5", Unknown},
+		{"SELECT * WHERE NOT score > 50", True},
+		{"SELECT * WHERE missing > 5 OR score > 5", True},
+		{"SELECT * WHERE missing > 5 AND score > 50", False},
+		{"SELECT * WHERE missing > 5 AND score > 5", Unknown},
+		{"SELECT * WHERE missing IS NULL", True},
+		{"SELECT * WHERE nil IS NULL", True},
+		{"SELECT * WHERE score IS NOT NULL", True},
+		{"SELECT * WHERE NOT (score IS NULL OR missing = 1)", Unknown},
+		{"SELECT * WHERE name > 'alice'", True},
+		{"SELECT * WHERE name > 5", Unknown},
+		{"SELECT * WHERE ts < '2026-02-01T00:00:00Z'", True},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery failed: %v", err)
+			}
+			if result := q.Filter.Test(record); result != tt.expected {
+				t.Errorf("Test(%s) = %v, want %v", q.Filter, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompareOrder(t *testing.T) {
+	values := []interface{}{"b", nil, float64(10), true, float64(9), "a"}
+	sorted := func(nulls NullOrder) []interface{} {
+		out := append([]interface{}{}, values...)
+		sort.SliceStable(out, func(i, j int) bool { return CompareOrder(out[i], out[j], nulls) < 0 })
+		return out
+	}
+
+	if got, want := fmt.Sprint(sorted(NullsLast)), "[true 9 10 a b <nil>]"; got != want {
+		t.Errorf("NULLS LAST order = %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(sorted(NullsFirst)), "[<nil> true 9 10 a b]"; got != want {
+		t.Errorf("NULLS FIRST order = %s, want %s", got, want)
+	}
+}
+
+func TestFilterExpressionQuantifiers(t *testing.T) {
+	record := parser.Record{
+		"scores": []interface{}{float64(60), float64(75)},
+	}
+
+	tests := []struct {
+		expr       string
+		quantifier string
+		expected   bool
+	}{
+		{"scores>70", "", true},
+		{"ALL(scores)>70", QuantifierAll, false},
+		{"all(scores)>50", QuantifierAll, true},
+		{"NONE(scores)=60", QuantifierNone, false},
+		{"ALL(scores)>50 AND NONE(scores)>100", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if tt.quantifier != "" {
+				fe := ParseFilterExpression(tt.expr)
+				if fe == nil || fe.Field != "scores" || fe.Quantifier != tt.quantifier {
+					t.Fatalf("ParseFilterExpression(%q) = %+v", tt.expr, fe)
+				}
+			}
+			if result := ParseExpression(tt.expr).Evaluate(record); result != tt.expected {
+				t.Errorf("Evaluate(%s) = %v, want %v", tt.expr, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	records := []parser.Record{
+		{"name": "a", "age": float64(30), "city": "NY", "vip": false},
+		{"name": "b", "age": float64(20), "city": "LA", "vip": true},
+		{"name": "c", "age": float64(40), "city": "LA", "vip": false},
+	}
+
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"age>28", "[a c]"},
+		{"(age>28 AND city='NY') OR vip=true", "[a b]"},
+		{"city=LA and not vip=true", "[c]"},
+		{"(age<25 OR age>35) AND city = 'LA'", "[b c]"},
+		{"name~=c", "[c]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			var matched []interface{}
+			for _, r := range records {
+				if expr.Evaluate(r) {
+					matched = append(matched, r["name"])
+				}
+			}
+			if fmt.Sprint(matched) != tt.expected {
+				t.Errorf("matched %v, want %s", matched, tt.expected)
+			}
+		})
+	}
+
+	for _, expr := range []string{"", "age>", "email=a@b.c"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("Expected parse error for %q", expr)
+		}
+	}
+}
+
+func TestFilterExpressionLiterals(t *testing.T) {
+	records := []parser.Record{
+		{"name": "a", "active": true, "deleted": nil, "code": "007"},
+		{"name": "b", "active": false, "code": "true"},
+		{"name": "c", "active": true, "deleted": float64(1), "code": float64(7)},
+	}
+
+	tests := []struct {
+		expr     string
+		literal  interface{}
+		expected string
+	}{
+		{"active=true", true, "[a c]"},
+		{"active=FALSE", false, "[b]"},
+		{"deleted=null", nil, "[a b]"},
+		{"deleted!=null", nil, "[c]"},
+		{"code=7", float64(7), "[a c]"},
+		{"code='007'", "007", "[a c]"},
+		{"code=7.5", 7.5, "[]"},
+		{`code="true"`, "true", "[b]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			fe := ParseFilterExpression(tt.expr)
+			if fe == nil {
+				t.Fatalf("ParseFilterExpression(%q) = nil", tt.expr)
+			}
+			if lit := fe.Literal(); lit != tt.literal {
+				t.Errorf("Literal() = %#v, want %#v", lit, tt.literal)
+			}
+			f := fe.Filter()
+			var matched []interface{}
+			for _, r := range records {
+				if f.Match(r) {
+					matched = append(matched, r["name"])
+				}
+			}
+			if fmt.Sprint(matched) != tt.expected {
+				t.Errorf("matched %v, want %s", matched, tt.expected)
+			}
+		})
+	}
+}
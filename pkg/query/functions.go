@@ -0,0 +1,227 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// Scalar functions of the SELECT list
+const (
+	// FuncTokenize splits text into lower-cased words: TOKENIZE(message)
+	FuncTokenize = "TOKENIZE"
+	// FuncUnnest outputs one row per element of an array: UNNEST(tags)
+	FuncUnnest = "UNNEST"
+)
+
+// ApplyFunc applies the scalar function name (e.g. FuncTokenize) to val
+func ApplyFunc(name string, val interface{}) interface{} {
+	switch name {
+	case FuncTokenize:
+		return Tokenize(val)
+	default:
+		return val
+	}
+}
+
+// Tokenize splits a string into lower-cased words, i.e. runs of letters and
+// digits. The words of an array of strings are concatenated; other values
+// have no words and give nil.
+func Tokenize(val interface{}) interface{} {
+	var words []interface{}
+	var add func(v interface{})
+	add = func(v interface{}) {
+		switch s := v.(type) {
+		case string:
+			for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			}) {
+				words = append(words, strings.ToLower(w))
+			}
+		case []interface{}:
+			for _, item := range s {
+				add(item)
+			}
+		}
+	}
+	add(val)
+	if words == nil {
+		switch val.(type) {
+		case string, []interface{}:
+			return []interface{}{}
+		default:
+			return nil
+		}
+	}
+	return words
+}
+
+// scalarField builds the field of a TOKENIZE(path), UNNEST(path) or
+// UNNEST(TOKENIZE(path)) call
+func scalarField(fn *ASTFunction) (Field, error) {
+	name := strings.ToUpper(fn.Name)
+	if len(fn.Args) != 1 {
+		return Field{}, fmt.Errorf("%s expects a single argument", name)
+	}
+
+	var field Field
+	arg := fn.Args[0]
+	switch {
+	case arg.Value != nil:
+		field.Path = arg.Value.String()
+	case arg.Function != nil && name == FuncUnnest && strings.EqualFold(arg.Function.Name, FuncTokenize):
+		inner, err := scalarField(arg.Function)
+		if err != nil {
+			return Field{}, err
+		}
+		field = inner
+	default:
+		return Field{}, fmt.Errorf("%s expects a field, got %s", name, arg.String())
+	}
+
+	if name == FuncUnnest {
+		field.Unnest = true
+	} else {
+		field.Func = name
+	}
+	return field, nil
+}
+
+// isScalarFunc reports whether name is a scalar function of the SELECT list
+func isScalarFunc(name string) bool {
+	name = strings.ToUpper(name)
+	return name == FuncTokenize || name == FuncUnnest
+}
+
+// defaultAlias is the output key of a scalar field without AS: the path for
+// UNNEST(path), TOKENIZE_path otherwise
+func (f Field) defaultAlias() string {
+	if f.Func == "" {
+		return DisplayPath(f.Path)
+	}
+	return fmtKey(f.Func, DisplayPath(f.Path))
+}
+
+// pathFuncs are the functions that may end a path: .tags.length(),
+// .metadata.keys(), .price.type() and .metadata.values()
+var pathFuncs = map[string]func(interface{}) (interface{}, error){
+	"length": pathLength,
+	"keys":   pathKeys,
+	"type":   pathType,
+	"values": pathValues,
+}
+
+// pathFunc returns the function called by a path part such as "length()"
+func pathFunc(part string) (func(interface{}) (interface{}, error), bool) {
+	name, ok := strings.CutSuffix(part, "()")
+	if !ok {
+		return nil, false
+	}
+	fn, ok := pathFuncs[strings.ToLower(name)]
+	return fn, ok
+}
+
+// pathLength is the number of elements of an array, keys of an object or
+// characters of a string; null has length 0
+func pathLength(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		return utf8.RuneCountInString(v), nil
+	case []interface{}:
+		return len(v), nil
+	}
+	if keys, ok := objectKeys(val); ok {
+		return len(keys), nil
+	}
+	return nil, fmt.Errorf("length() is not defined for %s", typeName(val))
+}
+
+// pathKeys lists the keys of an object (sorted, or in document order for
+// ordered objects) or the indices of an array
+func pathKeys(val interface{}) (interface{}, error) {
+	if arr, ok := val.([]interface{}); ok {
+		indices := make([]interface{}, len(arr))
+		for i := range arr {
+			indices[i] = i
+		}
+		return indices, nil
+	}
+	keys, ok := objectKeys(val)
+	if !ok {
+		return nil, fmt.Errorf("keys() is not defined for %s", typeName(val))
+	}
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = k
+	}
+	return out, nil
+}
+
+// pathValues lists the values of an object in the order of keys(), or the
+// elements of an array
+func pathValues(val interface{}) (interface{}, error) {
+	if arr, ok := val.([]interface{}); ok {
+		return arr, nil
+	}
+	if _, ok := objectKeys(val); !ok {
+		return nil, fmt.Errorf("values() is not defined for %s", typeName(val))
+	}
+	return iterateValue(val)
+}
+
+// pathType names the JSON type of a value
+func pathType(val interface{}) (interface{}, error) {
+	return typeName(val), nil
+}
+
+// typeName is the JSON type of a value: null, boolean, number, string,
+// array or object
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := toFloat64(val); ok {
+		return "number"
+	}
+	if _, ok := objectKeys(val); ok {
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+// objectKeys returns the keys of an object, sorted for maps and in document
+// order for ordered objects
+func objectKeys(val interface{}) ([]string, bool) {
+	switch v := val.(type) {
+	case parser.Record:
+		return objectKeys(map[string]interface{}(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys, true
+	case Object:
+		var keys []string
+		v.Range(func(key string, _ interface{}) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys, true
+	}
+	return nil, false
+}
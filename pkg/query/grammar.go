@@ -9,14 +9,48 @@ import (
 
 type ASTSelect struct {
 	SelectFields []*ASTSelectField `parser:"'SELECT' @@ (',' @@)*"`
+	Into         *string           `parser:"('INTO' @String)?"`
 	From         *ASTFromClause    `parser:"('FROM' @@)?"`
 	Where        *ASTExpression    `parser:"('WHERE' @@)?"`
-	GroupBy      *ASTValue         `parser:"('GROUP' 'BY' @@)?"`
+	GroupBy      *ASTGroupBy       `parser:"('GROUP' 'BY' @@)?"`
+	OrderBy      []*ASTOrderKey    `parser:"('ORDER' 'BY' @@ (',' @@)*)?"`
+	Limit        *int              `parser:"('LIMIT' @Number)?"`
+	// INTO is also accepted at the end of the statement
+	IntoTail *string `parser:"('INTO' @String)?"`
+}
+
+// ASTGroupBy is a field or a bucketing function: BUCKET(price, 100)
+type ASTGroupBy struct {
+	Function *ASTFunction `parser:"  @@"`
+	Value    *ASTValue    `parser:"| @@"`
+}
+
+// ASTOrderKey is a key of ORDER BY: price DESC NULLS FIRST
+type ASTOrderKey struct {
+	Value     *ASTValue `parser:"@@"`
+	Direction string    `parser:"@('ASC' | 'DESC')?"`
+	Nulls     *string   `parser:"('NULLS' @Ident)?"`
+}
+
+type ASTUpdate struct {
+	Table       *string          `parser:"'UPDATE' (@Ident | @String)?"`
+	Assignments []*ASTAssignment `parser:"'SET' @@ (',' @@)*"`
+	Where       *ASTExpression   `parser:"('WHERE' @@)?"`
+}
+
+type ASTDelete struct {
+	Table *string        `parser:"'DELETE' ('FROM' (@Ident | @String))?"`
+	Where *ASTExpression `parser:"('WHERE' @@)?"`
+}
+
+type ASTAssignment struct {
+	Path  *ASTValue   `parser:"@@ '='"`
+	Value *ASTOperand `parser:"@@"`
 }
 
 type ASTSelectField struct {
 	Expression *ASTExpression `parser:"@@"`
-	Alias      string         `parser:"('AS' @Ident)?"`
+	Alias      string         `parser:"('AS' (@Ident | @QuotedIdent))?"`
 }
 
 type ASTFromClause struct {
@@ -33,16 +67,31 @@ type ASTOrCondition struct {
 }
 
 type ASTCondition struct {
-	Grouped *ASTExpression      `parser:"  '(' @@ ')'"`
-	Simple  *ASTSimpleCondition `parser:"| @@"`
+	Not     bool                `parser:"@'NOT'?"`
+	Grouped *ASTExpression      `parser:"(  '(' @@ ')'"`
+	Simple  *ASTSimpleCondition `parser:" | @@ )"`
 }
 
 type ASTSimpleCondition struct {
 	Operand *ASTOperand `parser:"  @@"`
-	Op      *string     `parser:"( @('='|'!='|'>'|'<'|'>='|'<='|'CONTAINS'|'~=')"`
+	IsNull  *ASTIsNull  `parser:"( @@"`
+	Op      *string     `parser:"| @('='|'!='|'>'|'<'|'>='|'<='|'CONTAINS'|'~=')"`
 	Value   *ASTOperand `parser:"  @@ )?"`
 }
 
+type ASTIsNull struct {
+	Is  string `parser:"@'IS'"`
+	Not bool   `parser:"@'NOT'? 'NULL'"`
+}
+
+// Operator returns the filter operator of the null check
+func (n *ASTIsNull) Operator() string {
+	if n.Not {
+		return OpIsNotNull
+	}
+	return OpIsNull
+}
+
 type ASTOperand struct {
 	Function *ASTFunction `parser:"  @@"`
 	Literal  *ASTLiteral  `parser:"| @@"`
@@ -56,26 +105,51 @@ type ASTFunction struct {
 }
 
 type ASTValue struct {
-	// Value can be a path with dots and wildcards
-	// Ident, "*" or "$" separated by "."
-	// We need to capture the whole thing as a string or list of parts?
-	// Simplest: Capture parts and join them.
-	Parts []string `parser:"(@Ident | @('*') | @('$')) ('.' (@Ident | @('*') | @('$')))*"`
+	// Value can be a path with dots, wildcards and subscripts
+	// Ident, `quoted ident`, "*" or "$" separated by ".", each optionally
+	// followed by [index], [start:end] or a bracketed key ["a.b"].
+	// Quoted idents keep their backticks so the path parser treats them as literal keys.
+	Head string         `parser:"(@Ident | @QuotedIdent | @('*') | @('$') | @Subscript)"`
+	Tail []*ASTPathTail `parser:"@@*"`
+}
+
+// ASTPathTail is a ".key" step or a subscript token: an array index ([-1]),
+// a slice ([1:4], [:2], [-3:]) or a literal key (["key.with.dots"])
+type ASTPathTail struct {
+	Key       *string `parser:"  '.' (@Ident | @QuotedIdent | @('*') | @('$'))"`
+	Subscript *string `parser:"| '.'? @Subscript"`
 }
 
 func (v *ASTValue) String() string {
-	return strings.Join(v.Parts, ".")
+	var sb strings.Builder
+	sb.WriteString(v.Head)
+	for _, t := range v.Tail {
+		if t.Key != nil {
+			sb.WriteString("." + *t.Key)
+		} else if t.Subscript != nil {
+			sb.WriteString(*t.Subscript)
+		}
+	}
+	return sb.String()
 }
 
 type ASTLiteral struct {
 	Number *float64 `parser:"@Number"`
 	StrVal *string  `parser:"| @String"`
-	Bool   *bool    `parser:"| @('TRUE'|'FALSE')"`
+	Bool   *Boolean `parser:"| @('TRUE'|'FALSE')"`
+}
+
+// Boolean captures TRUE/FALSE keywords (a plain bool would be set to true on any match)
+type Boolean bool
+
+func (b *Boolean) Capture(values []string) error {
+	*b = Boolean(strings.EqualFold(values[0], "TRUE"))
+	return nil
 }
 
 // Helpers
 
-func (s *ASTSelect) ToSelectQuery() *SelectQuery {
+func (s *ASTSelect) ToSelectQuery() (*SelectQuery, error) {
 	sq := &SelectQuery{
 		Fields: []Field{},
 	}
@@ -83,39 +157,156 @@ func (s *ASTSelect) ToSelectQuery() *SelectQuery {
 	for _, f := range s.SelectFields {
 		path, agg := f.Info()
 
-		alias := f.Alias
+		alias := unquoteIdent(f.Alias)
 		if alias == "" {
 			if agg != "" {
-				alias = fmtKey(agg, path)
+				alias = fmtKey(agg, DisplayPath(path))
 			} else {
-				alias = path
+				alias = DisplayPath(path)
 			}
 		}
 
-		sq.Fields = append(sq.Fields, Field{
+		field := Field{
 			Path:      path,
 			Alias:     alias,
 			Aggregate: agg,
-		})
+		}
+		switch {
+		case IsBucketFunc(agg):
+			// A bucket is the group value, not an aggregate
+			bucket, err := newBucket(f.Expression.Or[0].And[0].Simple.Operand.Function)
+			if err != nil {
+				return nil, err
+			}
+			field.Aggregate = ""
+			field.Bucket = bucket
+		case isScalarFunc(agg):
+			scalar, err := scalarField(f.Expression.Or[0].And[0].Simple.Operand.Function)
+			if err != nil {
+				return nil, err
+			}
+			scalar.Alias = unquoteIdent(f.Alias)
+			if scalar.Alias == "" {
+				scalar.Alias = scalar.defaultAlias()
+			}
+			field = scalar
+		case agg != "":
+			// COUNT(TOKENIZE(message)) aggregates the words
+			fn := f.Expression.Or[0].And[0].Simple.Operand.Function
+			if len(fn.Args) == 1 && fn.Args[0].Function != nil {
+				if !strings.EqualFold(fn.Args[0].Function.Name, FuncTokenize) {
+					return nil, fmt.Errorf("unsupported argument of %s: %s", agg, fn.Args[0].String())
+				}
+				scalar, err := scalarField(fn.Args[0].Function)
+				if err != nil {
+					return nil, err
+				}
+				field.Path = scalar.Path
+				field.Func = scalar.Func
+				if f.Alias == "" {
+					field.Alias = fmtKey(agg, scalar.defaultAlias())
+				}
+			}
+		}
+		sq.Fields = append(sq.Fields, field)
 	}
 
 	if s.From != nil {
 		if s.From.TableName != nil {
 			sq.FromTable = *s.From.TableName
 		} else if s.From.SubQuery != nil {
-			sq.FromQuery = s.From.SubQuery.ToSelectQuery()
+			sub, err := s.From.SubQuery.ToSelectQuery()
+			if err != nil {
+				return nil, err
+			}
+			sq.FromQuery = sub
 		}
 	}
 
 	if s.GroupBy != nil {
-		sq.GroupBy = s.GroupBy.String()
+		if fn := s.GroupBy.Function; fn != nil {
+			if !IsBucketFunc(fn.Name) {
+				return nil, fmt.Errorf("unsupported GROUP BY function %s (use BUCKET or HISTOGRAM)", strings.ToUpper(fn.Name))
+			}
+			bucket, err := newBucket(fn)
+			if err != nil {
+				return nil, err
+			}
+			sq.GroupBy = bucket.Path
+			sq.GroupBucket = bucket
+		} else {
+			sq.GroupBy = s.GroupBy.Value.String()
+		}
+	}
+
+	for _, key := range s.OrderBy {
+		k := OrderKey{Path: key.Value.String(), Desc: strings.EqualFold(key.Direction, "DESC")}
+		if key.Nulls != nil {
+			nulls, err := parseNullOrder(*key.Nulls)
+			if err != nil {
+				return nil, err
+			}
+			k.Nulls = nulls
+		}
+		sq.OrderBy = append(sq.OrderBy, k)
+	}
+
+	if s.Limit != nil {
+		if *s.Limit < 0 {
+			return nil, fmt.Errorf("LIMIT must not be negative: %d", *s.Limit)
+		}
+		limit := *s.Limit
+		sq.Limit = &limit
+	}
+
+	if s.Into != nil && s.IntoTail != nil {
+		return nil, fmt.Errorf("INTO given twice ('%s' and '%s')", *s.Into, *s.IntoTail)
+	}
+	if s.Into != nil {
+		sq.Into = *s.Into
+	} else if s.IntoTail != nil {
+		sq.Into = *s.IntoTail
 	}
 
 	if s.Where != nil {
 		sq.Filter = s.Where.ToExpression()
 	}
 
-	return sq
+	return sq, nil
+}
+
+func (u *ASTUpdate) ToUpdateQuery() (*UpdateQuery, error) {
+	uq := &UpdateQuery{}
+	if u.Table != nil {
+		uq.Table = *u.Table
+	}
+	for _, a := range u.Assignments {
+		assignment := Assignment{Path: a.Path.String()}
+		switch {
+		case a.Value.Literal != nil:
+			assignment.Value = a.Value.Literal.ToValue()
+		case a.Value.Value != nil:
+			assignment.Source = a.Value.Value.String()
+		default:
+			return nil, fmt.Errorf("unsupported value in SET %s: %s", assignment.Path, a.Value.String())
+		}
+		uq.Assignments = append(uq.Assignments, assignment)
+	}
+	if u.Where != nil {
+		uq.Filter = u.Where.ToExpression()
+	}
+	return uq, nil
+}
+
+func (d *ASTDelete) ToDeleteQuery() *DeleteQuery {
+	dq := &DeleteQuery{}
+	if d.Table != nil {
+		dq.Table = *d.Table
+	}
+	if d.Where != nil {
+		dq.Filter = d.Where.ToExpression()
+	}
+	return dq
 }
 
 func (f *ASTSelectField) Info() (path, agg string) {
@@ -168,15 +359,21 @@ func (o *ASTOrCondition) String() string {
 }
 
 func (c *ASTCondition) String() string {
+	not := ""
+	if c.Not {
+		not = "NOT "
+	}
 	if c.Grouped != nil {
-		return "(" + c.Grouped.String() + ")"
+		return not + "(" + c.Grouped.String() + ")"
 	}
 	if c.Simple != nil {
 		s := c.Simple.Operand.String()
-		if c.Simple.Op != nil && c.Simple.Value != nil {
+		if c.Simple.IsNull != nil {
+			s += " " + strings.ToUpper(c.Simple.IsNull.Operator())
+		} else if c.Simple.Op != nil && c.Simple.Value != nil {
 			s += *c.Simple.Op + c.Simple.Value.String()
 		}
-		return s
+		return not + s
 	}
 	return ""
 }
@@ -227,7 +424,7 @@ func (l *ASTLiteral) ToValue() interface{} {
 		return *l.StrVal
 	}
 	if l.Bool != nil {
-		return *l.Bool
+		return bool(*l.Bool)
 	}
 	return nil
 }
@@ -267,14 +464,30 @@ func (o *ASTOrCondition) ToExpression() Expression {
 }
 
 func (c *ASTCondition) ToExpression() Expression {
+	expr := c.toExpression()
+	if c.Not && expr != nil {
+		return &NotExpression{Expr: expr}
+	}
+	return expr
+}
+
+func (c *ASTCondition) toExpression() Expression {
 	if c.Grouped != nil {
 		return c.Grouped.ToExpression()
 	}
 	if c.Simple != nil {
 		// Map to Filter
 		leftPath := c.Simple.Operand.String() // simplify
+		quantifier := ""
+		if fn := c.Simple.Operand.Function; fn != nil && IsQuantifier(fn.Name) && len(fn.Args) == 1 {
+			// ANY(path) / ALL(path) / NONE(path) choose how collections are matched
+			quantifier = strings.ToUpper(fn.Name)
+			leftPath = fn.Args[0].String()
+		}
 		op := "="
-		if c.Simple.Op != nil {
+		if c.Simple.IsNull != nil {
+			op = c.Simple.IsNull.Operator()
+		} else if c.Simple.Op != nil {
 			op = *c.Simple.Op
 		}
 		var val interface{}
@@ -282,8 +495,10 @@ func (c *ASTCondition) ToExpression() Expression {
 			val = c.Simple.Value.ToValue()
 		}
 
+		f := NewFilter(leftPath, op, val)
+		f.Quantifier = quantifier
 		return &Condition{
-			Filter: NewFilter(leftPath, op, val),
+			Filter: f,
 		}
 	}
 	return nil
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Key filter operators of wildcard segments, besides the comparisons
+// (=, !=, <, <=, >, >=) and ~= (contains)
+const (
+	// KeyPrefix keeps the keys starting with a value (*^=temp_)
+	KeyPrefix = "^="
+	// KeySuffix keeps the keys ending with a value (*$=_c)
+	KeySuffix = "$="
+	// KeyRegex keeps the keys matching a regular expression (*~/^temp_\d+$/)
+	KeyRegex = "~/"
+)
+
+var keyOperators = []string{KeyRegex, KeyPrefix, KeySuffix, ">=", "<=", "!=", "~=", ">", "<", "="}
+
+// regexKeyFilter matches a wildcard regex key filter, which may contain dots
+var regexKeyFilter = regexp.MustCompile(`[*%$]~/(?:\\.|[^/\\])*/`)
+
+// splitKeyFilter splits a wildcard key filter ("*>=b", "$~/^a/") into its
+// operator and value; for KeyRegex the value is the expression itself
+func splitKeyFilter(part string) (op, value string, ok bool) {
+	if part == "" || !strings.ContainsRune("*%$", rune(part[0])) {
+		return "", "", false
+	}
+	for _, candidate := range keyOperators {
+		rest, found := strings.CutPrefix(part[1:], candidate)
+		if !found {
+			continue
+		}
+		if candidate == KeyRegex {
+			expr, closed := strings.CutSuffix(rest, "/")
+			return candidate, expr, closed
+		}
+		return candidate, rest, true
+	}
+	return "", "", false
+}
+
+// regexEnd returns the index of the "/" closing the regex opened at
+// path[start] ("~/"), skipping escaped characters, or -1
+func regexEnd(path string, start int) int {
+	for i := start + 2; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+// keyFilter returns the predicate a wildcard segment applies to keys.
+// Comparisons with a number are numeric (*>=10 keeps "100" but not "9" or
+// "name"), comparisons with anything else lexical.
+func (q *Query) keyFilter(part string) (func(key string) bool, error) {
+	if part == "*" || part == "%" || part == "$" {
+		return func(string) bool { return true }, nil
+	}
+	op, value, ok := splitKeyFilter(part)
+	if !ok {
+		return nil, fmt.Errorf("invalid wildcard filter: %s", part)
+	}
+	if op == KeyRegex {
+		re, err := q.regexSegment(part, value)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	fold := func(s string) string { return s }
+	if q.IgnoreCase {
+		fold = strings.ToLower
+	}
+	value = fold(value)
+	number, err := strconv.ParseFloat(value, 64)
+	numeric := err == nil
+	// compare orders a key against the value; with a numeric value, keys
+	// that are not numbers are not comparable
+	compare := func(key string) (int, bool) {
+		if !numeric {
+			return strings.Compare(key, value), true
+		}
+		n, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return 0, false
+		}
+		return cmp.Compare(n, number), true
+	}
+
+	return func(key string) bool {
+		key = fold(key)
+		switch op {
+		case KeyPrefix:
+			return strings.HasPrefix(key, value)
+		case KeySuffix:
+			return strings.HasSuffix(key, value)
+		case "~=":
+			return strings.Contains(key, value)
+		}
+		c, ok := compare(key)
+		switch op {
+		case "!=":
+			return !ok || c != 0
+		case "=":
+			return ok && c == 0
+		case ">":
+			return ok && c > 0
+		case ">=":
+			return ok && c >= 0
+		case "<":
+			return ok && c < 0
+		default: // <=
+			return ok && c <= 0
+		}
+	}, nil
+}
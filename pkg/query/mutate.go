@@ -0,0 +1,238 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// Set stores value at path in record, in place. Missing (or null)
+// intermediate segments are created as objects; "*" applies the rest of
+// the path to every element of an array (or value of an object) and numeric
+// segments index arrays, negative ones counting from the end.
+func Set(record parser.Record, path string, value interface{}) error {
+	parts := parsePath(path)
+	if len(parts) == 0 {
+		return fmt.Errorf("cannot set the whole record")
+	}
+	_, err := setValue(map[string]interface{}(record), parts, value, nil)
+	return err
+}
+
+// SetOrdered is Set for a record decoded with PreserveOrder, returning the
+// record: a new key is appended after the existing ones
+func SetOrdered(record parser.OrderedMap, path string, value interface{}) (parser.OrderedMap, error) {
+	parts := parsePath(path)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("cannot set the whole record")
+	}
+	data, err := setValue(record, parts, value, nil)
+	if err != nil {
+		return nil, err
+	}
+	return data.(parser.OrderedMap), nil
+}
+
+// setValue returns data with value stored at parts, creating data if nil
+func setValue(data interface{}, parts []string, value interface{}, currentPath []string) (interface{}, error) {
+	if len(parts) == 0 {
+		return value, nil
+	}
+	part := parts[0]
+	key := unquoteIdent(part)
+	path := append(currentPath, key)
+
+	if r, ok := data.(parser.Record); ok {
+		data = map[string]interface{}(r)
+	}
+	switch v := data.(type) {
+	case nil:
+		if part == "*" {
+			return nil, fmt.Errorf("cannot set %s: no values to match '*'", formatPath(path))
+		}
+		child, err := setValue(nil, parts[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{key: child}, nil
+
+	case map[string]interface{}:
+		if part == "*" {
+			for k, val := range v {
+				child, err := setValue(val, parts[1:], value, append(currentPath, k))
+				if err != nil {
+					return nil, err
+				}
+				v[k] = child
+			}
+			return v, nil
+		}
+		child, err := setValue(v[key], parts[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		v[key] = child
+		return v, nil
+
+	case parser.OrderedMap:
+		if part == "*" {
+			for i, kv := range v {
+				child, err := setValue(kv.Val, parts[1:], value, append(currentPath, kv.Key))
+				if err != nil {
+					return nil, err
+				}
+				v[i].Val = child
+			}
+			return v, nil
+		}
+		current, _ := v.Get(key)
+		child, err := setValue(current, parts[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		return v.Set(key, child), nil
+
+	case []interface{}:
+		if part == "*" {
+			for i, val := range v {
+				child, err := setValue(val, parts[1:], value, append(currentPath, strconv.Itoa(i)))
+				if err != nil {
+					return nil, err
+				}
+				v[i] = child
+			}
+			return v, nil
+		}
+		idx, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set %s: '%s' is not an array index", formatPath(path), part)
+		}
+		if idx < 0 {
+			idx += len(v)
+		}
+		if idx < 0 || idx >= len(v) {
+			return nil, fmt.Errorf("cannot set %s: array index %s out of bounds", formatPath(path), part)
+		}
+		child, err := setValue(v[idx], parts[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		v[idx] = child
+		return v, nil
+
+	default:
+		return nil, fmt.Errorf("cannot set %s: %s is a %s, not an object", formatPath(path), formatPath(currentPath), parser.TypeOf(v))
+	}
+}
+
+// formatPath renders path segments for error messages
+func formatPath(parts []string) string {
+	return "." + strings.Join(parts, ".")
+}
+
+// Delete removes the value at path from record, in place. "*" applies the
+// rest of the path to every element of an array (or value of an object), so
+// "users.*.password" strips the key from every user; as a last segment it
+// removes all the elements. Numeric segments index arrays, negative ones
+// counting from the end. Deleting a path that does not exist is a no-op.
+func Delete(record parser.Record, path string) error {
+	parts := parsePath(path)
+	if len(parts) == 0 {
+		return fmt.Errorf("cannot delete the whole record")
+	}
+	deleteValue(map[string]interface{}(record), parts)
+	return nil
+}
+
+// DeleteOrdered is Delete for a record decoded with PreserveOrder, returning
+// the record
+func DeleteOrdered(record parser.OrderedMap, path string) (parser.OrderedMap, error) {
+	parts := parsePath(path)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("cannot delete the whole record")
+	}
+	return deleteValue(record, parts).(parser.OrderedMap), nil
+}
+
+// deleteValue returns data without the value at parts
+func deleteValue(data interface{}, parts []string) interface{} {
+	part := parts[0]
+	last := len(parts) == 1
+
+	if r, ok := data.(parser.Record); ok {
+		data = map[string]interface{}(r)
+	}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if part == "*" {
+			for k, val := range v {
+				if last {
+					delete(v, k)
+				} else {
+					v[k] = deleteValue(val, parts[1:])
+				}
+			}
+			return v
+		}
+		key := unquoteIdent(part)
+		val, ok := v[key]
+		if !ok {
+			return v
+		}
+		if last {
+			delete(v, key)
+		} else {
+			v[key] = deleteValue(val, parts[1:])
+		}
+		return v
+
+	case parser.OrderedMap:
+		if part == "*" {
+			if last {
+				return v[:0]
+			}
+			for i, kv := range v {
+				v[i].Val = deleteValue(kv.Val, parts[1:])
+			}
+			return v
+		}
+		key := unquoteIdent(part)
+		val, ok := v.Get(key)
+		if !ok {
+			return v
+		}
+		if last {
+			return v.Delete(key)
+		}
+		return v.Set(key, deleteValue(val, parts[1:]))
+
+	case []interface{}:
+		if part == "*" {
+			if last {
+				return v[:0]
+			}
+			for i, val := range v {
+				v[i] = deleteValue(val, parts[1:])
+			}
+			return v
+		}
+		idx, err := strconv.Atoi(part)
+		if err != nil {
+			return v
+		}
+		if idx < 0 {
+			idx += len(v)
+		}
+		if idx < 0 || idx >= len(v) {
+			return v
+		}
+		if last {
+			return append(v[:idx], v[idx+1:]...)
+		}
+		v[idx] = deleteValue(v[idx], parts[1:])
+		return v
+	}
+	return data
+}
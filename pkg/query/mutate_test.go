@@ -0,0 +1,147 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		path     string
+		value    interface{}
+		expected string
+		wantErr  bool
+	}{
+		{"Existing key", `{"user":{"active":false}}`, ".user.active", true, `{"user":{"active":true}}`, false},
+		{"Create intermediate objects", `{"a":1}`, "user.address.city", "Rome", `{"a":1,"user":{"address":{"city":"Rome"}}}`, false},
+		{"Replace null", `{"user":null}`, "user.name", "x", `{"user":{"name":"x"}}`, false},
+		{"Array index", `{"tags":["a","b"]}`, "tags[-1]", "z", `{"tags":["a","z"]}`, false},
+		{"Wildcard", `{"items":[{"n":1},{"n":2}]}`, "items.*.ok", true, `{"items":[{"n":1,"ok":true},{"n":2,"ok":true}]}`, false},
+		{"Quoted key", `{}`, "`a.b`.c", float64(1), `{"a.b":{"c":1}}`, false},
+		{"Object value", `{}`, "meta", map[string]interface{}{"v": float64(2)}, `{"meta":{"v":2}}`, false},
+		{"Through a scalar", `{"a":5}`, "a.b", true, "", true},
+		{"Index out of bounds", `{"tags":[]}`, "tags[0]", "x", "", true},
+		{"Key on an array", `{"tags":["a"]}`, "tags.x", "x", "", true},
+		{"Whole record", `{}`, ".", "x", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record parser.Record
+			if err := json.Unmarshal([]byte(tt.input), &record); err != nil {
+				t.Fatal(err)
+			}
+			err := Set(record, tt.path, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := SetOrdered(readOrdered(t, tt.input), tt.path, tt.value); err == nil {
+					t.Error("SetOrdered() should fail too")
+				}
+				return
+			}
+			got, _ := json.Marshal(record)
+			if string(got) != tt.expected {
+				t.Errorf("Set() = %s, want %s", got, tt.expected)
+			}
+
+			ordered, err := SetOrdered(readOrdered(t, tt.input), tt.path, tt.value)
+			if err != nil {
+				t.Fatalf("SetOrdered() failed: %v", err)
+			}
+			if got, _ := json.Marshal(ordered); string(got) != tt.expected {
+				t.Errorf("SetOrdered() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{"Key", `{"a":1,"b":2}`, "a", `{"b":2}`, false},
+		{"Nested key", `{"user":{"name":"x","password":"p"}}`, ".user.password", `{"user":{"name":"x"}}`, false},
+		{"Wildcard", `{"users":[{"n":1,"password":"p"},{"n":2}]}`, "users.*.password", `{"users":[{"n":1},{"n":2}]}`, false},
+		{"Object wildcard", `{"m":{"a":{"x":1,"y":2},"b":{"x":3}}}`, "m.*.x", `{"m":{"a":{"y":2},"b":{}}}`, false},
+		{"Array element", `{"tags":["a","b","c"]}`, "tags[1]", `{"tags":["a","c"]}`, false},
+		{"Last element", `{"tags":["a","b","c"]}`, "tags[-1]", `{"tags":["a","b"]}`, false},
+		{"All elements", `{"tags":["a","b"]}`, "tags.*", `{"tags":[]}`, false},
+		{"Quoted key", `{"a.b":1,"a":{"b":2}}`, "`a.b`", `{"a":{"b":2}}`, false},
+		{"Missing path", `{"a":1}`, "b.c", `{"a":1}`, false},
+		{"Through a scalar", `{"a":1}`, "a.b", `{"a":1}`, false},
+		{"Whole record", `{}`, ".", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record parser.Record
+			if err := json.Unmarshal([]byte(tt.input), &record); err != nil {
+				t.Fatal(err)
+			}
+			err := Delete(record, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _ := json.Marshal(record)
+			if string(got) != tt.expected {
+				t.Errorf("Delete() = %s, want %s", got, tt.expected)
+			}
+
+			ordered, err := DeleteOrdered(readOrdered(t, tt.input), tt.path)
+			if err != nil {
+				t.Fatalf("DeleteOrdered() failed: %v", err)
+			}
+			if got, _ := json.Marshal(ordered); string(got) != tt.expected {
+				t.Errorf("DeleteOrdered() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// readOrdered decodes a JSON object keeping its keys in document order
+func readOrdered(t *testing.T, input string) parser.OrderedMap {
+	t.Helper()
+	record, err := parser.NewReaderParser("input", strings.NewReader(input)).ReadOrdered()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestMutateOrdered(t *testing.T) {
+	record := readOrdered(t, `{"z":1,"m":{"y":1,"b":2},"a":[{"k":1,"c":2}]}`)
+	var err error
+	for _, path := range []string{"m.x", "n", "a.*.d", "z"} {
+		if record, err = SetOrdered(record, path, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := `{"z":true,"m":{"y":1,"b":2,"x":true},"a":[{"k":1,"c":2,"d":true}],"n":true}`
+	if got, _ := json.Marshal(record); string(got) != expected {
+		t.Errorf("SetOrdered() = %s, want %s", got, expected)
+	}
+
+	for _, path := range []string{"m.y", "a.*.k", "z"} {
+		if record, err = DeleteOrdered(record, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected = `{"m":{"b":2,"x":true},"a":[{"c":2,"d":true}],"n":true}`
+	if got, _ := json.Marshal(record); string(got) != expected {
+		t.Errorf("DeleteOrdered() = %s, want %s", got, expected)
+	}
+}
@@ -0,0 +1,90 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OrderKey is one key of a sort: a path, its direction and where its nulls
+// go, last unless Nulls is NullsFirst, whatever the direction
+type OrderKey struct {
+	Path  string
+	Desc  bool
+	Nulls NullOrder
+}
+
+func (k OrderKey) String() string {
+	s := DisplayPath(k.Path) + " ASC"
+	if k.Desc {
+		s = DisplayPath(k.Path) + " DESC"
+	}
+	if k.Nulls == NullsFirst {
+		s += " NULLS FIRST"
+	}
+	return s
+}
+
+// parseNullOrder parses the FIRST or LAST of NULLS FIRST / NULLS LAST
+func parseNullOrder(s string) (NullOrder, error) {
+	switch {
+	case strings.EqualFold(s, "first"):
+		return NullsFirst, nil
+	case strings.EqualFold(s, "last"):
+		return NullsLast, nil
+	}
+	return NullsLast, fmt.Errorf("invalid NULLS %s (use NULLS FIRST or NULLS LAST)", s)
+}
+
+// ParseOrderBy parses a comma-separated sort specification, each key being
+// a path optionally followed by asc or desc, then by nulls first or nulls
+// last: "category asc, price desc nulls first, name"
+func ParseOrderBy(spec string) ([]OrderKey, error) {
+	var keys []OrderKey
+	for _, item := range SplitPaths(spec) {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty sort key in '%s'", spec)
+		}
+		key := OrderKey{Path: strings.TrimPrefix(fields[0], ".")}
+		rest := fields[1:]
+		if len(rest) > 0 && (strings.EqualFold(rest[0], "asc") || strings.EqualFold(rest[0], "desc")) {
+			key.Desc = strings.EqualFold(rest[0], "desc")
+			rest = rest[1:]
+		}
+		if len(rest) == 2 && strings.EqualFold(rest[0], "nulls") {
+			nulls, err := parseNullOrder(rest[1])
+			if err != nil {
+				return nil, err
+			}
+			key.Nulls = nulls
+			rest = nil
+		}
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("invalid sort key '%s' (use: path [asc|desc] [nulls first|last])", item)
+		}
+		if key.Path == "" {
+			return nil, fmt.Errorf("empty sort key in '%s'", spec)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty sort specification")
+	}
+	return keys, nil
+}
+
+// CompareRows orders two rows by the values of their keys (a[i] and b[i]
+// being the values of keys[i]), following CompareOrder. DESC reverses the
+// order of the values but not the position of the nulls.
+func CompareRows(a, b []interface{}, keys []OrderKey) int {
+	for i, key := range keys {
+		c := CompareOrder(a[i], b[i], key.Nulls)
+		if key.Desc && a[i] != nil && b[i] != nil {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
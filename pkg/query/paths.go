@@ -0,0 +1,123 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PathMatch is a value a path resolves to, with the concrete path leading
+// to it: "metrics.cpu_temp" for "metrics.*~=temp", "sensors[1].name" for
+// "sensors.*.name"
+type PathMatch struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// ExtractWithPaths returns every value the path resolves to with its
+// concrete path, one match per wildcard key or array element. Object keys
+// are visited in sorted order and array elements in index order.
+func (q *Query) ExtractWithPaths(record interface{}) ([]PathMatch, error) {
+	if q.Path == "" || q.Path == "." {
+		return []PathMatch{{Path: ".", Value: record}}, nil
+	}
+	if IsPipeline(q.Path) {
+		return nil, fmt.Errorf("matched paths are not available for pipelines")
+	}
+
+	tracked := *q.prepared()
+	tracked.tracker = &pathTracker{}
+	if _, err := tracked.extractValue(record, tracked.compiled.parts, []string{}); err != nil {
+		return nil, err
+	}
+	return tracked.tracker.matches, nil
+}
+
+// pathTracker records the concrete path walked by an extraction and the
+// values found at its end
+type pathTracker struct {
+	steps   []string
+	matches []PathMatch
+}
+
+// enter pushes the step to a key; it is a no-op unless the query tracks paths.
+// Keys that would not parse as a plain step are bracketed.
+func (q *Query) enter(key string) {
+	if q.tracker == nil {
+		return
+	}
+	if !isPlainKey(key) && !strings.HasSuffix(key, "()") {
+		key = "[" + strconv.Quote(key) + "]"
+	}
+	q.tracker.steps = append(q.tracker.steps, key)
+}
+
+// enterIndex pushes the step to an array element
+func (q *Query) enterIndex(i int) {
+	if q.tracker != nil {
+		q.tracker.steps = append(q.tracker.steps, "["+strconv.Itoa(i)+"]")
+	}
+}
+
+// leave pops the step pushed by enter or enterIndex
+func (q *Query) leave() {
+	if q.tracker != nil {
+		q.tracker.steps = q.tracker.steps[:len(q.tracker.steps)-1]
+	}
+}
+
+// found records a value at the end of the path
+func (q *Query) found(value interface{}) {
+	if q.tracker != nil {
+		q.tracker.matches = append(q.tracker.matches, PathMatch{Path: q.tracker.path(), Value: value})
+	}
+}
+
+// path joins the current steps, dotting keys and appending bracketed steps
+func (t *pathTracker) path() string {
+	var sb strings.Builder
+	for _, step := range t.steps {
+		if sb.Len() > 0 && !strings.HasPrefix(step, "[") {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(step)
+	}
+	if sb.Len() == 0 {
+		return "."
+	}
+	return sb.String()
+}
+
+// isPlainKey reports whether a key can be written as a dotted step
+func isPlainKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && (r == '-' || (r >= '0' && r <= '9'))) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// sortedObject visits a plain map in key order, so that tracked matches
+// are deterministic
+type sortedObject struct {
+	mapObject
+}
+
+func (m sortedObject) Range(fn func(key string, val interface{}) bool) {
+	keys := make([]string, 0, len(m.mapObject))
+	for k := range m.mapObject {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k, m.mapObject[k]) {
+			return
+		}
+	}
+}
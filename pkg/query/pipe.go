@@ -0,0 +1,158 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bisegni/jsl/pkg/parser"
+)
+
+// SplitPipes splits a jq-style pipeline into its stages
+// (".items | .[] | .price" -> [".items", ".[]", ".price"]). Pipes inside
+// backticks, brackets or quotes do not separate stages.
+func SplitPipes(expr string) []string {
+	var stages []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '`' || c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case (c == ']' || c == ')') && depth > 0:
+			depth--
+		case c == '|' && depth == 0:
+			stages = append(stages, strings.TrimSpace(expr[start:i]))
+			start = i + 1
+		}
+	}
+	return append(stages, strings.TrimSpace(expr[start:]))
+}
+
+// IsPipeline reports whether path chains several stages with "|" or
+// iterates with "[]" (".items[].price")
+func IsPipeline(path string) bool {
+	return strings.Contains(path, "[]") || (strings.Contains(path, "|") && len(SplitPipes(path)) > 1)
+}
+
+// pipeStep is a path applied to each value, whose result is iterated when
+// followed by "[]"
+type pipeStep struct {
+	path    string
+	iterate bool
+}
+
+// pipeSteps splits the stages of a pipeline at their "[]" iterations
+// (".items[].price" -> ".items" iterated, then ".price")
+func pipeSteps(path string) []pipeStep {
+	var steps []pipeStep
+	for _, stage := range SplitPipes(path) {
+		pieces := strings.Split(stage, "[]")
+		for i, piece := range pieces {
+			if i == len(pieces)-1 && piece == "" && i > 0 {
+				break
+			}
+			steps = append(steps, pipeStep{path: piece, iterate: i < len(pieces)-1})
+		}
+	}
+	return steps
+}
+
+// ExtractEach evaluates the path stage by stage and returns its outputs.
+// Each stage is applied to every output of the previous one; "[]" (".[]",
+// ".items[]") outputs the elements of an array, or the values of an object
+// in key order. Values a stage cannot resolve are dropped, the last such
+// error is returned when nothing is left.
+func (q *Query) ExtractEach(record interface{}) ([]interface{}, error) {
+	outputs := []interface{}{record}
+	var lastErr error
+	for _, step := range pipeSteps(q.Path) {
+		sub := &Query{Path: step.path, FilterContext: q.FilterContext, CaseInsensitive: q.CaseInsensitive, IgnoreCase: q.IgnoreCase}
+
+		var next []interface{}
+		for _, in := range outputs {
+			val, err := sub.extractStage(in)
+			if err == nil && step.iterate {
+				var items []interface{}
+				items, err = iterateValue(val)
+				next = append(next, items...)
+			} else if err == nil {
+				next = append(next, val)
+			}
+			if err != nil {
+				lastErr = err
+			}
+		}
+		outputs = next
+	}
+	if len(outputs) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return outputs, nil
+}
+
+// extractStage extracts the stage path from any value (records, objects,
+// arrays or scalars for the identity ".")
+func (q *Query) extractStage(val interface{}) (interface{}, error) {
+	if q.Path == "" || q.Path == "." {
+		return val, nil
+	}
+	return q.ExtractOnValue(val)
+}
+
+// extractPipeline is Extract for pipelines: the single output, or the list
+// of outputs when a stage iterates
+func (q *Query) extractPipeline(record interface{}) (interface{}, error) {
+	outputs, err := q.ExtractEach(record)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range pipeSteps(q.Path) {
+		if step.iterate {
+			return outputs, nil
+		}
+	}
+	if len(outputs) != 1 {
+		return nil, fmt.Errorf("pipeline %s has no output", q.Path)
+	}
+	return outputs[0], nil
+}
+
+// iterateValue returns the elements of an array or the values of an object
+func iterateValue(val interface{}) ([]interface{}, error) {
+	switch v := val.(type) {
+	case []interface{}:
+		return v, nil
+	case parser.Record:
+		return iterateValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]interface{}, len(keys))
+		for i, k := range keys {
+			items[i] = v[k]
+		}
+		return items, nil
+	case Object:
+		var items []interface{}
+		v.Range(func(_ string, item interface{}) bool {
+			items = append(items, item)
+			return true
+		})
+		return items, nil
+	default:
+		return nil, fmt.Errorf("cannot iterate over %T", val)
+	}
+}
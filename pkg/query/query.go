@@ -1,6 +1,7 @@
 package query
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -10,26 +11,87 @@ import (
 
 // Query represents a path-based query
 type Query struct {
-	Path          string
+	Path string
+	// FilterContext is the WHERE clause of the statement, used by the correlated
+	// wildcard "$" to keep only the array elements that satisfy it
 	FilterContext Expression
+	// CaseInsensitive lets keys match regardless of case when there is no exact match
+	CaseInsensitive bool
+	// IgnoreCase compares string values of path filters (type=temp) and
+	// wildcard key filters (*=Temp) regardless of case
+	IgnoreCase bool
+
+	// compiled is the parsed Path, see Compile
+	compiled *CompiledPath
+	// tracker records matched paths during ExtractWithPaths
+	tracker *pathTracker
 }
 
-// NewQuery creates a new query from a path string
+// NewQuery creates a new query from a path string, compiled once for all
+// the records it is applied to
 func NewQuery(path string) *Query {
-	return &Query{Path: path}
+	q := &Query{Path: path}
+	if !IsPipeline(path) {
+		q.compiled = Compile(path)
+	}
+	return q
 }
 
-// Extract extracts values from a record using the path
-func (q *Query) Extract(record parser.Record) (interface{}, error) {
+// Extract extracts values from a record using the path. The record may be
+// any JSON value: an object, an array (".0.name") or a scalar (".").
+func (q *Query) Extract(record interface{}) (interface{}, error) {
 	if q.Path == "" || q.Path == "." {
 		return record, nil
 	}
+	if IsPipeline(q.Path) {
+		return q.extractPipeline(record)
+	}
+
+	p := q.prepared()
+	return p.extractValue(record, p.compiled.parts, []string{})
+}
+
+// SplitPath splits a plain dotted path into keys, removing identifier quotes
+// (e.g. "a.`b.c`" -> ["a", "b.c"])
+func SplitPath(path string) []string {
+	parts := parsePath(path)
+	for i, p := range parts {
+		parts[i] = unquoteIdent(p)
+	}
+	return parts
+}
 
-	parts := parsePath(q.Path)
-	return q.extractValue(record, parts, []string{})
+// SplitPaths splits a comma-separated list of paths (".name, .address.city").
+// Commas inside backticks, brackets or quotes do not separate paths.
+func SplitPaths(expr string) []string {
+	var paths []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '`' || ((c == '"' || c == '\'') && depth > 0):
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case (c == ']' || c == ')') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			paths = append(paths, strings.TrimSpace(expr[start:i]))
+			start = i + 1
+		}
+	}
+	return append(paths, strings.TrimSpace(expr[start:]))
 }
 
-// parsePath parses a dot-separated path into parts
+// parsePath parses a dot-separated path into parts. A backslash escapes the
+// next character, so metrics.\* addresses the key "*" and a\.b the key "a.b".
 func parsePath(path string) []string {
 	// Remove leading dot if present
 	path = strings.TrimPrefix(path, ".")
@@ -43,17 +105,84 @@ func parsePath(path string) []string {
 	var parts []string
 	var current strings.Builder
 
+	inQuote := false
+	// escaped is set when the current part holds a backslash-escaped
+	// character (\* or \.); unless the part is a filter, it is a literal
+	// key rather than a wildcard
+	escaped, filter := false, false
+	part := func() string {
+		p := current.String()
+		current.Reset()
+		literal := escaped && !filter
+		escaped, filter = false, false
+		if literal {
+			return "`" + p + "`"
+		}
+		return p
+	}
 	for i := 0; i < len(path); i++ {
+		// Backtick-quoted keys are copied verbatim (quotes included) so that
+		// dots and operators inside them are not interpreted.
+		if path[i] == '`' {
+			inQuote = !inQuote
+		}
+		if inQuote {
+			current.WriteByte(path[i])
+			continue
+		}
+		// Regex key filters (*~/^temp_\d+$/) are copied verbatim
+		if path[i] == '~' && isWildcard(current.String()) {
+			if end := regexEnd(path, i); end > 0 && path[i+1] == '/' {
+				current.WriteString(path[i : end+1])
+				i = end
+				filter = true
+				continue
+			}
+		}
+		if path[i] == '\\' && i+1 < len(path) {
+			i++
+			current.WriteByte(path[i])
+			escaped = true
+			continue
+		}
+		// Parenthesized filters ((type=temp AND value>20)) are one part
+		if path[i] == '(' && current.Len() == 0 {
+			if end := closingParen(path, i); end > 0 {
+				current.WriteString(path[i : end+1])
+				i = end
+				continue
+			}
+		}
+		// Bracketed keys (["key.with.dots"]) become quoted literal parts
+		if path[i] == '[' && !strings.ContainsAny(current.String(), "=<>!~") {
+			if key, n, ok := bracketKey(path[i:]); ok {
+				parts = append(parts, part(), "`"+key+"`")
+				i += n - 1
+				continue
+			}
+		}
 		if path[i] == '.' {
 			// Check if this dot is a separator
 			// Look ahead for an operator before the next dot
 			isSeparator := true
 			rest := path[i+1:]
+			if strings.HasPrefix(rest, "`") || strings.HasPrefix(rest, "[") || strings.HasPrefix(rest, "(") {
+				parts = append(parts, part())
+				continue
+			}
+			if strings.Contains(rest, `\`) {
+				rest = maskEscapes(rest)
+			}
 			nextDot := strings.Index(rest, ".")
 			segment := rest
 			if nextDot != -1 {
 				segment = rest[:nextDot]
 			}
+			if open := strings.IndexByte(segment, '['); open != -1 {
+				if _, _, ok := bracketKey(rest[open:]); ok {
+					segment = segment[:open]
+				}
+			}
 
 			for _, op := range operators {
 				if strings.Contains(segment, op) {
@@ -61,7 +190,7 @@ func parsePath(path string) []string {
 					// regardless of operators.
 					// e.g. "foo.*.value>20" -> "foo", "*", "value>20"
 					// If we don't split, we get "foo", "*.value>20" which is wrong.
-					if current.String() == "*" || current.String() == "%" || current.String() == "$" {
+					if isWildcard(current.String()) {
 						isSeparator = true
 					} else if strings.HasPrefix(segment, "*") || strings.HasPrefix(segment, "%") || strings.HasPrefix(segment, "$") {
 						isSeparator = true
@@ -73,194 +202,484 @@ func parsePath(path string) []string {
 			}
 
 			if isSeparator {
-				parts = append(parts, current.String())
-				current.Reset()
+				parts = append(parts, part())
 				continue
 			}
 		}
+		if strings.IndexByte("=<>!~", path[i]) != -1 {
+			filter = true
+		}
 		current.WriteByte(path[i])
 	}
-	parts = append(parts, current.String())
+	parts = append(parts, part())
 
-	// Filter out empty parts
+	// Filter out empty parts, splitting subscripts (items[1:4] -> items, 1:4)
 	var filtered []string
 	for _, p := range parts {
-		if p != "" {
-			filtered = append(filtered, p)
+		for _, sub := range splitSubscripts(p) {
+			if sub != "" {
+				filtered = append(filtered, sub)
+			}
 		}
 	}
 	return filtered
 }
 
-// extractFromMap handles extracting values from a map, supporting wildcards and operators
-func (q *Query) extractFromMap(m map[string]interface{}, part string, remaining []string, currentPath []string) (interface{}, error) {
-	// Check if this part is a filter expression (e.g., "type=temp")
-	if IsFilterExpression(part) {
-		expr := ParseFilterExpression(part)
-		if expr != nil {
-			// Extract the field from the current map to check the condition
-			subQ := NewQuery(expr.Field)
-			val, err := subQ.Extract(m)
-			if err == nil {
-				// We found the field, now compare
-				// Parse filter value for comparison (try number first)
-				var filterVal interface{}
-				filterVal = expr.Value
-				if n, err := strconv.ParseFloat(expr.Value, 64); err == nil {
-					filterVal = n
-				}
+// closingParen returns the index of the parenthesis closing the one at
+// open, skipping quoted strings, or -1
+func closingParen(s string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
 
-				match := false
-				switch expr.Operator {
-				case "=", "==":
-					match = compareEqual(val, filterVal)
-				case "!=":
-					match = !compareEqual(val, filterVal)
-				case ">":
-					match = compareGreater(val, filterVal)
-				case ">=":
-					match = compareGreaterEqual(val, filterVal)
-				case "<":
-					match = compareLess(val, filterVal)
-				case "<=":
-					match = compareLessEqual(val, filterVal)
-				case "contains":
-					match = containsValue(val, filterVal)
-				}
+// splitSubscripts splits bracket subscripts off a path part, e.g.
+// "items[0][1:3]" -> ["items", "0", "1:3"]. Parts whose brackets do not hold
+// an index or a slice are returned unchanged.
+func splitSubscripts(part string) []string {
+	open := strings.IndexByte(part, '[')
+	if open < 0 || part[0] == '`' || !strings.HasSuffix(part, "]") {
+		return []string{part}
+	}
+	out := []string{part[:open]}
+	rest := part[open:]
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 || !isSubscript(rest[1:end]) {
+			return []string{part}
+		}
+		out = append(out, rest[1:end])
+		rest = rest[end+1:]
+	}
+	return out
+}
 
-				if match {
-					// Condition met! Continue with remaining path on the SAME map
-					return q.extractValue(m, remaining, currentPath)
-				}
-				return nil, fmt.Errorf("filter '%s' did not match", part)
+// bracketKey parses a bracketed key at the start of s, e.g. ["a.b"] or
+// ['a.b'], returning the key and the length consumed. Backslash escapes
+// the quote character and itself.
+func bracketKey(s string) (key string, n int, ok bool) {
+	if len(s) < 4 || s[0] != '[' || (s[1] != '"' && s[1] != '\'') {
+		return "", 0, false
+	}
+	quote := s[1]
+	var sb strings.Builder
+	for i := 2; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\'):
+			i++
+			sb.WriteByte(s[i])
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == ']' {
+				return sb.String(), i + 2, true
 			}
+			return "", 0, false
+		default:
+			sb.WriteByte(s[i])
 		}
 	}
+	return "", 0, false
+}
 
-	// Simple key access
-	if !strings.HasPrefix(part, "*") && !strings.HasPrefix(part, "%") && !strings.HasPrefix(part, "$") {
-		if val, ok := m[part]; ok {
+// isSubscript reports whether s is an array index (-1) or slice (1:4, :2, -3:)
+func isSubscript(s string) bool {
+	start, end, isSlice := strings.Cut(s, ":")
+	if !isSlice {
+		_, err := strconv.Atoi(s)
+		return err == nil
+	}
+	for _, bound := range []string{start, end} {
+		if bound == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(bound); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSlice resolves a "start:end" part against an array of length n,
+// clamping the bounds like Python slices do
+func parseSlice(part string, n int) (start, end int, ok bool) {
+	if !strings.Contains(part, ":") || !isSubscript(part) {
+		return 0, 0, false
+	}
+	startStr, endStr, _ := strings.Cut(part, ":")
+	bound := func(s string, def int) int {
+		if s == "" {
+			return def
+		}
+		i, _ := strconv.Atoi(s)
+		if i < 0 {
+			i += n
+		}
+		return max(0, min(i, n))
+	}
+	start, end = bound(startStr, 0), bound(endStr, n)
+	if start > end {
+		start = end
+	}
+	return start, end, true
+}
+
+// parsePosition parses a position filter on array elements, "*#" followed by
+// a comparison with an index (*#<3, *#>=-2, *#!=0), into the ranges of the
+// selected elements of an array of length n
+func parsePosition(part string, n int) ([][2]int, bool) {
+	cond, ok := strings.CutPrefix(part, "*#")
+	if !ok {
+		return nil, false
+	}
+	var op string
+	for _, candidate := range []string{"<=", ">=", "!=", "<", ">", "="} {
+		if strings.HasPrefix(cond, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, false
+	}
+	pos, err := strconv.Atoi(strings.TrimSpace(cond[len(op):]))
+	if err != nil {
+		return nil, false
+	}
+	if pos < 0 {
+		pos += n
+	}
+	clamp := func(i int) int { return max(0, min(i, n)) }
+	switch op {
+	case "<":
+		return [][2]int{{0, clamp(pos)}}, true
+	case "<=":
+		return [][2]int{{0, clamp(pos + 1)}}, true
+	case ">":
+		return [][2]int{{clamp(pos + 1), n}}, true
+	case ">=":
+		return [][2]int{{clamp(pos), n}}, true
+	case "=":
+		if pos < 0 || pos >= n {
+			return nil, true
+		}
+		return [][2]int{{pos, pos + 1}}, true
+	default: // !=
+		if pos < 0 || pos >= n {
+			return [][2]int{{0, n}}, true
+		}
+		return [][2]int{{0, pos}, {pos + 1, n}}, true
+	}
+}
+
+// unquoteIdent strips the backticks of a quoted identifier such as `user-id`
+func unquoteIdent(s string) string {
+	if key, ok := quotedKey(s); ok {
+		return key
+	}
+	return s
+}
+
+// maskEscapes replaces escaped characters and their backslash with
+// underscores, so that they are not taken for separators or operators
+func maskEscapes(path string) string {
+	b := []byte(path)
+	for i := 0; i < len(b)-1; i++ {
+		if b[i] == '\\' {
+			b[i], b[i+1] = '_', '_'
+			i++
+		}
+	}
+	return string(b)
+}
+
+// quotedKey reports whether a path part is a backtick-quoted literal key
+func quotedKey(part string) (string, bool) {
+	if len(part) >= 2 && part[0] == '`' && part[len(part)-1] == '`' {
+		return part[1 : len(part)-1], true
+	}
+	return "", false
+}
+
+// DisplayPath renders a path for output keys, dropping identifier quotes
+// and escapes. A key holding a dot keeps its backticks (a.`b.c`), so that
+// the name is not taken for the nested path a.b.c.
+func DisplayPath(path string) string {
+	if !strings.ContainsAny(path, "`\\") && !strings.Contains(path, "[\"") && !strings.Contains(path, "['") {
+		return path
+	}
+	parts := parsePath(path)
+	for i, part := range parts {
+		if key, ok := quotedKey(part); ok && !strings.Contains(key, ".") {
+			parts[i] = key
+		}
+	}
+	display := strings.Join(parts, ".")
+	if strings.HasPrefix(path, ".") {
+		display = "." + display
+	}
+	return display
+}
+
+// Object is implemented by map-like values that paths can walk without
+// conversion, such as database.OrderedMap (which preserves key order)
+type Object interface {
+	// Get returns the value for a key
+	Get(key string) (interface{}, bool)
+	// Range calls fn for each key/value pair until fn returns false
+	Range(fn func(key string, val interface{}) bool)
+}
+
+// mapObject adapts plain maps to Object
+type mapObject map[string]interface{}
+
+func (m mapObject) Get(key string) (interface{}, bool) {
+	val, ok := m[key]
+	return val, ok
+}
+
+func (m mapObject) Range(fn func(key string, val interface{}) bool) {
+	for k, v := range m {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// extractFromMap handles extracting values from a map, supporting wildcards and operators
+func (q *Query) extractFromMap(m Object, part string, remaining []string, currentPath []string) (interface{}, error) {
+	// Quoted keys are always literal, even if they contain operators or wildcards
+	if key, ok := quotedKey(part); ok {
+		if val, ok := q.lookupKey(m, key); ok {
+			q.enter(key)
+			defer q.leave()
 			return q.extractValue(val, remaining, append(currentPath, part))
 		}
-		return nil, fmt.Errorf("key '%s' not found", part)
+		return nil, fmt.Errorf("key '%s' not found", key)
 	}
 
-	// Wildcard access
-	var operator string
-	var filterValue string
+	// Boolean filters ("(type=temp AND value>20)") keep the map if it matches
+	if strings.HasPrefix(part, "(") && strings.HasSuffix(part, ")") {
+		if q.exprSegment(part).Evaluate(objectRecord(m)) {
+			return q.extractValue(m, remaining, currentPath)
+		}
+		return nil, fmt.Errorf("filter '%s' did not match", part)
+	}
 
-	if part == "*" || part == "%" || part == "$" {
-		operator = "*" // match all
-	} else {
-		// Try to find an operator
-		operators := []string{">=", "<=", "!=", "~=", ">", "<", "="}
-		wildcards := []string{"*", "%", "$"}
-		for _, w := range wildcards {
-			for _, op := range operators {
-				if strings.HasPrefix(part, w+op) {
-					operator = op
-					filterValue = part[len(op)+1:]
-					goto found
-				}
+	// Check if this part is a filter expression (e.g., "type=temp")
+	if expr := q.filterSegment(part); expr != nil {
+		// Extract the field from the current map to check the condition
+		subQ := NewQuery(expr.Field)
+		subQ.CaseInsensitive = q.CaseInsensitive
+		subQ.IgnoreCase = q.IgnoreCase
+		f := expr.Filter()
+		f.IgnoreCase = q.IgnoreCase
+		val, err := subQ.ExtractOnValue(m)
+		if err != nil && f.isNullCheck() {
+			// A missing field is null: discount=null matches it
+			val, err = nil, nil
+		}
+		if err == nil {
+			// We found the field, now compare
+			match := f.matchValue(val)
+
+			if match {
+				// Condition met! Continue with remaining path on the SAME map
+				return q.extractValue(m, remaining, currentPath)
 			}
+			return nil, fmt.Errorf("filter '%s' did not match", part)
 		}
-	found:
-		if operator == "" {
-			return nil, fmt.Errorf("invalid wildcard filter: %s", part)
+	}
+
+	// Simple key access
+	if !strings.HasPrefix(part, "*") && !strings.HasPrefix(part, "%") && !strings.HasPrefix(part, "$") {
+		if val, ok := q.lookupKey(m, part); ok {
+			q.enter(part)
+			defer q.leave()
+			return q.extractValue(val, remaining, append(currentPath, part))
 		}
+		return nil, fmt.Errorf("key '%s' not found", part)
 	}
 
-	results := make(map[string]interface{})
-	for k, v := range m {
-		match := false
-		switch operator {
-		case "*":
-			match = true
-		case "=":
-			match = k == filterValue
-		case "!=":
-			match = k != filterValue
-		case "~=":
-			match = strings.Contains(k, filterValue)
-		case ">":
-			match = k > filterValue
-		case ">=":
-			match = k >= filterValue
-		case "<":
-			match = k < filterValue
-		case "<=":
-			match = k <= filterValue
-		}
-
-		if match {
+	// Wildcard access, optionally filtering keys (*~=temp, *^=temp_, *~/^t\d+$/)
+	matchKey, err := q.keyFilter(part)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ordered objects give ordered results, plain maps plain maps
+	mo, plain := m.(mapObject)
+	if plain && q.tracker != nil {
+		m = sortedObject{mo}
+	}
+	var results parser.OrderedMap
+	m.Range(func(key string, v interface{}) bool {
+		if matchKey(key) {
 			// If we are at a correlated wildcard $, we might want further filtering
 			if part == "$" && q.FilterContext != nil {
 				// Check if this item satisfies the filter context
-				if !q.matchesFilterContext(v, append(currentPath, k)) {
-					continue
+				if !q.matchesFilterContext(v, append(currentPath, key)) {
+					return true
 				}
 			}
 
-			val, err := q.extractValue(v, remaining, append(currentPath, k))
+			q.enter(key)
+			val, err := q.extractValue(v, remaining, append(currentPath, key))
+			q.leave()
 			if err == nil {
-				results[k] = val
+				results = append(results, parser.KeyVal{Key: key, Val: val})
 			}
 		}
-	}
+		return true
+	})
 
 	if len(results) == 0 {
 		return nil, fmt.Errorf("no keys matched wildcard filter '%s'", part)
 	}
+	if plain {
+		return results.ToMap(), nil
+	}
 	return results, nil
 }
 
+// objectRecord returns the record of an object's keys, for evaluating
+// expressions on it
+func objectRecord(m Object) parser.Record {
+	if r, ok := m.(mapObject); ok {
+		return parser.Record(r)
+	}
+	record := make(parser.Record)
+	m.Range(func(key string, val interface{}) bool {
+		record[key] = val
+		return true
+	})
+	return record
+}
+
+// lookupKey returns the value for key, falling back to a case-insensitive
+// match when the query allows it. Exact matches always win; among several
+// case-insensitive matches ("NAME" and "name" for "Name") the lowest key in
+// byte order does, whatever the order of the object's keys.
+func (q *Query) lookupKey(m Object, key string) (interface{}, bool) {
+	if val, ok := m.Get(key); ok {
+		return val, true
+	}
+	var found interface{}
+	var foundKey string
+	ok := false
+	if q.CaseInsensitive {
+		m.Range(func(k string, val interface{}) bool {
+			if strings.EqualFold(k, key) && (!ok || k < foundKey) {
+				found, foundKey, ok = val, k, true
+			}
+			return true
+		})
+	}
+	return found, ok
+}
+
 func (q *Query) extractValue(data interface{}, parts []string, currentPath []string) (interface{}, error) {
 	if len(parts) == 0 {
+		q.found(data)
 		return data, nil
 	}
 
 	part := parts[0]
 	remaining := parts[1:]
 
+	// Terminal functions (.tags.length()) apply to the value itself
+	if len(remaining) == 0 {
+		if fn, ok := pathFunc(part); ok {
+			val, err := fn(data)
+			if err == nil {
+				q.enter(part)
+				q.found(val)
+				q.leave()
+			}
+			return val, err
+		}
+	}
+
 	switch v := data.(type) {
 	case parser.Record:
 		// Handle parser.Record (which is map[string]interface{})
-		return q.extractFromMap(v, part, remaining, currentPath)
+		return q.extractFromMap(mapObject(v), part, remaining, currentPath)
 
 	case map[string]interface{}:
 		// Handle object access
+		return q.extractFromMap(mapObject(v), part, remaining, currentPath)
+
+	case Object:
+		// Ordered objects (e.g. projected rows) are walked without conversion
 		return q.extractFromMap(v, part, remaining, currentPath)
 
 	case []interface{}:
 		// Handle array access
 		// 1. Explicit Wildcards
 		if part == "*" || part == "%" || part == "$" {
-			return q.extractFromSlice(v, remaining, currentPath, part == "$")
+			return q.extractFromSlice(v, 0, remaining, currentPath, part == "$")
 		}
 
-		// 2. Numeric Index
+		// 2. Numeric Index (negative indices count from the end)
 		idx, err := strconv.Atoi(part)
 		if err == nil {
+			if idx < 0 {
+				idx += len(v)
+			}
 			if idx < 0 || idx >= len(v) {
-				return nil, fmt.Errorf("array index %d out of bounds", idx)
+				return nil, fmt.Errorf("array index %s out of bounds", part)
 			}
+			q.enterIndex(idx)
+			defer q.leave()
 			return q.extractValue(v[idx], remaining, append(currentPath, part))
 		}
 
-		// 3. Implicit Wildcard (Array Traversal)
+		// 3. Slice start:end (either bound optional, negative counts from the end)
+		if start, end, ok := parseSlice(part, len(v)); ok {
+			return q.extractFromSlice(v[start:end], start, remaining, currentPath, false)
+		}
+
+		// 4. Position filter *#<3 (negative positions count from the end)
+		if ranges, ok := parsePosition(part, len(v)); ok {
+			results := make([]interface{}, 0)
+			for _, r := range ranges {
+				val, _ := q.extractFromSlice(v[r[0]:r[1]], r[0], remaining, currentPath, false)
+				results = append(results, val.([]interface{})...)
+			}
+			return results, nil
+		}
+
+		// 5. Implicit Wildcard (Array Traversal)
 		// If part is NOT an index, assume we want to map over values
 		// e.g., sensors.type -> sensors.*.type
-		return q.extractFromSlice(v, parts, currentPath, false)
+		return q.extractFromSlice(v, 0, parts, currentPath, false)
 
 	default:
 		return nil, fmt.Errorf("cannot access '%s' on type %T", part, data)
 	}
 }
 
-// extractFromSlice helper to avoid duplication
-func (q *Query) extractFromSlice(v []interface{}, parts []string, currentPath []string, useFilter bool) (interface{}, error) {
+// extractFromSlice helper to avoid duplication. offset is the index of the
+// first element of v in its array (for slices).
+func (q *Query) extractFromSlice(v []interface{}, offset int, parts []string, currentPath []string, useFilter bool) (interface{}, error) {
 	results := make([]interface{}, 0, len(v))
-	for _, item := range v {
+	for i, item := range v {
 		if useFilter && q.FilterContext != nil {
 			// Check if this item satisfies the filter context
 			// We use string index initially, but maybe we need more logic
@@ -269,7 +688,9 @@ func (q *Query) extractFromSlice(v []interface{}, parts []string, currentPath []
 			}
 		}
 
+		q.enterIndex(offset + i)
 		val, err := q.extractValue(item, parts, append(currentPath, "*"))
+		q.leave()
 		if err == nil {
 			results = append(results, val)
 		}
@@ -282,37 +703,31 @@ func (q *Query) matchesFilterContext(val interface{}, pathParts []string) bool {
 	if q.FilterContext == nil {
 		return true
 	}
-
-	fullPath := strings.Join(pathParts, ".")
-
-	// Try to find a condition in the filter context that applies to this path
-	// or a subpath of it.
-	// For now, let's implement a simple version:
-	// If the filter has a condition on a field that STARTS with our path,
-	// we evaluate that condition relative to our value.
-	return matchesPartialFilter(val, q.FilterContext, fullPath)
+	// Evaluate the conditions of the filter context that apply to this path
+	// (or a subpath of it) relative to our value.
+	return matchesPartialFilter(val, q.FilterContext, pathParts)
 }
 
-func matchesPartialFilter(val interface{}, expr Expression, prefix string) bool {
+func matchesPartialFilter(val interface{}, expr Expression, prefix []string) bool {
 	switch e := expr.(type) {
 	case *Condition:
-		// Check if the condition's field matches our prefix
-		field := e.Filter.Field
-		if field == prefix {
+		subPath, ok := correlatedSubPath(e.Filter.Field, prefix)
+		if !ok {
+			return true // Condition doesn't apply to this path
+		}
+		if subPath == "" {
 			// Exact match! Evaluate filter on the current value
 			return e.Filter.matchValue(val)
 		}
-		if strings.HasPrefix(field, prefix+".") {
-			// Filter is on a subfield.
-			subPath := field[len(prefix)+1:]
-			subQ := NewQuery(subPath)
-			subVal, err := subQ.ExtractOnValue(val)
-			if err != nil {
-				return false
-			}
-			return e.Filter.matchValue(subVal)
+		// Filter is on a subfield.
+		subQ := NewQuery(subPath)
+		subQ.CaseInsensitive = e.Filter.CaseInsensitive
+		subQ.IgnoreCase = e.Filter.IgnoreCase
+		subVal, err := subQ.ExtractOnValue(val)
+		if err != nil {
+			return false
 		}
-		return true // Condition doesn't apply to this path
+		return e.Filter.matchValue(subVal)
 	case *AndExpression:
 		return matchesPartialFilter(val, e.Left, prefix) && matchesPartialFilter(val, e.Right, prefix)
 	case *OrExpression:
@@ -320,13 +735,127 @@ func matchesPartialFilter(val interface{}, expr Expression, prefix string) bool
 		// For now, assume if either applies, we check it.
 		// But if it doesn't apply, it should return true (pass-through).
 		return matchesPartialFilter(val, e.Left, prefix) || matchesPartialFilter(val, e.Right, prefix)
+	case *NotExpression:
+		if !appliesToPath(e.Expr, prefix) {
+			return true
+		}
+		return !matchesPartialFilter(val, e.Expr, prefix)
 	}
 	return true
 }
 
+// appliesToPath reports whether any condition of expr applies to the value at prefix
+func appliesToPath(expr Expression, prefix []string) bool {
+	for _, field := range ExpressionFields(expr) {
+		if _, ok := correlatedSubPath(field, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// correlatedSubPath reports whether a filter field applies to the value found at
+// prefix, returning the remaining path to evaluate on it ("" for the value itself).
+// "$" in the field is treated like "*", and array levels may be implicit, so
+// "sensors.type", "sensors.*.type" and "sensors.$.type" all apply to "sensors.*".
+func correlatedSubPath(field string, prefix []string) (string, bool) {
+	parts := parsePath(field)
+	for i, p := range parts {
+		if p == "$" {
+			parts[i] = "*"
+		}
+	}
+	if rest, ok := trimPathPrefix(parts, prefix); ok {
+		return strings.Join(rest, "."), true
+	}
+	// Implicit traversal: compare the paths without their wildcard levels
+	if rest, ok := trimPathPrefix(withoutWildcards(parts), withoutWildcards(prefix)); ok && len(rest) > 0 {
+		return strings.Join(rest, "."), true
+	}
+	return "", false
+}
+
+func trimPathPrefix(parts, prefix []string) ([]string, bool) {
+	if len(parts) < len(prefix) {
+		return nil, false
+	}
+	for i, p := range prefix {
+		if unquoteIdent(parts[i]) != unquoteIdent(p) {
+			return nil, false
+		}
+	}
+	return parts[len(prefix):], true
+}
+
+func withoutWildcards(parts []string) []string {
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "*" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 func (q *Query) ExtractOnValue(val interface{}) (interface{}, error) {
-	parts := parsePath(q.Path)
-	return q.extractValue(val, parts, []string{})
+	p := q.prepared()
+	return p.extractValue(val, p.compiled.parts, []string{})
+}
+
+// Exists reports whether the path resolves in record. A path through
+// arrays (wildcards or implicit traversal) exists only if some element has it.
+func (q *Query) Exists(record interface{}) bool {
+	if IsPipeline(q.Path) {
+		outputs, err := q.ExtractEach(record)
+		return err == nil && len(outputs) > 0
+	}
+	val, err := q.Extract(record)
+	if err != nil {
+		return false
+	}
+	if arr, ok := val.([]interface{}); ok && len(arr) == 0 {
+		// An empty collection exists only if it is the value at the path itself
+		return q.resolvesDirectly(record, q.prepared().compiled.parts)
+	}
+	return true
+}
+
+// resolvesDirectly walks parts through object keys and array indices only
+func (q *Query) resolvesDirectly(data interface{}, parts []string) bool {
+	for _, part := range parts {
+		if r, ok := data.(parser.Record); ok {
+			data = map[string]interface{}(r)
+		}
+		switch v := data.(type) {
+		case map[string]interface{}:
+			val, ok := q.lookupKey(mapObject(v), unquoteIdent(part))
+			if !ok {
+				return false
+			}
+			data = val
+		case Object:
+			val, ok := q.lookupKey(v, unquoteIdent(part))
+			if !ok {
+				return false
+			}
+			data = val
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil {
+				return false
+			}
+			if idx < 0 {
+				idx += len(v)
+			}
+			if idx < 0 || idx >= len(v) {
+				return false
+			}
+			data = v[idx]
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // Filter represents a filtering condition
@@ -334,6 +863,39 @@ type Filter struct {
 	Field    string
 	Operator string
 	Value    interface{}
+	// CaseInsensitive matches Field against keys regardless of case
+	CaseInsensitive bool
+	// IgnoreCase compares string values regardless of case (name~=john matches "John")
+	IgnoreCase bool
+	// Quantifier controls how arrays and objects are matched ("" means QuantifierAny)
+	Quantifier string
+}
+
+// Quantifiers for matching a filter against a collection (array or object) value.
+// Scalars match the same way under ANY and ALL; NONE negates ANY.
+const (
+	// QuantifierAny matches if at least one element matches. This is the default.
+	QuantifierAny = "ANY"
+	// QuantifierAll matches if every element matches (an empty collection matches).
+	QuantifierAll = "ALL"
+	// QuantifierNone matches if no element matches (a missing field matches).
+	QuantifierNone = "NONE"
+)
+
+// Null checks, written "field IS NULL" and "field IS NOT NULL".
+// A missing field is null.
+const (
+	OpIsNull    = "is null"
+	OpIsNotNull = "is not null"
+)
+
+// IsQuantifier reports whether name is ANY, ALL or NONE (case-insensitive)
+func IsQuantifier(name string) bool {
+	switch strings.ToUpper(name) {
+	case QuantifierAny, QuantifierAll, QuantifierNone:
+		return true
+	}
+	return false
 }
 
 // NewFilter creates a new filter
@@ -351,6 +913,9 @@ func NewFilter(field, operator string, value interface{}) *Filter {
 
 // String returns a string representation of the filter
 func (f *Filter) String() string {
+	if f.isNullCheck() {
+		return fmt.Sprintf("%s %s", f.Field, strings.ToUpper(f.Operator))
+	}
 	valStr := fmt.Sprintf("%v", f.Value)
 	if _, ok := f.Value.(string); ok {
 		valStr = "'" + valStr + "'"
@@ -359,117 +924,152 @@ func (f *Filter) String() string {
 	if op == "contains" {
 		op = "~="
 	}
-	return fmt.Sprintf("%s %s %s", f.Field, op, valStr)
+	field := f.Field
+	if f.Quantifier != "" {
+		field = f.Quantifier + "(" + field + ")"
+	}
+	return fmt.Sprintf("%s %s %s", field, op, valStr)
 }
 
 // Match checks if a record matches the filter
 func (f *Filter) Match(record parser.Record) bool {
+	return f.Test(record) == True
+}
+
+// Test evaluates the filter on a record with three-valued logic: comparing a
+// missing or null field is Unknown rather than False, so that NOT does not
+// turn it into a match
+func (f *Filter) Test(record parser.Record) Truth {
 	q := NewQuery(f.Field)
+	q.CaseInsensitive = f.CaseInsensitive
+	q.IgnoreCase = f.IgnoreCase
 	value, err := q.Extract(record)
 	if err != nil {
-		return false
+		if f.Quantifier == QuantifierNone && !f.isNullCheck() {
+			return True
+		}
+		value = nil // missing fields behave like null
 	}
 
-	return f.matchValue(value)
+	return f.testValue(value)
 }
 
 func (f *Filter) matchValue(value interface{}) bool {
-	// Handle collections - if ANY element matches, the filter matches
+	return f.testValue(value) == True
+}
+
+func (f *Filter) testValue(value interface{}) Truth {
+	if f.isNullCheck() {
+		// IS [NOT] NULL looks at the value itself, not at its elements
+		return f.testScalar(value)
+	}
+	switch f.Quantifier {
+	case QuantifierAll:
+		return testAll(value, f.testScalar)
+	case QuantifierNone:
+		return testAny(value, f.testScalar).Not()
+	default:
+		return testAny(value, f.testScalar)
+	}
+}
+
+// testAny matches collections if ANY element matches (the default semantics)
+func testAny(value interface{}, scalar func(interface{}) Truth) Truth {
+	result := False
 	switch v := value.(type) {
 	case map[string]interface{}:
 		for _, val := range v {
-			if f.matchValue(val) {
-				return true
+			if result = result.Or(testAny(val, scalar)); result == True {
+				break
 			}
 		}
-		return false
 	case []interface{}:
 		for _, val := range v {
-			if f.matchValue(val) {
-				return true
+			if result = result.Or(testAny(val, scalar)); result == True {
+				break
 			}
 		}
-		return false
-	}
-
-	switch f.Operator {
-	case "=", "==":
-		return compareEqual(value, f.Value)
-	case "!=":
-		return !compareEqual(value, f.Value)
-	case ">":
-		return compareGreater(value, f.Value)
-	case ">=":
-		return compareGreaterEqual(value, f.Value)
-	case "<":
-		return compareLess(value, f.Value)
-	case "<=":
-		return compareLessEqual(value, f.Value)
-	case "contains":
-		return containsValue(value, f.Value)
+	case Object:
+		v.Range(func(_ string, val interface{}) bool {
+			result = result.Or(testAny(val, scalar))
+			return result != True
+		})
 	default:
-		return false
+		return scalar(value)
 	}
+	return result
 }
 
-func compareEqual(a, b interface{}) bool {
-	// Try direct comparison for common types
-	switch av := a.(type) {
-	case string:
-		if bv, ok := b.(string); ok {
-			return av == bv
-		}
-	case float64:
-		if bv, ok := b.(float64); ok {
-			return av == bv
-		}
-	case bool:
-		if bv, ok := b.(bool); ok {
-			return av == bv
+// testAll matches collections if ALL elements match (vacuously true when empty)
+func testAll(value interface{}, scalar func(interface{}) Truth) Truth {
+	result := True
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, val := range v {
+			if result = result.And(testAll(val, scalar)); result == False {
+				break
+			}
 		}
-	case int:
-		if bv, ok := b.(int); ok {
-			return av == bv
+	case []interface{}:
+		for _, val := range v {
+			if result = result.And(testAll(val, scalar)); result == False {
+				break
+			}
 		}
+	case Object:
+		v.Range(func(_ string, val interface{}) bool {
+			result = result.And(testAll(val, scalar))
+			return result != False
+		})
+	default:
+		return scalar(value)
 	}
-	// Fallback to string comparison for other types
-	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+	return result
 }
 
-func compareGreater(a, b interface{}) bool {
-	af, aok := toFloat64(a)
-	bf, bok := toFloat64(b)
-	if aok && bok {
-		return af > bf
+func (f *Filter) testScalar(value interface{}) Truth {
+	switch f.Operator {
+	case OpIsNull:
+		return truthOf(value == nil)
+	case OpIsNotNull:
+		return truthOf(value != nil)
 	}
-	return false
+	if value == nil || f.Value == nil {
+		return Unknown
+	}
+	target := f.Value
+	if f.IgnoreCase {
+		value, target = foldCase(value), foldCase(target)
+	}
+	return compareScalar(f.Operator, value, target)
 }
 
-func compareGreaterEqual(a, b interface{}) bool {
-	af, aok := toFloat64(a)
-	bf, bok := toFloat64(b)
-	if aok && bok {
-		return af >= bf
+// compareScalar applies a comparison operator to two non-null values
+func compareScalar(op string, value, target interface{}) Truth {
+	switch op {
+	case "=", "==":
+		return testEqual(value, target)
+	case "!=":
+		return testEqual(value, target).Not()
+	case ">", ">=", "<", "<=":
+		return testOrder(value, target, op)
+	case "contains":
+		return truthOf(containsValue(value, target))
+	default:
+		return False
 	}
-	return false
 }
 
-func compareLess(a, b interface{}) bool {
-	af, aok := toFloat64(a)
-	bf, bok := toFloat64(b)
-	if aok && bok {
-		return af < bf
+// foldCase lower-cases strings for case-insensitive comparisons
+func foldCase(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return strings.ToLower(s)
 	}
-	return false
+	return v
 }
 
-func compareLessEqual(a, b interface{}) bool {
-	af, aok := toFloat64(a)
-	bf, bok := toFloat64(b)
-	if aok && bok {
-		return af <= bf
-	}
-	return false
+func (f *Filter) isNullCheck() bool {
+	return f.Operator == OpIsNull || f.Operator == OpIsNotNull
 }
 
 func containsValue(a, b interface{}) bool {
@@ -488,29 +1088,77 @@ func containsValue(a, b interface{}) bool {
 	return strings.Contains(aStr, bStr)
 }
 
-func toFloat64(v interface{}) (float64, bool) {
-	switch val := v.(type) {
-	case float64:
-		return val, true
-	case float32:
-		return float64(val), true
-	case int:
-		return float64(val), true
-	case int64:
-		return float64(val), true
-	case int32:
-		return float64(val), true
-	default:
-		f, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
-		return f, err == nil
-	}
-}
-
 // FilterExpr represents a parsed filter expression
 type FilterExpr struct {
 	Field    string
 	Operator string
 	Value    string
+	// Quantifier is set when the field is written ANY(path), ALL(path) or NONE(path)
+	Quantifier string
+	// Quoted is set when Value was written in quotes and is always a string
+	Quoted bool
+}
+
+// Literal returns the typed value of the expression, as the SQL grammar
+// reads it: quoted values are strings, true/false booleans, numbers float64
+// and null is nil. Other unquoted values are strings.
+func (e *FilterExpr) Literal() interface{} {
+	if e.Quoted {
+		return e.Value
+	}
+	return ParseLiteral(e.Value)
+}
+
+// Filter builds the filter of the expression with its typed value.
+// "= null" and "!= null" test for nulls like IS NULL and IS NOT NULL.
+func (e *FilterExpr) Filter() *Filter {
+	op := e.Operator
+	val := e.Literal()
+	if val == nil {
+		switch op {
+		case "=":
+			op = OpIsNull
+		case "!=":
+			op = OpIsNotNull
+		}
+	}
+	f := NewFilter(e.Field, op, val)
+	f.Quantifier = e.Quantifier
+	return f
+}
+
+// ParseLiteral converts unquoted value text to a boolean (true/false), nil
+// (null), a float64 (JSON numbers) or, failing that, keeps it as a string.
+// Keywords are case-insensitive.
+func ParseLiteral(s string) interface{} {
+	switch strings.ToLower(s) {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	var n float64
+	if err := json.Unmarshal([]byte(s), &n); err == nil {
+		return n
+	}
+	return s
+}
+
+// SplitQuantifier splits a field written ANY(path), ALL(path) or NONE(path)
+// into its upper-cased quantifier and path. Other fields are returned as they are.
+func SplitQuantifier(field string) (quantifier, path string) {
+	field = strings.TrimSpace(field)
+	open := strings.Index(field, "(")
+	if open <= 0 || !strings.HasSuffix(field, ")") {
+		return "", field
+	}
+	name := strings.TrimSpace(field[:open])
+	if !IsQuantifier(name) {
+		return "", field
+	}
+	return strings.ToUpper(name), strings.TrimSpace(field[open+1 : len(field)-1])
 }
 
 // IsFilterExpression checks if a string looks like a filter expression (contains an operator)
@@ -523,16 +1171,57 @@ func IsFilterExpression(expr string) bool {
 	if strings.HasPrefix(expr, "*") || strings.HasPrefix(expr, "%") || strings.HasPrefix(expr, "$") {
 		return false
 	}
+	unquoted := stripWildcardSegments(stripQuotedIdents(expr))
 	operators := []string{">=", "<=", "!=", "~=", ">", "<", "="}
 	for _, op := range operators {
-		if strings.Contains(expr, op) {
+		if strings.Contains(unquoted, op) {
 			return true
 		}
 	}
 	return false
 }
 
+// isWildcard reports whether a path segment is a wildcard, with or without
+// a key filter (*, $, *~=temp)
+func isWildcard(part string) bool {
+	return part != "" && strings.ContainsRune("*%$", rune(part[0]))
+}
+
+// stripWildcardSegments drops the path segments starting with a wildcard
+// (metrics.*~=temp, items.*#<3), whose operators filter keys or positions
+func stripWildcardSegments(s string) string {
+	parts := strings.Split(regexKeyFilter.ReplaceAllString(s, "*"), ".")
+	kept := parts[:0]
+	for i, part := range parts {
+		if i > 0 && part != "" && strings.ContainsRune("*%$", rune(part[0])) {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, ".")
+}
+
+// stripQuotedIdents removes backtick-quoted sections so their content is not mistaken for operators
+func stripQuotedIdents(s string) string {
+	if !strings.Contains(s, "`") {
+		return s
+	}
+	var sb strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '`' {
+			inQuote = !inQuote
+			continue
+		}
+		if !inQuote {
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
 // ParseFilterExpression parses expressions like "age>28", "name=john", "status!=active"
+// or "ALL(scores)>50"
 func ParseFilterExpression(expr string) *FilterExpr {
 	// Try to find operator in the expression
 	operators := []string{">=", "<=", "!=", "~=", ">", "<", "="}
@@ -544,10 +1233,12 @@ func ParseFilterExpression(expr string) *FilterExpr {
 
 			if field != "" && value != "" {
 				// Strip quotes if present
+				quoted := false
 				if len(value) >= 2 {
 					if (strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) ||
 						(strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")) {
 						value = value[1 : len(value)-1]
+						quoted = true
 					}
 				}
 
@@ -556,10 +1247,13 @@ func ParseFilterExpression(expr string) *FilterExpr {
 				if op == "~=" {
 					internalOp = "contains"
 				}
+				quantifier, path := SplitQuantifier(field)
 				return &FilterExpr{
-					Field:    field,
-					Operator: internalOp,
-					Value:    value,
+					Field:      path,
+					Operator:   internalOp,
+					Value:      value,
+					Quantifier: quantifier,
+					Quoted:     quoted,
 				}
 			}
 		}
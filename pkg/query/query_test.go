@@ -1,6 +1,10 @@
 package query
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/bisegni/jsl/pkg/parser"
@@ -161,6 +165,59 @@ func TestFilterMatch(t *testing.T) {
 	}
 }
 
+func TestWildcardKeyOperators(t *testing.T) {
+	record := parser.Record{
+		"metrics": map[string]interface{}{
+			"temp_1":  float64(20),
+			"temp_22": float64(22),
+			"temp_x":  float64(30),
+			"Temp_3":  map[string]interface{}{"value": float64(25)},
+		},
+		"shards": map[string]interface{}{"9": "a", "10": "b", "100": "c", "name": "d"},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{`metrics.*~/^temp_\d+$/`, "map[temp_1:20 temp_22:22]", false},
+		{`metrics.*~/^t.mp_\d{2}$/`, "map[temp_22:22]", false},
+		{`metrics.*~/^Temp/.value`, "map[Temp_3:25]", false},
+		{"metrics.*^=temp_", "map[temp_1:20 temp_22:22 temp_x:30]", false},
+		{"metrics.*$=_1", "map[temp_1:20]", false},
+		{"metrics.%$=_x", "map[temp_x:30]", false},
+		{"shards.*>=10", "map[10:b 100:c]", false},
+		{"shards.*<10", "map[9:a]", false},
+		{"shards.*=010", "map[10:b]", false},
+		{"shards.*!=10", "map[100:c 9:a name:d]", false},
+		{"shards.*>=name", "map[name:d]", false},
+		{`metrics.*~/[/`, "", true},
+		{`metrics.*~/^temp`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if IsFilterExpression(tt.path) {
+				t.Errorf("IsFilterExpression(%s) = true, want a path", tt.path)
+			}
+			got, err := NewQuery(tt.path).Extract(record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(got) != tt.expected {
+				t.Errorf("Extract() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	q := NewQuery(`metrics.*~/^temp_\d+$/`)
+	q.IgnoreCase = true
+	if got, _ := q.Extract(record); fmt.Sprint(got) != "map[Temp_3:map[value:25] temp_1:20 temp_22:22]" {
+		t.Errorf("Extract() with IgnoreCase = %v", got)
+	}
+}
+
 func TestWildcardExtract(t *testing.T) {
 	record := parser.Record{
 		"employees": []interface{}{
@@ -307,3 +364,752 @@ func TestWildcardKeyExtract(t *testing.T) {
 		})
 	}
 }
+
+func TestQuotedIdentifiers(t *testing.T) {
+	record := parser.Record{
+		"user-id": float64(7),
+		"a.b":     map[string]interface{}{"c": float64(2)},
+		"x=y":     "literal",
+	}
+
+	paths := map[string]interface{}{
+		"`user-id`": float64(7),
+		"`a.b`.c":   float64(2),
+		"`x=y`":     "literal",
+	}
+	for path, expected := range paths {
+		val, err := NewQuery(path).Extract(record)
+		if err != nil {
+			t.Errorf("Extract(%s) failed: %v", path, err)
+			continue
+		}
+		if val != expected {
+			t.Errorf("Extract(%s) = %v, want %v", path, val, expected)
+		}
+	}
+
+	q, err := ParseQuery("SELECT `user-id`, `a.b`.c AS `the c` WHERE `a.b`.c = 2")
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+	if q.Fields[0].Path != "`user-id`" || q.Fields[0].Alias != "user-id" {
+		t.Errorf("Unexpected first field: %+v", q.Fields[0])
+	}
+	if q.Fields[1].Alias != "the c" {
+		t.Errorf("Expected alias 'the c', got %q", q.Fields[1].Alias)
+	}
+	if !q.Filter.Evaluate(record) {
+		t.Errorf("Expected filter %s to match", q.Filter)
+	}
+
+	// Keys holding dots keep their quotes in column names, unlike nested paths
+	q, err = ParseQuery("SELECT a.`b.c`, a.b.c, `x.y`, `a-b`.c")
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+	var aliases []string
+	for _, f := range q.Fields {
+		aliases = append(aliases, f.Alias)
+	}
+	if expected := []string{"a.`b.c`", "a.b.c", "`x.y`", "a-b.c"}; !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected columns %q, got %q", expected, aliases)
+	}
+	if got := DisplayPath(`.a\.b.c`); got != ".`a.b`.c" {
+		t.Errorf("DisplayPath() = %s, want .`a.b`.c", got)
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT name INTO 'out.jsonl' WHERE age > 1",
+		"SELECT name WHERE age > 1 INTO 'out.jsonl'",
+		"select name into \"out.jsonl\"",
+	} {
+		q, err := ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) failed: %v", sql, err)
+		}
+		if q.Into != "out.jsonl" {
+			t.Errorf("ParseQuery(%q).Into = %q, want out.jsonl", sql, q.Into)
+		}
+	}
+
+	if _, err := ParseQuery("SELECT a INTO 'x.jsonl' INTO 'y.jsonl'"); err == nil || !strings.Contains(err.Error(), "INTO given twice") {
+		t.Errorf("Expected an error for two INTO targets, got %v", err)
+	}
+}
+
+// orderedObject is a minimal ordered Object implementation for tests
+type orderedObject struct {
+	keys []string
+	vals []interface{}
+}
+
+func (o orderedObject) Get(key string) (interface{}, bool) {
+	for i, k := range o.keys {
+		if k == key {
+			return o.vals[i], true
+		}
+	}
+	return nil, false
+}
+
+func (o orderedObject) Range(fn func(key string, val interface{}) bool) {
+	for i, k := range o.keys {
+		if !fn(k, o.vals[i]) {
+			return
+		}
+	}
+}
+
+func TestExtractObject(t *testing.T) {
+	inner := orderedObject{keys: []string{"city", "zip"}, vals: []interface{}{"Rome", "00100"}}
+	obj := orderedObject{
+		keys: []string{"name", "address", "tags"},
+		vals: []interface{}{"Alice", inner, []interface{}{"a", "b"}},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"name", "Alice"},
+		{"address.city", "Rome"},
+		{"tags.1", "b"},
+		{"address.*", `{"city":"Rome","zip":"00100"}`},
+	}
+	for _, tt := range tests {
+		val, err := NewQuery(tt.path).ExtractOnValue(obj)
+		if err != nil {
+			t.Errorf("ExtractOnValue(%s) failed: %v", tt.path, err)
+			continue
+		}
+		if got := fmt.Sprintf("%v", val); got != tt.expected {
+			t.Errorf("ExtractOnValue(%s) = %s, want %s", tt.path, got, tt.expected)
+		}
+	}
+
+	if val, err := NewQuery("city=Rome.zip").ExtractOnValue(inner); err != nil || val != "00100" {
+		t.Errorf("Expected filter segment to match inside an Object, got %v, %v", val, err)
+	}
+	if !NewFilter("address", "=", "Rome").matchValue(inner) {
+		t.Error("Expected filter to match a value inside an Object")
+	}
+}
+
+func TestExtractNonObjectRoot(t *testing.T) {
+	root := []interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "b"},
+	}
+
+	tests := []struct {
+		path     string
+		record   interface{}
+		expected string
+		wantErr  bool
+	}{
+		{".0.name", root, "a", false},
+		{"[-1].name", root, "b", false},
+		{".*.name", root, "[a b]", false},
+		{".name", root, "[a b]", false},
+		{".[].name", root, "[a b]", false},
+		{".", float64(7), "7", false},
+		{".length()", "abc", "3", false},
+		{".name", "abc", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).Extract(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(got) != tt.expected {
+				t.Errorf("Extract() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	if !NewQuery(".1").Exists(root) || NewQuery(".2").Exists(root) {
+		t.Error("Exists() on an array root should check the index")
+	}
+}
+
+func TestArraySlicing(t *testing.T) {
+	record := parser.Record{
+		"items": []interface{}{
+			map[string]interface{}{"id": float64(0)},
+			map[string]interface{}{"id": float64(1)},
+			map[string]interface{}{"id": float64(2)},
+			map[string]interface{}{"id": float64(3)},
+			map[string]interface{}{"id": float64(4)},
+		},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{"items.-1.id", "4", false},
+		{"items[-2].id", "3", false},
+		{"items.1:4.id", "[1 2 3]", false},
+		{"items[1:4].id", "[1 2 3]", false},
+		{"items[:2].id", "[0 1]", false},
+		{"items[-2:].id", "[3 4]", false},
+		{"items[3:1].id", "[]", false},
+		{"items[2:100].id", "[2 3 4]", false},
+		{"items[0][0]", "", true},
+		{"items.-6", "", true},
+		{"items.*#<3.id", "[0 1 2]", false},
+		{"items.*#<=1.id", "[0 1]", false},
+		{"items.*#>=-2.id", "[3 4]", false},
+		{"items.*#>3.id", "[4]", false},
+		{"items.*#=2.id", "[2]", false},
+		{"items.*#!=0.id", "[1 2 3 4]", false},
+		{"items.*#=9.id", "[]", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).Extract(record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(got) != tt.expected {
+				t.Errorf("Extract() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	q, err := ParseQuery("SELECT items[-1].id AS last, items[1:3] WHERE items[0].id = 0")
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+	if q.Fields[0].Path != "items[-1].id" || q.Fields[1].Path != "items[1:3]" {
+		t.Errorf("Unexpected fields: %+v", q.Fields)
+	}
+	if !q.Filter.Evaluate(record) {
+		t.Errorf("Expected %s to match", q.Filter)
+	}
+	if _, err := ParseQuery("SELECT items[]"); err == nil {
+		t.Error("Expected error for empty subscript")
+	}
+}
+
+func TestBracketKeys(t *testing.T) {
+	record := parser.Record{
+		"key.with.dots": map[string]interface{}{"value": float64(1)},
+		"meta": map[string]interface{}{
+			"a.b":   "dotted",
+			"*":     "star",
+			"x=y":   "op",
+			`q"uo`:  "quote",
+			"other": "plain",
+		},
+		"list": []interface{}{map[string]interface{}{"k.1": "first"}},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{`.["key.with.dots"].value`, "1"},
+		{`["key.with.dots"].value`, "1"},
+		{`meta["a.b"]`, "dotted"},
+		{`meta.['a.b']`, "dotted"},
+		{`meta["*"]`, "star"},
+		{`meta["x=y"]`, "op"},
+		{`meta["q\"uo"]`, "quote"},
+		{`list[0]["k.1"]`, "first"},
+		{`meta.\*`, "star"},
+		{`.meta.a\.b`, "dotted"},
+		{`meta.x\=y`, "op"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).Extract(record)
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("Extract() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	q, err := ParseQuery(`SELECT meta["a.b"], ["key.with.dots"].value AS v WHERE meta.["*"] = 'star'`)
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+	if q.Fields[0].Path != `meta["a.b"]` || q.Fields[0].Alias != "meta.`a.b`" {
+		t.Errorf("Unexpected field: %+v", q.Fields[0])
+	}
+	if !q.Filter.Evaluate(record) {
+		t.Errorf("Expected %s to match", q.Filter)
+	}
+
+	if _, err := NewQuery(`meta.\%`).Extract(record); err == nil {
+		t.Errorf("Expected meta.\\%% to address a missing key, not a wildcard")
+	}
+	if got := DisplayPath(`meta.\*`); got != "meta.*" {
+		t.Errorf("DisplayPath() = %s, want meta.*", got)
+	}
+}
+
+func TestSplitPaths(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{".name", []string{".name"}},
+		{".name, .address.city", []string{".name", ".address.city"}},
+		{".name,.tags[0:2],.age", []string{".name", ".tags[0:2]", ".age"}},
+		{".`a,b`, .c[\"x,y\"]", []string{".`a,b`", ".c[\"x,y\"]"}},
+		{".c['x\\',y'], .d", []string{".c['x\\',y']", ".d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got := SplitPaths(tt.expr)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) || len(got) != len(tt.expected) {
+				t.Errorf("SplitPaths() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExists(t *testing.T) {
+	record := parser.Record{
+		"a":     map[string]interface{}{"b": nil},
+		"empty": []interface{}{},
+		"items": []interface{}{map[string]interface{}{"x": float64(1)}},
+	}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"a", true},
+		{"a.b", true}, // null values exist
+		{"a.c", false},
+		{"empty", true},
+		{"items.*.x", true},
+		{"items.x", true},
+		{"items.*.y", false},
+		{"items.y", false},
+		{"items[0].x", true},
+		{"items[-1]", true},
+		{"items[3]", false},
+		{"nope.x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := NewQuery(tt.path).Exists(record); got != tt.expected {
+				t.Errorf("Exists() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	record := parser.Record{
+		"items": []interface{}{
+			map[string]interface{}{"price": float64(10), "tags": []interface{}{"a", "b"}},
+			map[string]interface{}{"price": float64(20)},
+			map[string]interface{}{"name": "no price"},
+		},
+		"meta": map[string]interface{}{"b": "second", "a": "first"},
+	}
+
+	tests := []struct {
+		path     string
+		expected []interface{}
+	}{
+		{".items | .[] | .price", []interface{}{float64(10), float64(20)}},
+		{".items | .[].price", []interface{}{float64(10), float64(20)}},
+		{".items[].price", []interface{}{float64(10), float64(20)}},
+		{".items[] | .tags[]", []interface{}{"a", "b"}},
+		{".items | .[0] | .price", []interface{}{float64(10)}},
+		{".meta | .[]", []interface{}{"first", "second"}},
+		{". | .meta | .a", []interface{}{"first"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).ExtractEach(record)
+			if err != nil {
+				t.Fatalf("ExtractEach() error = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("ExtractEach() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	if got, err := NewQuery(".items | .[0] | .price").Extract(record); err != nil || got != float64(10) {
+		t.Errorf("Extract() = %v, %v, want the single output", got, err)
+	}
+	if got, err := NewQuery(".items[].price").Extract(record); err != nil || fmt.Sprint(got) != "[10 20]" {
+		t.Errorf("Extract() = %v, %v, want the list of outputs", got, err)
+	}
+	if _, err := NewQuery(".meta | .a | .[]").ExtractEach(record); err == nil {
+		t.Error("Expected an error iterating over a string")
+	}
+	if got := SplitPipes(".a | .`x|y` | .b[\"p|q\"]"); len(got) != 3 {
+		t.Errorf("SplitPipes() = %q, want 3 stages", got)
+	}
+}
+
+func TestPathFunctions(t *testing.T) {
+	record := parser.Record{
+		"tags":     []interface{}{"a", "b"},
+		"metadata": map[string]interface{}{"z": float64(1), "a": "x"},
+		"price":    float64(9.5),
+		"name":     "héllo",
+		"items": []interface{}{
+			map[string]interface{}{"t": []interface{}{1}},
+			map[string]interface{}{"t": []interface{}{1, 2}},
+		},
+		"none": nil,
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{".tags.length()", "2", false},
+		{".name.length()", "5", false},
+		{".metadata.length()", "2", false},
+		{".none.length()", "0", false},
+		{".price.length()", "", true},
+		{".metadata.keys()", "[a z]", false},
+		{".tags.keys()", "[0 1]", false},
+		{".metadata.values()", "[x 1]", false},
+		{".price.type()", "number", false},
+		{".metadata.type()", "object", false},
+		{".none.type()", "null", false},
+		{".items.*.t.length()", "[1 2]", false},
+		{".length()", "6", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).Extract(record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(got) != tt.expected {
+				t.Errorf("Extract() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPathBooleanFilters(t *testing.T) {
+	record := parser.Record{
+		"sensors": []interface{}{
+			map[string]interface{}{"name": "t1", "type": "temp", "value": float64(25), "meta": map[string]interface{}{"loc": "in"}},
+			map[string]interface{}{"name": "t2", "type": "temp", "value": float64(10), "meta": map[string]interface{}{"loc": "out"}},
+			map[string]interface{}{"name": "h1", "type": "hum", "value": float64(50), "meta": map[string]interface{}{"loc": "in"}},
+		},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"sensors.*.(type=temp AND value>20).name", "[t1]"},
+		{"sensors.*.(type=hum OR value<15).name", "[t2 h1]"},
+		{"sensors.(meta.loc=in AND NOT type=hum).name", "[t1]"},
+		{"sensors.*.(type='temp' AND (value>20 OR value<15)).name", "[t1 t2]"},
+		{"sensors.*.(type=none OR value>100).name", "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).Extract(record)
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("Extract() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	items := parser.Record{
+		"items": []interface{}{
+			map[string]interface{}{"n": "a", "discount": nil},
+			map[string]interface{}{"n": "b", "discount": float64(5)},
+			map[string]interface{}{"n": "c"},
+		},
+	}
+	for path, expected := range map[string]string{
+		"items.*.discount=null.n":      "[a c]",
+		"items.*.discount!=null.n":     "[b]",
+		"items.*.(discount=null).n":    "[a c]",
+		"items.*.discount=NULL":        "[map[discount:<nil> n:a] map[n:c]]",
+		"items.*.(discount!=null).n":   "[b]",
+		"items.*.(discount IS NULL).n": "[a c]",
+	} {
+		got, err := NewQuery(path).Extract(items)
+		if err != nil || fmt.Sprint(got) != expected {
+			t.Errorf("Extract(%s) = %v, %v, want %s", path, got, err, expected)
+		}
+	}
+
+	if parts := parsePath("a.(b.c=1 AND d>2).e"); len(parts) != 3 || parts[1] != "(b.c=1 AND d>2)" {
+		t.Errorf("parsePath() = %q", parts)
+	}
+}
+
+func TestIgnoreCase(t *testing.T) {
+	record := parser.Record{
+		"name": "John Smith",
+		"tags": []interface{}{"Admin", "Dev"},
+		"sensors": []interface{}{
+			map[string]interface{}{"name": "t1", "type": "Temp"},
+			map[string]interface{}{"name": "h1", "type": "hum"},
+		},
+		"labels": map[string]interface{}{"Env": "prod", "region": "eu"},
+	}
+
+	for _, tt := range []struct {
+		field    string
+		operator string
+		value    interface{}
+		expected bool
+	}{
+		{"name", "~=", "john", true},
+		{"name", "=", "JOHN SMITH", true},
+		{"name", "!=", "john smith", false},
+		{"tags", "contains", "admin", true},
+	} {
+		f := NewFilter(tt.field, tt.operator, tt.value)
+		if f.Match(record) != !tt.expected {
+			t.Errorf("%s %s %v: Match() = %v without IgnoreCase", tt.field, tt.operator, tt.value, !tt.expected)
+		}
+		f.IgnoreCase = true
+		if got := f.Match(record); got != tt.expected {
+			t.Errorf("%s %s %v: Match() = %v, want %v", tt.field, tt.operator, tt.value, got, tt.expected)
+		}
+	}
+
+	expr, err := ParseFilter("name~='JOHN' AND tags contains 'dev'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetIgnoreCase(expr, true)
+	if !expr.Evaluate(record) {
+		t.Errorf("Evaluate(%s) = false with IgnoreCase", expr)
+	}
+
+	for path, expected := range map[string]string{
+		"sensors.*.type=temp.name":   "[t1]",
+		"sensors.*.(type=TEMP).name": "[t1]",
+		"labels.*~=env":              "map[Env:prod]",
+	} {
+		q := NewQuery(path)
+		q.IgnoreCase = true
+		got, err := q.Extract(record)
+		if err != nil || fmt.Sprint(got) != expected {
+			t.Errorf("Extract(%s) = %v, %v, want %s", path, got, err, expected)
+		}
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	record := parser.Record{
+		"NAME":  "upper",
+		"name":  "lower",
+		"nAmE":  "mixed",
+		"other": map[string]interface{}{"Id": 1, "ID": 2, "id": 3},
+	}
+	for path, expected := range map[string]interface{}{
+		"name":     "lower", // exact match
+		"Name":     "upper", // "NAME" < "nAmE" < "name"
+		"NAMe":     "upper",
+		"other.iD": 2, // "ID" < "Id" < "id"
+		"OTHER.id": 3,
+	} {
+		q := NewQuery(path)
+		q.CaseInsensitive = true
+		// Map iteration order varies from run to run, the match must not
+		for i := 0; i < 50; i++ {
+			got, err := q.Extract(record)
+			if err != nil || got != expected {
+				t.Fatalf("Extract(%s) = %v, %v, want %v", path, got, err, expected)
+			}
+		}
+	}
+}
+
+func TestExtractWithPaths(t *testing.T) {
+	record := parser.Record{
+		"metrics": map[string]interface{}{"cpu_temp": float64(60), "gpu_temp": float64(70), "fan": float64(1200)},
+		"sensors": []interface{}{
+			map[string]interface{}{"name": "t1", "type": "temp"},
+			map[string]interface{}{"name": "h1", "type": "hum"},
+			map[string]interface{}{"name": "t2", "type": "temp"},
+		},
+		"meta": map[string]interface{}{"a.b": "dotted"},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"metrics.*~=temp", "[{metrics.cpu_temp 60} {metrics.gpu_temp 70}]"},
+		{"metrics.fan", "[{metrics.fan 1200}]"},
+		{"sensors.*.name", "[{sensors[0].name t1} {sensors[1].name h1} {sensors[2].name t2}]"},
+		{"sensors.*.type=temp.name", "[{sensors[0].name t1} {sensors[2].name t2}]"},
+		{"sensors[-1].name", "[{sensors[2].name t2}]"},
+		{"sensors[1:].name", "[{sensors[1].name h1} {sensors[2].name t2}]"},
+		{"meta.*", `[{meta["a.b"] dotted}]`},
+		{"sensors.length()", "[{sensors.length() 3}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := NewQuery(tt.path).ExtractWithPaths(record)
+			if err != nil {
+				t.Fatalf("ExtractWithPaths() error = %v", err)
+			}
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("ExtractWithPaths() = %v, want %s", got, tt.expected)
+			}
+			// Every matched path resolves to its value
+			for _, m := range got {
+				if m.Path == "sensors.length()" {
+					continue
+				}
+				val, err := NewQuery(m.Path).Extract(record)
+				if err != nil || fmt.Sprint(val) != fmt.Sprint(m.Value) {
+					t.Errorf("Extract(%s) = %v, %v, want %v", m.Path, val, err, m.Value)
+				}
+			}
+		})
+	}
+
+	if _, err := NewQuery("missing.*").ExtractWithPaths(record); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+}
+
+func TestCompiledPaths(t *testing.T) {
+	record := parser.Record{
+		"sensors": []interface{}{
+			map[string]interface{}{"name": "t1", "type": "temp", "value": float64(25)},
+			map[string]interface{}{"name": "h1", "type": "Hum", "value": float64(50)},
+		},
+	}
+
+	if Compile("sensors.*.name") != Compile("sensors.*.name") {
+		t.Error("Expected the compiled path to be cached")
+	}
+	c := Compile("sensors.*.(type=temp AND value>20).name")
+	if got := fmt.Sprint(c.Parts()); got != "[sensors * (type=temp AND value>20) name]" {
+		t.Errorf("Parts() = %s", got)
+	}
+	if len(c.exprs) != 1 {
+		t.Errorf("Expected the boolean segment to be parsed at compile time, got %v", c.exprs)
+	}
+	if len(Compile("sensors.*.type=temp.name").filters) != 1 {
+		t.Error("Expected the filter segment to be parsed at compile time")
+	}
+
+	// A query whose path changes after NewQuery uses the new path
+	q := NewQuery("sensors.*.name")
+	q.Path = "sensors.*.value"
+	if got, err := q.Extract(record); err != nil || fmt.Sprint(got) != "[25 50]" {
+		t.Errorf("Extract() = %v, %v, want [25 50]", got, err)
+	}
+
+	// Case options are applied to a private copy of compiled expressions
+	q = NewQuery("sensors.*.(type=hum).name")
+	q.IgnoreCase = true
+	if got, err := q.Extract(record); err != nil || fmt.Sprint(got) != "[h1]" {
+		t.Errorf("Extract() with IgnoreCase = %v, %v, want [h1]", got, err)
+	}
+	if got, _ := NewQuery("sensors.*.(type=hum).name").Extract(record); fmt.Sprint(got) != "[]" {
+		t.Errorf("Extract() = %v, want [] once IgnoreCase is off", got)
+	}
+
+	// The same compiled query serves concurrent extractions
+	q = NewQuery("sensors.*.(value>20).name")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got, err := q.Extract(record); err != nil || fmt.Sprint(got) != "[t1 h1]" {
+					t.Errorf("Extract() = %v, %v", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	cache := newLRUCache(2)
+	a := cache.get("a")
+	cache.get("b")
+	cache.get("a") // b is now the least recently used
+	cache.get("c")
+	if cache.len() != 2 || cache.get("a") != a {
+		t.Errorf("Expected a to stay cached, len = %d", cache.len())
+	}
+	if _, ok := cache.items["b"]; ok {
+		t.Error("Expected b to be evicted")
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	keys, err := ParseOrderBy("category asc, .price DESC nulls first, name NULLS LAST, id nulls first")
+	if err != nil {
+		t.Fatalf("ParseOrderBy failed: %v", err)
+	}
+	if got := fmt.Sprint(keys); got != "[category ASC price DESC NULLS FIRST name ASC id ASC NULLS FIRST]" {
+		t.Errorf("ParseOrderBy() = %s", got)
+	}
+
+	for _, spec := range []string{"", "price up", "a,,b", "price desc extra", "price nulls", "price nulls middle", "price nulls first desc"} {
+		if _, err := ParseOrderBy(spec); err == nil {
+			t.Errorf("ParseOrderBy(%q) should fail", spec)
+		}
+	}
+
+	rows := [][]interface{}{{"x", nil}, {"x", 10.0}, {"y", 5.0}}
+	keys = []OrderKey{{Path: "a"}, {Path: "b", Desc: true}}
+	if CompareRows(rows[0], rows[1], keys) <= 0 {
+		t.Error("Expected null to sort last in descending order too")
+	}
+	keys[1].Nulls = NullsFirst
+	if CompareRows(rows[0], rows[1], keys) >= 0 {
+		t.Error("Expected null to sort first with NULLS FIRST")
+	}
+	if CompareRows(rows[1], rows[2], keys) >= 0 {
+		t.Error("Expected the first key to decide")
+	}
+
+	for query, expected := range map[string]string{
+		"SELECT a ORDER BY b DESC NULLS FIRST": "[b DESC NULLS FIRST]",
+		"SELECT a ORDER BY b nulls last, c":    "[b ASC c ASC]",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got := fmt.Sprint(q.OrderBy); got != expected {
+			t.Errorf("%s: ORDER BY = %s, want %s", query, got, expected)
+		}
+	}
+	if _, err := ParseQuery("SELECT a ORDER BY b NULLS NEVER"); err == nil || !strings.Contains(err.Error(), "NULLS FIRST or NULLS LAST") {
+		t.Errorf("Expected an invalid NULLS to be refused, got %v", err)
+	}
+}
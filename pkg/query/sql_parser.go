@@ -12,16 +12,37 @@ import (
 type Field struct {
 	Path      string
 	Alias     string
-	Aggregate string // "MAX", "MIN", "AVG", "COUNT", "SUM" or empty
+	Aggregate string // "MAX", "MIN", "AVG", "COUNT", "SUM", "FIRST" or empty
+	// Bucket is set for BUCKET()/HISTOGRAM() fields, which output the
+	// range of the matching GROUP BY
+	Bucket *Bucket
+	// Func is a scalar function applied to the value at Path (FuncTokenize)
+	Func string
+	// Unnest outputs one row per element of the value (UNNEST)
+	Unnest bool
 }
 
 func (f Field) String() string {
+	s := f.expression()
+	if f.Alias != "" && f.Alias != DisplayPath(f.Path) {
+		s += " AS " + f.Alias
+	}
+	return s
+}
+
+// expression is the select list expression of the field, without its alias
+func (f Field) expression() string {
 	s := f.Path
-	if f.Aggregate != "" {
-		s = fmt.Sprintf("%s(%s)", f.Aggregate, f.Path)
+	if f.Func != "" {
+		s = fmt.Sprintf("%s(%s)", f.Func, s)
 	}
-	if f.Alias != "" && f.Alias != f.Path {
-		s += " AS " + f.Alias
+	if f.Unnest {
+		s = fmt.Sprintf("%s(%s)", FuncUnnest, s)
+	}
+	if f.Aggregate != "" {
+		s = fmt.Sprintf("%s(%s)", f.Aggregate, s)
+	} else if f.Bucket != nil {
+		s = f.Bucket.String()
 	}
 	return s
 }
@@ -33,12 +54,215 @@ type SelectQuery struct {
 	FromQuery *SelectQuery // Recursive subquery if source is another query
 	Filter    Expression   // Compiled expression tree for the WHERE clause
 	GroupBy   string
+	// GroupBucket groups GroupBy values into ranges (GROUP BY BUCKET(...)), or nil
+	GroupBucket *Bucket
+	// GroupUnnest is the UNNEST field whose alias GroupBy names, expanded
+	// into one row per element before grouping, or nil
+	GroupUnnest *Field
+	Into        string // Target file for SELECT ... INTO 'file', empty for stdout
+	// OrderBy sorts the result rows by their columns (ORDER BY a, b DESC)
+	OrderBy []OrderKey
+	// Limit is the maximum number of rows returned (LIMIT n), nil for all rows
+	Limit *int
+
+	// CaseInsensitive matches field names regardless of case (engine option, not SQL syntax)
+	CaseInsensitive bool
+	// IgnoreCase compares string values in WHERE regardless of case (engine option)
+	IgnoreCase bool
+	// Strict fails the query when a referenced field is absent from every scanned record
+	Strict bool
+	// ArrayMatch is the default quantifier for WHERE conditions on arrays
+	// (QuantifierAny when empty); ANY(...)/ALL(...)/NONE(...) override it.
+	ArrayMatch string
+	// Sources keeps the "_source" field locating each input record in the
+	// result rows, aggregated rows listing the sources of their group
+	// (engine option). The input table must add it to its records.
+	Sources bool
+	// Workers scans inputs that can be split (database.SplitTable) with this
+	// many parallel workers, filtering as they decode (engine option; 0 or 1
+	// scans sequentially)
+	Workers int
+	// MemoryLimit bounds the memory of the rows held by ORDER BY and GROUP BY,
+	// which spill to temporary files in TempDir beyond it (engine option; 0
+	// holds them all in memory)
+	MemoryLimit int64
+	TempDir     string
+	// SortedBy declares the input table sorted by this field, GROUP BY on
+	// it returning each group as soon as its rows are read instead of
+	// holding them all (engine option)
+	SortedBy string
+}
+
+// Clone returns a deep copy of the query. Planning changes the query it is
+// given (ResolveAliases, the engine options set on its conditions), so
+// planner.CreatePlan plans a clone: a parsed query can be planned by
+// several goroutines at once.
+func (sq *SelectQuery) Clone() *SelectQuery {
+	c := *sq
+	c.Fields = append([]Field(nil), sq.Fields...)
+	for i, f := range c.Fields {
+		if f.Bucket != nil {
+			bucket := *f.Bucket
+			c.Fields[i].Bucket = &bucket
+		}
+	}
+	if sq.FromQuery != nil {
+		c.FromQuery = sq.FromQuery.Clone()
+	}
+	if sq.Filter != nil {
+		c.Filter = CloneExpression(sq.Filter)
+	}
+	if sq.GroupBucket != nil {
+		bucket := *sq.GroupBucket
+		c.GroupBucket = &bucket
+	}
+	if sq.GroupUnnest != nil {
+		unnest := *sq.GroupUnnest
+		c.GroupUnnest = &unnest
+	}
+	c.OrderBy = append([]OrderKey(nil), sq.OrderBy...)
+	if sq.Limit != nil {
+		limit := *sq.Limit
+		c.Limit = &limit
+	}
+	return &c
+}
+
+// ResolveAliases rewrites references to SELECT aliases in WHERE and GROUP BY
+// into the aliased paths, so that "SELECT price AS p WHERE p > 100" filters
+// on price. A reference may also continue past the alias (a.city for
+// "address AS a"). Aliases of aggregates cannot be referenced, their values
+// only exist after grouping. GROUP BY the alias of an UNNEST field groups
+// its elements (GroupUnnest), the field then selecting the grouped value.
+//
+// An alias takes precedence over a source field of the same name: in
+// "SELECT name AS price WHERE price > 10" the filter is on name. A path
+// starting with a bracketed key (["price"] > 10) always refers to the
+// source field.
+func (sq *SelectQuery) ResolveAliases() error {
+	aliases := make(map[string]Field)
+	for _, f := range sq.Fields {
+		if f.Alias == "" || (f.Aggregate == "" && f.Alias == DisplayPath(f.Path)) {
+			continue
+		}
+		aliases[f.Alias] = f
+	}
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	resolve := func(clause string) func(string) (string, error) {
+		return func(path string) (string, error) {
+			if strings.HasPrefix(path, "[") {
+				return path, nil
+			}
+			parts := parsePath(path)
+			if len(parts) == 0 {
+				return path, nil
+			}
+			f, ok := sq.lookupAlias(aliases, unquoteIdent(parts[0]))
+			if !ok {
+				return path, nil
+			}
+			if f.Aggregate != "" {
+				return "", fmt.Errorf("cannot use aggregate alias '%s' in %s", f.Alias, clause)
+			}
+			if f.Unnest && clause == "GROUP BY" && len(parts) == 1 {
+				unnest := f
+				sq.GroupUnnest = &unnest
+				for i := range sq.Fields {
+					if sq.Fields[i].Alias == f.Alias {
+						sq.Fields[i] = Field{Path: f.Alias, Alias: f.Alias}
+					}
+				}
+				return f.Alias, nil
+			}
+			if f.Func != "" || f.Unnest {
+				return "", fmt.Errorf("cannot use alias '%s' of %s in %s", f.Alias, f.expression(), clause)
+			}
+			if f.Bucket != nil {
+				if clause != "GROUP BY" || len(parts) > 1 || sq.GroupBucket != nil {
+					return "", fmt.Errorf("cannot use %s alias '%s' in %s", f.Bucket.Func, f.Alias, clause)
+				}
+				// GROUP BY r for "BUCKET(price, 100) AS r"
+				bucket := *f.Bucket
+				sq.GroupBucket = &bucket
+			}
+			return strings.Join(append([]string{f.Path}, parts[1:]...), "."), nil
+		}
+	}
+
+	if sq.Filter != nil {
+		if err := MapFields(sq.Filter, resolve("WHERE")); err != nil {
+			return err
+		}
+	}
+	if sq.GroupBy != "" {
+		path, err := resolve("GROUP BY")(sq.GroupBy)
+		if err != nil {
+			return err
+		}
+		sq.GroupBy = path
+		if sq.GroupBucket != nil {
+			sq.GroupBucket.Path = path
+		}
+	}
+	return nil
+}
+
+func (sq *SelectQuery) lookupAlias(aliases map[string]Field, name string) (Field, bool) {
+	if f, ok := aliases[name]; ok {
+		return f, true
+	}
+	if sq.CaseInsensitive {
+		for alias, f := range aliases {
+			if strings.EqualFold(alias, name) {
+				return f, true
+			}
+		}
+	}
+	return Field{}, false
+}
+
+// Assignment sets Path to a literal Value, or to the value found at Source
+type Assignment struct {
+	Path   string
+	Value  interface{}
+	Source string // Path to copy from, empty when Value is a literal
+}
+
+func (a Assignment) String() string {
+	if a.Source != "" {
+		return a.Path + " = " + a.Source
+	}
+	if s, ok := a.Value.(string); ok {
+		return fmt.Sprintf("%s = '%s'", a.Path, s)
+	}
+	return fmt.Sprintf("%s = %v", a.Path, a.Value)
+}
+
+// UpdateQuery represents a parsed UPDATE ... SET ... WHERE statement.
+// Matching records are rewritten, all others pass through unchanged.
+type UpdateQuery struct {
+	Table       string
+	Assignments []Assignment
+	Filter      Expression
+}
+
+// DeleteQuery represents a parsed DELETE [FROM table] WHERE statement.
+// Records matching Filter are removed, all others pass through unchanged.
+// Without a WHERE clause every record is removed.
+type DeleteQuery struct {
+	Table  string
+	Filter Expression
 }
 
 // Lexer definition
 var (
 	sqlLexer = lexer.MustSimple([]lexer.SimpleRule{
-		{Name: "Keyword", Pattern: `(?i)\b(SELECT|FROM|WHERE|GROUP|BY|AS|AND|OR|TRUE|FALSE|CONTAINS)\b`},
+		{Name: "Keyword", Pattern: `(?i)\b(SELECT|UPDATE|SET|DELETE|INTO|FROM|WHERE|GROUP|ORDER|BY|ASC|DESC|NULLS|LIMIT|AS|AND|OR|NOT|IS|NULL|TRUE|FALSE|CONTAINS)\b`},
+		{Name: "QuotedIdent", Pattern: "`[^`]+`"},
+		{Name: "Subscript", Pattern: `\[(-?\d+|-?\d*:-?\d*|"(\\.|[^"\\])*"|'(\\.|[^'\\])*')\]`},
 		{Name: "Ident", Pattern: `[a-zA-Z_][a-zA-Z0-9_]*`},
 		{Name: "Number", Pattern: `[-+]?\d*\.?\d+`},
 		{Name: "String", Pattern: `'[^']*'|"[^"]*"`},
@@ -55,6 +279,30 @@ var (
 		participle.Elide("Whitespace"),
 		participle.UseLookahead(2), // Lookahead to resolve ambiguity if needed
 	)
+
+	updateParser = participle.MustBuild[ASTUpdate](
+		participle.Lexer(sqlLexer),
+		participle.Unquote("String"),
+		participle.CaseInsensitive("Keyword"),
+		participle.Elide("Whitespace"),
+		participle.UseLookahead(2),
+	)
+
+	filterParser = participle.MustBuild[ASTExpression](
+		participle.Lexer(sqlLexer),
+		participle.Unquote("String"),
+		participle.CaseInsensitive("Keyword"),
+		participle.Elide("Whitespace"),
+		participle.UseLookahead(2),
+	)
+
+	deleteParser = participle.MustBuild[ASTDelete](
+		participle.Lexer(sqlLexer),
+		participle.Unquote("String"),
+		participle.CaseInsensitive("Keyword"),
+		participle.Elide("Whitespace"),
+		participle.UseLookahead(2),
+	)
 )
 
 // ParseQuery parses a SELECT string using Participle
@@ -70,5 +318,51 @@ func ParseQuery(input string) (*SelectQuery, error) {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
 
-	return ast.ToSelectQuery(), nil
+	return ast.ToSelectQuery()
+}
+
+// ParseUpdate parses an UPDATE statement
+func ParseUpdate(input string) (*UpdateQuery, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	ast, err := updateParser.ParseString("", input)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	return ast.ToUpdateQuery()
+}
+
+// ParseDelete parses a DELETE statement
+func ParseDelete(input string) (*DeleteQuery, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	ast, err := deleteParser.ParseString("", input)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	return ast.ToDeleteQuery(), nil
+}
+
+// ParseFilter parses a standalone boolean expression with the grammar of
+// the WHERE clause, e.g. "(age>28 AND city='NY') OR vip=true"
+func ParseFilter(input string) (Expression, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	ast, err := filterParser.ParseString("", input)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	return ast.ToExpression(), nil
 }